@@ -32,6 +32,28 @@ import (
 	"github.com/pkg/errors"
 )
 
+// causer is implemented by errors wrapping another one, as used by
+// errors.Cause
+type causer interface {
+	Cause() error
+}
+
+// hasCause returns true if match is true for err or for any error it wraps,
+// so typed errors are found even when they wrap or are wrapped by others
+func hasCause(err error, match func(error) bool) bool {
+	for err != nil {
+		if match(err) {
+			return true
+		}
+		c, ok := err.(causer)
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
 // DomainObject is a marker interface method for an Entity
 type DomainObject interface {
 	// dummy marker interface method
@@ -57,8 +79,10 @@ func (*ErrNotInitialized) Error() string {
 // ErrorIsNotInitialized checks if the error is a "ErrNotInitialized"
 // (possibly wrapped)
 func ErrorIsNotInitialized(err error) bool {
-	_, ok := errors.Cause(err).(*ErrNotInitialized)
-	return ok
+	return hasCause(err, func(cause error) bool {
+		_, ok := cause.(*ErrNotInitialized)
+		return ok
+	})
 }
 
 // ErrNotFound is an error when a row is not found (single or multiple)
@@ -72,8 +96,10 @@ func (*ErrNotFound) Error() string {
 // ErrorIsNotFound checks if the error is a "ErrNotFound"
 // (possibly wrapped)
 func ErrorIsNotFound(err error) bool {
-	_, ok := errors.Cause(err).(*ErrNotFound)
-	return ok
+	return hasCause(err, func(cause error) bool {
+		_, ok := cause.(*ErrNotFound)
+		return ok
+	})
 }
 
 // ErrAlreadyExists is an error returned when CreateIfNotExists but a row already exists
@@ -85,8 +111,155 @@ func (*ErrAlreadyExists) Error() string {
 
 // ErrorIsAlreadyExists checks if the error is caused by "ErrAlreadyExists"
 func ErrorIsAlreadyExists(err error) bool {
-	_, ok := errors.Cause(err).(*ErrAlreadyExists)
-	return ok
+	return hasCause(err, func(cause error) bool {
+		_, ok := cause.(*ErrAlreadyExists)
+		return ok
+	})
+}
+
+// ErrRetryable is an error returned by a connector when the operation failed
+// because of a transient problem and may succeed if it is tried again
+type ErrRetryable struct {
+	Err error
+}
+
+// Error returns the message of the underlying error
+func (e *ErrRetryable) Error() string {
+	return "retryable error: " + e.Err.Error()
+}
+
+// Cause returns the underlying error, so errors.Cause finds it
+func (e *ErrRetryable) Cause() error {
+	return e.Err
+}
+
+// ErrorIsRetryable checks if the error is caused by "ErrRetryable"
+func ErrorIsRetryable(err error) bool {
+	return hasCause(err, func(cause error) bool {
+		_, ok := cause.(*ErrRetryable)
+		return ok
+	})
+}
+
+// ErrInvalidRequest is an error returned when a request does not fit the
+// entity definition or is otherwise malformed, such as a value of the wrong
+// type or a missing key
+type ErrInvalidRequest struct {
+	// Column is the offending column, if the problem is with one column
+	Column string
+	Err    error
+}
+
+// Error returns the offending column and the reason the request is invalid
+func (e *ErrInvalidRequest) Error() string {
+	if e.Column == "" {
+		return "invalid request: " + e.Err.Error()
+	}
+	return fmt.Sprintf("invalid request for column %q: %s", e.Column, e.Err.Error())
+}
+
+// ErrorIsInvalidRequest checks if the error is caused by "ErrInvalidRequest"
+func ErrorIsInvalidRequest(err error) bool {
+	return hasCause(err, func(cause error) bool {
+		_, ok := cause.(*ErrInvalidRequest)
+		return ok
+	})
+}
+
+// ErrSchemaMismatch is an error returned when the schema used by a request
+// does not match the schema known to the server, for example after an
+// incompatible schema change
+type ErrSchemaMismatch struct {
+	Err error
+}
+
+// Error returns the message of the underlying error
+func (e *ErrSchemaMismatch) Error() string {
+	return "schema mismatch: " + e.Err.Error()
+}
+
+// Cause returns the underlying error, so errors.Cause finds it
+func (e *ErrSchemaMismatch) Cause() error {
+	return e.Err
+}
+
+// ErrorIsSchemaMismatch checks if the error is caused by "ErrSchemaMismatch"
+func ErrorIsSchemaMismatch(err error) bool {
+	return hasCause(err, func(cause error) bool {
+		_, ok := cause.(*ErrSchemaMismatch)
+		return ok
+	})
+}
+
+// ErrTimeout is an error returned when an operation did not complete in time
+type ErrTimeout struct {
+	Err error
+}
+
+// Error returns the message of the underlying error
+func (e *ErrTimeout) Error() string {
+	return "timeout: " + e.Err.Error()
+}
+
+// Cause returns the underlying error, so errors.Cause finds it
+func (e *ErrTimeout) Cause() error {
+	return e.Err
+}
+
+// ErrorIsTimeout checks if the error is caused by "ErrTimeout"
+func ErrorIsTimeout(err error) bool {
+	return hasCause(err, func(cause error) bool {
+		_, ok := cause.(*ErrTimeout)
+		return ok
+	})
+}
+
+// ErrThrottled is an error returned when an operation was rejected because
+// the caller sent too many requests
+type ErrThrottled struct {
+	Err error
+}
+
+// Error returns the message of the underlying error
+func (e *ErrThrottled) Error() string {
+	return "throttled: " + e.Err.Error()
+}
+
+// Cause returns the underlying error, so errors.Cause finds it
+func (e *ErrThrottled) Cause() error {
+	return e.Err
+}
+
+// ErrorIsThrottled checks if the error is caused by "ErrThrottled"
+func ErrorIsThrottled(err error) bool {
+	return hasCause(err, func(cause error) bool {
+		_, ok := cause.(*ErrThrottled)
+		return ok
+	})
+}
+
+// ErrInternal is an error returned when the server failed to process an
+// otherwise valid request
+type ErrInternal struct {
+	Err error
+}
+
+// Error returns the message of the underlying error
+func (e *ErrInternal) Error() string {
+	return "internal error: " + e.Err.Error()
+}
+
+// Cause returns the underlying error, so errors.Cause finds it
+func (e *ErrInternal) Cause() error {
+	return e.Err
+}
+
+// ErrorIsInternal checks if the error is caused by "ErrInternal"
+func ErrorIsInternal(err error) bool {
+	return hasCause(err, func(cause error) bool {
+		_, ok := cause.(*ErrInternal)
+		return ok
+	})
 }
 
 // Client defines the methods to operate with DOSA entities
@@ -102,28 +275,25 @@ type Client interface {
 	// specified. Use All() or nil for all fields.
 	Read(context.Context, []string, DomainObject) error
 
-	// TODO: Coming in v2.1
 	// MultiRead fetches several rows by primary key. A list of fields can be
 	// specified. Use All() or nil for all fields.
-	// MultiRead(context.Context, []string, ...DomainObject) (MultiResult, error)
+	MultiRead(context.Context, []string, ...DomainObject) (MultiResult, error)
 
 	// Upsert creates or update a row. A list of fields to update can be
 	// specified. Use All() or nil for all fields.
 	Upsert(context.Context, []string, DomainObject) error
 
-	// TODO: Coming in v2.1
 	// MultiUpsert creates or updates multiple rows. A list of fields to
 	// update can be specified. Use All() or nil for all fields.
-	// MultiUpsert(context.Context, []string, ...DomainObject) (MultiResult, error)
+	MultiUpsert(context.Context, []string, ...DomainObject) (MultiResult, error)
 
 	// Remove removes a row by primary key. The passed-in entity should contain
 	// the primary key field values.
 	Remove(context.Context, DomainObject) error
 
-	// TODO: Coming in v2.1
 	// MultiRemove removes multiple rows by primary key. The passed-in entity should
 	// contain the primary key field values.
-	// MultiRemove(context.Context, ...DomainObject) (MultiResult, error)
+	MultiRemove(context.Context, ...DomainObject) (MultiResult, error)
 
 	// Range fetches entities within a range
 	Range(context.Context, *RangeOp) ([]DomainObject, string, error)
@@ -167,6 +337,10 @@ type AdminClient interface {
 	TruncateScope(ctx context.Context, s string) error
 	// DropScope drops the scope and the data and schemas in the scope
 	DropScope(ctx context.Context, s string) error
+	// ScopeExists checks whether the scope exists
+	ScopeExists(ctx context.Context, s string) (bool, error)
+	// ListScopes lists the scopes, only those owned by owner if it is not empty
+	ListScopes(ctx context.Context, owner string) ([]string, error)
 }
 
 type client struct {
@@ -262,9 +436,89 @@ func (c *client) Read(ctx context.Context, fieldsToRead []string, entity DomainO
 // MultiRead fetches several entities by primary key, The entities provided
 // must contain values for all components of its primary key for the operation
 // to succeed. If `fieldsToRead` is provided, only a subset of fields will be
-// marshalled onto the given entities.
-func (c *client) MultiRead(context.Context, []string, ...DomainObject) (MultiResult, error) {
-	panic("not implemented")
+// marshalled onto the given entities. Entities of different types may be
+// mixed; the connector is called once for each type.
+func (c *client) MultiRead(ctx context.Context, fieldsToRead []string, entities ...DomainObject) (MultiResult, error) {
+	if !c.initialized {
+		return nil, &ErrNotInitialized{}
+	}
+
+	groups, err := c.groupByEntity(entities)
+	if err != nil {
+		return nil, errors.Wrap(err, "MultiRead")
+	}
+
+	result := MultiResult{}
+	for _, g := range groups {
+		// build a list of column names from a list of entities field names
+		columnsToRead, err := g.re.ColumnNames(fieldsToRead)
+		if err != nil {
+			return nil, errors.Wrap(err, "MultiRead")
+		}
+
+		// translate each entity to a map of primary key name/values pairs
+		keys := make([]map[string]FieldValue, len(g.entities))
+		for i, entity := range g.entities {
+			keys[i] = g.re.KeyFieldValues(entity)
+		}
+
+		results, err := c.connector.MultiRead(ctx, g.re.EntityInfo(), keys, columnsToRead)
+		if err != nil {
+			return nil, errors.Wrap(err, "MultiRead")
+		}
+		if len(results) != len(g.entities) {
+			return nil, errors.Errorf("MultiRead: connector returned %d results for %d entities of %s", len(results), len(g.entities), g.re.table.StructName)
+		}
+
+		// map results to entity fields, recording any per-entity failure
+		for i, entity := range g.entities {
+			if results[i] == nil {
+				result[entity] = &ErrNotFound{}
+				continue
+			}
+			if results[i].Error != nil {
+				result[entity] = results[i].Error
+				continue
+			}
+			g.re.SetFieldValues(entity, results[i].Values)
+		}
+	}
+
+	return result, nil
+}
+
+// entityGroup holds the entities of a single registered type
+type entityGroup struct {
+	re       *RegisteredEntity
+	entities []DomainObject
+}
+
+// groupByEntity partitions the entities by their registration, in the order
+// each registration was first seen, so that multi operations can issue one
+// connector call per entity type.
+func (c *client) groupByEntity(entities []DomainObject) ([]*entityGroup, error) {
+	if len(entities) == 0 {
+		return nil, errors.New("no entities provided")
+	}
+
+	var groups []*entityGroup
+	index := map[*RegisteredEntity]*entityGroup{}
+	for _, entity := range entities {
+		// lookup registered entity, registry will return error if registration
+		// is not found
+		re, err := c.registrar.Find(entity)
+		if err != nil {
+			return nil, err
+		}
+		g, ok := index[re]
+		if !ok {
+			g = &entityGroup{re: re}
+			index[re] = g
+			groups = append(groups, g)
+		}
+		g.entities = append(g.entities, entity)
+	}
+	return groups, nil
 }
 
 type createOrUpsertType func(context.Context, *EntityInfo, map[string]FieldValue) error
@@ -289,29 +543,75 @@ func (c *client) createOrUpsert(ctx context.Context, fieldsToUpdate []string, en
 		return err
 	}
 
+	fieldValues, err := upsertFieldValues(re, entity, fieldsToUpdate)
+	if err != nil {
+		return err
+	}
+
+	return fn(ctx, re.EntityInfo(), fieldValues)
+}
+
+// upsertFieldValues builds the column name/value pairs needed to create or
+// upsert an entity: the primary key values plus the fields to update.
+func upsertFieldValues(re *RegisteredEntity, entity DomainObject, fieldsToUpdate []string) (map[string]FieldValue, error) {
 	// translate entity field values to a map of primary key name/values pairs
 	keyFieldValues := re.KeyFieldValues(entity)
 
 	// translate remaining entity fields values to map of column name/value pairs
 	fieldValues, err := re.OnlyFieldValues(entity, fieldsToUpdate)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// merge key and remaining values
 	for k, v := range keyFieldValues {
 		fieldValues[k] = v
 	}
-
-	return fn(ctx, re.EntityInfo(), fieldValues)
+	return fieldValues, nil
 }
 
 // MultiUpsert updates several entities by primary key, The entities provided
 // must contain values for all components of its primary key for the operation
 // to succeed. If `fieldsToUpdate` is provided, only a subset of fields will be
-// updated.
-func (c *client) MultiUpsert(context.Context, []string, ...DomainObject) (MultiResult, error) {
-	panic("not implemented")
+// updated. Entities of different types may be mixed; the connector is called
+// once for each type.
+func (c *client) MultiUpsert(ctx context.Context, fieldsToUpdate []string, entities ...DomainObject) (MultiResult, error) {
+	if !c.initialized {
+		return nil, &ErrNotInitialized{}
+	}
+
+	groups, err := c.groupByEntity(entities)
+	if err != nil {
+		return nil, errors.Wrap(err, "MultiUpsert")
+	}
+
+	result := MultiResult{}
+	for _, g := range groups {
+		multiValues := make([]map[string]FieldValue, len(g.entities))
+		for i, entity := range g.entities {
+			fieldValues, err := upsertFieldValues(g.re, entity, fieldsToUpdate)
+			if err != nil {
+				return nil, errors.Wrap(err, "MultiUpsert")
+			}
+			multiValues[i] = fieldValues
+		}
+
+		rowErrors, err := c.connector.MultiUpsert(ctx, g.re.EntityInfo(), multiValues)
+		if err != nil {
+			return nil, errors.Wrap(err, "MultiUpsert")
+		}
+		if len(rowErrors) != len(g.entities) {
+			return nil, errors.Errorf("MultiUpsert: connector returned %d results for %d entities of %s", len(rowErrors), len(g.entities), g.re.table.StructName)
+		}
+
+		for i, entity := range g.entities {
+			if rowErrors[i] != nil {
+				result[entity] = rowErrors[i]
+			}
+		}
+	}
+
+	return result, nil
 }
 
 // Remove deletes an entity by primary key, The entity provided must contain
@@ -337,9 +637,42 @@ func (c *client) Remove(ctx context.Context, entity DomainObject) error {
 
 // MultiRemove deletes several entities by primary key, The entities provided
 // must contain values for all components of its primary key for the operation
-// to succeed.
-func (c *client) MultiRemove(context.Context, ...DomainObject) (MultiResult, error) {
-	panic("not implemented")
+// to succeed. Entities of different types may be mixed; the connector is
+// called once for each type.
+func (c *client) MultiRemove(ctx context.Context, entities ...DomainObject) (MultiResult, error) {
+	if !c.initialized {
+		return nil, &ErrNotInitialized{}
+	}
+
+	groups, err := c.groupByEntity(entities)
+	if err != nil {
+		return nil, errors.Wrap(err, "MultiRemove")
+	}
+
+	result := MultiResult{}
+	for _, g := range groups {
+		// translate each entity to a map of primary key name/values pairs
+		multiKeys := make([]map[string]FieldValue, len(g.entities))
+		for i, entity := range g.entities {
+			multiKeys[i] = g.re.KeyFieldValues(entity)
+		}
+
+		rowErrors, err := c.connector.MultiRemove(ctx, g.re.EntityInfo(), multiKeys)
+		if err != nil {
+			return nil, errors.Wrap(err, "MultiRemove")
+		}
+		if len(rowErrors) != len(g.entities) {
+			return nil, errors.Errorf("MultiRemove: connector returned %d results for %d entities of %s", len(rowErrors), len(g.entities), g.re.table.StructName)
+		}
+
+		for i, entity := range g.entities {
+			if rowErrors[i] != nil {
+				result[entity] = rowErrors[i]
+			}
+		}
+	}
+
+	return result, nil
 }
 
 // Range uses the connector to fetch DOSA entities for a given range.
@@ -390,8 +723,36 @@ func objectsFromValueArray(object DomainObject, values []map[string]FieldValue,
 }
 
 // Search uses the connector to fetch DOSA entities by fields that have been marked "searchable".
-func (c *client) Search(context.Context, *SearchOp) ([]DomainObject, string, error) {
-	panic("not implemented")
+func (c *client) Search(ctx context.Context, sop *SearchOp) ([]DomainObject, string, error) {
+	if !c.initialized {
+		return nil, "", &ErrNotInitialized{}
+	}
+	// look up the entity in the registry
+	re, err := c.registrar.Find(sop.sop.object)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Search")
+	}
+
+	// convert the search field to the server side column and check it is searchable
+	fieldPair, err := convertSearchOpField(sop, re.table)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Search")
+	}
+
+	// convert the fieldsToRead to the server side equivalent
+	fieldsToRead, err := re.ColumnNames(sop.sop.fieldsToRead)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Search")
+	}
+
+	// call the server side method
+	values, token, err := c.connector.Search(ctx, re.info, fieldPair, fieldsToRead, sop.sop.token, sop.sop.limit)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Search")
+	}
+
+	objectArray := objectsFromValueArray(sop.sop.object, values, re)
+	return objectArray, token, nil
 }
 
 // ScanEverything uses the connector to fetch all DOSA entities of the given type.
@@ -573,3 +934,13 @@ func (c *adminClient) TruncateScope(ctx context.Context, s string) error {
 func (c *adminClient) DropScope(ctx context.Context, s string) error {
 	return c.connector.DropScope(ctx, s)
 }
+
+// ScopeExists checks whether the scope exists
+func (c *adminClient) ScopeExists(ctx context.Context, s string) (bool, error) {
+	return c.connector.ScopeExists(ctx, s)
+}
+
+// ListScopes lists the scopes, only those owned by owner if it is not empty
+func (c *adminClient) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	return c.connector.ListScopes(ctx, owner)
+}
@@ -40,7 +40,7 @@ type ClientTestEntity1 struct {
 	dosaRenamed.Entity `dosa:"primaryKey=(ID)"`
 	ID                 int64
 	Name               string
-	Email              string
+	Email              string `dosa:"searchable"`
 }
 
 type ClientTestEntity2 struct {
@@ -178,6 +178,94 @@ func TestClient_Read_Errors(t *testing.T) {
 	assert.Contains(t, err.Error(), "badcol")
 }
 
+func TestClient_MultiRead(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	reg2, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1, cte2)
+	e1 := &ClientTestEntity1{ID: int64(1)}
+	e2 := &ClientTestEntity1{ID: int64(2)}
+	e3 := &ClientTestEntity2{UUID: "b1f23fa3-f453-45b4-a5d5-6d73078ac3bd", Color: "blue"}
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	_, err := c1.MultiRead(ctx, dosaRenamed.All(), e1)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+
+	// no entities
+	c1.Initialize(ctx)
+	_, err = c1.MultiRead(ctx, dosaRenamed.All())
+	assert.Error(t, err)
+
+	// unregistered object
+	_, err = c1.MultiRead(ctx, dosaRenamed.All(), e1, e3)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ClientTestEntity2")
+
+	// bad field
+	_, err = c1.MultiRead(ctx, []string{"badcol"}, e1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "badcol")
+
+	// devnull connector reports every entity as not found
+	result, err := c1.MultiRead(ctx, dosaRenamed.All(), e1, e2)
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	assert.True(t, dosaRenamed.ErrorIsNotFound(result[e1]))
+	assert.True(t, dosaRenamed.ErrorIsNotFound(result[e2]))
+
+	// happy path, one call per entity type, mock connector
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	mockConn.EXPECT().MultiRead(ctx, gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, ei *dosaRenamed.EntityInfo, keys []map[string]dosaRenamed.FieldValue, columnsToRead []string) {
+			assert.Equal(t, "clienttestentity1", ei.Def.Name)
+			assert.Equal(t, []map[string]dosaRenamed.FieldValue{{"id": e1.ID}, {"id": e2.ID}}, keys)
+			assert.Len(t, columnsToRead, 3)
+		}).Return([]*dosaRenamed.FieldValuesOrError{
+		{Values: map[string]dosaRenamed.FieldValue{"id": int64(1), "email": "one@email.com"}},
+		{Error: &dosaRenamed.ErrNotFound{}},
+	}, nil)
+	mockConn.EXPECT().MultiRead(ctx, gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, ei *dosaRenamed.EntityInfo, keys []map[string]dosaRenamed.FieldValue, columnsToRead []string) {
+			assert.Equal(t, "clienttestentity2", ei.Def.Name)
+			assert.Equal(t, []map[string]dosaRenamed.FieldValue{{"uuid": e3.UUID, "color": e3.Color}}, keys)
+		}).Return([]*dosaRenamed.FieldValuesOrError{
+		{Values: map[string]dosaRenamed.FieldValue{"isactive": true}},
+	}, nil)
+	c2 := dosaRenamed.NewClient(reg2, mockConn)
+	assert.NoError(t, c2.Initialize(ctx))
+	result, err = c2.MultiRead(ctx, dosaRenamed.All(), e1, e3, e2)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	assert.True(t, dosaRenamed.ErrorIsNotFound(result[e2]))
+	assert.Equal(t, "one@email.com", e1.Email)
+	assert.Empty(t, e2.Email)
+	assert.True(t, e3.IsActive)
+}
+
+func TestClient_MultiRead_Errors(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	c1 := dosaRenamed.NewClient(reg1, mockConn)
+	assert.NoError(t, c1.Initialize(ctx))
+
+	// connector error
+	mockConn.EXPECT().MultiRead(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+	_, err := c1.MultiRead(ctx, dosaRenamed.All(), &ClientTestEntity1{ID: int64(1)})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "oops")
+
+	// connector returned the wrong number of results
+	mockConn.EXPECT().MultiRead(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return([]*dosaRenamed.FieldValuesOrError{}, nil)
+	_, err = c1.MultiRead(ctx, dosaRenamed.All(), &ClientTestEntity1{ID: int64(1)})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "0 results for 1 entities")
+}
+
 func TestClient_Upsert(t *testing.T) {
 	reg1, _ := dosaRenamed.NewRegistrar("test", "team.service", cte1)
 	reg2, _ := dosaRenamed.NewRegistrar("test", "team.service", cte1, cte2)
@@ -265,6 +353,77 @@ func TestClient_Upsert_Errors(t *testing.T) {
 	assert.Contains(t, err.Error(), "badcol")
 }
 
+func TestClient_MultiUpsert(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	reg2, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1, cte2)
+	e1 := &ClientTestEntity1{ID: int64(1), Name: "one", Email: "one@email.com"}
+	e2 := &ClientTestEntity1{ID: int64(2), Name: "two", Email: "two@email.com"}
+	e3 := &ClientTestEntity2{UUID: "b1f23fa3-f453-45b4-a5d5-6d73078ac3bd", Color: "blue", IsActive: true}
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	_, err := c1.MultiUpsert(ctx, dosaRenamed.All(), e1)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+
+	// no entities
+	c1.Initialize(ctx)
+	_, err = c1.MultiUpsert(ctx, dosaRenamed.All())
+	assert.Error(t, err)
+
+	// unregistered object
+	_, err = c1.MultiUpsert(ctx, dosaRenamed.All(), e1, e3)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ClientTestEntity2")
+
+	// bad field
+	_, err = c1.MultiUpsert(ctx, []string{"badcol"}, e1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "badcol")
+
+	// devnull accepts everything
+	result, err := c1.MultiUpsert(ctx, dosaRenamed.All(), e1, e2)
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+
+	// happy path, one call per entity type, mock connector
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	mockConn.EXPECT().MultiUpsert(ctx, gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, ei *dosaRenamed.EntityInfo, multiValues []map[string]dosaRenamed.FieldValue) {
+			assert.Equal(t, "clienttestentity1", ei.Def.Name)
+			assert.Equal(t, []map[string]dosaRenamed.FieldValue{
+				{"id": e1.ID, "email": e1.Email},
+				{"id": e2.ID, "email": e2.Email},
+			}, multiValues)
+		}).Return([]error{nil, errors.New("oops")}, nil)
+	c2 := dosaRenamed.NewClient(reg2, mockConn)
+	assert.NoError(t, c2.Initialize(ctx))
+	result, err = c2.MultiUpsert(ctx, []string{"Email"}, e1, e2)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	assert.Contains(t, result[e2].Error(), "oops")
+
+	// mixed entity types
+	mockConn.EXPECT().MultiUpsert(ctx, gomock.Any(), gomock.Any()).Return([]error{nil}, nil).Times(2)
+	result, err = c2.MultiUpsert(ctx, dosaRenamed.All(), e1, e3)
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+
+	// connector error
+	mockConn.EXPECT().MultiUpsert(ctx, gomock.Any(), gomock.Any()).Return(nil, errors.New("connector error"))
+	_, err = c2.MultiUpsert(ctx, dosaRenamed.All(), e1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "connector error")
+
+	// connector returned the wrong number of results
+	mockConn.EXPECT().MultiUpsert(ctx, gomock.Any(), gomock.Any()).Return([]error{}, nil)
+	_, err = c2.MultiUpsert(ctx, dosaRenamed.All(), e1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "0 results for 1 entities")
+}
+
 func TestClient_Range(t *testing.T) {
 	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
 	fieldsToRead := []string{"ID", "Email"}
@@ -415,24 +574,132 @@ func TestClient_Remove(t *testing.T) {
 
 }
 
-func TestClient_Unimplemented(t *testing.T) {
+func TestClient_MultiRemove(t *testing.T) {
 	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	reg2, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1, cte2)
+	e1 := &ClientTestEntity1{ID: int64(1)}
+	e2 := &ClientTestEntity1{ID: int64(2)}
+	e3 := &ClientTestEntity2{UUID: "b1f23fa3-f453-45b4-a5d5-6d73078ac3bd", Color: "blue"}
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	_, err := c1.MultiRemove(ctx, e1)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+
+	// no entities
+	c1.Initialize(ctx)
+	_, err = c1.MultiRemove(ctx)
+	assert.Error(t, err)
+
+	// unregistered object
+	_, err = c1.MultiRemove(ctx, e1, e3)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ClientTestEntity2")
 
-	c := dosaRenamed.NewClient(reg1, nullConnector)
-	/* TODO: Coming in v2.1
-	assert.Panics(t, func() {
-		c.MultiRead(ctx, dosaRenamed.All(), &ClientTestEntity1{})
-	})
-	assert.Panics(t, func() {
-		c.MultiUpsert(ctx, dosaRenamed.All(), &ClientTestEntity1{})
-	})
-	assert.Panics(t, func() {
-		c.MultiRemove(ctx, &ClientTestEntity1{})
-	})
-	*/
-	assert.Panics(t, func() {
-		c.Search(ctx, &dosaRenamed.SearchOp{})
-	})
+	// devnull connector reports every entity as not found
+	result, err := c1.MultiRemove(ctx, e1, e2)
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	assert.True(t, dosaRenamed.ErrorIsNotFound(result[e1]))
+	assert.True(t, dosaRenamed.ErrorIsNotFound(result[e2]))
+
+	// happy path, one call per entity type, mock connector
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	mockConn.EXPECT().MultiRemove(ctx, gomock.Any(), []map[string]dosaRenamed.FieldValue{{"id": e1.ID}, {"id": e2.ID}}).
+		Return([]error{nil, &dosaRenamed.ErrNotFound{}}, nil)
+	mockConn.EXPECT().MultiRemove(ctx, gomock.Any(), []map[string]dosaRenamed.FieldValue{{"uuid": e3.UUID, "color": e3.Color}}).
+		Return([]error{nil}, nil)
+	c2 := dosaRenamed.NewClient(reg2, mockConn)
+	assert.NoError(t, c2.Initialize(ctx))
+	result, err = c2.MultiRemove(ctx, e1, e3, e2)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	assert.True(t, dosaRenamed.ErrorIsNotFound(result[e2]))
+
+	// connector error
+	mockConn.EXPECT().MultiRemove(ctx, gomock.Any(), gomock.Any()).Return(nil, errors.New("connector error"))
+	_, err = c2.MultiRemove(ctx, e1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "connector error")
+
+	// connector returned the wrong number of results
+	mockConn.EXPECT().MultiRemove(ctx, gomock.Any(), gomock.Any()).Return([]error{nil, nil}, nil)
+	_, err = c2.MultiRemove(ctx, e1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "2 results for 1 entities")
+}
+
+func TestClient_Search(t *testing.T) {
+	reg1, _ := dosaRenamed.NewRegistrar(scope, namePrefix, cte1)
+	fieldsToRead := []string{"ID", "Email"}
+	resultRow := map[string]dosaRenamed.FieldValue{
+		"id":    int64(2),
+		"name":  "bar",
+		"email": "bar@email.com",
+	}
+
+	// uninitialized
+	c1 := dosaRenamed.NewClient(reg1, nullConnector)
+	sop := dosaRenamed.NewSearchOp(cte1).By("Email", "bar@email.com").Fields(fieldsToRead)
+	_, _, err := c1.Search(ctx, sop)
+	assert.True(t, dosaRenamed.ErrorIsNotInitialized(err))
+
+	c1.Initialize(ctx)
+
+	// bad entity
+	_, _, err = c1.Search(ctx, dosaRenamed.NewSearchOp(cte2).By("Color", "blue"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ClientTestEntity2")
+
+	// no search field
+	_, _, err = c1.Search(ctx, dosaRenamed.NewSearchOp(cte1))
+	assert.Error(t, err)
+
+	// unknown search field
+	_, _, err = c1.Search(ctx, dosaRenamed.NewSearchOp(cte1).By("borkborkbork", "x"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "borkborkbork")
+
+	// field is not searchable
+	_, _, err = c1.Search(ctx, dosaRenamed.NewSearchOp(cte1).By("Name", "bar"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not searchable")
+
+	// wrong value type
+	_, _, err = c1.Search(ctx, dosaRenamed.NewSearchOp(cte1).By("Email", int64(1)))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Email")
+
+	// bad projected column
+	_, _, err = c1.Search(ctx, dosaRenamed.NewSearchOp(cte1).By("Email", "bar@email.com").Fields([]string{"borkborkbork"}))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "borkborkbork")
+
+	// success case
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().CheckSchema(ctx, gomock.Any(), gomock.Any(), gomock.Any()).Return(int32(1), nil).AnyTimes()
+	mockConn.EXPECT().Search(ctx, gomock.Any(), dosaRenamed.FieldNameValuePair{Name: "email", Value: "bar@email.com"}, []string{"id", "email"}, "tokeytoketoke", 10).
+		Return([]map[string]dosaRenamed.FieldValue{resultRow}, "continuation-token", nil)
+	c2 := dosaRenamed.NewClient(reg1, mockConn)
+	c2.Initialize(ctx)
+	rows, token, err := c2.Search(ctx, sop.Offset("tokeytoketoke").Limit(10))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, resultRow["id"], rows[0].(*ClientTestEntity1).ID)
+	assert.Equal(t, resultRow["email"], rows[0].(*ClientTestEntity1).Email)
+	assert.Equal(t, "continuation-token", token)
+
+	// connector error
+	mockConn.EXPECT().Search(ctx, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil, "", errors.New("connector error"))
+	_, _, err = c2.Search(ctx, sop)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "connector error")
 }
 
 func TestAdminClient_CreateScope(t *testing.T) {
@@ -459,6 +726,26 @@ func TestAdminClient_DropScope(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAdminClient_ScopeExists(t *testing.T) {
+	c := dosaRenamed.NewAdminClient(nullConnector)
+	assert.NotNil(t, c)
+
+	exists, err := c.ScopeExists(context.TODO(), scope)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestAdminClient_ListScopes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().ListScopes(context.TODO(), "owner").Return([]string{"a", "b"}, nil)
+
+	scopes, err := dosaRenamed.NewAdminClient(mockConn).ListScopes(context.TODO(), "owner")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, scopes)
+}
+
 func TestAdminClient_CheckSchema(t *testing.T) {
 	// write some entities to disk
 	tmpdir := ".testcheckschema"
@@ -745,3 +1032,51 @@ func TestErrorIsAlreadyExists(t *testing.T) {
 	assert.True(t, dosaRenamed.ErrorIsAlreadyExists(errors.Wrap(&dosaRenamed.ErrAlreadyExists{}, "wrapped")))
 	assert.Equal(t, "already exists", (&dosaRenamed.ErrAlreadyExists{}).Error())
 }
+
+func TestErrorIsRetryable(t *testing.T) {
+	assert.False(t, dosaRenamed.ErrorIsRetryable(errors.New("not a retryable error")))
+	assert.False(t, dosaRenamed.ErrorIsRetryable(&dosaRenamed.ErrNotFound{}))
+	assert.True(t, dosaRenamed.ErrorIsRetryable(errors.Wrap(&dosaRenamed.ErrRetryable{Err: errors.New("timeout")}, "wrapped")))
+	assert.Equal(t, "retryable error: timeout", (&dosaRenamed.ErrRetryable{Err: errors.New("timeout")}).Error())
+}
+
+func TestErrorIsInvalidRequest(t *testing.T) {
+	assert.False(t, dosaRenamed.ErrorIsInvalidRequest(errors.New("not an invalid request")))
+	assert.True(t, dosaRenamed.ErrorIsInvalidRequest(errors.Wrap(&dosaRenamed.ErrInvalidRequest{Err: errors.New("bad")}, "wrapped")))
+	assert.Equal(t, "invalid request: bad", (&dosaRenamed.ErrInvalidRequest{Err: errors.New("bad")}).Error())
+	assert.Equal(t, `invalid request for column "id": bad`, (&dosaRenamed.ErrInvalidRequest{Column: "id", Err: errors.New("bad")}).Error())
+}
+
+func TestTypedErrors(t *testing.T) {
+	cause := errors.New("cause")
+	tcs := []struct {
+		err   error
+		is    func(error) bool
+		error string
+	}{
+		{&dosaRenamed.ErrSchemaMismatch{Err: cause}, dosaRenamed.ErrorIsSchemaMismatch, "schema mismatch: cause"},
+		{&dosaRenamed.ErrTimeout{Err: cause}, dosaRenamed.ErrorIsTimeout, "timeout: cause"},
+		{&dosaRenamed.ErrThrottled{Err: cause}, dosaRenamed.ErrorIsThrottled, "throttled: cause"},
+		{&dosaRenamed.ErrInternal{Err: cause}, dosaRenamed.ErrorIsInternal, "internal error: cause"},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.error, tc.err.Error())
+		assert.True(t, tc.is(tc.err))
+		assert.True(t, tc.is(errors.Wrap(tc.err, "wrapped")))
+		assert.False(t, tc.is(cause))
+		assert.False(t, tc.is(&dosaRenamed.ErrNotFound{}))
+		assert.Equal(t, cause, errors.Cause(tc.err))
+	}
+}
+
+func TestErrorCause(t *testing.T) {
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(&dosaRenamed.ErrTimeout{Err: context.DeadlineExceeded}))
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(errors.Wrap(&dosaRenamed.ErrTimeout{Err: context.DeadlineExceeded}, "wrapped")))
+
+	retryable := &dosaRenamed.ErrRetryable{Err: &dosaRenamed.ErrNotFound{}}
+	assert.True(t, dosaRenamed.ErrorIsNotFound(retryable))
+	assert.True(t, dosaRenamed.ErrorIsRetryable(retryable))
+	assert.True(t, dosaRenamed.ErrorIsNotFound(errors.Wrap(retryable, "wrapped")))
+	assert.True(t, dosaRenamed.ErrorIsRetryable(errors.Wrap(retryable, "wrapped")))
+	assert.False(t, dosaRenamed.ErrorIsAlreadyExists(retryable))
+}
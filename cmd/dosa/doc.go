@@ -47,10 +47,23 @@ Create a new scope called "infra_dev":
 
 	$ dosa scope create infra_dev
 
+Check whether the "infra_dev" scope exists:
+
+	$ dosa scope exists infra_dev
+
 List all scopes created by $USER (you):
 
 	$ dosa scope list
 
+List all scopes created by anyone:
+
+	$ dosa scope list --all
+
+Listing scopes is not supported by the gateway yet: the gateway API has no
+operation to list scopes and does not record their owners, so with the default
+yarpc connector "dosa scope list" fails with an "is not supported" error. Only
+connectors that record scope owners, such as memory and file, can list scopes.
+
 Scope subcommand usage:
 
 	$ dosa scope help
@@ -75,6 +88,17 @@ Upsert schema with prefix "oss.user" to the "infra_dev" scope:
 	$ dosa schema upsert -s infra_dev -np oss.user
 
 
+Running a Local Gateway:
+
+Serve the gateway API on the default host:port, storing data in memory:
+
+	$ dosa gateway serve
+
+Serve the gateway API over HTTP on port 8080, storing data in the "dosa-data" directory:
+
+	$ dosa --transport http -p 8080 gateway serve --backend file --directory dosa-data
+
+
 Code Generation:
 
 TODO
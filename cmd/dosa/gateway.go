@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/gateway"
+	rpc "go.uber.org/yarpc"
+	"go.uber.org/yarpc/api/transport"
+	"go.uber.org/yarpc/transport/http"
+	"go.uber.org/yarpc/transport/tchannel"
+)
+
+// waitForShutdown blocks until the gateway should stop, for testing, we make
+// it an overridable routine
+var waitForShutdown = func() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+}
+
+// GatewayOptions contains configuration for gateway command flags
+type GatewayOptions struct{}
+
+// GatewayServe contains data for executing the gateway serve command.
+type GatewayServe struct {
+	Backend   string `long:"backend" default:"memory" description:"Name of the connector storing the data. Options: memory, file, devnull."`
+	Directory string `long:"directory" default:"dosa-data" description:"Directory holding the data of the file backend."`
+}
+
+// Execute executes a gateway serve command
+func (c *GatewayServe) Execute(args []string) error {
+	// set default service name if one isn't provided, same as scope commands
+	if options.ServiceName == "" {
+		options.ServiceName = _defServiceName // defined in options.go
+	}
+
+	conn, err := dosa.GetConnector(c.Backend, map[string]interface{}{
+		"directory": c.Directory,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot create %q backend", c.Backend)
+	}
+	defer conn.Shutdown()
+
+	hostPort := fmt.Sprintf("%s:%s", options.Host, options.Port)
+	var inbound transport.Inbound
+	switch options.Transport {
+	case "http":
+		inbound = http.NewTransport().NewInbound(hostPort)
+	case "tchannel":
+		ts, err := tchannel.NewChannelTransport(tchannel.ServiceName(options.ServiceName), tchannel.ListenAddr(hostPort))
+		if err != nil {
+			return err
+		}
+		inbound = ts.NewInbound()
+	default:
+		return errors.New("invalid transport (only http or tchannel supported)")
+	}
+
+	dispatcher := rpc.NewDispatcher(rpc.Config{
+		Name:     options.ServiceName,
+		Inbounds: rpc.Inbounds{inbound},
+	})
+	dispatcher.Register(gateway.NewServer(conn).Procedures())
+	if err := dispatcher.Start(); err != nil {
+		return errors.Wrap(err, "cannot start gateway")
+	}
+	defer dispatcher.Stop()
+
+	fmt.Printf("serving %q on %s %s with %q backend\n", options.ServiceName, options.Transport, hostPort, c.Backend)
+	waitForShutdown()
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_Serve(t *testing.T) {
+	waited := 0
+	waitForShutdown = func() { waited++ }
+
+	// memory backend over tchannel by default, listen on any free port
+	exit = func(r int) {
+		assert.Equal(t, 0, r)
+	}
+	os.Args = []string{"dosa", "--service", "", "-p", "0", "gateway", "serve"}
+	c := StartCapture()
+	main()
+	output := c.stop(false)
+	assert.Contains(t, output, `"dosa-dev-gateway" on tchannel 127.0.0.1:0`)
+	assert.Contains(t, output, `"memory" backend`)
+
+	// file backend over http
+	dir, err := ioutil.TempDir("", "dosa-gateway")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	os.Args = []string{"dosa", "--service", "foo", "--transport", "http", "-p", "0", "gateway", "serve",
+		"--backend", "file", "--directory", filepath.Join(dir, "data")}
+	c = StartCapture()
+	main()
+	output = c.stop(false)
+	assert.Contains(t, output, `"foo" on http 127.0.0.1:0`)
+	assert.Contains(t, output, `"file" backend`)
+	_, err = os.Stat(filepath.Join(dir, "data"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, waited)
+}
+
+func TestGateway_ServeErrors(t *testing.T) {
+	waitForShutdown = func() {
+		t.Error("gateway should not have started")
+	}
+	exit = func(r int) {
+		assert.Equal(t, 1, r)
+	}
+
+	// unknown backend
+	os.Args = []string{"dosa", "--service", "", "gateway", "serve", "--backend", "nope"}
+	c := StartCapture()
+	main()
+	assert.Contains(t, c.stop(true), "nope")
+
+	// invalid transport
+	os.Args = []string{"dosa", "--service", "", "--transport", "carrier-pigeon", "gateway", "serve"}
+	c = StartCapture()
+	main()
+	assert.Contains(t, c.stop(true), "invalid transport")
+}
@@ -25,6 +25,9 @@ import (
 	"os"
 
 	flags "github.com/jessevdk/go-flags"
+	_ "github.com/uber-go/dosa/connectors/devnull"
+	_ "github.com/uber-go/dosa/connectors/file"
+	_ "github.com/uber-go/dosa/connectors/memory"
 	_ "github.com/uber-go/dosa/connectors/yarpc"
 )
 
@@ -53,10 +56,12 @@ func main() {
 	OptionsParser.ShortDescription = "DOSA CLI - The command-line tool for your DOSA client"
 	OptionsParser.LongDescription = `
 dosa manages your schema both in production and development scopes`
-	c, _ := OptionsParser.AddCommand("scope", "commands to manage scope", "create, drop, or truncate development scopes", &ScopeOptions{})
+	c, _ := OptionsParser.AddCommand("scope", "commands to manage scope", "create, drop, truncate, check or list development scopes", &ScopeOptions{})
 	_, _ = c.AddCommand("create", "Create scope", "creates a new scope", &ScopeCreate{})
 	_, _ = c.AddCommand("drop", "Drop scope", "drops a scope", &ScopeDrop{})
 	_, _ = c.AddCommand("truncate", "Truncate scope", "truncates a scope", &ScopeTruncate{})
+	_, _ = c.AddCommand("exists", "Check scope existence", "checks whether scopes exist", &ScopeExists{})
+	_, _ = c.AddCommand("list", "List scopes", "lists the scopes owned by a user; not supported by the yarpc connector", &ScopeList{})
 
 	c, _ = OptionsParser.AddCommand("schema", "commands to manage schemas", "check or update schemas", &SchemaOptions{})
 	_, _ = c.AddCommand("check", "Check schema", "check the schema", &SchemaCheck{})
@@ -64,6 +69,9 @@ dosa manages your schema both in production and development scopes`
 	_, _ = c.AddCommand("dump", "Dump schema", "display the schema in a given format", &SchemaDump{})
 	_, _ = c.AddCommand("status", "Check schema status", "Check application status of schema", &SchemaStatus{})
 
+	c, _ = OptionsParser.AddCommand("gateway", "commands to run a gateway", "run a local gateway for development", &GatewayOptions{})
+	_, _ = c.AddCommand("serve", "Serve gateway", "serves the gateway API backed by a local connector", &GatewayServe{})
+
 	_, err := OptionsParser.Parse()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
@@ -23,6 +23,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/pkg/errors"
 	"github.com/uber-go/dosa"
@@ -34,7 +35,7 @@ type ScopeOptions struct{}
 // ScopeCmd is a placeholder for all scope commands
 type ScopeCmd struct{}
 
-func (c *ScopeCmd) doScopeOp(name string, f func(dosa.AdminClient, context.Context, string) error, scopes []string) error {
+func (c *ScopeCmd) adminClient() (dosa.AdminClient, error) {
 	// set default service name if one isn't provided, this is done here instead
 	// of in the struct tags because schema and scope commands differ slightly
 	// in how the service name should be inferred.
@@ -42,7 +43,11 @@ func (c *ScopeCmd) doScopeOp(name string, f func(dosa.AdminClient, context.Conte
 		options.ServiceName = _defServiceName // defined in options.go
 	}
 
-	client, err := getAdminClient(options)
+	return getAdminClient(options)
+}
+
+func (c *ScopeCmd) doScopeOp(name string, f func(dosa.AdminClient, context.Context, string) error, scopes []string) error {
+	client, err := c.adminClient()
 	if err != nil {
 		return err
 	}
@@ -67,7 +72,10 @@ type ScopeCreate struct {
 
 // Execute executes a scope create command
 func (c *ScopeCreate) Execute(args []string) error {
-	return c.doScopeOp("create", dosa.AdminClient.CreateScope, c.Args.Scopes)
+	// the scopes are owned by the user creating them
+	return c.doScopeOp("create", func(client dosa.AdminClient, ctx context.Context, scope string) error {
+		return client.CreateScope(dosa.WithScopeOwner(ctx, os.Getenv("USER")), scope)
+	}, c.Args.Scopes)
 }
 
 // ScopeDrop contains data for executing scope drop command.
@@ -95,3 +103,63 @@ type ScopeTruncate struct {
 func (c *ScopeTruncate) Execute(args []string) error {
 	return c.doScopeOp("truncate", dosa.AdminClient.TruncateScope, c.Args.Scopes)
 }
+
+// ScopeExists contains data for executing scope exists command.
+type ScopeExists struct {
+	*ScopeCmd
+	Args struct {
+		Scopes []string `positional-arg-name:"scopes" required:"1"`
+	} `positional-args:"yes" required:"1"`
+}
+
+// Execute executes a scope exists command
+func (c *ScopeExists) Execute(args []string) error {
+	client, err := c.adminClient()
+	if err != nil {
+		return err
+	}
+	for _, s := range c.Args.Scopes {
+		ctx, cancel := context.WithTimeout(context.Background(), options.Timeout.Duration())
+		defer cancel()
+		exists, err := client.ScopeExists(ctx, s)
+		if err != nil {
+			return errors.Wrapf(err, "exists scope on %q", s)
+		}
+		fmt.Printf("scope %q exists: %t\n", s, exists)
+	}
+	return nil
+}
+
+// ScopeList contains data for executing scope list command. Only connectors
+// that track scope owners, such as memory and file, can list scopes; the
+// yarpc connector used against a gateway cannot.
+type ScopeList struct {
+	*ScopeCmd
+	Owner string `long:"owner" description:"List the scopes owned by this user instead of $USER."`
+	All   bool   `long:"all" description:"List the scopes of all owners."`
+}
+
+// Execute executes a scope list command
+func (c *ScopeList) Execute(args []string) error {
+	owner := c.Owner
+	if c.All {
+		owner = ""
+	} else if owner == "" {
+		owner = os.Getenv("USER")
+	}
+
+	client, err := c.adminClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout.Duration())
+	defer cancel()
+	scopes, err := client.ListScopes(ctx, owner)
+	if err != nil {
+		return errors.Wrapf(err, "list scopes with connector %q", options.Connector)
+	}
+	for _, s := range scopes {
+		fmt.Println(s)
+	}
+	return nil
+}
@@ -21,6 +21,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -28,6 +29,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
 	"github.com/uber-go/dosa/mocks"
 )
 
@@ -156,3 +158,127 @@ func TestScopeTruncate_Execute(t *testing.T) {
 	main()
 	assert.Contains(t, c.stop(true), "\"one_fish\"")
 }
+
+func TestScopeExists_Execute(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// success case
+	c := StartCapture()
+	exit = func(r int) {
+		assert.Equal(t, 0, r)
+	}
+	dosa.RegisterConnector("mock", func(map[string]interface{}) (dosa.Connector, error) {
+		mc := mocks.NewMockConnector(ctrl)
+		mc.EXPECT().ScopeExists(gomock.Any(), "one_fish").Return(true, nil)
+		mc.EXPECT().ScopeExists(gomock.Any(), "two_fish").Return(false, nil)
+		return mc, nil
+	})
+	os.Args = []string{"dosa", "--connector", "mock", "scope", "exists", "one_fish", "two_fish"}
+	main()
+	output := c.stop(false)
+	assert.Contains(t, output, "scope \"one_fish\" exists: true")
+	assert.Contains(t, output, "scope \"two_fish\" exists: false")
+
+	// failure case
+	c = StartCapture()
+	exit = func(r int) {
+		assert.Equal(t, 1, r)
+	}
+	dosa.RegisterConnector("mock", func(map[string]interface{}) (dosa.Connector, error) {
+		mc := mocks.NewMockConnector(ctrl)
+		mc.EXPECT().ScopeExists(gomock.Any(), gomock.Any()).Return(false, errors.New("oops"))
+		return mc, nil
+	})
+	os.Args = []string{"dosa", "--connector", "mock", "scope", "exists", "one_fish"}
+	main()
+	assert.Contains(t, c.stop(true), "oops")
+}
+
+func TestScopeList_Execute(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	exit = func(r int) {
+		assert.Equal(t, 0, r)
+	}
+	for _, tc := range []struct {
+		args  []string
+		owner string
+	}{
+		{owner: os.Getenv("USER")},
+		{args: []string{"--owner", "someone"}, owner: "someone"},
+		{args: []string{"--all"}, owner: ""},
+	} {
+		c := StartCapture()
+		dosa.RegisterConnector("mock", func(map[string]interface{}) (dosa.Connector, error) {
+			mc := mocks.NewMockConnector(ctrl)
+			mc.EXPECT().ListScopes(gomock.Any(), tc.owner).Return([]string{"one_fish", "two_fish"}, nil)
+			return mc, nil
+		})
+		os.Args = append([]string{"dosa", "--connector", "mock", "scope", "list"}, tc.args...)
+		main()
+		assert.Equal(t, "one_fish\ntwo_fish\n", c.stop(false))
+	}
+
+	// failure case
+	c := StartCapture()
+	exit = func(r int) {
+		assert.Equal(t, 1, r)
+	}
+	dosa.RegisterConnector("mock", func(map[string]interface{}) (dosa.Connector, error) {
+		mc := mocks.NewMockConnector(ctrl)
+		mc.EXPECT().ListScopes(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+		return mc, nil
+	})
+	os.Args = []string{"dosa", "--connector", "mock", "scope", "list"}
+	main()
+	output := c.stop(true)
+	assert.Contains(t, output, "oops")
+	assert.Contains(t, output, `connector "mock"`)
+}
+
+func TestScopeList_Yarpc(t *testing.T) {
+	exit = func(r int) {
+		assert.Equal(t, 1, r)
+	}
+	// the default connector cannot list scopes, and says so
+	c := StartCapture()
+	os.Args = []string{"dosa", "scope", "list"}
+	main()
+	output := c.stop(true)
+	assert.Contains(t, output, `connector "yarpc"`)
+	assert.Contains(t, output, "not supported")
+}
+
+func TestScopeList_Owner(t *testing.T) {
+	exit = func(r int) {
+		assert.Equal(t, 0, r)
+	}
+	defer os.Setenv("USER", os.Getenv("USER"))
+	assert.NoError(t, os.Setenv("USER", "me"))
+
+	// one connector shared by all the commands, so they see each other's
+	// scopes
+	conn := memory.NewConnector()
+	assert.NoError(t, conn.CreateScope(dosa.WithScopeOwner(context.Background(), "someone_else"), "their_scope"))
+	dosa.RegisterConnector("shared", func(map[string]interface{}) (dosa.Connector, error) {
+		return conn, nil
+	})
+
+	os.Args = []string{"dosa", "--connector", "shared", "scope", "create", "my_scope"}
+	c := StartCapture()
+	main()
+	assert.Contains(t, c.stop(false), "\"my_scope\": OK")
+
+	// the scopes created by $USER
+	os.Args = []string{"dosa", "--connector", "shared", "scope", "list"}
+	c = StartCapture()
+	main()
+	assert.Equal(t, "my_scope\n", c.stop(false))
+
+	os.Args = []string{"dosa", "--connector", "shared", "scope", "list", "--all"}
+	c = StartCapture()
+	main()
+	assert.Equal(t, "my_scope\ntheir_scope\n", c.stop(false))
+}
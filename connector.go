@@ -131,7 +131,7 @@ type Connector interface {
 
 	// Datastore management
 	// CreateScope creates a scope for storage of data, usually implemented by a keyspace for this data
-	// This is usually followed by UpsertSchema
+	// This is usually followed by UpsertSchema. Connectors tracking scope owners record ScopeOwner(ctx).
 	CreateScope(ctx context.Context, scope string) error
 	// TruncateScope keeps the scope around, but removes all the data
 	TruncateScope(ctx context.Context, scope string) error
@@ -139,11 +139,27 @@ type Connector interface {
 	DropScope(ctx context.Context, scope string) error
 	// ScopeExists checks whether a scope exists or not
 	ScopeExists(ctx context.Context, scope string) (bool, error)
+	// ListScopes returns the names of the scopes, only those owned by owner if it is not empty
+	ListScopes(ctx context.Context, owner string) ([]string, error)
 
 	// Shutdown finishes the connector to do clean up work
 	Shutdown() error
 }
 
+type scopeOwnerKey struct{}
+
+// WithScopeOwner returns a context that makes CreateScope record owner as
+// the owner of the new scope, on connectors that track scope owners
+func WithScopeOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, scopeOwnerKey{}, owner)
+}
+
+// ScopeOwner returns the owner set with WithScopeOwner, or "" if there is none
+func ScopeOwner(ctx context.Context) string {
+	owner, _ := ctx.Value(scopeOwnerKey{}).(string)
+	return owner
+}
+
 // CreationFuncType is the type of a creation function that creates an instance of a registered connector
 type CreationFuncType func(map[string]interface{}) (Connector, error)
 
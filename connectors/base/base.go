@@ -181,6 +181,14 @@ func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error)
 	return c.Next.ScopeExists(ctx, scope)
 }
 
+// ListScopes calls Next
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	if c.Next == nil {
+		return nil, ErrNoMoreConnector{}
+	}
+	return c.Next.ListScopes(ctx, owner)
+}
+
 // Shutdown always returns nil
 func (c *Connector) Shutdown() error {
 	if c.Next == nil {
@@ -0,0 +1,359 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+const (
+	// DefaultWindow is how long the first Read of a batch waits for others
+	DefaultWindow = time.Millisecond
+	// DefaultMaxBatch is the number of distinct keys that sends a batch
+	// right away
+	DefaultMaxBatch = 100
+)
+
+// Config controls how Reads are batched
+type Config struct {
+	// Window is how long the first Read of a batch waits for others
+	Window time.Duration
+	// MaxBatch is the number of distinct keys that sends a batch before
+	// the window ends
+	MaxBatch int
+}
+
+// batchContext is the context of a MultiRead. It has the values of the
+// first caller of the batch and the latest deadline of all the callers,
+// which moves as callers join. It is not cancelled by any of them.
+type batchContext struct {
+	values context.Context
+	done   chan struct{}
+
+	lock     sync.Mutex
+	deadline time.Time
+	// unbounded is set when a caller has no deadline
+	unbounded bool
+	timer     *time.Timer
+	err       error
+}
+
+func newBatchContext(values context.Context) *batchContext {
+	return &batchContext{values: values, done: make(chan struct{})}
+}
+
+// Deadline satisfies context.Context
+func (bc *batchContext) Deadline() (time.Time, bool) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	return bc.deadline, !bc.unbounded && !bc.deadline.IsZero()
+}
+
+// Done satisfies context.Context
+func (bc *batchContext) Done() <-chan struct{} {
+	return bc.done
+}
+
+// Err satisfies context.Context
+func (bc *batchContext) Err() error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	return bc.err
+}
+
+// Value satisfies context.Context with the values of the first caller
+func (bc *batchContext) Value(key interface{}) interface{} {
+	return bc.values.Value(key)
+}
+
+// join extends the deadline to the one of ctx. It returns false when the
+// context already ended and the caller cannot share it.
+func (bc *batchContext) join(ctx context.Context) bool {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	if bc.err != nil {
+		return false
+	}
+	if bc.unbounded {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		bc.unbounded = true
+		if bc.timer != nil {
+			bc.timer.Stop()
+		}
+		return true
+	}
+	if deadline.After(bc.deadline) {
+		bc.deadline = deadline
+		if bc.timer != nil {
+			bc.timer.Stop()
+		}
+		bc.timer = time.AfterFunc(deadline.Sub(time.Now()), bc.expire)
+	}
+	return true
+}
+
+// expire ends the context once its deadline passed
+func (bc *batchContext) expire() {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	// a caller may have moved the deadline while the timer fired
+	if !bc.unbounded && !time.Now().Before(bc.deadline) {
+		bc.end(context.DeadlineExceeded)
+	}
+}
+
+// cancel ends the context with err, unless it already ended
+func (bc *batchContext) cancel(err error) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	bc.end(err)
+}
+
+// end ends the context with err, unless it already ended. The caller must
+// hold the lock.
+func (bc *batchContext) end(err error) {
+	if bc.err != nil {
+		return
+	}
+	bc.err = err
+	if bc.timer != nil {
+		bc.timer.Stop()
+	}
+	close(bc.done)
+}
+
+// call is a Read of one row, shared by all callers reading the row while it
+// is in flight
+type call struct {
+	ctx    *batchContext
+	done   chan struct{}
+	values map[string]dosa.FieldValue
+	err    error
+}
+
+// batch is a set of Reads sent as one MultiRead
+type batch struct {
+	ctx     *batchContext
+	ei      *dosa.EntityInfo
+	fields  []string
+	keys    []map[string]dosa.FieldValue
+	calls   []*call
+	rowKeys []string
+	timer   *time.Timer
+}
+
+// Connector coalesces concurrent Reads of the same entity into MultiReads.
+// The first Read of a batch waits for up to the window for other Reads of
+// the same entity and fields; the batch is sent earlier when it reaches the
+// maximum size. Concurrent Reads of the same row share a single read.
+//
+// The MultiRead runs on its own context, with the values of the first caller
+// and the latest deadline of all the callers, including those joining a read
+// in flight, so a caller giving up does not fail the others; each caller
+// returns as soon as its own context is done.
+type Connector struct {
+	base.Connector
+	window   time.Duration
+	maxBatch int
+
+	lock     sync.Mutex
+	pending  map[string]*batch
+	inflight map[string]*call
+	running  sync.WaitGroup
+}
+
+// NewConnector creates a batching connector in front of next
+func NewConnector(next dosa.Connector, cfg Config) *Connector {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+	if cfg.MaxBatch <= 0 {
+		cfg.MaxBatch = DefaultMaxBatch
+	}
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		window:    cfg.Window,
+		maxBatch:  cfg.MaxBatch,
+		pending:   map[string]*batch{},
+		inflight:  map[string]*call{},
+	}
+}
+
+// batchKey identifies the Reads that can share a MultiRead
+func batchKey(ref *dosa.SchemaRef, fieldsToRead []string) string {
+	key := fmt.Sprintf("%q/%q/%q/%d", ref.Scope, ref.NamePrefix, ref.EntityName, ref.Version)
+	if len(fieldsToRead) == 0 {
+		return key + "/*"
+	}
+	return key + fmt.Sprintf("/%q", fieldsToRead)
+}
+
+// rowKey identifies a row within a batch
+func rowKey(keys map[string]dosa.FieldValue) string {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&b, "/%q=%T:", name, keys[name])
+		switch v := keys[name].(type) {
+		case []byte:
+			fmt.Fprintf(&b, "%x", v)
+		case time.Time:
+			fmt.Fprintf(&b, "%d", v.UnixNano())
+		default:
+			fmt.Fprintf(&b, "%#v", v)
+		}
+	}
+	return b.String()
+}
+
+// Read joins the batch of its entity, or the read of the same row if one is
+// in flight, and waits for the result
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	if ei == nil || ei.Ref == nil {
+		return c.Connector.Read(ctx, ei, keys, fieldsToRead)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	bk := batchKey(ei.Ref, fieldsToRead)
+	rk := bk + rowKey(keys)
+
+	c.lock.Lock()
+	cl, ok := c.inflight[rk]
+	if !ok || !cl.ctx.join(ctx) {
+		b := c.pending[bk]
+		if b != nil && !b.ctx.join(ctx) {
+			// the deadline of the batch passed before it was sent; send it
+			// now so it fails, and start another one
+			c.send(bk, b)
+			b = nil
+		}
+		if b == nil {
+			b = &batch{ctx: newBatchContext(ctx), ei: ei, fields: fieldsToRead}
+			b.ctx.join(ctx)
+			c.pending[bk] = b
+			b.timer = time.AfterFunc(c.window, func() { c.flush(bk, b) })
+		}
+		cl = &call{ctx: b.ctx, done: make(chan struct{})}
+		c.inflight[rk] = cl
+		b.keys = append(b.keys, keys)
+		b.calls = append(b.calls, cl)
+		b.rowKeys = append(b.rowKeys, rk)
+		if len(b.keys) >= c.maxBatch {
+			c.send(bk, b)
+		}
+	}
+	c.lock.Unlock()
+
+	select {
+	case <-cl.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if cl.err != nil {
+		return nil, cl.err
+	}
+	// callers sharing the read get their own map
+	values := make(map[string]dosa.FieldValue, len(cl.values))
+	for name, value := range cl.values {
+		values[name] = value
+	}
+	return values, nil
+}
+
+// flush sends a batch when its window ends, unless it was already sent
+func (c *Connector) flush(bk string, b *batch) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.pending[bk] == b {
+		c.send(bk, b)
+	}
+}
+
+// send starts the MultiRead of a batch. The caller must hold the lock.
+func (c *Connector) send(bk string, b *batch) {
+	delete(c.pending, bk)
+	b.timer.Stop()
+	c.running.Add(1)
+	go c.run(b)
+}
+
+// run reads the rows of a batch and hands the results to the callers
+func (c *Connector) run(b *batch) {
+	defer c.running.Done()
+
+	defer b.ctx.cancel(context.Canceled)
+	results, err := c.Connector.MultiRead(b.ctx, b.ei, b.keys, b.fields)
+	if err == nil && len(results) != len(b.keys) {
+		err = errors.Errorf("MultiRead returned %d results for %d keys", len(results), len(b.keys))
+	}
+
+	c.lock.Lock()
+	for i, cl := range b.calls {
+		switch {
+		case err != nil:
+			cl.err = err
+		case results[i] == nil:
+			cl.err = errors.New("MultiRead returned no result")
+		default:
+			cl.values, cl.err = results[i].Values, results[i].Error
+		}
+		// a later caller may have replaced a call whose context ended
+		if c.inflight[b.rowKeys[i]] == cl {
+			delete(c.inflight, b.rowKeys[i])
+		}
+	}
+	c.lock.Unlock()
+
+	for _, cl := range b.calls {
+		close(cl.done)
+	}
+}
+
+// Shutdown sends the pending batches, waits for all batches to complete and
+// shuts down the next connector
+func (c *Connector) Shutdown() error {
+	c.lock.Lock()
+	for bk, b := range c.pending {
+		c.send(bk, b)
+	}
+	c.lock.Unlock()
+	c.running.Wait()
+	return c.Connector.Shutdown()
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batch_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/batch"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{Scope: "scope", NamePrefix: "prefix", EntityName: "user"},
+	Def: &dosa.EntityDefinition{
+		Name: "user",
+		Key:  &dosa.PrimaryKey{PartitionKeys: []string{"id"}},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "id", Type: dosa.Int64},
+			{Name: "name", Type: dosa.String},
+		},
+	},
+}
+
+func keys(id int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"id": id}
+}
+
+type ctxKey struct{}
+
+// counting records the size and the context value of each MultiRead and can
+// hold them until released or until their context ends
+type counting struct {
+	*memory.Connector
+	lock    sync.Mutex
+	batches []int
+	values  []interface{}
+	gate    chan struct{}
+}
+
+func (c *counting) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	c.lock.Lock()
+	c.batches = append(c.batches, len(keys))
+	c.values = append(c.values, ctx.Value(ctxKey{}))
+	c.lock.Unlock()
+	if c.gate != nil {
+		select {
+		case <-c.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+}
+
+func (c *counting) sizes() []int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]int(nil), c.batches...)
+}
+
+func newCounting(t *testing.T, rows int) *counting {
+	mem := memory.NewConnector()
+	for i := 0; i < rows; i++ {
+		assert.NoError(t, mem.Upsert(ctx, testEi, map[string]dosa.FieldValue{"id": int64(i), "name": fmt.Sprintf("user%d", i)}))
+	}
+	return &counting{Connector: mem}
+}
+
+// readAll reads the given ids concurrently and returns the names read
+func readAll(t *testing.T, c *batch.Connector, ids []int64) []dosa.FieldValue {
+	names := make([]dosa.FieldValue, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id int64) {
+			defer wg.Done()
+			values, err := c.Read(ctx, testEi, keys(id), nil)
+			if err != nil {
+				names[i] = err
+				return
+			}
+			names[i] = values["name"]
+		}(i, id)
+	}
+	wg.Wait()
+	return names
+}
+
+func TestCoalesce(t *testing.T) {
+	next := newCounting(t, 10)
+	c := batch.NewConnector(next, batch.Config{Window: 50 * time.Millisecond})
+
+	names := readAll(t, c, []int64{0, 1, 2, 3, 4})
+	for i, name := range names {
+		assert.Equal(t, fmt.Sprintf("user%d", i), name)
+	}
+	assert.Equal(t, []int{5}, next.sizes())
+
+	_, err := c.Read(ctx, testEi, keys(42), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.NoError(t, c.Shutdown())
+}
+
+func TestMaxBatch(t *testing.T) {
+	next := newCounting(t, 10)
+	c := batch.NewConnector(next, batch.Config{Window: time.Hour, MaxBatch: 2})
+
+	names := readAll(t, c, []int64{0, 1, 2, 3})
+	assert.Equal(t, []dosa.FieldValue{"user0", "user1", "user2", "user3"}, names)
+	assert.Equal(t, []int{2, 2}, next.sizes())
+}
+
+func TestDeduplicate(t *testing.T) {
+	next := newCounting(t, 10)
+	next.gate = make(chan struct{})
+	c := batch.NewConnector(next, batch.Config{Window: 10 * time.Millisecond})
+
+	first, second := make(chan []dosa.FieldValue), make(chan []dosa.FieldValue)
+	go func() { first <- readAll(t, c, []int64{7, 7, 7}) }()
+	// wait for the batch to be in flight, then read the same row again
+	for len(next.sizes()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() { second <- readAll(t, c, []int64{7}) }()
+	time.Sleep(20 * time.Millisecond)
+	close(next.gate)
+
+	assert.Equal(t, []dosa.FieldValue{"user7", "user7", "user7"}, <-first)
+	assert.Equal(t, []dosa.FieldValue{"user7"}, <-second)
+	assert.Equal(t, []int{1}, next.sizes())
+}
+
+func TestCallerContext(t *testing.T) {
+	next := newCounting(t, 10)
+	next.gate = make(chan struct{})
+	c := batch.NewConnector(next, batch.Config{Window: time.Millisecond})
+
+	short, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+	shortErr := make(chan error)
+	go func() {
+		_, err := c.Read(short, testEi, keys(1), nil)
+		shortErr <- err
+	}()
+	patient := make(chan dosa.FieldValue)
+	go func() {
+		values, err := c.Read(ctx, testEi, keys(2), nil)
+		assert.NoError(t, err)
+		patient <- values["name"]
+	}()
+
+	// the short caller gives up while the batch is held
+	assert.Equal(t, context.DeadlineExceeded, <-shortErr)
+	close(next.gate)
+	assert.Equal(t, "user2", <-patient)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := c.Read(canceled, testEi, keys(1), nil)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestBatchContext(t *testing.T) {
+	next := newCounting(t, 10)
+	next.gate = make(chan struct{})
+	c := batch.NewConnector(next, batch.Config{Window: time.Millisecond})
+
+	// the MultiRead has the values of the first caller
+	short, cancel := context.WithTimeout(context.WithValue(ctx, ctxKey{}, "first"), 20*time.Millisecond)
+	defer cancel()
+	shortErr := make(chan error)
+	go func() {
+		_, err := c.Read(short, testEi, keys(3), nil)
+		shortErr <- err
+	}()
+	for len(next.sizes()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a caller joining the read in flight extends its deadline
+	patient := make(chan dosa.FieldValue)
+	go func() {
+		values, err := c.Read(ctx, testEi, keys(3), nil)
+		assert.NoError(t, err)
+		patient <- values["name"]
+	}()
+	assert.Equal(t, context.DeadlineExceeded, <-shortErr)
+	time.Sleep(10 * time.Millisecond)
+	close(next.gate)
+	assert.Equal(t, "user3", <-patient)
+	assert.Equal(t, []int{1}, next.sizes())
+	assert.Equal(t, []interface{}{"first"}, next.values)
+
+	// once the read ended, the row is read again
+	_, err := c.Read(ctx, testEi, keys(3), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 1}, next.sizes())
+}
+
+func TestBatchContextExpired(t *testing.T) {
+	next := newCounting(t, 10)
+	next.gate = make(chan struct{})
+	c := batch.NewConnector(next, batch.Config{Window: time.Millisecond})
+
+	// a read whose deadline passed is not shared with later callers
+	short, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+	_, err := c.Read(short, testEi, keys(4), nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	close(next.gate)
+	values, err := c.Read(ctx, testEi, keys(4), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "user4", values["name"])
+	assert.NoError(t, c.Shutdown())
+}
@@ -0,0 +1,497 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package breaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// State is the state of a circuit
+type State int
+
+// The states of a circuit
+const (
+	// Closed lets all requests through while counting failures
+	Closed State = iota
+	// Open rejects all requests with ErrCircuitOpen
+	Open
+	// HalfOpen lets a few probe requests through to decide whether the
+	// circuit can be closed again
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Key identifies a circuit. Scope and schema operations use a key with an
+// empty entity name.
+type Key struct {
+	Scope  string
+	Entity string
+}
+
+// ErrCircuitOpen is returned without calling the next connector while the
+// circuit of the scope and entity is open
+type ErrCircuitOpen struct {
+	Key Key
+}
+
+// Error satisfies the error interface
+func (e *ErrCircuitOpen) Error() string {
+	if e.Key.Entity == "" {
+		return fmt.Sprintf("circuit open for scope %q", e.Key.Scope)
+	}
+	return fmt.Sprintf("circuit open for entity %q in scope %q", e.Key.Entity, e.Key.Scope)
+}
+
+// ErrorIsCircuitOpen checks if the error is caused by "ErrCircuitOpen"
+func ErrorIsCircuitOpen(err error) bool {
+	_, ok := errors.Cause(err).(*ErrCircuitOpen)
+	return ok
+}
+
+// Default values used for unset fields of Config
+const (
+	DefaultFailureRatio     = 0.5
+	DefaultMinRequests      = 20
+	DefaultWindow           = 10 * time.Second
+	DefaultOpenTimeout      = 5 * time.Second
+	DefaultHalfOpenRequests = 1
+)
+
+// Config controls when circuits open and close
+type Config struct {
+	// FailureRatio is the fraction of failed requests in a window that opens
+	// the circuit
+	FailureRatio float64
+	// MinRequests is the number of requests a window needs before the
+	// failure ratio is considered
+	MinRequests int
+	// Window is the period over which failures are counted
+	Window time.Duration
+	// OpenTimeout is how long a circuit stays open before probing
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of successful probes needed to close
+	// the circuit; this many probes may be in flight at once
+	HalfOpenRequests int
+	// IsFailure decides whether an error counts as a failure. By default
+	// all errors count except dosa.ErrNotFound and dosa.ErrAlreadyExists,
+	// which are ordinary results, and dosa.ErrInvalidRequest and
+	// dosa.ErrSchemaMismatch, which are the caller's fault. Requests
+	// abandoned by the caller, either cancelled or failed with a deadline
+	// other than their own, are never counted, whatever IsFailure says.
+	IsFailure func(error) bool
+	// OnStateChange is called, outside of any lock, every time a circuit
+	// changes state
+	OnStateChange func(key Key, from, to State)
+}
+
+// isFailure is the default for Config.IsFailure
+func isFailure(err error) bool {
+	return err != nil && !dosa.ErrorIsNotFound(err) && !dosa.ErrorIsAlreadyExists(err) &&
+		!dosa.ErrorIsInvalidRequest(err) && !dosa.ErrorIsSchemaMismatch(err)
+}
+
+// abandoned returns true if the request ended because the caller gave up on
+// it rather than because of the next connector. A cancelled request says
+// nothing about the health of the backend, and neither does a deadline error
+// unless the request's own deadline has passed.
+func abandoned(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	if ctx.Err() == context.Canceled {
+		return true
+	}
+	switch errors.Cause(err) {
+	case context.Canceled:
+		return true
+	case context.DeadlineExceeded:
+		return ctx.Err() != context.DeadlineExceeded
+	}
+	return false
+}
+
+// circuit holds the state of one scope and entity
+type circuit struct {
+	state State
+	// closed state: counts of the current window
+	windowStart time.Time
+	requests    int
+	failures    int
+	// open state
+	openedAt time.Time
+	// half-open state
+	probes    int
+	successes int
+}
+
+// transition is a state change to report
+type transition struct {
+	key      Key
+	from, to State
+}
+
+// Connector is a circuit breaker. It tracks the failure rate of the next
+// connector per scope and entity, and once the rate gets too high it fails
+// fast with ErrCircuitOpen instead of calling the next connector. After
+// OpenTimeout a few probe requests are let through; the circuit closes if
+// they succeed and opens again if any of them fails.
+type Connector struct {
+	base.Connector
+	config Config
+	now    func() time.Time
+
+	lock     sync.Mutex
+	circuits map[Key]*circuit
+}
+
+// NewConnector creates a circuit breaker in front of next. Zero fields of cfg
+// are replaced with the package defaults.
+func NewConnector(next dosa.Connector, cfg Config) *Connector {
+	if cfg.FailureRatio <= 0 || cfg.FailureRatio > 1 {
+		cfg.FailureRatio = DefaultFailureRatio
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = DefaultMinRequests
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = DefaultOpenTimeout
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = DefaultHalfOpenRequests
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = isFailure
+	}
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		config:    cfg,
+		now:       time.Now,
+		circuits:  map[Key]*circuit{},
+	}
+}
+
+// State returns the current state of the circuit for the key
+func (c *Connector) State(key Key) State {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cb, ok := c.circuits[key]; ok {
+		return cb.state
+	}
+	return Closed
+}
+
+// setState changes the state of a circuit and records the transition
+func (c *Connector) setState(key Key, cb *circuit, to State, transitions []transition) []transition {
+	if cb.state == to {
+		return transitions
+	}
+	transitions = append(transitions, transition{key: key, from: cb.state, to: to})
+	cb.state = to
+	now := c.now()
+	switch to {
+	case Closed:
+		cb.windowStart, cb.requests, cb.failures = now, 0, 0
+	case Open:
+		cb.openedAt = now
+	case HalfOpen:
+		cb.probes, cb.successes = 0, 0
+	}
+	return transitions
+}
+
+func (c *Connector) notify(transitions []transition) {
+	if c.config.OnStateChange == nil {
+		return
+	}
+	for _, t := range transitions {
+		c.config.OnStateChange(t.key, t.from, t.to)
+	}
+}
+
+// allow decides whether a request may go through; probe is true when it is
+// one of the half-open probes
+func (c *Connector) allow(key Key) (probe bool, err error) {
+	var transitions []transition
+	defer func() { c.notify(transitions) }()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cb, ok := c.circuits[key]
+	if !ok {
+		cb = &circuit{windowStart: c.now()}
+		c.circuits[key] = cb
+	}
+	if cb.state == Open && c.now().Sub(cb.openedAt) >= c.config.OpenTimeout {
+		transitions = c.setState(key, cb, HalfOpen, transitions)
+	}
+	switch cb.state {
+	case Open:
+		return false, &ErrCircuitOpen{Key: key}
+	case HalfOpen:
+		if cb.probes >= c.config.HalfOpenRequests {
+			return false, &ErrCircuitOpen{Key: key}
+		}
+		cb.probes++
+		return true, nil
+	}
+	return false, nil
+}
+
+// done records the outcome of a request let through by allow
+func (c *Connector) done(ctx context.Context, key Key, probe bool, err error) {
+	var transitions []transition
+	defer func() { c.notify(transitions) }()
+
+	skip := abandoned(ctx, err)
+	failed := !skip && c.config.IsFailure(err)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cb := c.circuits[key]
+	switch {
+	case skip:
+		// an abandoned probe frees its slot without deciding anything
+		if probe && cb.state == HalfOpen {
+			cb.probes--
+		}
+	case probe && cb.state == HalfOpen:
+		cb.probes--
+		if failed {
+			transitions = c.setState(key, cb, Open, transitions)
+			return
+		}
+		cb.successes++
+		if cb.successes >= c.config.HalfOpenRequests {
+			transitions = c.setState(key, cb, Closed, transitions)
+		}
+	case !probe && cb.state == Closed:
+		if now := c.now(); now.Sub(cb.windowStart) >= c.config.Window {
+			cb.windowStart, cb.requests, cb.failures = now, 0, 0
+		}
+		cb.requests++
+		if failed {
+			cb.failures++
+		}
+		if cb.requests >= c.config.MinRequests && float64(cb.failures) >= c.config.FailureRatio*float64(cb.requests) {
+			transitions = c.setState(key, cb, Open, transitions)
+		}
+	}
+	// results of requests that started in another state are ignored
+}
+
+// call runs op if the circuit allows it and records its outcome
+func (c *Connector) call(ctx context.Context, key Key, op func() error) error {
+	probe, err := c.allow(key)
+	if err != nil {
+		return err
+	}
+	err = op()
+	c.done(ctx, key, probe, err)
+	return err
+}
+
+func entityKey(ei *dosa.EntityInfo) Key {
+	if ei == nil || ei.Ref == nil {
+		return Key{}
+	}
+	return Key{Scope: ei.Ref.Scope, Entity: ei.Ref.EntityName}
+}
+
+// CreateIfNotExists calls Next unless the circuit is open
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.call(ctx, entityKey(ei), func() error {
+		return c.Connector.CreateIfNotExists(ctx, ei, values)
+	})
+}
+
+// Read calls Next unless the circuit is open
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	var values map[string]dosa.FieldValue
+	err := c.call(ctx, entityKey(ei), func() (err error) {
+		values, err = c.Connector.Read(ctx, ei, keys, fieldsToRead)
+		return err
+	})
+	return values, err
+}
+
+// MultiRead calls Next unless the circuit is open
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	var results []*dosa.FieldValuesOrError
+	err := c.call(ctx, entityKey(ei), func() (err error) {
+		results, err = c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+		return err
+	})
+	return results, err
+}
+
+// Upsert calls Next unless the circuit is open
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.call(ctx, entityKey(ei), func() error {
+		return c.Connector.Upsert(ctx, ei, values)
+	})
+}
+
+// MultiUpsert calls Next unless the circuit is open
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	var errs []error
+	err := c.call(ctx, entityKey(ei), func() (err error) {
+		errs, err = c.Connector.MultiUpsert(ctx, ei, values)
+		return err
+	})
+	return errs, err
+}
+
+// Remove calls Next unless the circuit is open
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	return c.call(ctx, entityKey(ei), func() error {
+		return c.Connector.Remove(ctx, ei, keys)
+	})
+}
+
+// MultiRemove calls Next unless the circuit is open
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	var errs []error
+	err := c.call(ctx, entityKey(ei), func() (err error) {
+		errs, err = c.Connector.MultiRemove(ctx, ei, multiKeys)
+		return err
+	})
+	return errs, err
+}
+
+// Range calls Next unless the circuit is open
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	var values []map[string]dosa.FieldValue
+	var next string
+	err := c.call(ctx, entityKey(ei), func() (err error) {
+		values, next, err = c.Connector.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+		return err
+	})
+	return values, next, err
+}
+
+// Search calls Next unless the circuit is open
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPairs dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	var values []map[string]dosa.FieldValue
+	var next string
+	err := c.call(ctx, entityKey(ei), func() (err error) {
+		values, next, err = c.Connector.Search(ctx, ei, fieldPairs, fieldsToRead, token, limit)
+		return err
+	})
+	return values, next, err
+}
+
+// Scan calls Next unless the circuit is open
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	var values []map[string]dosa.FieldValue
+	var next string
+	err := c.call(ctx, entityKey(ei), func() (err error) {
+		values, next, err = c.Connector.Scan(ctx, ei, fieldsToRead, token, limit)
+		return err
+	})
+	return values, next, err
+}
+
+// CheckSchema calls Next unless the circuit of the scope is open
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	var version int32
+	err := c.call(ctx, Key{Scope: scope}, func() (err error) {
+		version, err = c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+		return err
+	})
+	return version, err
+}
+
+// UpsertSchema calls Next unless the circuit of the scope is open
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	var status *dosa.SchemaStatus
+	err := c.call(ctx, Key{Scope: scope}, func() (err error) {
+		status, err = c.Connector.UpsertSchema(ctx, scope, namePrefix, ed)
+		return err
+	})
+	return status, err
+}
+
+// CheckSchemaStatus calls Next unless the circuit of the scope is open
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope string, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	var status *dosa.SchemaStatus
+	err := c.call(ctx, Key{Scope: scope}, func() (err error) {
+		status, err = c.Connector.CheckSchemaStatus(ctx, scope, namePrefix, version)
+		return err
+	})
+	return status, err
+}
+
+// CreateScope calls Next unless the circuit of the scope is open
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	return c.call(ctx, Key{Scope: scope}, func() error {
+		return c.Connector.CreateScope(ctx, scope)
+	})
+}
+
+// TruncateScope calls Next unless the circuit of the scope is open
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	return c.call(ctx, Key{Scope: scope}, func() error {
+		return c.Connector.TruncateScope(ctx, scope)
+	})
+}
+
+// DropScope calls Next unless the circuit of the scope is open
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	return c.call(ctx, Key{Scope: scope}, func() error {
+		return c.Connector.DropScope(ctx, scope)
+	})
+}
+
+// ScopeExists calls Next unless the circuit of the scope is open
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	var exists bool
+	err := c.call(ctx, Key{Scope: scope}, func() (err error) {
+		exists, err = c.Connector.ScopeExists(ctx, scope)
+		return err
+	})
+	return exists, err
+}
+
+// ListScopes passes the call on; it does not belong to any scope, so no
+// circuit applies
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	return c.Connector.ListScopes(ctx, owner)
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/devnull"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testScope",
+		NamePrefix: "testPrefix",
+		EntityName: "testEntityName",
+	},
+	Def: &dosa.EntityDefinition{},
+}
+
+var otherEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testScope",
+		NamePrefix: "testPrefix",
+		EntityName: "otherEntityName",
+	},
+	Def: &dosa.EntityDefinition{},
+}
+
+var (
+	testKey    = Key{Scope: "testScope", Entity: "testEntityName"}
+	testValues = map[string]dosa.FieldValue{"id": int64(1)}
+	errBoom    = errors.New("boom")
+)
+
+type recorder []string
+
+func (r *recorder) record(key Key, from, to State) {
+	*r = append(*r, key.Entity+": "+from.String()+" -> "+to.String())
+}
+
+func newTestConnector(next dosa.Connector, r *recorder) (*Connector, *time.Time) {
+	sut := NewConnector(next, Config{
+		FailureRatio:     0.5,
+		MinRequests:      4,
+		Window:           time.Minute,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenRequests: 2,
+		OnStateChange:    r.record,
+	})
+	now := time.Unix(1500000000, 0)
+	sut.now = func() time.Time { return now }
+	return sut, &now
+}
+
+func TestBreaker_OpensAndCloses(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	var r recorder
+	sut, now := newTestConnector(mc, &r)
+
+	// two successes and two failures reach the ratio
+	gomock.InOrder(
+		mc.EXPECT().Upsert(ctx, testEi, testValues).Return(nil).Times(2),
+		mc.EXPECT().Upsert(ctx, testEi, testValues).Return(errBoom).Times(2),
+	)
+	for i := 0; i < 4; i++ {
+		_ = sut.Upsert(ctx, testEi, testValues)
+	}
+	assert.Equal(t, Open, sut.State(testKey))
+	assert.Equal(t, recorder{"testEntityName: closed -> open"}, r)
+
+	// fails fast while open, other entities are not affected
+	err := sut.Upsert(ctx, testEi, testValues)
+	assert.True(t, ErrorIsCircuitOpen(err))
+	assert.EqualError(t, err, `circuit open for entity "testEntityName" in scope "testScope"`)
+	mc.EXPECT().Upsert(ctx, otherEi, testValues).Return(nil)
+	assert.NoError(t, sut.Upsert(ctx, otherEi, testValues))
+
+	// probes after the timeout, and closes after enough successes
+	*now = now.Add(10 * time.Second)
+	mc.EXPECT().Read(ctx, testEi, testValues, nil).Return(testValues, nil).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err = sut.Read(ctx, testEi, testValues, nil)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, Closed, sut.State(testKey))
+	assert.Equal(t, recorder{
+		"testEntityName: closed -> open",
+		"testEntityName: open -> half-open",
+		"testEntityName: half-open -> closed",
+	}, r)
+}
+
+func TestBreaker_FailedProbeReopens(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	var r recorder
+	sut, now := newTestConnector(mc, &r)
+
+	mc.EXPECT().Remove(ctx, testEi, testValues).Return(errBoom).Times(4)
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, errBoom, sut.Remove(ctx, testEi, testValues))
+	}
+	assert.Equal(t, Open, sut.State(testKey))
+
+	*now = now.Add(time.Minute)
+	mc.EXPECT().Remove(ctx, testEi, testValues).Return(errBoom)
+	assert.Equal(t, errBoom, sut.Remove(ctx, testEi, testValues))
+	assert.Equal(t, Open, sut.State(testKey))
+	assert.True(t, ErrorIsCircuitOpen(sut.Remove(ctx, testEi, testValues)))
+	assert.Equal(t, recorder{
+		"testEntityName: closed -> open",
+		"testEntityName: open -> half-open",
+		"testEntityName: half-open -> open",
+	}, r)
+}
+
+func TestBreaker_LimitsProbes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	var r recorder
+	sut, now := newTestConnector(mc, &r)
+
+	mc.EXPECT().Upsert(ctx, testEi, testValues).Return(errBoom).Times(4)
+	for i := 0; i < 4; i++ {
+		_ = sut.Upsert(ctx, testEi, testValues)
+	}
+	*now = now.Add(time.Minute)
+
+	// while two probes are in flight, other requests fail fast
+	mc.EXPECT().Upsert(ctx, testEi, testValues).Do(func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue) {
+		mc.EXPECT().Upsert(ctx, testEi, testValues).Do(func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue) {
+			assert.True(t, ErrorIsCircuitOpen(sut.Upsert(ctx, testEi, testValues)))
+		}).Return(nil)
+		assert.NoError(t, sut.Upsert(ctx, testEi, testValues))
+	}).Return(nil)
+	assert.NoError(t, sut.Upsert(ctx, testEi, testValues))
+	assert.Equal(t, Closed, sut.State(testKey))
+}
+
+func TestBreaker_IgnoresAbandoned(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	var r recorder
+	sut, now := newTestConnector(mc, &r)
+
+	// cancelled requests, and deadline errors while the request's own
+	// deadline has not passed, are not failures
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	mc.EXPECT().Upsert(cctx, testEi, testValues).Return(context.Canceled).Times(4)
+	mc.EXPECT().Upsert(ctx, testEi, testValues).Return(errors.Wrap(context.Canceled, "hedged")).Times(4)
+	mc.EXPECT().Upsert(ctx, testEi, testValues).Return(errors.Wrap(context.DeadlineExceeded, "remote")).Times(4)
+	for i := 0; i < 4; i++ {
+		assert.Error(t, sut.Upsert(cctx, testEi, testValues))
+		assert.Error(t, sut.Upsert(ctx, testEi, testValues))
+		assert.Error(t, sut.Upsert(ctx, testEi, testValues))
+	}
+	assert.Equal(t, Closed, sut.State(testKey))
+
+	// requests whose own deadline passed are failures
+	dctx, cancel := context.WithDeadline(ctx, time.Unix(0, 0))
+	defer cancel()
+	mc.EXPECT().Upsert(dctx, testEi, testValues).Return(context.DeadlineExceeded).Times(4)
+	for i := 0; i < 4; i++ {
+		assert.Error(t, sut.Upsert(dctx, testEi, testValues))
+	}
+	assert.Equal(t, Open, sut.State(testKey))
+
+	// an abandoned probe frees its slot for another probe
+	*now = now.Add(time.Minute)
+	mc.EXPECT().Upsert(cctx, testEi, testValues).Return(context.Canceled).Times(2)
+	mc.EXPECT().Upsert(ctx, testEi, testValues).Return(nil).Times(2)
+	for i := 0; i < 2; i++ {
+		assert.Error(t, sut.Upsert(cctx, testEi, testValues))
+	}
+	assert.Equal(t, HalfOpen, sut.State(testKey))
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, sut.Upsert(ctx, testEi, testValues))
+	}
+	assert.Equal(t, Closed, sut.State(testKey))
+}
+
+func TestBreaker_Window(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	var r recorder
+	sut, now := newTestConnector(mc, &r)
+
+	// not-found and already-exists are not failures
+	mc.EXPECT().Read(ctx, testEi, testValues, nil).Return(nil, &dosa.ErrNotFound{}).Times(4)
+	for i := 0; i < 4; i++ {
+		_, _ = sut.Read(ctx, testEi, testValues, nil)
+	}
+	mc.EXPECT().CreateIfNotExists(ctx, testEi, testValues).Return(&dosa.ErrAlreadyExists{}).Times(4)
+	for i := 0; i < 4; i++ {
+		_ = sut.CreateIfNotExists(ctx, testEi, testValues)
+	}
+	assert.Equal(t, Closed, sut.State(testKey))
+
+	// neither are errors of the caller, such as invalid rows or a stale
+	// schema
+	mc.EXPECT().Upsert(ctx, testEi, testValues).Return(&dosa.ErrInvalidRequest{Err: errBoom}).Times(4)
+	for i := 0; i < 4; i++ {
+		_ = sut.Upsert(ctx, testEi, testValues)
+	}
+	mc.EXPECT().Upsert(ctx, testEi, testValues).Return(errors.Wrap(&dosa.ErrSchemaMismatch{Err: errBoom}, "wrapped")).Times(4)
+	for i := 0; i < 4; i++ {
+		_ = sut.Upsert(ctx, testEi, testValues)
+	}
+	assert.Equal(t, Closed, sut.State(testKey))
+
+	// failures spread over several windows do not open the circuit
+	mc.EXPECT().Upsert(ctx, testEi, testValues).Return(errBoom).Times(6)
+	for i := 0; i < 6; i++ {
+		_ = sut.Upsert(ctx, testEi, testValues)
+		*now = now.Add(30 * time.Second)
+	}
+	assert.Equal(t, Closed, sut.State(testKey))
+	assert.Empty(t, r)
+}
+
+func TestBreaker_ScopeOps(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	var r recorder
+	sut, _ := newTestConnector(mc, &r)
+
+	mc.EXPECT().CreateScope(ctx, "testScope").Return(errBoom)
+	mc.EXPECT().TruncateScope(ctx, "testScope").Return(errBoom)
+	mc.EXPECT().DropScope(ctx, "testScope").Return(errBoom)
+	mc.EXPECT().ScopeExists(ctx, "testScope").Return(false, errBoom)
+	_ = sut.CreateScope(ctx, "testScope")
+	_ = sut.TruncateScope(ctx, "testScope")
+	_ = sut.DropScope(ctx, "testScope")
+	_, _ = sut.ScopeExists(ctx, "testScope")
+
+	scopeKey := Key{Scope: "testScope"}
+	assert.Equal(t, Open, sut.State(scopeKey))
+	assert.Equal(t, Closed, sut.State(testKey))
+	_, err := sut.CheckSchema(ctx, "testScope", "testPrefix", nil)
+	assert.EqualError(t, err, `circuit open for scope "testScope"`)
+	_, err = sut.UpsertSchema(ctx, "testScope", "testPrefix", nil)
+	assert.True(t, ErrorIsCircuitOpen(err))
+	_, err = sut.CheckSchemaStatus(ctx, "testScope", "testPrefix", 1)
+	assert.True(t, ErrorIsCircuitOpen(err))
+}
+
+func TestBreaker_PassesResults(t *testing.T) {
+	sut := NewConnector(&devnull.Connector{}, Config{})
+	_, err := sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{testValues}, nil)
+	assert.NoError(t, err)
+	_, err = sut.MultiUpsert(ctx, testEi, nil)
+	assert.NoError(t, err)
+	_, err = sut.MultiRemove(ctx, testEi, nil)
+	assert.NoError(t, err)
+	_, _, err = sut.Range(ctx, testEi, nil, nil, "", 1)
+	assert.Error(t, err)
+	_, _, err = sut.Search(ctx, testEi, dosa.FieldNameValuePair{}, nil, "", 1)
+	assert.Error(t, err)
+	_, _, err = sut.Scan(ctx, testEi, nil, "", 1)
+	assert.Error(t, err)
+	assert.Equal(t, Closed, sut.State(testKey))
+	assert.Equal(t, "State(7)", State(7).String())
+}
@@ -0,0 +1,377 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Tag is the ColumnDefinition tag that opts an entity into caching. An entity
+// is cached when any of its columns carries this tag, or when its name is
+// listed in Config.Entities.
+const Tag = dosa.CacheTag
+
+// Default values used for unset fields of Config
+const (
+	DefaultSize = 1024
+	DefaultTTL  = time.Minute
+)
+
+// Config controls which entities are cached and for how long
+type Config struct {
+	// Size is the maximum number of rows kept; the least recently used row
+	// is evicted first
+	Size int
+	// TTL is how long a row stays in the cache after being read
+	TTL time.Duration
+	// Entities lists the names of the entities to cache, in addition to the
+	// ones that opt in with a column tag
+	Entities []string
+}
+
+// entry is a cached row
+type entry struct {
+	key     string
+	values  map[string]dosa.FieldValue
+	expires time.Time
+}
+
+// Connector is a read-through cache in front of another connector. Read and
+// MultiRead results of opted-in entities are cached by schema reference and
+// primary key; Upsert, Remove, MultiUpsert and MultiRemove invalidate the
+// rows they touch, and TruncateScope and DropScope invalidate the whole
+// scope. All other operations go straight to the next connector.
+//
+// Rows are always fetched with all their columns, so reads asking for
+// different fields can share the same entry.
+type Connector struct {
+	base.Connector
+	size     int
+	ttl      time.Duration
+	entities map[string]struct{}
+	now      func() time.Time
+
+	lock    sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// writes counts invalidations; a row fetched while an invalidation
+	// happened may be stale and is not stored
+	writes uint64
+}
+
+// NewConnector creates a caching connector in front of next
+func NewConnector(next dosa.Connector, cfg Config) *Connector {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultSize
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	entities := make(map[string]struct{}, len(cfg.Entities))
+	for _, name := range cfg.Entities {
+		entities[name] = struct{}{}
+	}
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		size:      cfg.Size,
+		ttl:       cfg.TTL,
+		entities:  entities,
+		now:       time.Now,
+		lru:       list.New(),
+		entries:   map[string]*list.Element{},
+	}
+}
+
+// cacheable returns true if rows of the entity should be cached
+func (c *Connector) cacheable(ei *dosa.EntityInfo) bool {
+	if ei == nil || ei.Ref == nil || ei.Def == nil || ei.Def.Key == nil {
+		return false
+	}
+	if _, ok := c.entities[ei.Def.Name]; ok {
+		return true
+	}
+	for _, col := range ei.Def.Columns {
+		if _, ok := col.Tags[Tag]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// scopePrefix is the part of the cache key shared by all rows of a scope
+func scopePrefix(scope string) string {
+	return fmt.Sprintf("%q/", scope)
+}
+
+// cacheKey builds the cache key of a row from its schema reference and the
+// values of its primary key. It returns false if a key value is missing.
+func cacheKey(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) (string, bool) {
+	names := make([]string, 0, len(ei.Def.KeySet()))
+	for name := range ei.Def.KeySet() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s%q/%q", scopePrefix(ei.Ref.Scope), ei.Ref.NamePrefix, ei.Ref.EntityName)
+	for _, name := range names {
+		value, ok := values[name]
+		if !ok {
+			return "", false
+		}
+		b.WriteByte('/')
+		b.WriteString(name)
+		b.WriteByte('=')
+		writeValue(&b, value)
+	}
+	return b.String(), true
+}
+
+func writeValue(b *bytes.Buffer, value dosa.FieldValue) {
+	switch v := value.(type) {
+	case []byte:
+		fmt.Fprintf(b, "%x", v)
+	case time.Time:
+		fmt.Fprintf(b, "%d", v.UnixNano())
+	case string:
+		fmt.Fprintf(b, "%q", v)
+	case dosa.UUID:
+		fmt.Fprintf(b, "%q", string(v))
+	default:
+		fmt.Fprintf(b, "%v", v)
+	}
+}
+
+// project returns the requested fields of a cached row; all of them when
+// fieldsToRead is nil
+func project(values map[string]dosa.FieldValue, fieldsToRead []string) map[string]dosa.FieldValue {
+	if fieldsToRead == nil {
+		result := make(map[string]dosa.FieldValue, len(values))
+		for k, v := range values {
+			result[k] = v
+		}
+		return result
+	}
+	result := make(map[string]dosa.FieldValue, len(fieldsToRead))
+	for _, name := range fieldsToRead {
+		if v, ok := values[name]; ok {
+			result[name] = v
+		}
+	}
+	return result
+}
+
+// get returns the cached row with the given key, if it has not expired
+func (c *Connector) get(key string) (map[string]dosa.FieldValue, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if c.now().After(e.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return e.values, true
+}
+
+// generation returns the number of invalidations so far
+func (c *Connector) generation() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.writes
+}
+
+// put stores a row fetched when the invalidation count was gen
+func (c *Connector) put(gen uint64, key string, values map[string]dosa.FieldValue) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if gen != c.writes {
+		return
+	}
+	e := &entry{key: key, values: values, expires: c.now().Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).key)
+	}
+}
+
+// invalidate removes the rows whose keys match
+func (c *Connector) invalidate(match func(key string) bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.writes++
+	for key, elem := range c.entries {
+		if match(key) {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// invalidateRows removes the rows identified by the given key values. When a
+// key cannot be built the whole entity is dropped from the cache.
+func (c *Connector) invalidateRows(ei *dosa.EntityInfo, rows ...map[string]dosa.FieldValue) {
+	if !c.cacheable(ei) {
+		return
+	}
+	keys := make(map[string]struct{}, len(rows))
+	for _, row := range rows {
+		key, ok := cacheKey(ei, row)
+		if !ok {
+			prefix := fmt.Sprintf("%s%q/%q/", scopePrefix(ei.Ref.Scope), ei.Ref.NamePrefix, ei.Ref.EntityName)
+			c.invalidate(func(key string) bool { return strings.HasPrefix(key, prefix) })
+			return
+		}
+		keys[key] = struct{}{}
+	}
+	c.invalidate(func(key string) bool {
+		_, ok := keys[key]
+		return ok
+	})
+}
+
+// Read returns the cached row when there is one, otherwise it reads the
+// whole row from the next connector and caches it
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	if !c.cacheable(ei) {
+		return c.Connector.Read(ctx, ei, keys, fieldsToRead)
+	}
+	key, ok := cacheKey(ei, keys)
+	if !ok {
+		return c.Connector.Read(ctx, ei, keys, fieldsToRead)
+	}
+	if values, ok := c.get(key); ok {
+		return project(values, fieldsToRead), nil
+	}
+	gen := c.generation()
+	values, err := c.Connector.Read(ctx, ei, keys, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.put(gen, key, values)
+	return project(values, fieldsToRead), nil
+}
+
+// MultiRead serves the cached rows and reads the others from the next
+// connector in a single call
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	if !c.cacheable(ei) {
+		return c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+	}
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	cacheKeys := make([]string, len(keys))
+	var missing []int
+	for i, k := range keys {
+		key, ok := cacheKey(ei, k)
+		if !ok {
+			return c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+		}
+		cacheKeys[i] = key
+		if values, ok := c.get(key); ok {
+			results[i] = &dosa.FieldValuesOrError{Values: project(values, fieldsToRead)}
+			continue
+		}
+		missing = append(missing, i)
+	}
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	gen := c.generation()
+	batch := make([]map[string]dosa.FieldValue, len(missing))
+	for i, idx := range missing {
+		batch[i] = keys[idx]
+	}
+	fetched, err := c.Connector.MultiRead(ctx, ei, batch, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i, idx := range missing {
+		if i >= len(fetched) || fetched[i] == nil {
+			continue
+		}
+		if fetched[i].Error != nil {
+			results[idx] = fetched[i]
+			continue
+		}
+		c.put(gen, cacheKeys[idx], fetched[i].Values)
+		results[idx] = &dosa.FieldValuesOrError{Values: project(fetched[i].Values, fieldsToRead)}
+	}
+	return results, nil
+}
+
+// Upsert invalidates the row and calls Next
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	defer c.invalidateRows(ei, values)
+	return c.Connector.Upsert(ctx, ei, values)
+}
+
+// MultiUpsert invalidates the rows and calls Next
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	defer c.invalidateRows(ei, values...)
+	return c.Connector.MultiUpsert(ctx, ei, values)
+}
+
+// Remove invalidates the row and calls Next
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	defer c.invalidateRows(ei, keys)
+	return c.Connector.Remove(ctx, ei, keys)
+}
+
+// MultiRemove invalidates the rows and calls Next
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	defer c.invalidateRows(ei, multiKeys...)
+	return c.Connector.MultiRemove(ctx, ei, multiKeys)
+}
+
+// TruncateScope invalidates every row of the scope and calls Next
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	defer c.invalidate(func(key string) bool { return strings.HasPrefix(key, scopePrefix(scope)) })
+	return c.Connector.TruncateScope(ctx, scope)
+}
+
+// DropScope invalidates every row of the scope and calls Next
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	defer c.invalidate(func(key string) bool { return strings.HasPrefix(key, scopePrefix(scope)) })
+	return c.Connector.DropScope(ctx, scope)
+}
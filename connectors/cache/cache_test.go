@@ -0,0 +1,293 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testScope",
+		NamePrefix: "testPrefix",
+		EntityName: "testEntityName",
+	},
+	Def: &dosa.EntityDefinition{
+		Name: "testentityname",
+		Key: &dosa.PrimaryKey{
+			PartitionKeys:  []string{"p1"},
+			ClusteringKeys: []*dosa.ClusteringKey{{Name: "c1"}},
+		},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "p1", Type: dosa.String},
+			{Name: "c1", Type: dosa.Blob},
+			{Name: "v1", Type: dosa.Int64, Tags: map[string]string{Tag: ""}},
+			{Name: "v2", Type: dosa.String},
+		},
+	},
+}
+
+var uncachedEi = &dosa.EntityInfo{
+	Ref: testEi.Ref,
+	Def: &dosa.EntityDefinition{
+		Name:    "uncached",
+		Key:     testEi.Def.Key,
+		Columns: []*dosa.ColumnDefinition{{Name: "p1", Type: dosa.String}, {Name: "c1", Type: dosa.Blob}},
+	},
+}
+
+func keys(p1 string) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"p1": p1, "c1": []byte{1, 2}}
+}
+
+func row(p1 string, v1 int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"p1": p1, "c1": []byte{1, 2}, "v1": v1, "v2": "x"}
+}
+
+func TestCache_ReadThrough(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut := NewConnector(mc, Config{})
+
+	mc.EXPECT().Read(ctx, testEi, keys("a"), nil).Return(row("a", 1), nil).Times(1)
+	values, err := sut.Read(ctx, testEi, keys("a"), []string{"v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dosa.FieldValue{"v1": int64(1)}, values)
+
+	// served from the cache, with other fields
+	values, err = sut.Read(ctx, testEi, keys("a"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row("a", 1), values)
+
+	// errors are not cached
+	mc.EXPECT().Read(ctx, testEi, keys("b"), nil).Return(nil, &dosa.ErrNotFound{}).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err = sut.Read(ctx, testEi, keys("b"), nil)
+		assert.True(t, dosa.ErrorIsNotFound(err))
+	}
+}
+
+func TestCache_NotOptedIn(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut := NewConnector(mc, Config{})
+
+	mc.EXPECT().Read(ctx, uncachedEi, keys("a"), []string{"p1"}).Return(keys("a"), nil).Times(2)
+	mc.EXPECT().MultiRead(ctx, uncachedEi, nil, nil).Return(nil, nil).Times(1)
+	for i := 0; i < 2; i++ {
+		_, err := sut.Read(ctx, uncachedEi, keys("a"), []string{"p1"})
+		assert.NoError(t, err)
+	}
+	_, err := sut.MultiRead(ctx, uncachedEi, nil, nil)
+	assert.NoError(t, err)
+
+	// opting in by name
+	sut = NewConnector(mc, Config{Entities: []string{"uncached"}})
+	mc.EXPECT().Read(ctx, uncachedEi, keys("a"), nil).Return(keys("a"), nil).Times(1)
+	for i := 0; i < 2; i++ {
+		_, err := sut.Read(ctx, uncachedEi, keys("a"), []string{"p1"})
+		assert.NoError(t, err)
+	}
+}
+
+type taggedEntity struct {
+	dosa.Entity `dosa:"primaryKey=ID"`
+	ID          int64
+	Name        string `dosa:"cache"`
+}
+
+func TestCache_EntityTag(t *testing.T) {
+	table, err := dosa.TableFromInstance(&taggedEntity{})
+	assert.NoError(t, err)
+	sut := NewConnector(nil, Config{})
+	assert.True(t, sut.cacheable(&dosa.EntityInfo{Ref: testEi.Ref, Def: &table.EntityDefinition}))
+	assert.False(t, sut.cacheable(uncachedEi))
+}
+
+func TestCache_MissingKeyIsNotCached(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut := NewConnector(mc, Config{})
+
+	partial := map[string]dosa.FieldValue{"p1": "a"}
+	mc.EXPECT().Read(ctx, testEi, partial, nil).Return(nil, errors.New("missing key")).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err := sut.Read(ctx, testEi, partial, nil)
+		assert.Error(t, err)
+	}
+}
+
+func TestCache_TTLAndSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut := NewConnector(mc, Config{Size: 2, TTL: time.Minute})
+	now := time.Unix(1500000000, 0)
+	sut.now = func() time.Time { return now }
+
+	for _, p1 := range []string{"a", "b", "c"} {
+		mc.EXPECT().Read(ctx, testEi, keys(p1), nil).Return(row(p1, 1), nil).Times(1)
+		_, err := sut.Read(ctx, testEi, keys(p1), nil)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, sut.lru.Len())
+
+	// "a" was evicted, "c" is still cached
+	mc.EXPECT().Read(ctx, testEi, keys("a"), nil).Return(row("a", 1), nil).Times(1)
+	_, err := sut.Read(ctx, testEi, keys("a"), nil)
+	assert.NoError(t, err)
+	_, err = sut.Read(ctx, testEi, keys("c"), nil)
+	assert.NoError(t, err)
+
+	// everything expires
+	now = now.Add(2 * time.Minute)
+	mc.EXPECT().Read(ctx, testEi, keys("c"), nil).Return(row("c", 2), nil).Times(1)
+	values, err := sut.Read(ctx, testEi, keys("c"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), values["v1"])
+}
+
+func TestCache_MultiRead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut := NewConnector(mc, Config{})
+
+	mc.EXPECT().Read(ctx, testEi, keys("b"), nil).Return(row("b", 2), nil)
+	_, err := sut.Read(ctx, testEi, keys("b"), nil)
+	assert.NoError(t, err)
+
+	mc.EXPECT().MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("a"), keys("c")}, nil).Return([]*dosa.FieldValuesOrError{
+		{Values: row("a", 1)},
+		{Error: &dosa.ErrNotFound{}},
+	}, nil)
+	results, err := sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("a"), keys("b"), keys("c")}, []string{"v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dosa.FieldValue{"v1": int64(1)}, results[0].Values)
+	assert.Equal(t, map[string]dosa.FieldValue{"v1": int64(2)}, results[1].Values)
+	assert.True(t, dosa.ErrorIsNotFound(results[2].Error))
+
+	// "a" and "b" are now cached
+	results, err = sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("b"), keys("a")}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row("b", 2), results[0].Values)
+	assert.Equal(t, row("a", 1), results[1].Values)
+
+	mc.EXPECT().MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("c")}, nil).Return(nil, errors.New("boom"))
+	_, err = sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("a"), keys("c")}, nil)
+	assert.Error(t, err)
+}
+
+func TestCache_Invalidation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut := NewConnector(mc, Config{})
+
+	fill := func(p1 string) {
+		mc.EXPECT().Read(ctx, testEi, keys(p1), nil).Return(row(p1, 1), nil).Times(1)
+		_, err := sut.Read(ctx, testEi, keys(p1), nil)
+		assert.NoError(t, err)
+		// a second read is a hit
+		_, err = sut.Read(ctx, testEi, keys(p1), nil)
+		assert.NoError(t, err)
+	}
+
+	fill("a")
+	mc.EXPECT().Upsert(ctx, testEi, row("a", 5)).Return(nil)
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a", 5)))
+	fill("a")
+	mc.EXPECT().Remove(ctx, testEi, keys("a")).Return(nil)
+	assert.NoError(t, sut.Remove(ctx, testEi, keys("a")))
+	fill("a")
+	fill("b")
+	mc.EXPECT().MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("a", 2), row("b", 2)}).Return(nil, nil)
+	_, err := sut.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("a", 2), row("b", 2)})
+	assert.NoError(t, err)
+	fill("a")
+	fill("b")
+	mc.EXPECT().MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("a")}).Return(nil, nil)
+	_, err = sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("a")})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sut.lru.Len())
+	fill("a")
+
+	// a write without the full key drops the whole entity
+	partial := map[string]dosa.FieldValue{"p1": "a"}
+	mc.EXPECT().Upsert(ctx, testEi, partial).Return(errors.New("missing key"))
+	assert.Error(t, sut.Upsert(ctx, testEi, partial))
+	assert.Equal(t, 0, sut.lru.Len())
+
+	fill("a")
+	mc.EXPECT().TruncateScope(ctx, "otherScope").Return(nil)
+	assert.NoError(t, sut.TruncateScope(ctx, "otherScope"))
+	assert.Equal(t, 1, sut.lru.Len())
+	mc.EXPECT().TruncateScope(ctx, "testScope").Return(nil)
+	assert.NoError(t, sut.TruncateScope(ctx, "testScope"))
+	assert.Equal(t, 0, sut.lru.Len())
+	fill("a")
+	mc.EXPECT().DropScope(ctx, "testScope").Return(nil)
+	assert.NoError(t, sut.DropScope(ctx, "testScope"))
+	assert.Equal(t, 0, sut.lru.Len())
+}
+
+func TestCache_StaleReadIsNotStored(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut := NewConnector(mc, Config{})
+
+	// an upsert completes while the read is in flight
+	mc.EXPECT().Upsert(ctx, testEi, row("a", 2)).Return(nil)
+	mc.EXPECT().Read(ctx, testEi, keys("a"), nil).Do(func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue, []string) {
+		assert.NoError(t, sut.Upsert(ctx, testEi, row("a", 2)))
+	}).Return(row("a", 1), nil)
+	_, err := sut.Read(ctx, testEi, keys("a"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sut.lru.Len())
+}
+
+func TestCacheKey(t *testing.T) {
+	ts := time.Unix(1500000000, 0)
+	key, ok := cacheKey(testEi, map[string]dosa.FieldValue{"p1": "a/b", "c1": []byte{0xff}, "v1": int64(1)})
+	assert.True(t, ok)
+	assert.Equal(t, `"testScope"/"testPrefix"/"testEntityName"/c1=ff/p1="a/b"`, key)
+
+	var b bytes.Buffer
+	writeValue(&b, ts)
+	writeValue(&b, dosa.UUID("u"))
+	writeValue(&b, int32(7))
+	assert.Equal(t, `1500000000000000000"u"7`, b.String())
+}
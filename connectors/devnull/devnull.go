@@ -130,6 +130,11 @@ func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error)
 	return true, nil
 }
 
+// ListScopes returns no scopes
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	return nil, nil
+}
+
 // Shutdown always returns nil
 func (c *Connector) Shutdown() error {
 	return nil
@@ -147,6 +147,12 @@ func TestDevNull_ScopeExists(t *testing.T) {
 	assert.True(t, e)
 }
 
+func TestDevNull_ListScopes(t *testing.T) {
+	scopes, err := sut.ListScopes(ctx, "")
+	assert.NoError(t, err)
+	assert.Empty(t, scopes)
+}
+
 func TestDevNull_Shutdown(t *testing.T) {
 	assert.Nil(t, sut.Shutdown())
 }
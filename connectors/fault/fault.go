@@ -0,0 +1,348 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fault
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// ErrInjected is the error returned for failures injected without a
+// specific error
+type ErrInjected struct {
+	Method string
+}
+
+// Error returns the method the failure was injected in
+func (e *ErrInjected) Error() string {
+	return fmt.Sprintf("injected failure in %s", e.Method)
+}
+
+// ErrorIsInjected checks if the error is caused by "ErrInjected"
+func ErrorIsInjected(err error) bool {
+	_, ok := errors.Cause(err).(*ErrInjected)
+	return ok
+}
+
+// Fault describes a fault and the calls it is injected in
+type Fault struct {
+	// Methods are the connector methods the fault applies to, such as
+	// "Read" or "Range"; empty means all methods
+	Methods []string
+	// Entities are the entity names the fault applies to; empty means all
+	// entities. Schema and scope operations have no entity name.
+	Entities []string
+	// Probability is the chance that a matching call gets the fault; zero
+	// means every matching call gets it
+	Probability float64
+
+	// Latency delays the call, or fails it with the context's error if
+	// the context ends first
+	Latency time.Duration
+	// Error fails the call without passing it on. Use &dosa.ErrNotFound{}
+	// for missing rows, &dosa.ErrTimeout{} for timeouts or &ErrInjected{}
+	// for generic failures; the method of an ErrInjected is filled in when
+	// it is empty. A bare context.DeadlineExceeded is returned as a
+	// dosa.ErrTimeout, as the yarpc connector reports gateway timeouts, so
+	// retries and circuit breakers treat both the same.
+	Error error
+	// RowErrorProbability is the chance that each row of a MultiRead is
+	// replaced by RowError (or by ErrInjected when RowError is nil)
+	RowErrorProbability float64
+	RowError            error
+	// PageLimit caps the number of rows of Range, Search and Scan pages,
+	// so callers get short pages that still have a valid next token
+	PageLimit int
+}
+
+func contains(names []string, name string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Config lists the faults to inject
+type Config struct {
+	// Faults are tried in order and the first one that matches a call and
+	// passes its probability draw is injected
+	Faults []Fault
+	// Seed seeds the random choices so a run can be reproduced; zero picks
+	// a seed from the current time
+	Seed int64
+}
+
+// Connector injects latency and failures into the calls to the next
+// connector, to test how services behave when the storage misbehaves
+type Connector struct {
+	base.Connector
+	faults []Fault
+
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+// NewConnector creates a fault injecting connector in front of next
+func NewConnector(next dosa.Connector, cfg Config) *Connector {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		faults:    cfg.Faults,
+		rand:      rand.New(rand.NewSource(seed)),
+	}
+}
+
+// draw returns true with the given probability
+func (c *Connector) draw(p float64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.rand.Float64() < p
+}
+
+// inject picks the fault for a call and applies its latency and error. It
+// returns the fault, if any, and the error to fail the call with.
+func (c *Connector) inject(ctx context.Context, method string, ei *dosa.EntityInfo) (*Fault, error) {
+	entity := ""
+	if ei != nil && ei.Ref != nil {
+		entity = ei.Ref.EntityName
+	}
+
+	var fault *Fault
+	for i := range c.faults {
+		f := &c.faults[i]
+		if !contains(f.Methods, method) || !contains(f.Entities, entity) {
+			continue
+		}
+		if f.Probability > 0 && !c.draw(f.Probability) {
+			continue
+		}
+		fault = f
+		break
+	}
+	if fault == nil {
+		return nil, nil
+	}
+
+	if fault.Latency > 0 {
+		timer := time.NewTimer(fault.Latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fault, ctx.Err()
+		}
+	}
+	if fault.Error != nil {
+		if injected, ok := fault.Error.(*ErrInjected); ok && injected.Method == "" {
+			return fault, &ErrInjected{Method: method}
+		}
+		if fault.Error == context.DeadlineExceeded {
+			return fault, &dosa.ErrTimeout{Err: fault.Error}
+		}
+		return fault, fault.Error
+	}
+	return fault, nil
+}
+
+// pageLimit returns the limit to pass on for a page
+func pageLimit(fault *Fault, limit int) int {
+	if fault != nil && fault.PageLimit > 0 && (limit <= 0 || limit > fault.PageLimit) {
+		return fault.PageLimit
+	}
+	return limit
+}
+
+// CreateIfNotExists injects faults into CreateIfNotExists
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if _, err := c.inject(ctx, "CreateIfNotExists", ei); err != nil {
+		return err
+	}
+	return c.Connector.CreateIfNotExists(ctx, ei, values)
+}
+
+// Read injects faults into Read
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	if _, err := c.inject(ctx, "Read", ei); err != nil {
+		return nil, err
+	}
+	return c.Connector.Read(ctx, ei, keys, fieldsToRead)
+}
+
+// MultiRead injects faults into MultiRead, including failures of single rows
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	fault, err := c.inject(ctx, "MultiRead", ei)
+	if err != nil {
+		return nil, err
+	}
+	results, err := c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+	if err != nil || fault == nil || fault.RowErrorProbability <= 0 {
+		return results, err
+	}
+	for i := range results {
+		if !c.draw(fault.RowErrorProbability) {
+			continue
+		}
+		rowErr := fault.RowError
+		if rowErr == nil {
+			rowErr = &ErrInjected{Method: "MultiRead"}
+		}
+		results[i] = &dosa.FieldValuesOrError{Error: rowErr}
+	}
+	return results, nil
+}
+
+// Upsert injects faults into Upsert
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if _, err := c.inject(ctx, "Upsert", ei); err != nil {
+		return err
+	}
+	return c.Connector.Upsert(ctx, ei, values)
+}
+
+// MultiUpsert injects faults into MultiUpsert
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	if _, err := c.inject(ctx, "MultiUpsert", ei); err != nil {
+		return nil, err
+	}
+	return c.Connector.MultiUpsert(ctx, ei, values)
+}
+
+// Remove injects faults into Remove
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	if _, err := c.inject(ctx, "Remove", ei); err != nil {
+		return err
+	}
+	return c.Connector.Remove(ctx, ei, keys)
+}
+
+// MultiRemove injects faults into MultiRemove
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	if _, err := c.inject(ctx, "MultiRemove", ei); err != nil {
+		return nil, err
+	}
+	return c.Connector.MultiRemove(ctx, ei, multiKeys)
+}
+
+// Range injects faults into Range, including short pages
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	fault, err := c.inject(ctx, "Range", ei)
+	if err != nil {
+		return nil, "", err
+	}
+	return c.Connector.Range(ctx, ei, columnConditions, fieldsToRead, token, pageLimit(fault, limit))
+}
+
+// Search injects faults into Search, including short pages
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPairs dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	fault, err := c.inject(ctx, "Search", ei)
+	if err != nil {
+		return nil, "", err
+	}
+	return c.Connector.Search(ctx, ei, fieldPairs, fieldsToRead, token, pageLimit(fault, limit))
+}
+
+// Scan injects faults into Scan, including short pages
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	fault, err := c.inject(ctx, "Scan", ei)
+	if err != nil {
+		return nil, "", err
+	}
+	return c.Connector.Scan(ctx, ei, fieldsToRead, token, pageLimit(fault, limit))
+}
+
+// CheckSchema injects faults into CheckSchema
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	if _, err := c.inject(ctx, "CheckSchema", nil); err != nil {
+		return dosa.InvalidVersion, err
+	}
+	return c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+}
+
+// UpsertSchema injects faults into UpsertSchema
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	if _, err := c.inject(ctx, "UpsertSchema", nil); err != nil {
+		return nil, err
+	}
+	return c.Connector.UpsertSchema(ctx, scope, namePrefix, ed)
+}
+
+// CheckSchemaStatus injects faults into CheckSchemaStatus
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	if _, err := c.inject(ctx, "CheckSchemaStatus", nil); err != nil {
+		return nil, err
+	}
+	return c.Connector.CheckSchemaStatus(ctx, scope, namePrefix, version)
+}
+
+// CreateScope injects faults into CreateScope
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	if _, err := c.inject(ctx, "CreateScope", nil); err != nil {
+		return err
+	}
+	return c.Connector.CreateScope(ctx, scope)
+}
+
+// TruncateScope injects faults into TruncateScope
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	if _, err := c.inject(ctx, "TruncateScope", nil); err != nil {
+		return err
+	}
+	return c.Connector.TruncateScope(ctx, scope)
+}
+
+// DropScope injects faults into DropScope
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	if _, err := c.inject(ctx, "DropScope", nil); err != nil {
+		return err
+	}
+	return c.Connector.DropScope(ctx, scope)
+}
+
+// ScopeExists injects faults into ScopeExists
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	if _, err := c.inject(ctx, "ScopeExists", nil); err != nil {
+		return false, err
+	}
+	return c.Connector.ScopeExists(ctx, scope)
+}
+
+// ListScopes injects faults into ListScopes
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	if _, err := c.inject(ctx, "ListScopes", nil); err != nil {
+		return nil, err
+	}
+	return c.Connector.ListScopes(ctx, owner)
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fault_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+	"github.com/uber-go/dosa/connectors/fault"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/connectors/retry"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+func entityInfo(entity string) *dosa.EntityInfo {
+	return &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "scope", NamePrefix: "prefix", EntityName: entity},
+		Def: &dosa.EntityDefinition{
+			Name: entity,
+			Key: &dosa.PrimaryKey{
+				PartitionKeys:  []string{"p"},
+				ClusteringKeys: []*dosa.ClusteringKey{{Name: "c"}},
+			},
+			Columns: []*dosa.ColumnDefinition{
+				{Name: "p", Type: dosa.String},
+				{Name: "c", Type: dosa.Int64},
+			},
+		},
+	}
+}
+
+var (
+	users  = entityInfo("user")
+	orders = entityInfo("order")
+	keys   = map[string]dosa.FieldValue{"p": "a", "c": int64(1)}
+)
+
+func TestMatchByMethodAndEntity(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	next := mocks.NewMockConnector(ctrl)
+	c := fault.NewConnector(next, fault.Config{Faults: []fault.Fault{
+		{Methods: []string{"Read"}, Entities: []string{"user"}, Error: &dosa.ErrNotFound{}},
+		{Methods: []string{"Upsert"}, Error: &fault.ErrInjected{}},
+		{Methods: []string{"CreateScope"}, Error: context.DeadlineExceeded},
+	}})
+
+	_, err := c.Read(ctx, users, keys, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	next.EXPECT().Read(ctx, orders, keys, nil).Return(keys, nil)
+	values, err := c.Read(ctx, orders, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, keys, values)
+
+	err = c.Upsert(ctx, orders, keys)
+	assert.True(t, fault.ErrorIsInjected(err))
+	assert.EqualError(t, err, "injected failure in Upsert")
+
+	// a bare deadline is reported as a timeout, and still has the deadline
+	// as its cause
+	err = c.CreateScope(ctx, "scope")
+	assert.True(t, dosa.ErrorIsTimeout(err))
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	next.EXPECT().DropScope(ctx, "scope").Return(nil)
+	assert.NoError(t, c.DropScope(ctx, "scope"))
+}
+
+// counter counts the reads passed to the next connector
+type counter struct {
+	base.Connector
+	reads int
+}
+
+func (c *counter) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	c.reads++
+	return c.Connector.Read(ctx, ei, keys, fieldsToRead)
+}
+
+func TestInjectedTimeoutIsRetried(t *testing.T) {
+	for _, injected := range []error{&dosa.ErrTimeout{Err: context.DeadlineExceeded}, context.DeadlineExceeded} {
+		c := &counter{Connector: base.Connector{Next: fault.NewConnector(memory.NewConnector(), fault.Config{Faults: []fault.Fault{
+			{Methods: []string{"Read"}, Error: injected},
+		}})}}
+		sut := retry.NewConnector(c, retry.Config{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+		_, err := sut.Read(ctx, users, keys, nil)
+		assert.True(t, dosa.ErrorIsTimeout(err))
+		assert.Equal(t, 3, c.reads, "%v", injected)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	c := fault.NewConnector(memory.NewConnector(), fault.Config{Faults: []fault.Fault{
+		{Methods: []string{"Upsert"}, Latency: 20 * time.Millisecond},
+	}})
+
+	start := time.Now()
+	assert.NoError(t, c.Upsert(ctx, users, keys))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+	short, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.Upsert(short, users, keys))
+}
+
+func TestSeededProbability(t *testing.T) {
+	run := func(seed int64) []bool {
+		c := fault.NewConnector(&base.Connector{}, fault.Config{
+			Seed:   seed,
+			Faults: []fault.Fault{{Probability: 0.5, Error: &fault.ErrInjected{}}},
+		})
+		var injected []bool
+		for i := 0; i < 50; i++ {
+			_, err := c.Read(ctx, users, keys, nil)
+			injected = append(injected, fault.ErrorIsInjected(err))
+		}
+		return injected
+	}
+
+	first := run(42)
+	assert.Equal(t, first, run(42))
+	var count int
+	for _, injected := range first {
+		if injected {
+			count++
+		}
+	}
+	assert.True(t, count > 0 && count < 50)
+}
+
+func TestPartialMultiRead(t *testing.T) {
+	mem := memory.NewConnector()
+	var ks []map[string]dosa.FieldValue
+	for i := 0; i < 20; i++ {
+		row := map[string]dosa.FieldValue{"p": fmt.Sprintf("p%d", i), "c": int64(i)}
+		assert.NoError(t, mem.Upsert(ctx, users, row))
+		ks = append(ks, row)
+	}
+
+	c := fault.NewConnector(mem, fault.Config{Seed: 7, Faults: []fault.Fault{
+		{Methods: []string{"MultiRead"}, RowErrorProbability: 0.5},
+	}})
+	results, err := c.MultiRead(ctx, users, ks, nil)
+	assert.NoError(t, err)
+	assert.Len(t, results, 20)
+	var failed int
+	for i, result := range results {
+		if result.Error != nil {
+			failed++
+			assert.True(t, fault.ErrorIsInjected(result.Error))
+			continue
+		}
+		assert.Equal(t, ks[i]["p"], result.Values["p"])
+	}
+	assert.True(t, failed > 0 && failed < 20)
+}
+
+func TestShortPages(t *testing.T) {
+	mem := memory.NewConnector()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, mem.Upsert(ctx, users, map[string]dosa.FieldValue{"p": "a", "c": int64(i)}))
+	}
+
+	c := fault.NewConnector(mem, fault.Config{Faults: []fault.Fault{
+		{Methods: []string{"Range", "Scan"}, PageLimit: 3},
+	}})
+	conditions := map[string][]*dosa.Condition{"p": {{Op: dosa.Eq, Value: "a"}}}
+	var seen int
+	token := ""
+	for {
+		rows, next, err := c.Range(ctx, users, conditions, nil, token, 100)
+		assert.NoError(t, err)
+		assert.True(t, len(rows) <= 3)
+		seen += len(rows)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(t, 10, seen)
+
+	rows, _, err := c.Scan(ctx, users, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+}
@@ -0,0 +1,528 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package file
+
+import (
+	"context"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+// fileSuffix is the suffix of the files holding the rows of an entity
+const fileSuffix = ".gob"
+
+// ownerFile is the file of a scope directory holding the owner of the scope
+const ownerFile = "owner"
+
+// scanLimit is the page size used to read back all rows of an entity
+const scanLimit = 1024
+
+// Connector is a connector that persists its data in a local directory so
+// the data survives process restarts. Each scope is a subdirectory, and the
+// rows of each entity (identified by its SchemaRef) are stored in one file
+// of that subdirectory.
+//
+// Rows are served from memory and an entity's file is read the first time
+// the entity is used. Every write rewrites the entity's file by writing a
+// temporary file and renaming it over the old one, so a crash never leaves
+// a partially written file behind.
+type Connector struct {
+	lock   sync.Mutex
+	dir    string
+	mem    *memory.Connector
+	loaded map[string]bool
+}
+
+// NewConnector creates a connector that stores its data in dir. The
+// directory is created if it does not exist.
+func NewConnector(dir string) (*Connector, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "cannot create directory %q", dir)
+	}
+	return &Connector{
+		dir:    filepath.Clean(dir),
+		mem:    memory.NewConnector(),
+		loaded: map[string]bool{},
+	}, nil
+}
+
+// checkName makes sure a scope, name prefix or entity name can be used as
+// part of a file name without reaching outside the data directory
+func checkName(kind, name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return &dosa.ErrInvalidRequest{Err: errors.Errorf("invalid %s name %q", kind, name)}
+	}
+	return nil
+}
+
+// inDir checks that a path built from names is inside the data directory
+func (c *Connector) inDir(path string) error {
+	rel, err := filepath.Rel(c.dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return &dosa.ErrInvalidRequest{Err: errors.Errorf("path %q is outside of %q", path, c.dir)}
+	}
+	return nil
+}
+
+func (c *Connector) scopeDir(scope string) (string, error) {
+	if err := checkName("scope", scope); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(c.dir, scope)
+	if err := c.inDir(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func (c *Connector) entityFile(ref *dosa.SchemaRef) (string, error) {
+	dir, err := c.scopeDir(ref.Scope)
+	if err != nil {
+		return "", err
+	}
+	if err := checkName("name prefix", ref.NamePrefix); err != nil {
+		return "", err
+	}
+	if err := checkName("entity", ref.EntityName); err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, ref.NamePrefix+"."+ref.EntityName+fileSuffix)
+	if err := c.inDir(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// load reads the rows of an entity from disk the first time the entity is
+// used. The caller must hold the lock.
+func (c *Connector) load(ctx context.Context, ei *dosa.EntityInfo) error {
+	name, err := c.entityFile(ei.Ref)
+	if err != nil {
+		return err
+	}
+	if c.loaded[name] {
+		return nil
+	}
+
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		c.loaded[name] = true
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot open %q", name)
+	}
+	defer f.Close()
+
+	var rows []map[string]dosa.FieldValue
+	if err := gob.NewDecoder(f).Decode(&rows); err != nil {
+		return errors.Wrapf(err, "cannot decode %q", name)
+	}
+	errs, err := c.mem.MultiUpsert(ctx, ei, rows)
+	if err != nil {
+		return errors.Wrapf(err, "cannot load %q", name)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return errors.Wrapf(err, "cannot load %q", name)
+		}
+	}
+	c.loaded[name] = true
+	return nil
+}
+
+// rows returns all the rows of an entity held in memory. The caller must
+// hold the lock.
+func (c *Connector) rows(ctx context.Context, ei *dosa.EntityInfo) ([]map[string]dosa.FieldValue, error) {
+	var rows []map[string]dosa.FieldValue
+	token := ""
+	for {
+		page, next, err := c.mem.Scan(ctx, ei, nil, token, scanLimit)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, page...)
+		if next == "" {
+			return rows, nil
+		}
+		token = next
+	}
+}
+
+// save writes all rows of an entity to disk. The caller must hold the lock.
+func (c *Connector) save(ctx context.Context, ei *dosa.EntityInfo) error {
+	rows, err := c.rows(ctx, ei)
+	if err != nil {
+		return err
+	}
+	name, err := c.entityFile(ei.Ref)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "cannot create directory %q", dir)
+	}
+	return writeFileAtomic(name, rows)
+}
+
+// unload drops the rows of an entity from memory, so they are read from
+// disk again the next time the entity is used. It undoes a change that was
+// made in memory but could not be saved. The caller must hold the lock.
+func (c *Connector) unload(ctx context.Context, ei *dosa.EntityInfo) {
+	if rows, err := c.rows(ctx, ei); err == nil {
+		_, _ = c.mem.MultiRemove(ctx, ei, rows)
+	}
+	if name, err := c.entityFile(ei.Ref); err == nil {
+		delete(c.loaded, name)
+	}
+}
+
+// writeFileAtomic encodes rows into a temporary file in the same directory
+// and renames it to name once it has been synced to disk. The directory is
+// synced after the rename so the new file survives a crash.
+func writeFileAtomic(name string, rows []map[string]dosa.FieldValue) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "cannot write %q", name)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err = gob.NewEncoder(f).Encode(rows); err != nil {
+		return errors.Wrapf(err, "cannot encode %q", name)
+	}
+	if err = f.Sync(); err != nil {
+		return errors.Wrapf(err, "cannot sync %q", name)
+	}
+	if err = f.Close(); err != nil {
+		return errors.Wrapf(err, "cannot close %q", name)
+	}
+	if err = os.Rename(f.Name(), name); err != nil {
+		return errors.Wrapf(err, "cannot rename %q", name)
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir flushes the entries of a directory to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open directory %q", dir)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return errors.Wrapf(err, "cannot sync directory %q", dir)
+	}
+	return nil
+}
+
+// read loads the entity and runs a read only operation on it
+func (c *Connector) read(ctx context.Context, ei *dosa.EntityInfo, fn func() error) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.load(ctx, ei); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// write loads the entity, runs a write operation on it and saves the entity
+// back to disk. The entity is saved even if the operation failed, since
+// multi operations can partially succeed. If saving fails, the in-memory
+// rows are dropped so memory matches what is on disk again.
+func (c *Connector) write(ctx context.Context, ei *dosa.EntityInfo, fn func() error) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.load(ctx, ei); err != nil {
+		return err
+	}
+	opErr := fn()
+	if err := c.save(ctx, ei); err != nil {
+		c.unload(ctx, ei)
+		return err
+	}
+	return opErr
+}
+
+// CreateIfNotExists inserts a row if it does not exist yet
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.write(ctx, ei, func() error {
+		return c.mem.CreateIfNotExists(ctx, ei, values)
+	})
+}
+
+// Read fetches a row by primary key
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (values map[string]dosa.FieldValue, err error) {
+	err = c.read(ctx, ei, func() error {
+		values, err = c.mem.Read(ctx, ei, keys, fieldsToRead)
+		return err
+	})
+	return values, err
+}
+
+// MultiRead fetches several rows by primary key
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) (results []*dosa.FieldValuesOrError, err error) {
+	err = c.read(ctx, ei, func() error {
+		results, err = c.mem.MultiRead(ctx, ei, keys, fieldsToRead)
+		return err
+	})
+	return results, err
+}
+
+// Upsert updates some columns of a row, creating it if needed
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.write(ctx, ei, func() error {
+		return c.mem.Upsert(ctx, ei, values)
+	})
+}
+
+// MultiUpsert upserts several rows
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) (result []error, err error) {
+	err = c.write(ctx, ei, func() error {
+		result, err = c.mem.MultiUpsert(ctx, ei, multiValues)
+		return err
+	})
+	return result, err
+}
+
+// Remove deletes a row by primary key
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	return c.write(ctx, ei, func() error {
+		return c.mem.Remove(ctx, ei, keys)
+	})
+}
+
+// MultiRemove removes several rows by primary key
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) (result []error, err error) {
+	err = c.write(ctx, ei, func() error {
+		result, err = c.mem.MultiRemove(ctx, ei, multiKeys)
+		return err
+	})
+	return result, err
+}
+
+// Range returns the rows of a partition matching the conditions, in key order
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) (rows []map[string]dosa.FieldValue, next string, err error) {
+	err = c.read(ctx, ei, func() error {
+		rows, next, err = c.mem.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+		return err
+	})
+	return rows, next, err
+}
+
+// Search returns the rows whose searchable field matches the given value
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPair dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) (rows []map[string]dosa.FieldValue, next string, err error) {
+	err = c.read(ctx, ei, func() error {
+		rows, next, err = c.mem.Search(ctx, ei, fieldPair, fieldsToRead, token, limit)
+		return err
+	})
+	return rows, next, err
+}
+
+// Scan returns all the rows of an entity
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) (rows []map[string]dosa.FieldValue, next string, err error) {
+	err = c.read(ctx, ei, func() error {
+		rows, next, err = c.mem.Scan(ctx, ei, fieldsToRead, token, limit)
+		return err
+	})
+	return rows, next, err
+}
+
+// CheckSchema validates the entity definitions and always returns version 1
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (int32, error) {
+	return c.mem.CheckSchema(ctx, scope, namePrefix, eds)
+}
+
+// UpsertSchema validates the entity definitions and always returns version 1
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	return c.mem.UpsertSchema(ctx, scope, namePrefix, eds)
+}
+
+// CheckSchemaStatus always returns a SchemaStatus with version 1 and COMPLETED status
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	return c.mem.CheckSchemaStatus(ctx, scope, namePrefix, version)
+}
+
+// CreateScope creates the directory of a scope and records
+// dosa.ScopeOwner(ctx) as its owner, returning ErrAlreadyExists if it exists
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	dir, err := c.scopeDir(scope)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return &dosa.ErrAlreadyExists{}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return errors.Wrapf(err, "cannot create scope %q", scope)
+	}
+	if owner := dosa.ScopeOwner(ctx); owner != "" {
+		if err := ioutil.WriteFile(filepath.Join(dir, ownerFile), []byte(owner), 0644); err != nil {
+			_ = os.RemoveAll(dir)
+			return errors.Wrapf(err, "cannot record the owner of scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// TruncateScope removes the data of all entities in a scope, returning
+// ErrNotFound if the scope does not exist
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	dir, err := c.scopeDir(scope)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return &dosa.ErrNotFound{}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileSuffix))
+	if err != nil {
+		return errors.Wrapf(err, "cannot truncate scope %q", scope)
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return errors.Wrapf(err, "cannot truncate scope %q", scope)
+		}
+	}
+	c.forget(ctx, scope, dir)
+	return nil
+}
+
+// DropScope removes a scope and all of its data, returning ErrNotFound if
+// the scope does not exist
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	dir, err := c.scopeDir(scope)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return &dosa.ErrNotFound{}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "cannot drop scope %q", scope)
+	}
+	c.forget(ctx, scope, dir)
+	return nil
+}
+
+// forget drops the in-memory copy of the scope stored in dir. The caller
+// must hold the lock.
+func (c *Connector) forget(ctx context.Context, scope, dir string) {
+	_ = c.mem.DropScope(ctx, scope)
+	prefix := dir + string(filepath.Separator)
+	for name := range c.loaded {
+		if strings.HasPrefix(name, prefix) {
+			delete(c.loaded, name)
+		}
+	}
+}
+
+// ScopeExists returns true if the directory of the scope exists
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	dir, err := c.scopeDir(scope)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot check scope %q", scope)
+	}
+	return info.IsDir(), nil
+}
+
+// ListScopes returns the names of the scope directories, in order, only
+// those created by owner if it is not empty
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot list directory %q", c.dir)
+	}
+	var scopes []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		if owner != "" {
+			recorded, err := ioutil.ReadFile(filepath.Join(c.dir, info.Name(), ownerFile))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, errors.Wrapf(err, "cannot read the owner of scope %q", info.Name())
+			}
+			if string(recorded) != owner {
+				continue
+			}
+		}
+		scopes = append(scopes, info.Name())
+	}
+	return scopes, nil
+}
+
+// Shutdown always returns nil, all data has already been written to disk
+func (c *Connector) Shutdown() error {
+	return nil
+}
+
+func init() {
+	// types stored in interface values must be registered with gob
+	gob.Register(dosa.UUID(""))
+	gob.Register(time.Time{})
+
+	dosa.RegisterConnector("file", func(args map[string]interface{}) (dosa.Connector, error) {
+		dir, ok := args["directory"].(string)
+		if !ok || dir == "" {
+			return nil, errors.New("directory must be specified")
+		}
+		return NewConnector(dir)
+	})
+}
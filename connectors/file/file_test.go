@@ -0,0 +1,322 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package file_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/file"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testscope",
+		NamePrefix: "testprefix",
+		EntityName: "testentityname",
+	},
+	Def: &dosa.EntityDefinition{
+		Name: "testentityname",
+		Key: &dosa.PrimaryKey{
+			PartitionKeys:  []string{"p1"},
+			ClusteringKeys: []*dosa.ClusteringKey{{Name: "c1", Descending: true}},
+		},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "p1", Type: dosa.TUUID},
+			{Name: "c1", Type: dosa.Timestamp},
+			{Name: "v1", Type: dosa.Blob},
+			{Name: "v2", Type: dosa.Int32},
+		},
+	},
+}
+
+var (
+	testUUID = dosa.UUID("b1f23fa3-f453-45b4-a5d5-6d73078ac3bd")
+	baseTime = time.Unix(1500000000, 0).UTC()
+)
+
+func row(c1 int) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"p1": testUUID,
+		"c1": baseTime.Add(time.Duration(c1) * time.Minute),
+		"v1": []byte{byte(c1)},
+		"v2": int32(c1),
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dosa-file-connector")
+	assert.NoError(t, err)
+	return dir
+}
+
+func TestConnector_Persistence(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	sut, err := file.NewConnector(dir)
+	assert.NoError(t, err)
+	for _, c1 := range []int{2, 1, 3} {
+		assert.NoError(t, sut.CreateIfNotExists(ctx, testEi, row(c1)))
+	}
+	assert.True(t, dosa.ErrorIsAlreadyExists(sut.CreateIfNotExists(ctx, testEi, row(1))))
+	errs, err := sut.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row(4), row(5)})
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.NoError(t, sut.Remove(ctx, testEi, map[string]dosa.FieldValue{"p1": testUUID, "c1": row(5)["c1"]}))
+	assert.NoError(t, sut.Shutdown())
+
+	// no temporary files are left behind
+	files, err := filepath.Glob(filepath.Join(dir, "testscope", "*"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "testscope", "testprefix.testentityname.gob")}, files)
+
+	// a new connector sees the same data
+	sut, err = file.NewConnector(dir)
+	assert.NoError(t, err)
+	values, err := sut.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": testUUID, "c1": row(2)["c1"]}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row(2), values)
+
+	results, err := sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{
+		{"p1": testUUID, "c1": row(4)["c1"]},
+		{"p1": testUUID, "c1": row(5)["c1"]},
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row(4), results[0].Values)
+	assert.True(t, dosa.ErrorIsNotFound(results[1].Error))
+
+	// range in descending key order, with pagination
+	conditions := map[string][]*dosa.Condition{"p1": {{Op: dosa.Eq, Value: testUUID}}}
+	rows, token, err := sut.Range(ctx, testEi, conditions, nil, "", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{row(4), row(3), row(2)}, rows)
+	rows, token, err = sut.Range(ctx, testEi, conditions, nil, token, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{row(1)}, rows)
+	assert.Empty(t, token)
+
+	rows, _, err = sut.Scan(ctx, testEi, []string{"v2"}, "", 0)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 4)
+
+	_, _, err = sut.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "v2", Value: int32(1)}, nil, "", 0)
+	assert.Error(t, err)
+
+	errs, err = sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{
+		{"p1": testUUID, "c1": row(1)["c1"]},
+		{"p1": testUUID, "c1": row(5)["c1"]},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, errs[0])
+	assert.True(t, dosa.ErrorIsNotFound(errs[1]))
+	assert.NoError(t, sut.Upsert(ctx, testEi, map[string]dosa.FieldValue{"p1": testUUID, "c1": row(2)["c1"], "v2": int32(42)}))
+
+	sut, err = file.NewConnector(dir)
+	assert.NoError(t, err)
+	rows, _, err = sut.Scan(ctx, testEi, []string{"v2"}, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{{"v2": int32(4)}, {"v2": int32(3)}, {"v2": int32(42)}}, rows)
+}
+
+func TestConnector_CorruptFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "testscope"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "testscope", "testprefix.testentityname.gob"), []byte("garbage"), 0644))
+
+	sut, err := file.NewConnector(dir)
+	assert.NoError(t, err)
+	_, err = sut.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": testUUID, "c1": baseTime}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot decode")
+}
+
+func TestConnector_Scopes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	sut, err := file.NewConnector(dir)
+	assert.NoError(t, err)
+
+	exists, err := sut.ScopeExists(ctx, "testscope")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.True(t, dosa.ErrorIsNotFound(sut.TruncateScope(ctx, "testscope")))
+	assert.True(t, dosa.ErrorIsNotFound(sut.DropScope(ctx, "testscope")))
+
+	assert.NoError(t, sut.CreateScope(ctx, "testscope"))
+	assert.True(t, dosa.ErrorIsAlreadyExists(sut.CreateScope(ctx, "testscope")))
+	exists, err = sut.ScopeExists(ctx, "testscope")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// truncate keeps the scope, but removes the data from disk and memory
+	assert.NoError(t, sut.Upsert(ctx, testEi, row(1)))
+	assert.NoError(t, sut.TruncateScope(ctx, "testscope"))
+	_, err = sut.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": testUUID, "c1": row(1)["c1"]}, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	exists, _ = sut.ScopeExists(ctx, "testscope")
+	assert.True(t, exists)
+
+	assert.NoError(t, sut.Upsert(ctx, testEi, row(1)))
+	assert.NoError(t, sut.DropScope(ctx, "testscope"))
+	exists, _ = sut.ScopeExists(ctx, "testscope")
+	assert.False(t, exists)
+	_, err = sut.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": testUUID, "c1": row(1)["c1"]}, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+}
+
+func TestConnector_FailedSave(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	sut, err := file.NewConnector(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, sut.Upsert(ctx, testEi, row(1)))
+
+	// replace the scope directory with a file so the next save fails
+	scopeDir := filepath.Join(dir, "testscope")
+	assert.NoError(t, os.Rename(scopeDir, scopeDir+".bak"))
+	assert.NoError(t, ioutil.WriteFile(scopeDir, nil, 0644))
+	assert.Error(t, sut.Upsert(ctx, testEi, row(2)))
+	assert.NoError(t, os.Remove(scopeDir))
+	assert.NoError(t, os.Rename(scopeDir+".bak", scopeDir))
+
+	// the row that was not saved is not served from memory either
+	_, err = sut.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": testUUID, "c1": row(2)["c1"]}, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	values, err := sut.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": testUUID, "c1": row(1)["c1"]}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row(1), values)
+}
+
+func TestConnector_InvalidNames(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	data := filepath.Join(dir, "data")
+	outside := filepath.Join(dir, "outside")
+	assert.NoError(t, os.Mkdir(outside, 0755))
+
+	sut, err := file.NewConnector(data)
+	assert.NoError(t, err)
+
+	for _, scope := range []string{"", ".", "..", "../outside", "a/../..", `a\b`} {
+		assert.True(t, dosa.ErrorIsInvalidRequest(sut.CreateScope(ctx, scope)), scope)
+		assert.True(t, dosa.ErrorIsInvalidRequest(sut.TruncateScope(ctx, scope)), scope)
+		assert.True(t, dosa.ErrorIsInvalidRequest(sut.DropScope(ctx, scope)), scope)
+		_, err = sut.ScopeExists(ctx, scope)
+		assert.True(t, dosa.ErrorIsInvalidRequest(err), scope)
+	}
+
+	for _, ref := range []dosa.SchemaRef{
+		{Scope: "..", NamePrefix: "prefix", EntityName: "entity"},
+		{Scope: "scope", NamePrefix: "../..", EntityName: "entity"},
+		{Scope: "scope", NamePrefix: "prefix", EntityName: "../entity"},
+		{Scope: "scope", NamePrefix: "", EntityName: "entity"},
+	} {
+		ref := ref
+		ei := &dosa.EntityInfo{Ref: &ref, Def: testEi.Def}
+		assert.True(t, dosa.ErrorIsInvalidRequest(sut.Upsert(ctx, ei, row(1))), "%v", ref)
+		_, err = sut.Read(ctx, ei, map[string]dosa.FieldValue{"p1": testUUID, "c1": row(1)["c1"]}, nil)
+		assert.True(t, dosa.ErrorIsInvalidRequest(err), "%v", ref)
+	}
+
+	// neither the data directory nor anything outside of it was touched
+	_, err = os.Stat(data)
+	assert.NoError(t, err)
+	_, err = os.Stat(outside)
+	assert.NoError(t, err)
+	files, err := ioutil.ReadDir(data)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestConnector_ListScopes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	sut, err := file.NewConnector(dir)
+	assert.NoError(t, err)
+
+	scopes, err := sut.ListScopes(ctx, "")
+	assert.NoError(t, err)
+	assert.Empty(t, scopes)
+
+	assert.NoError(t, sut.CreateScope(ctx, "b"))
+	assert.NoError(t, sut.CreateScope(ctx, "a"))
+	scopes, err = sut.ListScopes(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, scopes)
+
+	// scopes created with an owner can be listed by owner, also after a
+	// truncate and a restart
+	assert.NoError(t, sut.CreateScope(dosa.WithScopeOwner(ctx, "someone"), "c"))
+	assert.NoError(t, sut.CreateScope(dosa.WithScopeOwner(ctx, "other"), "d"))
+	assert.NoError(t, sut.TruncateScope(ctx, "c"))
+	sut, err = file.NewConnector(dir)
+	assert.NoError(t, err)
+	scopes, err = sut.ListScopes(ctx, "someone")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, scopes)
+	scopes, err = sut.ListScopes(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, scopes)
+}
+
+func TestConnector_Schema(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	sut, err := file.NewConnector(dir)
+	assert.NoError(t, err)
+	version, err := sut.CheckSchema(ctx, "testscope", "testprefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), version)
+	status, err := sut.UpsertSchema(ctx, "testscope", "testprefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), status.Version)
+	status, err = sut.CheckSchemaStatus(ctx, "testscope", "testprefix", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), status.Version)
+}
+
+func TestRegistration(t *testing.T) {
+	_, err := dosa.GetConnector("file", map[string]interface{}{})
+	assert.Error(t, err)
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c, err := dosa.GetConnector("file", map[string]interface{}{"directory": filepath.Join(dir, "data")})
+	assert.NoError(t, err)
+	assert.IsType(t, &file.Connector{}, c)
+	_, err = os.Stat(filepath.Join(dir, "data"))
+	assert.NoError(t, err)
+}
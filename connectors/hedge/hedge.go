@@ -0,0 +1,287 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hedge
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+const (
+	// DefaultDelay is how long a read waits before it is hedged
+	DefaultDelay = 10 * time.Millisecond
+	// DefaultSamples is the number of recent latencies the percentile is
+	// computed from
+	DefaultSamples = 1000
+	// DefaultMinDelay is the shortest delay the percentile may set
+	DefaultMinDelay = time.Millisecond
+	// DefaultMaxHedgeRatio is the largest fraction of requests hedged
+	DefaultMaxHedgeRatio = 0.1
+)
+
+const (
+	// minSamples is the number of latencies needed before the percentile
+	// is used instead of the fixed delay
+	minSamples = 20
+	// refreshEvery is the number of new latencies after which the
+	// percentile is computed again
+	refreshEvery = 16
+	// hedgeBurst is the number of hedges that may be sent in a row before
+	// the hedge ratio applies
+	hedgeBurst = 10
+)
+
+// Config controls when reads are hedged
+type Config struct {
+	// Delay is how long a read waits for its first response before a
+	// second request is sent. With a percentile it is used until enough
+	// latencies are known.
+	Delay time.Duration
+	// Percentile, between 0 and 1, hedges reads that take longer than this
+	// percentile of the recent latencies, e.g. 0.95; zero always uses Delay
+	Percentile float64
+	// Samples is the number of recent latencies the percentile is computed
+	// from
+	Samples int
+	// MinDelay is the shortest delay the percentile may set
+	MinDelay time.Duration
+	// MaxHedgeRatio, between 0 and 1, is the largest fraction of requests
+	// that may be hedged, so a slow backend does not get twice the load
+	MaxHedgeRatio float64
+}
+
+// Stats counts the hedged reads
+type Stats struct {
+	// Requests is the number of Reads and Ranges
+	Requests int64
+	// Hedged is the number of requests that sent a second request
+	Hedged int64
+	// HedgeWins is the number of hedged requests answered by the second
+	// request
+	HedgeWins int64
+}
+
+// Connector hedges Reads and Ranges to cut their tail latency: when the
+// first request has not returned after a delay, an identical second request
+// is sent, the first response is used and the other request is canceled
+// through its context. Only a fraction of the requests may be hedged.
+// Writes and other operations are passed on unchanged.
+type Connector struct {
+	// counters first, for the alignment of atomic operations
+	requests  int64
+	hedged    int64
+	hedgeWins int64
+
+	base.Connector
+	percentile float64
+	minDelay   time.Duration
+	ratio      float64
+
+	lock      sync.Mutex
+	delay     time.Duration
+	samples   []time.Duration
+	next      int
+	sinceLast int
+	// budget is the number of hedges that may be sent; every request adds
+	// ratio to it
+	budget float64
+}
+
+// NewConnector creates a hedging connector in front of next
+func NewConnector(next dosa.Connector, cfg Config) *Connector {
+	if cfg.Delay <= 0 {
+		cfg.Delay = DefaultDelay
+	}
+	if cfg.Samples <= 0 {
+		cfg.Samples = DefaultSamples
+	}
+	if cfg.MinDelay <= 0 {
+		cfg.MinDelay = DefaultMinDelay
+	}
+	if cfg.MaxHedgeRatio <= 0 || cfg.MaxHedgeRatio > 1 {
+		cfg.MaxHedgeRatio = DefaultMaxHedgeRatio
+	}
+	c := &Connector{
+		Connector: base.Connector{Next: next},
+		minDelay:  cfg.MinDelay,
+		ratio:     cfg.MaxHedgeRatio,
+		delay:     cfg.Delay,
+		budget:    hedgeBurst,
+	}
+	if cfg.Percentile > 0 && cfg.Percentile < 1 {
+		c.percentile = cfg.Percentile
+		c.samples = make([]time.Duration, 0, cfg.Samples)
+	}
+	return c
+}
+
+// Stats returns the hedging counters
+func (c *Connector) Stats() Stats {
+	return Stats{
+		Requests:  atomic.LoadInt64(&c.requests),
+		Hedged:    atomic.LoadInt64(&c.hedged),
+		HedgeWins: atomic.LoadInt64(&c.hedgeWins),
+	}
+}
+
+// Delay returns the current hedging delay
+func (c *Connector) Delay() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.delay
+}
+
+// observe records the latency of a request that was not canceled and
+// updates the delay from the percentile
+func (c *Connector) observe(latency time.Duration) {
+	if c.percentile == 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.samples) < cap(c.samples) {
+		c.samples = append(c.samples, latency)
+	} else {
+		c.samples[c.next] = latency
+		c.next = (c.next + 1) % len(c.samples)
+	}
+	c.sinceLast++
+	if len(c.samples) < minSamples || c.sinceLast < refreshEvery {
+		return
+	}
+	c.sinceLast = 0
+	sorted := append([]time.Duration(nil), c.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	c.delay = sorted[int(c.percentile*float64(len(sorted)-1))]
+	if c.delay < c.minDelay {
+		c.delay = c.minDelay
+	}
+}
+
+// earn adds the share of a new request to the hedge budget
+func (c *Connector) earn() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.budget += c.ratio
+	if c.budget > hedgeBurst {
+		c.budget = hedgeBurst
+	}
+}
+
+// spend takes a hedge from the budget, if there is one left
+func (c *Connector) spend() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.budget < 1 {
+		return false
+	}
+	c.budget--
+	return true
+}
+
+// hedge runs op, and runs it a second time if the first run is slower than
+// the delay. It returns the run whose result to use along with its error,
+// or -1 when the context ends first. The first successful run wins; an
+// error is only returned when no other run can still succeed.
+func (c *Connector) hedge(ctx context.Context, op func(ctx context.Context, run int) error) (int, error) {
+	atomic.AddInt64(&c.requests, 1)
+	c.earn()
+
+	type result struct {
+		run int
+		err error
+	}
+	results := make(chan result, 2)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := func(run int) {
+		go func() {
+			began := time.Now()
+			err := op(ctx, run)
+			// a run canceled because the other one won would cut the slow
+			// tail off the latencies
+			if ctx.Err() == nil {
+				c.observe(time.Since(began))
+			}
+			results <- result{run: run, err: err}
+		}()
+	}
+
+	start(0)
+	timer := time.NewTimer(c.Delay())
+	defer timer.Stop()
+	running := 1
+	for {
+		select {
+		case r := <-results:
+			running--
+			if r.err == nil || running == 0 {
+				if r.run == 1 {
+					atomic.AddInt64(&c.hedgeWins, 1)
+				}
+				return r.run, r.err
+			}
+		case <-timer.C:
+			if running == 1 && ctx.Err() == nil && c.spend() {
+				atomic.AddInt64(&c.hedged, 1)
+				running++
+				start(1)
+			}
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		}
+	}
+}
+
+// Read reads the row, hedging slow requests
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	var values [2]map[string]dosa.FieldValue
+	run, err := c.hedge(ctx, func(ctx context.Context, run int) error {
+		var err error
+		values[run], err = c.Connector.Read(ctx, ei, keys, fieldsToRead)
+		return err
+	})
+	if run < 0 {
+		return nil, err
+	}
+	return values[run], err
+}
+
+// Range reads the rows, hedging slow requests
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	var rows [2][]map[string]dosa.FieldValue
+	var tokens [2]string
+	run, err := c.hedge(ctx, func(ctx context.Context, run int) error {
+		var err error
+		rows[run], tokens[run], err = c.Connector.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+		return err
+	})
+	if run < 0 {
+		return nil, "", err
+	}
+	return rows[run], tokens[run], err
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hedge_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+	"github.com/uber-go/dosa/connectors/hedge"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{Scope: "scope", NamePrefix: "prefix", EntityName: "user"},
+}
+
+var keys = map[string]dosa.FieldValue{"id": int64(1)}
+
+// slow answers each call after the latency given for it, or with the
+// context's error if it is canceled first
+type slow struct {
+	base.Connector
+	lock      sync.Mutex
+	latencies []time.Duration
+	errs      []error
+	calls     int
+	canceled  int
+}
+
+func (s *slow) wait(ctx context.Context) error {
+	s.lock.Lock()
+	call := s.calls
+	s.calls++
+	latency := s.latencies[call%len(s.latencies)]
+	var err error
+	if call < len(s.errs) {
+		err = s.errs[call]
+	}
+	s.lock.Unlock()
+
+	select {
+	case <-time.After(latency):
+		return err
+	case <-ctx.Done():
+		s.lock.Lock()
+		s.canceled++
+		s.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *slow) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return map[string]dosa.FieldValue{"name": "alice"}, nil
+}
+
+func (s *slow) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, "", err
+	}
+	return []map[string]dosa.FieldValue{{"name": "alice"}}, "next", nil
+}
+
+func (s *slow) counts() (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls, s.canceled
+}
+
+func TestFastReadIsNotHedged(t *testing.T) {
+	next := &slow{latencies: []time.Duration{0}}
+	c := hedge.NewConnector(next, hedge.Config{Delay: 50 * time.Millisecond})
+
+	values, err := c.Read(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", values["name"])
+	calls, _ := next.counts()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, hedge.Stats{Requests: 1}, c.Stats())
+}
+
+func TestSlowReadIsHedged(t *testing.T) {
+	next := &slow{latencies: []time.Duration{time.Second, 0}}
+	c := hedge.NewConnector(next, hedge.Config{Delay: 5 * time.Millisecond})
+
+	start := time.Now()
+	values, err := c.Read(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", values["name"])
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, hedge.Stats{Requests: 1, Hedged: 1, HedgeWins: 1}, c.Stats())
+
+	// the first request is canceled
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, canceled := next.counts(); canceled == 1 {
+			break
+		}
+	}
+	_, canceled := next.counts()
+	assert.Equal(t, 1, canceled)
+}
+
+func TestSlowRangeIsHedged(t *testing.T) {
+	next := &slow{latencies: []time.Duration{time.Second, 0}}
+	c := hedge.NewConnector(next, hedge.Config{Delay: 5 * time.Millisecond})
+
+	rows, token, err := c.Range(ctx, testEi, nil, nil, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, "next", token)
+	assert.Equal(t, int64(1), c.Stats().HedgeWins)
+}
+
+func TestErrors(t *testing.T) {
+	// a failed first request waits for the hedged one
+	next := &slow{latencies: []time.Duration{20 * time.Millisecond, 30 * time.Millisecond}, errs: []error{errors.New("boom")}}
+	c := hedge.NewConnector(next, hedge.Config{Delay: 5 * time.Millisecond})
+	values, err := c.Read(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", values["name"])
+
+	// a failure before the delay is returned as is
+	next = &slow{latencies: []time.Duration{0}, errs: []error{&dosa.ErrNotFound{}}}
+	c = hedge.NewConnector(next, hedge.Config{Delay: 50 * time.Millisecond})
+	_, err = c.Read(ctx, testEi, keys, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.Equal(t, int64(0), c.Stats().Hedged)
+
+	// the caller's context still applies
+	next = &slow{latencies: []time.Duration{time.Second}}
+	c = hedge.NewConnector(next, hedge.Config{Delay: 5 * time.Millisecond})
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = c.Read(short, testEi, keys, nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestPercentileDelay(t *testing.T) {
+	next := &slow{latencies: []time.Duration{time.Millisecond}}
+	c := hedge.NewConnector(next, hedge.Config{Delay: time.Second, Percentile: 0.9, Samples: 50})
+	assert.Equal(t, time.Second, c.Delay())
+
+	for i := 0; i < 40; i++ {
+		_, err := c.Read(ctx, testEi, keys, nil)
+		assert.NoError(t, err)
+	}
+	assert.True(t, c.Delay() < 100*time.Millisecond, "delay %v", c.Delay())
+}
+
+func TestMinDelay(t *testing.T) {
+	next := &slow{latencies: []time.Duration{0}}
+	c := hedge.NewConnector(next, hedge.Config{Delay: time.Second, Percentile: 0.5, Samples: 50, MinDelay: 5 * time.Millisecond})
+
+	for i := 0; i < 40; i++ {
+		_, err := c.Read(ctx, testEi, keys, nil)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 5*time.Millisecond, c.Delay())
+}
+
+func TestCanceledRunsAreNotObserved(t *testing.T) {
+	// every first request is slow and loses to its hedge
+	next := &slow{latencies: []time.Duration{time.Second, 0}}
+	c := hedge.NewConnector(next, hedge.Config{Delay: 20 * time.Millisecond, Percentile: 0.5, Samples: 50, MaxHedgeRatio: 1})
+
+	for i := 0; i < 40; i++ {
+		_, err := c.Read(ctx, testEi, keys, nil)
+		assert.NoError(t, err)
+	}
+	// only the hedges completed, so the delay comes from their latency and
+	// not from the canceled first requests
+	assert.Equal(t, hedge.DefaultMinDelay, c.Delay())
+}
+
+func TestHedgeRatio(t *testing.T) {
+	next := &slow{latencies: []time.Duration{5 * time.Millisecond}}
+	c := hedge.NewConnector(next, hedge.Config{Delay: time.Millisecond, MaxHedgeRatio: 0.1})
+
+	for i := 0; i < 50; i++ {
+		_, err := c.Read(ctx, testEi, keys, nil)
+		assert.NoError(t, err)
+	}
+	// a burst of 10, then one in 10 requests
+	stats := c.Stats()
+	assert.Equal(t, int64(50), stats.Requests)
+	assert.True(t, stats.Hedged >= 10 && stats.Hedged <= 15, "hedged %d", stats.Hedged)
+}
+
+func TestWritesAreNotHedged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	next := mocks.NewMockConnector(ctrl)
+	next.EXPECT().Upsert(ctx, testEi, keys).Do(func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue) {
+		time.Sleep(20 * time.Millisecond)
+	}).Return(nil)
+	c := hedge.NewConnector(next, hedge.Config{Delay: time.Millisecond})
+	assert.NoError(t, c.Upsert(ctx, testEi, keys))
+	assert.Equal(t, hedge.Stats{}, c.Stats())
+}
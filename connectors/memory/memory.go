@@ -0,0 +1,724 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package memory
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// defaultLimit is the number of rows returned by Range, Search and Scan
+// when no limit is specified
+const defaultLimit = 128
+
+// partitions holds the rows of a single entity, grouped by encoded partition
+// key. The rows of each partition are kept sorted by clustering key.
+type partitions map[string][]map[string]dosa.FieldValue
+
+// Connector is an in-memory connector. Rows are stored per scope, name
+// prefix and entity and are lost when the process exits. It is mainly
+// intended for tests that need a working datastore without a gateway.
+//
+// Scopes are created on demand by the data operations, so CreateScope does
+// not need to be called before writing data.
+type Connector struct {
+	lock sync.Mutex
+	// scope -> prefix.entity -> partitions
+	data map[string]map[string]partitions
+	// scope -> owner, for scopes created with an owner
+	owners map[string]string
+}
+
+// NewConnector creates a new, empty in-memory connector
+func NewConnector() *Connector {
+	return &Connector{data: map[string]map[string]partitions{}, owners: map[string]string{}}
+}
+
+// tableName is the key used for an entity within a scope
+func tableName(ref *dosa.SchemaRef) string {
+	return ref.NamePrefix + "." + ref.EntityName
+}
+
+// partitionsFor returns the partitions of the entity, creating the scope
+// and the entity storage if create is set. The caller must hold the lock.
+func (c *Connector) partitionsFor(ei *dosa.EntityInfo, create bool) partitions {
+	scope, ok := c.data[ei.Ref.Scope]
+	if !ok {
+		if !create {
+			return nil
+		}
+		scope = map[string]partitions{}
+		c.data[ei.Ref.Scope] = scope
+	}
+	parts, ok := scope[tableName(ei.Ref)]
+	if !ok {
+		if !create {
+			return nil
+		}
+		parts = partitions{}
+		scope[tableName(ei.Ref)] = parts
+	}
+	return parts
+}
+
+// ensureKeys checks that a value is provided for every primary key column
+func ensureKeys(ed *dosa.EntityDefinition, values map[string]dosa.FieldValue) error {
+	for _, k := range ed.Key.PartitionKeys {
+		if _, ok := values[k]; !ok {
+			return errors.Errorf("missing value for partition key column %q", k)
+		}
+	}
+	for _, ck := range ed.Key.ClusteringKeys {
+		if _, ok := values[ck.Name]; !ok {
+			return errors.Errorf("missing value for clustering key column %q", ck.Name)
+		}
+	}
+	return nil
+}
+
+// find locates the row with the same primary key as values. It returns the
+// partition key, the index where the row is or should be inserted and
+// whether the row exists. The caller must hold the lock.
+func find(ed *dosa.EntityDefinition, parts partitions, values map[string]dosa.FieldValue) (string, int, bool) {
+	pk := partitionKey(ed, values)
+	rows := parts[pk]
+	idx := sort.Search(len(rows), func(i int) bool {
+		return compareClusteringKeys(ed, rows[i], values) >= 0
+	})
+	found := idx < len(rows) && compareClusteringKeys(ed, rows[idx], values) == 0
+	return pk, idx, found
+}
+
+// CreateIfNotExists inserts a row if it does not exist yet. It returns
+// ErrAlreadyExists if a row with the same primary key is already stored.
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := ensureKeys(ei.Def, values); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	parts := c.partitionsFor(ei, true)
+	pk, idx, found := find(ei.Def, parts, values)
+	if found {
+		return &dosa.ErrAlreadyExists{}
+	}
+	parts[pk] = insertRow(parts[pk], idx, copyValues(values, nil))
+	return nil
+}
+
+// Read fetches a row by primary key, returning ErrNotFound if it does not exist
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	if err := ensureKeys(ei.Def, keys); err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.read(ei, keys, fieldsToRead)
+}
+
+func (c *Connector) read(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	parts := c.partitionsFor(ei, false)
+	if parts == nil {
+		return nil, &dosa.ErrNotFound{}
+	}
+	pk, idx, found := find(ei.Def, parts, keys)
+	if !found {
+		return nil, &dosa.ErrNotFound{}
+	}
+	return copyValues(parts[pk][idx], fieldsToRead), nil
+}
+
+// MultiRead fetches several rows by primary key. Rows that do not exist
+// have ErrNotFound set in their result.
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	for i, k := range keys {
+		if err := ensureKeys(ei.Def, k); err != nil {
+			results[i] = &dosa.FieldValuesOrError{Error: err}
+			continue
+		}
+		values, err := c.read(ei, k, fieldsToRead)
+		results[i] = &dosa.FieldValuesOrError{Values: values, Error: err}
+	}
+	return results, nil
+}
+
+// Upsert updates the given columns of a row, creating it if needed
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := ensureKeys(ei.Def, values); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.upsert(ei, values)
+	return nil
+}
+
+func (c *Connector) upsert(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) {
+	parts := c.partitionsFor(ei, true)
+	pk, idx, found := find(ei.Def, parts, values)
+	if !found {
+		parts[pk] = insertRow(parts[pk], idx, copyValues(values, nil))
+		return
+	}
+	row := parts[pk][idx]
+	for k, v := range values {
+		row[k] = copyValue(v)
+	}
+}
+
+// MultiUpsert upserts several rows
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := make([]error, len(multiValues))
+	for i, values := range multiValues {
+		if err := ensureKeys(ei.Def, values); err != nil {
+			result[i] = err
+			continue
+		}
+		c.upsert(ei, values)
+	}
+	return result, nil
+}
+
+// Remove deletes a row by primary key, returning ErrNotFound if it does not exist
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	if err := ensureKeys(ei.Def, keys); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.remove(ei, keys)
+}
+
+func (c *Connector) remove(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	parts := c.partitionsFor(ei, false)
+	if parts == nil {
+		return &dosa.ErrNotFound{}
+	}
+	pk, idx, found := find(ei.Def, parts, keys)
+	if !found {
+		return &dosa.ErrNotFound{}
+	}
+	rows := parts[pk]
+	if len(rows) == 1 {
+		delete(parts, pk)
+		return nil
+	}
+	parts[pk] = append(rows[:idx], rows[idx+1:]...)
+	return nil
+}
+
+// MultiRemove removes several rows by primary key. Rows that do not exist
+// have ErrNotFound set in their result.
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := make([]error, len(multiKeys))
+	for i, keys := range multiKeys {
+		if err := ensureKeys(ei.Def, keys); err != nil {
+			result[i] = err
+			continue
+		}
+		result[i] = c.remove(ei, keys)
+	}
+	return result, nil
+}
+
+// Range returns the rows of a partition matching the conditions, in
+// clustering key order
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	if err := dosa.EnsureValidRangeConditions(ei.Def, columnConditions, func(s string) string { return s }); err != nil {
+		return nil, "", errors.Wrap(err, "invalid range conditions")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// all partition keys have an Eq condition, so build the partition key
+	// from the condition values
+	keys := map[string]dosa.FieldValue{}
+	for _, name := range ei.Def.Key.PartitionKeys {
+		keys[name] = columnConditions[name][0].Value
+	}
+
+	var matched []map[string]dosa.FieldValue
+	if parts := c.partitionsFor(ei, false); parts != nil {
+		for _, row := range parts[partitionKey(ei.Def, keys)] {
+			if matchesConditions(row, columnConditions) {
+				matched = append(matched, row)
+			}
+		}
+	}
+	return page(ei.Def, matched, fieldsToRead, token, limit)
+}
+
+// Search returns the rows whose searchable field matches the given value
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPair dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	cd := ei.Def.FindColumnDefinition(fieldPair.Name)
+	if cd == nil {
+		return nil, "", errors.Errorf("cannot search on unknown column %q", fieldPair.Name)
+	}
+	if !cd.IsSearchable() {
+		return nil, "", errors.Errorf("column %q is not searchable", fieldPair.Name)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var matched []map[string]dosa.FieldValue
+	for _, row := range c.allRows(ei) {
+		if v, ok := row[fieldPair.Name]; ok && compareValues(v, fieldPair.Value) == 0 {
+			matched = append(matched, row)
+		}
+	}
+	return page(ei.Def, matched, fieldsToRead, token, limit)
+}
+
+// Scan returns all the rows of an entity
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return page(ei.Def, c.allRows(ei), fieldsToRead, token, limit)
+}
+
+// allRows returns all rows of an entity, ordered by partition key and then
+// clustering key. The caller must hold the lock.
+func (c *Connector) allRows(ei *dosa.EntityInfo) []map[string]dosa.FieldValue {
+	parts := c.partitionsFor(ei, false)
+	pks := make([]string, 0, len(parts))
+	for pk := range parts {
+		pks = append(pks, pk)
+	}
+	sort.Strings(pks)
+
+	var rows []map[string]dosa.FieldValue
+	for _, pk := range pks {
+		rows = append(rows, parts[pk]...)
+	}
+	return rows
+}
+
+// position is the primary key of the last row of a page. Tokens hold a
+// position rather than an offset, so rows inserted or removed between two
+// pages do not make the next page skip or repeat rows.
+type position struct {
+	Partition  string            `json:"p"`
+	Clustering []json.RawMessage `json:"c"`
+}
+
+// encodeToken returns the token resuming after the given row
+func encodeToken(ed *dosa.EntityDefinition, row map[string]dosa.FieldValue) (string, error) {
+	pos := position{Partition: partitionKey(ed, row)}
+	for _, ck := range ed.Key.ClusteringKeys {
+		raw, err := json.Marshal(row[ck.Name])
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot encode value of column %q in token", ck.Name)
+		}
+		pos.Clustering = append(pos.Clustering, raw)
+	}
+	data, err := json.Marshal(pos)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot encode token")
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decodeToken returns the partition key and the clustering key values of
+// the row a token resumes after
+func decodeToken(ed *dosa.EntityDefinition, token string) (string, map[string]dosa.FieldValue, error) {
+	data, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", nil, errors.Errorf("invalid token %q", token)
+	}
+	var pos position
+	if err := json.Unmarshal(data, &pos); err != nil || len(pos.Clustering) != len(ed.Key.ClusteringKeys) {
+		return "", nil, errors.Errorf("invalid token %q", token)
+	}
+	values := make(map[string]dosa.FieldValue, len(pos.Clustering))
+	for i, ck := range ed.Key.ClusteringKeys {
+		cd := ed.FindColumnDefinition(ck.Name)
+		if cd == nil {
+			return "", nil, errors.Errorf("invalid token %q", token)
+		}
+		v, err := decodeTokenValue(cd.Type, pos.Clustering[i])
+		if err != nil {
+			return "", nil, errors.Errorf("invalid token %q", token)
+		}
+		values[ck.Name] = v
+	}
+	return pos.Partition, values, nil
+}
+
+// decodeTokenValue decodes a clustering key value of a token into the type of
+// its column
+func decodeTokenValue(t dosa.Type, raw json.RawMessage) (dosa.FieldValue, error) {
+	var v interface{}
+	switch t {
+	case dosa.TUUID:
+		v = new(dosa.UUID)
+	case dosa.String:
+		v = new(string)
+	case dosa.Int32:
+		v = new(int32)
+	case dosa.Int64:
+		v = new(int64)
+	case dosa.Double:
+		v = new(float64)
+	case dosa.Blob:
+		v = new([]byte)
+	case dosa.Timestamp:
+		v = new(time.Time)
+	case dosa.Bool:
+		v = new(bool)
+	default:
+		return nil, errors.Errorf("unsupported type %v", t)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(v).Elem().Interface(), nil
+}
+
+// page returns one page of rows following the row encoded in token, along
+// with the token for the next page. The rows must be ordered by partition
+// key and then clustering key, like allRows returns them. The token is empty
+// when there are no more rows.
+func page(ed *dosa.EntityDefinition, rows []map[string]dosa.FieldValue, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	offset := 0
+	if token != "" {
+		pk, last, err := decodeToken(ed, token)
+		if err != nil {
+			return nil, "", err
+		}
+		offset = sort.Search(len(rows), func(i int) bool {
+			if rowPK := partitionKey(ed, rows[i]); rowPK != pk {
+				return rowPK > pk
+			}
+			return compareClusteringKeys(ed, rows[i], last) > 0
+		})
+	}
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	end := offset + limit
+	if end > len(rows) {
+		end = len(rows)
+	}
+	results := make([]map[string]dosa.FieldValue, 0, end-offset)
+	for _, row := range rows[offset:end] {
+		results = append(results, copyValues(row, fieldsToRead))
+	}
+	if end == len(rows) {
+		return results, "", nil
+	}
+	nextToken, err := encodeToken(ed, rows[end-1])
+	if err != nil {
+		return nil, "", err
+	}
+	return results, nextToken, nil
+}
+
+// CheckSchema validates the entity definitions and always returns version 1
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (int32, error) {
+	for _, ed := range eds {
+		if err := ed.EnsureValid(); err != nil {
+			return dosa.InvalidVersion, errors.Wrap(err, "invalid entity definition")
+		}
+	}
+	return int32(1), nil
+}
+
+// UpsertSchema validates the entity definitions and always returns version 1
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	version, err := c.CheckSchema(ctx, scope, namePrefix, eds)
+	if err != nil {
+		return nil, err
+	}
+	return &dosa.SchemaStatus{Version: version, Status: "COMPLETED"}, nil
+}
+
+// CheckSchemaStatus always returns a SchemaStatus with version 1 and COMPLETED status
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	return &dosa.SchemaStatus{Version: int32(1), Status: "COMPLETED"}, nil
+}
+
+// CreateScope creates an empty scope owned by dosa.ScopeOwner(ctx),
+// returning ErrAlreadyExists if it exists
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.data[scope]; ok {
+		return &dosa.ErrAlreadyExists{}
+	}
+	c.data[scope] = map[string]partitions{}
+	if owner := dosa.ScopeOwner(ctx); owner != "" {
+		c.owners[scope] = owner
+	}
+	return nil
+}
+
+// TruncateScope removes all the data of a scope, returning ErrNotFound if
+// the scope does not exist
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.data[scope]; !ok {
+		return &dosa.ErrNotFound{}
+	}
+	c.data[scope] = map[string]partitions{}
+	return nil
+}
+
+// DropScope removes a scope and all of its data, returning ErrNotFound if
+// the scope does not exist
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.data[scope]; !ok {
+		return &dosa.ErrNotFound{}
+	}
+	delete(c.data, scope)
+	delete(c.owners, scope)
+	return nil
+}
+
+// ScopeExists returns true if the scope has been created
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, ok := c.data[scope]
+	return ok, nil
+}
+
+// ListScopes returns the names of the scopes that exist, in order, only
+// those created by owner if it is not empty
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	scopes := make([]string, 0, len(c.data))
+	for scope := range c.data {
+		if owner == "" || c.owners[scope] == owner {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+// Shutdown always returns nil
+func (c *Connector) Shutdown() error {
+	return nil
+}
+
+// insertRow inserts a row at index idx
+func insertRow(rows []map[string]dosa.FieldValue, idx int, row map[string]dosa.FieldValue) []map[string]dosa.FieldValue {
+	rows = append(rows, nil)
+	copy(rows[idx+1:], rows[idx:])
+	rows[idx] = row
+	return rows
+}
+
+// copyValues makes a copy of the values so callers can't modify the stored
+// rows. If fieldsToRead is not empty, only those fields are copied.
+func copyValues(values map[string]dosa.FieldValue, fieldsToRead []string) map[string]dosa.FieldValue {
+	result := make(map[string]dosa.FieldValue, len(values))
+	if len(fieldsToRead) == 0 {
+		for k, v := range values {
+			result[k] = copyValue(v)
+		}
+		return result
+	}
+	for _, k := range fieldsToRead {
+		if v, ok := values[k]; ok {
+			result[k] = copyValue(v)
+		}
+	}
+	return result
+}
+
+func copyValue(v dosa.FieldValue) dosa.FieldValue {
+	if b, ok := v.([]byte); ok && b != nil {
+		return append([]byte{}, b...)
+	}
+	return v
+}
+
+// partitionKey encodes the partition key values of a row into a string
+func partitionKey(ed *dosa.EntityDefinition, values map[string]dosa.FieldValue) string {
+	parts := make([]string, len(ed.Key.PartitionKeys))
+	for i, name := range ed.Key.PartitionKeys {
+		parts[i] = encodeValue(values[name])
+	}
+	return strings.Join(parts, ",")
+}
+
+func encodeValue(v dosa.FieldValue) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case dosa.UUID:
+		return strconv.Quote(string(v))
+	case []byte:
+		return fmt.Sprintf("%x", v)
+	case time.Time:
+		return strconv.FormatInt(v.UnixNano(), 10)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// compareClusteringKeys compares the clustering key values of two rows,
+// taking the order of each clustering key into account
+func compareClusteringKeys(ed *dosa.EntityDefinition, a, b map[string]dosa.FieldValue) int {
+	for _, ck := range ed.Key.ClusteringKeys {
+		r := compareValues(a[ck.Name], b[ck.Name])
+		if ck.Descending {
+			r = -r
+		}
+		if r != 0 {
+			return r
+		}
+	}
+	return 0
+}
+
+// matchesConditions checks whether a row satisfies all the conditions
+func matchesConditions(row map[string]dosa.FieldValue, columnConditions map[string][]*dosa.Condition) bool {
+	for name, conds := range columnConditions {
+		for _, cond := range conds {
+			r := compareValues(row[name], cond.Value)
+			var ok bool
+			switch cond.Op {
+			case dosa.Eq:
+				ok = r == 0
+			case dosa.Lt:
+				ok = r < 0
+			case dosa.LtOrEq:
+				ok = r <= 0
+			case dosa.Gt:
+				ok = r > 0
+			case dosa.GtOrEq:
+				ok = r >= 0
+			}
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compareValues compares two values of the same dosa type, returning -1, 0
+// or 1. Values of different or unsupported types compare as equal.
+func compareValues(a, b dosa.FieldValue) int {
+	switch av := a.(type) {
+	case int32:
+		if bv, ok := b.(int32); ok {
+			return compareInt64(int64(av), int64(bv))
+		}
+	case int64:
+		if bv, ok := b.(int64); ok {
+			return compareInt64(av, bv)
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1
+			case av > bv:
+				return 1
+			}
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv)
+		}
+	case dosa.UUID:
+		if bv, ok := b.(dosa.UUID); ok {
+			return strings.Compare(string(av), string(bv))
+		}
+	case []byte:
+		if bv, ok := b.([]byte); ok {
+			return bytes.Compare(av, bv)
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			switch {
+			case av.Before(bv):
+				return -1
+			case av.After(bv):
+				return 1
+			}
+		}
+	case bool:
+		if bv, ok := b.(bool); ok && av != bv {
+			if bv {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	dosa.RegisterConnector("memory", func(args map[string]interface{}) (dosa.Connector, error) {
+		return NewConnector(), nil
+	})
+}
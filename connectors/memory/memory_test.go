@@ -0,0 +1,403 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testScope",
+		NamePrefix: "testPrefix",
+		EntityName: "testEntityName",
+	},
+	Def: &dosa.EntityDefinition{
+		Name: "testentityname",
+		Key: &dosa.PrimaryKey{
+			PartitionKeys: []string{"p1"},
+			ClusteringKeys: []*dosa.ClusteringKey{
+				{Name: "c1", Descending: false},
+				{Name: "c2", Descending: true},
+			},
+		},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "p1", Type: dosa.String},
+			{Name: "c1", Type: dosa.Int64},
+			{Name: "c2", Type: dosa.Timestamp},
+			{Name: "v1", Type: dosa.Blob},
+			{Name: "v2", Type: dosa.String, Tags: map[string]string{dosa.SearchableTag: ""}},
+		},
+	},
+}
+
+var baseTime = time.Unix(1500000000, 0)
+
+func row(p1 string, c1 int64, c2 int, v2 string) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"p1": p1,
+		"c1": c1,
+		"c2": baseTime.Add(time.Duration(c2) * time.Second),
+		"v1": []byte{byte(c1), byte(c2)},
+		"v2": v2,
+	}
+}
+
+func keys(p1 string, c1 int64, c2 int) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"p1": p1,
+		"c1": c1,
+		"c2": baseTime.Add(time.Duration(c2) * time.Second),
+	}
+}
+
+func TestConnector_CreateIfNotExists(t *testing.T) {
+	sut := memory.NewConnector()
+
+	assert.NoError(t, sut.CreateIfNotExists(ctx, testEi, row("a", 1, 1, "x")))
+	err := sut.CreateIfNotExists(ctx, testEi, row("a", 1, 1, "y"))
+	assert.True(t, dosa.ErrorIsAlreadyExists(err))
+
+	values, err := sut.Read(ctx, testEi, keys("a", 1, 1), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "x", values["v2"])
+
+	// missing key
+	err = sut.CreateIfNotExists(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "c1")
+}
+
+func TestConnector_ReadUpsertRemove(t *testing.T) {
+	sut := memory.NewConnector()
+
+	_, err := sut.Read(ctx, testEi, keys("a", 1, 1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	original := row("a", 1, 1, "x")
+	assert.NoError(t, sut.Upsert(ctx, testEi, original))
+
+	// stored values must not alias the caller's values
+	original["v1"].([]byte)[0] = 42
+	values, err := sut.Read(ctx, testEi, keys("a", 1, 1), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row("a", 1, 1, "x"), values)
+
+	// partial upsert keeps the other columns
+	update := keys("a", 1, 1)
+	update["v2"] = "y"
+	assert.NoError(t, sut.Upsert(ctx, testEi, update))
+	values, err = sut.Read(ctx, testEi, keys("a", 1, 1), []string{"v2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dosa.FieldValue{"v2": "y"}, values)
+	values, err = sut.Read(ctx, testEi, keys("a", 1, 1), []string{"v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 1}, values["v1"])
+
+	assert.NoError(t, sut.Remove(ctx, testEi, keys("a", 1, 1)))
+	_, err = sut.Read(ctx, testEi, keys("a", 1, 1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.True(t, dosa.ErrorIsNotFound(sut.Remove(ctx, testEi, keys("a", 1, 1))))
+
+	// missing keys
+	assert.Error(t, sut.Upsert(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}))
+	_, err = sut.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}, nil)
+	assert.Error(t, err)
+	assert.Error(t, sut.Remove(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}))
+}
+
+func TestConnector_MultiOps(t *testing.T) {
+	sut := memory.NewConnector()
+
+	errs, err := sut.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{
+		row("a", 1, 1, "x"),
+		{"p1": "a"},
+		row("b", 2, 2, "y"),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, errs, 3)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+	assert.NoError(t, errs[2])
+
+	results, err := sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{
+		keys("b", 2, 2),
+		keys("c", 3, 3),
+		{"p1": "a"},
+		keys("a", 1, 1),
+	}, []string{"v2"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 4)
+	assert.Equal(t, map[string]dosa.FieldValue{"v2": "y"}, results[0].Values)
+	assert.True(t, dosa.ErrorIsNotFound(results[1].Error))
+	assert.Error(t, results[2].Error)
+	assert.Equal(t, map[string]dosa.FieldValue{"v2": "x"}, results[3].Values)
+
+	errs, err = sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{
+		keys("a", 1, 1),
+		keys("c", 3, 3),
+		{"p1": "a"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, errs, 3)
+	assert.NoError(t, errs[0])
+	assert.True(t, dosa.ErrorIsNotFound(errs[1]))
+	assert.Error(t, errs[2])
+}
+
+func TestConnector_Range(t *testing.T) {
+	sut := memory.NewConnector()
+
+	// insert out of order, c1 ascending and c2 descending
+	for _, c1 := range []int64{3, 1, 2} {
+		for _, c2 := range []int{1, 3, 2} {
+			assert.NoError(t, sut.Upsert(ctx, testEi, row("a", c1, c2, "x")))
+		}
+	}
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("b", 1, 1, "x")))
+
+	conditions := map[string][]*dosa.Condition{
+		"p1": {{Op: dosa.Eq, Value: "a"}},
+	}
+	rows, token, err := sut.Range(ctx, testEi, conditions, []string{"c1", "c2"}, "", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, token)
+	assert.Len(t, rows, 9)
+	expected := []struct {
+		c1 int64
+		c2 int
+	}{{1, 3}, {1, 2}, {1, 1}, {2, 3}, {2, 2}, {2, 1}, {3, 3}, {3, 2}, {3, 1}}
+	for i, e := range expected {
+		assert.Equal(t, keys("a", e.c1, e.c2)["c1"], rows[i]["c1"])
+		assert.Equal(t, keys("a", e.c1, e.c2)["c2"], rows[i]["c2"])
+		assert.NotContains(t, rows[i], "p1")
+	}
+
+	// conditions on clustering keys with pagination
+	conditions = map[string][]*dosa.Condition{
+		"p1": {{Op: dosa.Eq, Value: "a"}},
+		"c1": {{Op: dosa.Eq, Value: int64(2)}},
+		"c2": {{Op: dosa.Lt, Value: baseTime.Add(3 * time.Second)}},
+	}
+	rows, token, err = sut.Range(ctx, testEi, conditions, nil, "", 1)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, row("a", 2, 2, "x"), rows[0])
+	assert.NotEmpty(t, token)
+	rows, token, err = sut.Range(ctx, testEi, conditions, nil, token, 1)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, row("a", 2, 1, "x"), rows[0])
+	assert.Empty(t, token)
+
+	// range over a partition that does not exist
+	rows, token, err = sut.Range(ctx, testEi, map[string][]*dosa.Condition{
+		"p1": {{Op: dosa.Eq, Value: "z"}},
+	}, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, rows)
+	assert.Empty(t, token)
+
+	// invalid conditions
+	_, _, err = sut.Range(ctx, testEi, map[string][]*dosa.Condition{
+		"c1": {{Op: dosa.Eq, Value: int64(2)}},
+	}, nil, "", 0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "p1")
+
+	// invalid token
+	_, _, err = sut.Range(ctx, testEi, map[string][]*dosa.Condition{
+		"p1": {{Op: dosa.Eq, Value: "a"}},
+	}, nil, "bad token", 0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bad token")
+}
+
+func TestConnector_PagingWithWrites(t *testing.T) {
+	sut := memory.NewConnector()
+	for _, c1 := range []int64{1, 2, 3, 4} {
+		assert.NoError(t, sut.Upsert(ctx, testEi, row("a", c1, 1, "x")))
+	}
+	conditions := map[string][]*dosa.Condition{"p1": {{Op: dosa.Eq, Value: "a"}}}
+
+	rows, token, err := sut.Range(ctx, testEi, conditions, []string{"c1"}, "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{{"c1": int64(1)}, {"c1": int64(2)}}, rows)
+
+	// removing a row of the first page and inserting a row before the
+	// token neither skips nor repeats rows on the next page
+	assert.NoError(t, sut.Remove(ctx, testEi, keys("a", 1, 1)))
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a", 0, 1, "x")))
+	rows, token, err = sut.Range(ctx, testEi, conditions, []string{"c1"}, token, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{{"c1": int64(3)}, {"c1": int64(4)}}, rows)
+	assert.Empty(t, token)
+
+	rows, token, err = sut.Scan(ctx, testEi, []string{"c1"}, "", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{{"c1": int64(0)}}, rows)
+
+	// the row the token points at may itself be removed
+	assert.NoError(t, sut.Remove(ctx, testEi, keys("a", 0, 1)))
+	rows, _, err = sut.Scan(ctx, testEi, []string{"c1"}, token, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{{"c1": int64(2)}}, rows)
+}
+
+func TestConnector_ScanAndSearch(t *testing.T) {
+	sut := memory.NewConnector()
+
+	rows, token, err := sut.Scan(ctx, testEi, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, rows)
+	assert.Empty(t, token)
+
+	for _, p1 := range []string{"c", "a", "b"} {
+		for _, c1 := range []int64{2, 1} {
+			assert.NoError(t, sut.Upsert(ctx, testEi, row(p1, c1, 1, p1)))
+		}
+	}
+
+	var all []map[string]dosa.FieldValue
+	for {
+		rows, token, err = sut.Scan(ctx, testEi, []string{"p1", "c1"}, token, 4)
+		assert.NoError(t, err)
+		all = append(all, rows...)
+		if token == "" {
+			break
+		}
+	}
+	assert.Len(t, all, 6)
+	for i, p1 := range []string{"a", "a", "b", "b", "c", "c"} {
+		assert.Equal(t, p1, all[i]["p1"])
+		assert.Equal(t, int64(i%2+1), all[i]["c1"])
+	}
+
+	rows, token, err = sut.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "v2", Value: "b"}, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, token)
+	assert.Equal(t, []map[string]dosa.FieldValue{row("b", 1, 1, "b"), row("b", 2, 1, "b")}, rows)
+
+	_, _, err = sut.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "v1", Value: []byte{}}, nil, "", 0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not searchable")
+	_, _, err = sut.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "nope", Value: "x"}, nil, "", 0)
+	assert.Error(t, err)
+}
+
+func TestConnector_Scopes(t *testing.T) {
+	sut := memory.NewConnector()
+
+	exists, err := sut.ScopeExists(ctx, "testScope")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.True(t, dosa.ErrorIsNotFound(sut.TruncateScope(ctx, "testScope")))
+	assert.True(t, dosa.ErrorIsNotFound(sut.DropScope(ctx, "testScope")))
+
+	assert.NoError(t, sut.CreateScope(ctx, "testScope"))
+	assert.True(t, dosa.ErrorIsAlreadyExists(sut.CreateScope(ctx, "testScope")))
+	exists, err = sut.ScopeExists(ctx, "testScope")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a", 1, 1, "x")))
+	assert.NoError(t, sut.TruncateScope(ctx, "testScope"))
+	_, err = sut.Read(ctx, testEi, keys("a", 1, 1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	exists, _ = sut.ScopeExists(ctx, "testScope")
+	assert.True(t, exists)
+
+	// data in another scope is kept apart
+	otherEi := &dosa.EntityInfo{Ref: &dosa.SchemaRef{Scope: "otherScope", NamePrefix: "testPrefix", EntityName: "testEntityName"}, Def: testEi.Def}
+	assert.NoError(t, sut.Upsert(ctx, otherEi, row("a", 1, 1, "x")))
+	_, err = sut.Read(ctx, testEi, keys("a", 1, 1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	assert.NoError(t, sut.DropScope(ctx, "testScope"))
+	exists, _ = sut.ScopeExists(ctx, "testScope")
+	assert.False(t, exists)
+	exists, _ = sut.ScopeExists(ctx, "otherScope")
+	assert.True(t, exists)
+}
+
+func TestConnector_ListScopes(t *testing.T) {
+	sut := memory.NewConnector()
+
+	scopes, err := sut.ListScopes(ctx, "")
+	assert.NoError(t, err)
+	assert.Empty(t, scopes)
+
+	assert.NoError(t, sut.CreateScope(ctx, "b"))
+	assert.NoError(t, sut.CreateScope(ctx, "a"))
+	scopes, err = sut.ListScopes(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, scopes)
+
+	// scopes created with an owner can be listed by owner
+	assert.NoError(t, sut.CreateScope(dosa.WithScopeOwner(ctx, "someone"), "c"))
+	assert.NoError(t, sut.CreateScope(dosa.WithScopeOwner(ctx, "other"), "d"))
+	scopes, err = sut.ListScopes(ctx, "someone")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, scopes)
+	scopes, err = sut.ListScopes(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, scopes)
+
+	assert.NoError(t, sut.DropScope(ctx, "c"))
+	scopes, err = sut.ListScopes(ctx, "someone")
+	assert.NoError(t, err)
+	assert.Empty(t, scopes)
+}
+
+func TestConnector_Schema(t *testing.T) {
+	sut := memory.NewConnector()
+
+	version, err := sut.CheckSchema(ctx, "testScope", "testPrefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), version)
+
+	status, err := sut.UpsertSchema(ctx, "testScope", "testPrefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	assert.Equal(t, &dosa.SchemaStatus{Version: 1, Status: "COMPLETED"}, status)
+
+	_, err = sut.UpsertSchema(ctx, "testScope", "testPrefix", []*dosa.EntityDefinition{{Name: "bad"}})
+	assert.Error(t, err)
+
+	status, err = sut.CheckSchemaStatus(ctx, "testScope", "testPrefix", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), status.Version)
+
+	assert.NoError(t, sut.Shutdown())
+}
+
+func TestRegistration(t *testing.T) {
+	c, err := dosa.GetConnector("memory", nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &memory.Connector{}, c)
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Names of the metrics emitted by the connector
+const (
+	// CallsMetric counts every call to the connector
+	CallsMetric = "calls"
+	// ErrorsMetric counts calls that returned an error, tagged by error kind
+	ErrorsMetric = "errors"
+	// RowErrorsMetric counts the per-row errors of MultiRead, MultiUpsert
+	// and MultiRemove, tagged by error kind
+	RowErrorsMetric = "row_errors"
+	// LatencyMetric records how long each call took
+	LatencyMetric = "latency"
+)
+
+// Names of the tags attached to the metrics
+const (
+	MethodTag     = "method"
+	ScopeTag      = "scope"
+	NamePrefixTag = "prefix"
+	EntityTag     = "entity"
+	ErrorKindTag  = "error"
+)
+
+// Reporter receives the metrics emitted by the connector. Implementations
+// must be safe for concurrent use; they usually forward to a metrics library
+// such as tally.
+type Reporter interface {
+	// IncCounter adds delta to the named counter
+	IncCounter(name string, tags map[string]string, delta int64)
+	// RecordDuration adds a sample to the named latency histogram
+	RecordDuration(name string, tags map[string]string, d time.Duration)
+}
+
+// Connector reports call counts, error counts and latencies of every
+// operation to a Reporter before passing the results of the next connector
+// back unchanged.
+type Connector struct {
+	base.Connector
+	reporter Reporter
+}
+
+// NewConnector creates a metrics connector in front of next that reports to
+// the given reporter
+func NewConnector(next dosa.Connector, reporter Reporter) *Connector {
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		reporter:  reporter,
+	}
+}
+
+// ErrorKind classifies an error for the error kind tag
+func ErrorKind(err error) string {
+	switch {
+	case dosa.ErrorIsNotFound(err):
+		return "not_found"
+	case dosa.ErrorIsAlreadyExists(err):
+		return "already_exists"
+	case dosa.ErrorIsRetryable(err):
+		return "retryable"
+	case dosa.ErrorIsInvalidRequest(err):
+		return "invalid_request"
+	case dosa.ErrorIsSchemaMismatch(err):
+		return "schema_mismatch"
+	case dosa.ErrorIsTimeout(err):
+		return "timeout"
+	case dosa.ErrorIsThrottled(err):
+		return "throttled"
+	case dosa.ErrorIsInternal(err):
+		return "internal"
+	}
+	switch errors.Cause(err) {
+	case context.DeadlineExceeded:
+		return "timeout"
+	case context.Canceled:
+		return "canceled"
+	}
+	if _, ok := errors.Cause(err).(base.ErrNoMoreConnector); ok {
+		return "no_more_connector"
+	}
+	return "unknown"
+}
+
+func entityTags(method string, ei *dosa.EntityInfo) map[string]string {
+	tags := map[string]string{MethodTag: method}
+	if ei != nil && ei.Ref != nil {
+		tags[ScopeTag] = ei.Ref.Scope
+		tags[NamePrefixTag] = ei.Ref.NamePrefix
+		tags[EntityTag] = ei.Ref.EntityName
+	}
+	return tags
+}
+
+func schemaTags(method, scope, namePrefix string) map[string]string {
+	return map[string]string{MethodTag: method, ScopeTag: scope, NamePrefixTag: namePrefix}
+}
+
+func scopeTags(method, scope string) map[string]string {
+	return map[string]string{MethodTag: method, ScopeTag: scope}
+}
+
+// withKind returns a copy of tags with the error kind of err added
+func withKind(tags map[string]string, err error) map[string]string {
+	t := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		t[k] = v
+	}
+	t[ErrorKindTag] = ErrorKind(err)
+	return t
+}
+
+// report records the outcome of a call that started at start
+func (c *Connector) report(tags map[string]string, start time.Time, err error) {
+	c.reporter.RecordDuration(LatencyMetric, tags, time.Since(start))
+	c.reporter.IncCounter(CallsMetric, tags, 1)
+	if err != nil {
+		c.reporter.IncCounter(ErrorsMetric, withKind(tags, err), 1)
+	}
+}
+
+// reportRows counts the per-row errors of a batch call
+func (c *Connector) reportRows(tags map[string]string, errs []error) {
+	for _, err := range errs {
+		if err != nil {
+			c.reporter.IncCounter(RowErrorsMetric, withKind(tags, err), 1)
+		}
+	}
+}
+
+// CreateIfNotExists reports metrics for the call
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	start := time.Now()
+	err := c.Connector.CreateIfNotExists(ctx, ei, values)
+	c.report(entityTags("CreateIfNotExists", ei), start, err)
+	return err
+}
+
+// Read reports metrics for the call
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	start := time.Now()
+	values, err := c.Connector.Read(ctx, ei, keys, fieldsToRead)
+	c.report(entityTags("Read", ei), start, err)
+	return values, err
+}
+
+// MultiRead reports metrics for the call and counts the per-row errors
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	start := time.Now()
+	results, err := c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+	tags := entityTags("MultiRead", ei)
+	c.report(tags, start, err)
+	errs := make([]error, 0, len(results))
+	for _, result := range results {
+		if result != nil {
+			errs = append(errs, result.Error)
+		}
+	}
+	c.reportRows(tags, errs)
+	return results, err
+}
+
+// Upsert reports metrics for the call
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	start := time.Now()
+	err := c.Connector.Upsert(ctx, ei, values)
+	c.report(entityTags("Upsert", ei), start, err)
+	return err
+}
+
+// MultiUpsert reports metrics for the call and counts the per-row errors
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	start := time.Now()
+	errs, err := c.Connector.MultiUpsert(ctx, ei, values)
+	tags := entityTags("MultiUpsert", ei)
+	c.report(tags, start, err)
+	c.reportRows(tags, errs)
+	return errs, err
+}
+
+// Remove reports metrics for the call
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	start := time.Now()
+	err := c.Connector.Remove(ctx, ei, keys)
+	c.report(entityTags("Remove", ei), start, err)
+	return err
+}
+
+// MultiRemove reports metrics for the call and counts the per-row errors
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	start := time.Now()
+	errs, err := c.Connector.MultiRemove(ctx, ei, multiKeys)
+	tags := entityTags("MultiRemove", ei)
+	c.report(tags, start, err)
+	c.reportRows(tags, errs)
+	return errs, err
+}
+
+// Range reports metrics for the call
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	start := time.Now()
+	values, next, err := c.Connector.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+	c.report(entityTags("Range", ei), start, err)
+	return values, next, err
+}
+
+// Search reports metrics for the call
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPairs dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	start := time.Now()
+	values, next, err := c.Connector.Search(ctx, ei, fieldPairs, fieldsToRead, token, limit)
+	c.report(entityTags("Search", ei), start, err)
+	return values, next, err
+}
+
+// Scan reports metrics for the call
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	start := time.Now()
+	values, next, err := c.Connector.Scan(ctx, ei, fieldsToRead, token, limit)
+	c.report(entityTags("Scan", ei), start, err)
+	return values, next, err
+}
+
+// CheckSchema reports metrics for the call
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	start := time.Now()
+	version, err := c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+	c.report(schemaTags("CheckSchema", scope, namePrefix), start, err)
+	return version, err
+}
+
+// UpsertSchema reports metrics for the call
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	start := time.Now()
+	status, err := c.Connector.UpsertSchema(ctx, scope, namePrefix, ed)
+	c.report(schemaTags("UpsertSchema", scope, namePrefix), start, err)
+	return status, err
+}
+
+// CheckSchemaStatus reports metrics for the call
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope string, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	start := time.Now()
+	status, err := c.Connector.CheckSchemaStatus(ctx, scope, namePrefix, version)
+	c.report(schemaTags("CheckSchemaStatus", scope, namePrefix), start, err)
+	return status, err
+}
+
+// CreateScope reports metrics for the call
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	start := time.Now()
+	err := c.Connector.CreateScope(ctx, scope)
+	c.report(scopeTags("CreateScope", scope), start, err)
+	return err
+}
+
+// TruncateScope reports metrics for the call
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	start := time.Now()
+	err := c.Connector.TruncateScope(ctx, scope)
+	c.report(scopeTags("TruncateScope", scope), start, err)
+	return err
+}
+
+// DropScope reports metrics for the call
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	start := time.Now()
+	err := c.Connector.DropScope(ctx, scope)
+	c.report(scopeTags("DropScope", scope), start, err)
+	return err
+}
+
+// ScopeExists reports metrics for the call
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	start := time.Now()
+	exists, err := c.Connector.ScopeExists(ctx, scope)
+	c.report(scopeTags("ScopeExists", scope), start, err)
+	return exists, err
+}
+
+// ListScopes reports metrics for the call
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	start := time.Now()
+	scopes, err := c.Connector.ListScopes(ctx, owner)
+	c.report(map[string]string{MethodTag: "ListScopes"}, start, err)
+	return scopes, err
+}
+
+// Shutdown reports metrics for the call
+func (c *Connector) Shutdown() error {
+	start := time.Now()
+	err := c.Connector.Shutdown()
+	c.report(map[string]string{MethodTag: "Shutdown"}, start, err)
+	return err
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+	"github.com/uber-go/dosa/connectors/devnull"
+	"github.com/uber-go/dosa/connectors/metrics"
+	"github.com/uber-go/dosa/mocks"
+)
+
+// memorySink is an in-memory metrics.Reporter. Metrics are keyed by name and
+// sorted tags, e.g. "calls{entity=e,method=Read}".
+type memorySink struct {
+	lock      sync.Mutex
+	counters  map[string]int64
+	durations map[string][]time.Duration
+}
+
+func newMemorySink() *memorySink {
+	return &memorySink{counters: map[string]int64{}, durations: map[string][]time.Duration{}}
+}
+
+func metricKey(name string, tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (s *memorySink) IncCounter(name string, tags map[string]string, delta int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.counters[metricKey(name, tags)] += delta
+}
+
+func (s *memorySink) RecordDuration(name string, tags map[string]string, d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := metricKey(name, tags)
+	s.durations[key] = append(s.durations[key], d)
+}
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testScope",
+		NamePrefix: "testPrefix",
+		EntityName: "testEntityName",
+	},
+	Def: &dosa.EntityDefinition{},
+}
+
+var testValues = map[string]dosa.FieldValue{"id": int64(1)}
+
+func TestMetrics_EntityOps(t *testing.T) {
+	sink := newMemorySink()
+	sut := metrics.NewConnector(&devnull.Connector{}, sink)
+
+	assert.NoError(t, sut.Upsert(ctx, testEi, testValues))
+	assert.NoError(t, sut.Upsert(ctx, testEi, testValues))
+	_, err := sut.Read(ctx, testEi, testValues, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	tags := "entity=testEntityName,method=Upsert,prefix=testPrefix,scope=testScope"
+	assert.Equal(t, int64(2), sink.counters["calls{"+tags+"}"])
+	assert.Len(t, sink.durations["latency{"+tags+"}"], 2)
+	assert.Zero(t, sink.counters["errors{error=unknown,"+tags+"}"])
+
+	tags = "entity=testEntityName,method=Read,prefix=testPrefix,scope=testScope"
+	assert.Equal(t, int64(1), sink.counters["calls{"+tags+"}"])
+	assert.Equal(t, int64(1), sink.counters["errors{entity=testEntityName,error=not_found,method=Read,prefix=testPrefix,scope=testScope}"])
+	assert.Len(t, sink.durations["latency{"+tags+"}"], 1)
+}
+
+func TestMetrics_RowErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	keys := []map[string]dosa.FieldValue{testValues, testValues, testValues}
+	mc.EXPECT().MultiRead(ctx, testEi, keys, nil).Return([]*dosa.FieldValuesOrError{
+		{Values: testValues},
+		{Error: &dosa.ErrNotFound{}},
+		{Error: &dosa.ErrRetryable{Err: errors.New("overloaded")}},
+	}, nil)
+	mc.EXPECT().MultiUpsert(ctx, testEi, keys).Return([]error{nil, &dosa.ErrNotFound{}, nil}, nil)
+	mc.EXPECT().MultiRemove(ctx, testEi, keys).Return(nil, errors.New("boom"))
+
+	sink := newMemorySink()
+	sut := metrics.NewConnector(mc, sink)
+	_, err := sut.MultiRead(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	_, err = sut.MultiUpsert(ctx, testEi, keys)
+	assert.NoError(t, err)
+	_, err = sut.MultiRemove(ctx, testEi, keys)
+	assert.Error(t, err)
+
+	assert.Equal(t, map[string]int64{
+		"calls{entity=testEntityName,method=MultiRead,prefix=testPrefix,scope=testScope}":                        1,
+		"row_errors{entity=testEntityName,error=not_found,method=MultiRead,prefix=testPrefix,scope=testScope}":   1,
+		"row_errors{entity=testEntityName,error=retryable,method=MultiRead,prefix=testPrefix,scope=testScope}":   1,
+		"calls{entity=testEntityName,method=MultiUpsert,prefix=testPrefix,scope=testScope}":                      1,
+		"row_errors{entity=testEntityName,error=not_found,method=MultiUpsert,prefix=testPrefix,scope=testScope}": 1,
+		"calls{entity=testEntityName,method=MultiRemove,prefix=testPrefix,scope=testScope}":                      1,
+		"errors{entity=testEntityName,error=unknown,method=MultiRemove,prefix=testPrefix,scope=testScope}":       1,
+	}, sink.counters)
+}
+
+func TestMetrics_SchemaAndScopeOps(t *testing.T) {
+	sink := newMemorySink()
+	sut := metrics.NewConnector(&devnull.Connector{}, sink)
+
+	_, err := sut.CheckSchema(ctx, "s", "p", nil)
+	assert.NoError(t, err)
+	_, err = sut.UpsertSchema(ctx, "s", "p", nil)
+	assert.NoError(t, err)
+	_, err = sut.CheckSchemaStatus(ctx, "s", "p", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, sut.CreateScope(ctx, "s"))
+	assert.NoError(t, sut.TruncateScope(ctx, "s"))
+	assert.NoError(t, sut.DropScope(ctx, "s"))
+	_, err = sut.ScopeExists(ctx, "s")
+	assert.NoError(t, err)
+	assert.NoError(t, sut.Shutdown())
+
+	for _, method := range []string{"CheckSchema", "UpsertSchema", "CheckSchemaStatus"} {
+		assert.Equal(t, int64(1), sink.counters["calls{method="+method+",prefix=p,scope=s}"], method)
+	}
+	for _, method := range []string{"CreateScope", "TruncateScope", "DropScope", "ScopeExists"} {
+		assert.Equal(t, int64(1), sink.counters["calls{method="+method+",scope=s}"], method)
+	}
+	assert.Equal(t, int64(1), sink.counters["calls{method=Shutdown}"])
+}
+
+func TestMetrics_AllEntityOps(t *testing.T) {
+	sink := newMemorySink()
+	sut := metrics.NewConnector(nil, sink)
+
+	assert.Error(t, sut.CreateIfNotExists(ctx, testEi, testValues))
+	assert.Error(t, sut.Remove(ctx, testEi, testValues))
+	_, _, err := sut.Range(ctx, testEi, nil, nil, "", 10)
+	assert.Error(t, err)
+	_, _, err = sut.Search(ctx, testEi, dosa.FieldNameValuePair{}, nil, "", 10)
+	assert.Error(t, err)
+	_, _, err = sut.Scan(ctx, testEi, nil, "", 10)
+	assert.Error(t, err)
+
+	for _, method := range []string{"CreateIfNotExists", "Remove", "Range", "Search", "Scan"} {
+		tags := "entity=testEntityName,error=no_more_connector,method=" + method + ",prefix=testPrefix,scope=testScope"
+		assert.Equal(t, int64(1), sink.counters["errors{"+tags+"}"], method)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	assert.Equal(t, "not_found", metrics.ErrorKind(errors.Wrap(&dosa.ErrNotFound{}, "wrapped")))
+	assert.Equal(t, "already_exists", metrics.ErrorKind(&dosa.ErrAlreadyExists{}))
+	assert.Equal(t, "retryable", metrics.ErrorKind(&dosa.ErrRetryable{Err: errors.New("x")}))
+	assert.Equal(t, "invalid_request", metrics.ErrorKind(&dosa.ErrInvalidRequest{Err: errors.New("x")}))
+	assert.Equal(t, "schema_mismatch", metrics.ErrorKind(&dosa.ErrSchemaMismatch{Err: errors.New("x")}))
+	assert.Equal(t, "timeout", metrics.ErrorKind(&dosa.ErrTimeout{Err: errors.New("x")}))
+	assert.Equal(t, "throttled", metrics.ErrorKind(&dosa.ErrThrottled{Err: errors.New("x")}))
+	assert.Equal(t, "internal", metrics.ErrorKind(&dosa.ErrInternal{Err: errors.New("x")}))
+	assert.Equal(t, "timeout", metrics.ErrorKind(errors.Wrap(context.DeadlineExceeded, "read")))
+	assert.Equal(t, "canceled", metrics.ErrorKind(context.Canceled))
+	assert.Equal(t, "no_more_connector", metrics.ErrorKind(base.ErrNoMoreConnector{}))
+	assert.Equal(t, "unknown", metrics.ErrorKind(errors.New("x")))
+}
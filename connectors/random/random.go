@@ -192,11 +192,16 @@ func (c *Connector) DropScope(ctx context.Context, scope string) error {
 	return nil
 }
 
-// ScopeExists is not implemented yet
+// ScopeExists returns true
 func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
 	return true, nil
 }
 
+// ListScopes returns no scopes
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	return nil, nil
+}
+
 // Shutdown always returns nil
 func (c *Connector) Shutdown() error {
 	return nil
@@ -160,6 +160,12 @@ func TestRandom_ScopeExists(t *testing.T) {
 	assert.True(t, exists)
 }
 
+func TestRandom_ListScopes(t *testing.T) {
+	scopes, err := sut.ListScopes(ctx, "")
+	assert.NoError(t, err)
+	assert.Empty(t, scopes)
+}
+
 func TestRandom_Shutdown(t *testing.T) {
 	assert.Nil(t, sut.Shutdown())
 }
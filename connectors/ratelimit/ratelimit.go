@@ -0,0 +1,481 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Class groups the operations that share limits
+type Class int
+
+// The operation classes
+const (
+	// Reads are Read and MultiRead
+	Reads Class = iota
+	// Writes are CreateIfNotExists, Upsert, MultiUpsert, Remove and
+	// MultiRemove
+	Writes
+	// Scans are Range, Search and Scan
+	Scans
+	// Schema are the schema and scope operations
+	Schema
+)
+
+func (c Class) String() string {
+	switch c {
+	case Reads:
+		return "reads"
+	case Writes:
+		return "writes"
+	case Scans:
+		return "scans"
+	case Schema:
+		return "schema"
+	}
+	return fmt.Sprintf("Class(%d)", int(c))
+}
+
+// Limit is the limit of one class of operations. Zero values mean no limit.
+type Limit struct {
+	// Rate is the number of requests allowed per second
+	Rate float64
+	// Burst is the number of requests that can be made at once after a
+	// quiet period; it defaults to 1
+	Burst int
+	// MaxInFlight is the number of requests that can be outstanding at once
+	MaxInFlight int
+}
+
+// Config sets the limits enforced by the connector
+type Config struct {
+	// Classes are the limits shared by all the operations of a class
+	Classes map[Class]Limit
+	// Entities are additional limits per entity name; a request must be
+	// within both the limits of its class and of its entity
+	Entities map[string]map[Class]Limit
+	// Block makes requests over the limit wait for their turn, unless the
+	// context deadline would expire first. Otherwise they are rejected
+	// immediately.
+	Block bool
+}
+
+// ErrLimitExceeded is returned for requests that are over the limit
+type ErrLimitExceeded struct {
+	// Entity is empty when the limit of the whole class was hit
+	Entity string
+	Class  Class
+	// Reason is either "rate" or "in-flight"
+	Reason string
+}
+
+// Error satisfies the error interface
+func (e *ErrLimitExceeded) Error() string {
+	if e.Entity == "" {
+		return fmt.Sprintf("%s limit exceeded for %s", e.Reason, e.Class)
+	}
+	return fmt.Sprintf("%s limit exceeded for %s of entity %q", e.Reason, e.Class, e.Entity)
+}
+
+// ErrorIsLimitExceeded checks if the error is caused by "ErrLimitExceeded"
+func ErrorIsLimitExceeded(err error) bool {
+	_, ok := errors.Cause(err).(*ErrLimitExceeded)
+	return ok
+}
+
+// bucket is a token bucket
+type bucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token and returns how long to wait before using it. When
+// the wait is longer than max nothing is taken and ok is false.
+func (b *bucket) reserve(now time.Time, max time.Duration) (wait time.Duration, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait > max {
+		return wait, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// refund gives back a token taken by reserve
+func (b *bucket) refund() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens++
+}
+
+// limiter enforces one Limit
+type limiter struct {
+	entity string
+	class  Class
+	bucket *bucket
+	slots  chan struct{}
+}
+
+func newLimiter(entity string, class Class, limit Limit, now time.Time) *limiter {
+	l := &limiter{entity: entity, class: class}
+	if limit.Rate > 0 {
+		burst := float64(limit.Burst)
+		if burst < 1 {
+			burst = 1
+		}
+		l.bucket = &bucket{rate: limit.Rate, burst: burst, tokens: burst, last: now}
+	}
+	if limit.MaxInFlight > 0 {
+		l.slots = make(chan struct{}, limit.MaxInFlight)
+	}
+	return l
+}
+
+func (l *limiter) exceeded(reason string) error {
+	return &ErrLimitExceeded{Entity: l.entity, Class: l.class, Reason: reason}
+}
+
+// Connector limits the rate and the concurrency of the requests sent to the
+// next connector. Limits are set per operation class, and optionally per
+// entity and class.
+type Connector struct {
+	base.Connector
+	block bool
+	now   func() time.Time
+
+	classes  map[Class]*limiter
+	entities map[string]map[Class]*limiter
+}
+
+// NewConnector creates a limiting connector in front of next
+func NewConnector(next dosa.Connector, cfg Config) *Connector {
+	c := &Connector{
+		Connector: base.Connector{Next: next},
+		block:     cfg.Block,
+		now:       time.Now,
+		classes:   map[Class]*limiter{},
+		entities:  map[string]map[Class]*limiter{},
+	}
+	now := c.now()
+	for class, limit := range cfg.Classes {
+		c.classes[class] = newLimiter("", class, limit, now)
+	}
+	for entity, limits := range cfg.Entities {
+		c.entities[entity] = map[Class]*limiter{}
+		for class, limit := range limits {
+			c.entities[entity][class] = newLimiter(entity, class, limit, now)
+		}
+	}
+	return c
+}
+
+// limiters returns the limiters that apply to a request
+func (c *Connector) limiters(entity string, class Class) []*limiter {
+	var ls []*limiter
+	if l, ok := c.classes[class]; ok {
+		ls = append(ls, l)
+	}
+	if l, ok := c.entities[entity][class]; ok {
+		ls = append(ls, l)
+	}
+	return ls
+}
+
+// acquire waits for, or rejects, a request and returns the function that
+// releases its in-flight slots
+func (c *Connector) acquire(ctx context.Context, entity string, class Class) (func(), error) {
+	ls := c.limiters(entity, class)
+
+	// rate limits: reserve a token from every bucket, then wait for the
+	// longest reservation
+	var reserved []*bucket
+	refund := func() {
+		for _, b := range reserved {
+			b.refund()
+		}
+	}
+	var wait time.Duration
+	for _, l := range ls {
+		if l.bucket == nil {
+			continue
+		}
+		max := time.Duration(0)
+		if c.block {
+			max = time.Duration(1<<63 - 1)
+			if deadline, ok := ctx.Deadline(); ok {
+				max = deadline.Sub(c.now())
+			}
+		}
+		w, ok := l.bucket.reserve(c.now(), max)
+		if !ok {
+			refund()
+			return nil, l.exceeded("rate")
+		}
+		reserved = append(reserved, l.bucket)
+		if w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			refund()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	// concurrency limits
+	var held []*limiter
+	release := func() {
+		for _, l := range held {
+			<-l.slots
+		}
+	}
+	for _, l := range ls {
+		if l.slots == nil {
+			continue
+		}
+		if c.block {
+			select {
+			case l.slots <- struct{}{}:
+			case <-ctx.Done():
+				release()
+				return nil, ctx.Err()
+			}
+		} else {
+			select {
+			case l.slots <- struct{}{}:
+			default:
+				release()
+				return nil, l.exceeded("in-flight")
+			}
+		}
+		held = append(held, l)
+	}
+	return release, nil
+}
+
+// call runs op within the limits of the entity and class
+func (c *Connector) call(ctx context.Context, entity string, class Class, op func() error) error {
+	release, err := c.acquire(ctx, entity, class)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return op()
+}
+
+func entityName(ei *dosa.EntityInfo) string {
+	if ei == nil || ei.Ref == nil {
+		return ""
+	}
+	return ei.Ref.EntityName
+}
+
+// CreateIfNotExists calls Next within the write limits
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.call(ctx, entityName(ei), Writes, func() error {
+		return c.Connector.CreateIfNotExists(ctx, ei, values)
+	})
+}
+
+// Read calls Next within the read limits
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	var values map[string]dosa.FieldValue
+	err := c.call(ctx, entityName(ei), Reads, func() (err error) {
+		values, err = c.Connector.Read(ctx, ei, keys, fieldsToRead)
+		return err
+	})
+	return values, err
+}
+
+// MultiRead calls Next within the read limits
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	var results []*dosa.FieldValuesOrError
+	err := c.call(ctx, entityName(ei), Reads, func() (err error) {
+		results, err = c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+		return err
+	})
+	return results, err
+}
+
+// Upsert calls Next within the write limits
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.call(ctx, entityName(ei), Writes, func() error {
+		return c.Connector.Upsert(ctx, ei, values)
+	})
+}
+
+// MultiUpsert calls Next within the write limits
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	var errs []error
+	err := c.call(ctx, entityName(ei), Writes, func() (err error) {
+		errs, err = c.Connector.MultiUpsert(ctx, ei, values)
+		return err
+	})
+	return errs, err
+}
+
+// Remove calls Next within the write limits
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	return c.call(ctx, entityName(ei), Writes, func() error {
+		return c.Connector.Remove(ctx, ei, keys)
+	})
+}
+
+// MultiRemove calls Next within the write limits
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	var errs []error
+	err := c.call(ctx, entityName(ei), Writes, func() (err error) {
+		errs, err = c.Connector.MultiRemove(ctx, ei, multiKeys)
+		return err
+	})
+	return errs, err
+}
+
+// Range calls Next within the scan limits
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	var values []map[string]dosa.FieldValue
+	var next string
+	err := c.call(ctx, entityName(ei), Scans, func() (err error) {
+		values, next, err = c.Connector.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+		return err
+	})
+	return values, next, err
+}
+
+// Search calls Next within the scan limits
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPairs dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	var values []map[string]dosa.FieldValue
+	var next string
+	err := c.call(ctx, entityName(ei), Scans, func() (err error) {
+		values, next, err = c.Connector.Search(ctx, ei, fieldPairs, fieldsToRead, token, limit)
+		return err
+	})
+	return values, next, err
+}
+
+// Scan calls Next within the scan limits
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	var values []map[string]dosa.FieldValue
+	var next string
+	err := c.call(ctx, entityName(ei), Scans, func() (err error) {
+		values, next, err = c.Connector.Scan(ctx, ei, fieldsToRead, token, limit)
+		return err
+	})
+	return values, next, err
+}
+
+// CheckSchema calls Next within the schema limits
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	var version int32
+	err := c.call(ctx, "", Schema, func() (err error) {
+		version, err = c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+		return err
+	})
+	return version, err
+}
+
+// UpsertSchema calls Next within the schema limits
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	var status *dosa.SchemaStatus
+	err := c.call(ctx, "", Schema, func() (err error) {
+		status, err = c.Connector.UpsertSchema(ctx, scope, namePrefix, ed)
+		return err
+	})
+	return status, err
+}
+
+// CheckSchemaStatus calls Next within the schema limits
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope string, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	var status *dosa.SchemaStatus
+	err := c.call(ctx, "", Schema, func() (err error) {
+		status, err = c.Connector.CheckSchemaStatus(ctx, scope, namePrefix, version)
+		return err
+	})
+	return status, err
+}
+
+// CreateScope calls Next within the schema limits
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	return c.call(ctx, "", Schema, func() error {
+		return c.Connector.CreateScope(ctx, scope)
+	})
+}
+
+// TruncateScope calls Next within the schema limits
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	return c.call(ctx, "", Schema, func() error {
+		return c.Connector.TruncateScope(ctx, scope)
+	})
+}
+
+// DropScope calls Next within the schema limits
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	return c.call(ctx, "", Schema, func() error {
+		return c.Connector.DropScope(ctx, scope)
+	})
+}
+
+// ScopeExists calls Next within the schema limits
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	var exists bool
+	err := c.call(ctx, "", Schema, func() (err error) {
+		exists, err = c.Connector.ScopeExists(ctx, scope)
+		return err
+	})
+	return exists, err
+}
+
+// ListScopes waits for the schema limit
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	var scopes []string
+	err := c.call(ctx, "", Schema, func() (err error) {
+		scopes, err = c.Connector.ListScopes(ctx, owner)
+		return err
+	})
+	return scopes, err
+}
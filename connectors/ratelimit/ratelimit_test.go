@@ -0,0 +1,216 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ratelimit_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/devnull"
+	"github.com/uber-go/dosa/connectors/ratelimit"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+func entityInfo(name string) *dosa.EntityInfo {
+	return &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{
+			Scope:      "testScope",
+			NamePrefix: "testPrefix",
+			EntityName: name,
+		},
+		Def: &dosa.EntityDefinition{},
+	}
+}
+
+var (
+	testEi     = entityInfo("testEntityName")
+	otherEi    = entityInfo("otherEntityName")
+	testValues = map[string]dosa.FieldValue{"id": int64(1)}
+)
+
+func TestRateLimit_Reject(t *testing.T) {
+	sut := ratelimit.NewConnector(&devnull.Connector{}, ratelimit.Config{
+		Classes: map[ratelimit.Class]ratelimit.Limit{
+			ratelimit.Writes: {Rate: 1, Burst: 2},
+		},
+	})
+
+	assert.NoError(t, sut.Upsert(ctx, testEi, testValues))
+	assert.NoError(t, sut.Upsert(ctx, otherEi, testValues))
+	err := sut.Upsert(ctx, testEi, testValues)
+	assert.True(t, ratelimit.ErrorIsLimitExceeded(err))
+	assert.EqualError(t, err, "rate limit exceeded for writes")
+
+	// other classes are not limited
+	for i := 0; i < 5; i++ {
+		_, err = sut.Read(ctx, testEi, testValues, nil)
+		assert.False(t, ratelimit.ErrorIsLimitExceeded(err))
+	}
+}
+
+func TestRateLimit_PerEntity(t *testing.T) {
+	sut := ratelimit.NewConnector(&devnull.Connector{}, ratelimit.Config{
+		Entities: map[string]map[ratelimit.Class]ratelimit.Limit{
+			"testEntityName": {ratelimit.Scans: {Rate: 1}},
+		},
+	})
+
+	_, _, err := sut.Scan(ctx, testEi, nil, "", 10)
+	assert.False(t, ratelimit.ErrorIsLimitExceeded(err))
+	_, _, err = sut.Range(ctx, testEi, nil, nil, "", 10)
+	assert.EqualError(t, err, `rate limit exceeded for scans of entity "testEntityName"`)
+	_, _, err = sut.Search(ctx, otherEi, dosa.FieldNameValuePair{}, nil, "", 10)
+	assert.False(t, ratelimit.ErrorIsLimitExceeded(err))
+}
+
+func TestRateLimit_Block(t *testing.T) {
+	sut := ratelimit.NewConnector(&devnull.Connector{}, ratelimit.Config{
+		Classes: map[ratelimit.Class]ratelimit.Limit{
+			ratelimit.Reads: {Rate: 50},
+		},
+		Entities: map[string]map[ratelimit.Class]ratelimit.Limit{
+			"testEntityName": {ratelimit.Reads: {Rate: 1000, Burst: 10}},
+		},
+		Block: true,
+	})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := sut.MultiRead(ctx, testEi, nil, nil)
+		assert.NoError(t, err)
+	}
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+
+	// a wait that would outlast the deadline is rejected right away
+	sut = ratelimit.NewConnector(&devnull.Connector{}, ratelimit.Config{
+		Classes: map[ratelimit.Class]ratelimit.Limit{ratelimit.Schema: {Rate: 0.1}},
+		Block:   true,
+	})
+	dctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, sut.CreateScope(dctx, "s"))
+	start = time.Now()
+	err := sut.TruncateScope(dctx, "s")
+	assert.True(t, ratelimit.ErrorIsLimitExceeded(err))
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	// cancellation interrupts the wait
+	sut = ratelimit.NewConnector(&devnull.Connector{}, ratelimit.Config{
+		Classes: map[ratelimit.Class]ratelimit.Limit{ratelimit.Schema: {Rate: 0.1}},
+		Block:   true,
+	})
+	cctx, cancel := context.WithCancel(ctx)
+	assert.NoError(t, sut.DropScope(cctx, "s"))
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = sut.ScopeExists(cctx, "s")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestRateLimit_MaxInFlight(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+
+	sut := ratelimit.NewConnector(mc, ratelimit.Config{
+		Classes: map[ratelimit.Class]ratelimit.Limit{ratelimit.Writes: {MaxInFlight: 1}},
+	})
+	mc.EXPECT().Remove(ctx, testEi, testValues).Do(func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue) {
+		err := sut.Remove(ctx, testEi, testValues)
+		assert.EqualError(t, err, "in-flight limit exceeded for writes")
+	}).Return(nil)
+	assert.NoError(t, sut.Remove(ctx, testEi, testValues))
+
+	// the slot is released once the call returns
+	mc.EXPECT().CreateIfNotExists(ctx, testEi, testValues).Return(nil)
+	assert.NoError(t, sut.CreateIfNotExists(ctx, testEi, testValues))
+}
+
+func TestRateLimit_MaxInFlightBlock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+
+	sut := ratelimit.NewConnector(mc, ratelimit.Config{
+		Entities: map[string]map[ratelimit.Class]ratelimit.Limit{
+			"testEntityName": {ratelimit.Writes: {MaxInFlight: 2}},
+		},
+		Block: true,
+	})
+
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	mc.EXPECT().MultiUpsert(gomock.Any(), testEi, nil).Do(func(context.Context, *dosa.EntityInfo, []map[string]dosa.FieldValue) {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+	}).Return(nil, nil).Times(6)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := sut.MultiUpsert(ctx, testEi, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, maxInFlight)
+
+	// a request waiting for a slot gives up with its context
+	mc.EXPECT().MultiRemove(gomock.Any(), testEi, nil).Do(func(context.Context, *dosa.EntityInfo, []map[string]dosa.FieldValue) {
+		dctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		mc.EXPECT().Upsert(gomock.Any(), testEi, testValues).Do(func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue) {
+			assert.Equal(t, context.DeadlineExceeded, sut.Upsert(dctx, testEi, testValues))
+		}).Return(nil)
+		assert.NoError(t, sut.Upsert(ctx, testEi, testValues))
+	}).Return(nil, nil)
+	_, err := sut.MultiRemove(ctx, testEi, nil)
+	assert.NoError(t, err)
+}
+
+func TestRateLimit_Unlimited(t *testing.T) {
+	sut := ratelimit.NewConnector(&devnull.Connector{}, ratelimit.Config{})
+	for i := 0; i < 3; i++ {
+		_, err := sut.CheckSchema(ctx, "s", "p", nil)
+		assert.NoError(t, err)
+		_, err = sut.UpsertSchema(ctx, "s", "p", nil)
+		assert.NoError(t, err)
+		_, err = sut.CheckSchemaStatus(ctx, "s", "p", 1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "Class(9)", ratelimit.Class(9).String())
+}
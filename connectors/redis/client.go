@@ -0,0 +1,216 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errorReply is an error returned by the server
+type errorReply string
+
+func (e errorReply) Error() string {
+	return "redis: " + string(e)
+}
+
+// client is a minimal client for the Redis protocol (RESP). It keeps a small
+// pool of connections, each running one command at a time; a connection is
+// dropped after any network error and a new one is dialed when needed.
+type client struct {
+	addr    string
+	timeout time.Duration
+	// slots limits the number of connections in use
+	slots chan struct{}
+
+	lock sync.Mutex
+	idle []*conn
+}
+
+// conn is a connection to the server with its buffers
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newClient(addr string, timeout time.Duration, poolSize int) *client {
+	return &client{addr: addr, timeout: timeout, slots: make(chan struct{}, poolSize)}
+}
+
+// do sends a command and returns its reply: a string for simple strings, an
+// int64 for integers, a []byte (nil when missing) for bulk strings and an
+// []interface{} for arrays. Errors sent by the server are returned as
+// errorReply. Waiting for a free connection counts towards the timeout.
+func (c *client) do(ctx context.Context, args ...[]byte) (interface{}, error) {
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "no free connection")
+	}
+	defer func() { <-c.slots }()
+
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.roundTrip(deadline, args)
+	if _, ok := err.(errorReply); err != nil && !ok {
+		// the connection is in an unknown state
+		_ = cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// get returns an idle connection, or dials a new one
+func (c *client) get(ctx context.Context) (*conn, error) {
+	c.lock.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.lock.Unlock()
+		return cn, nil
+	}
+	c.lock.Unlock()
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", c.addr)
+	}
+	return &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}, nil
+}
+
+// put makes a connection available to the next command
+func (c *client) put(cn *conn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.idle = append(c.idle, cn)
+}
+
+func (cn *conn) roundTrip(deadline time.Time, args [][]byte) (interface{}, error) {
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(cn.w, "$%d\r\n", len(arg))
+		cn.w.Write(arg)
+		cn.w.WriteString("\r\n")
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(cn.r)
+}
+
+// close closes the idle connections; connections in use are kept until
+// their command completes
+func (c *client) close() error {
+	c.lock.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.lock.Unlock()
+
+	var err error
+	for _, cn := range idle {
+		if cerr := cn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.Errorf("invalid reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readReply reads a single RESP value
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errorReply(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return []byte(nil), nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, errors.Errorf("invalid reply type %q", line[0])
+}
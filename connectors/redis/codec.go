@@ -0,0 +1,223 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// writeBytes writes a length-prefixed byte slice
+func writeBytes(b *bytes.Buffer, data []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutUvarint(buf[:], uint64(len(data)))])
+	b.Write(data)
+}
+
+// writeValue encodes a value according to the type of its column
+func writeValue(b *bytes.Buffer, typ dosa.Type, value dosa.FieldValue) error {
+	var buf [binary.MaxVarintLen64]byte
+	switch typ {
+	case dosa.TUUID:
+		v, ok := value.(dosa.UUID)
+		if !ok {
+			return fmt.Errorf("expected a dosa.UUID, got %T", value)
+		}
+		writeBytes(b, []byte(v))
+	case dosa.String:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %T", value)
+		}
+		writeBytes(b, []byte(v))
+	case dosa.Blob:
+		v, ok := value.([]byte)
+		if !ok {
+			return fmt.Errorf("expected a []byte, got %T", value)
+		}
+		writeBytes(b, v)
+	case dosa.Int32:
+		v, ok := value.(int32)
+		if !ok {
+			return fmt.Errorf("expected an int32, got %T", value)
+		}
+		b.Write(buf[:binary.PutVarint(buf[:], int64(v))])
+	case dosa.Int64:
+		v, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("expected an int64, got %T", value)
+		}
+		b.Write(buf[:binary.PutVarint(buf[:], v)])
+	case dosa.Double:
+		v, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("expected a float64, got %T", value)
+		}
+		binary.BigEndian.PutUint64(buf[:8], math.Float64bits(v))
+		b.Write(buf[:8])
+	case dosa.Bool:
+		v, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected a bool, got %T", value)
+		}
+		if v {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case dosa.Timestamp:
+		v, ok := value.(time.Time)
+		if !ok {
+			return fmt.Errorf("expected a time.Time, got %T", value)
+		}
+		b.Write(buf[:binary.PutVarint(buf[:], v.UnixNano())])
+	default:
+		return fmt.Errorf("unsupported type %s", typ)
+	}
+	return nil
+}
+
+// readBytes reads a length-prefixed byte slice
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, errors.New("truncated value")
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+// readValue decodes a value written by writeValue
+func readValue(r *bytes.Reader, typ dosa.Type) (dosa.FieldValue, error) {
+	switch typ {
+	case dosa.TUUID:
+		data, err := readBytes(r)
+		return dosa.UUID(data), err
+	case dosa.String:
+		data, err := readBytes(r)
+		return string(data), err
+	case dosa.Blob:
+		return readBytes(r)
+	case dosa.Int32:
+		v, err := binary.ReadVarint(r)
+		return int32(v), err
+	case dosa.Int64:
+		return binary.ReadVarint(r)
+	case dosa.Double:
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf[:])), nil
+	case dosa.Bool:
+		v, err := r.ReadByte()
+		return v == 1, err
+	case dosa.Timestamp:
+		v, err := binary.ReadVarint(r)
+		return time.Unix(0, v), err
+	}
+	return nil, fmt.Errorf("unsupported type %s", typ)
+}
+
+// encodeRow serializes the columns of a row that are part of the entity
+// definition; other values are dropped
+func encodeRow(ed *dosa.EntityDefinition, values map[string]dosa.FieldValue) ([]byte, error) {
+	types := ed.ColumnTypes()
+	names := make([]string, 0, len(values))
+	for name, value := range values {
+		if _, ok := types[name]; ok && value != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutUvarint(buf[:], uint64(len(names)))])
+	for _, name := range names {
+		writeBytes(&b, []byte(name))
+		if err := writeValue(&b, types[name], values[name]); err != nil {
+			return nil, errors.Wrapf(err, "column %q", name)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// decodeRow deserializes a row written by encodeRow
+func decodeRow(ed *dosa.EntityDefinition, data []byte) (map[string]dosa.FieldValue, error) {
+	types := ed.ColumnTypes()
+	r := bytes.NewReader(data)
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid cached row")
+	}
+	values := make(map[string]dosa.FieldValue, n)
+	for i := uint64(0); i < n; i++ {
+		name, err := readBytes(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid cached row")
+		}
+		typ, ok := types[string(name)]
+		if !ok {
+			// the schema changed since the row was cached
+			return nil, fmt.Errorf("invalid cached row: unknown column %q", name)
+		}
+		if values[string(name)], err = readValue(r, typ); err != nil {
+			return nil, errors.Wrapf(err, "invalid cached row: column %q", name)
+		}
+	}
+	return values, nil
+}
+
+// rowKey builds the cache key of a row from its schema reference and primary
+// key values. It returns false if a key value is missing or has the wrong
+// type.
+func rowKey(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) ([]byte, bool) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "dosa:%q/%q/%q/", ei.Ref.Scope, ei.Ref.NamePrefix, ei.Ref.EntityName)
+	types := ei.Def.ColumnTypes()
+	names := append([]string{}, ei.Def.Key.PartitionKeys...)
+	for _, ck := range ei.Def.Key.ClusteringKeys {
+		names = append(names, ck.Name)
+	}
+	for _, name := range names {
+		value, ok := keys[name]
+		if !ok {
+			return nil, false
+		}
+		if err := writeValue(&b, types[name], value); err != nil {
+			return nil, false
+		}
+	}
+	return b.Bytes(), true
+}
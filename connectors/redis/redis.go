@@ -0,0 +1,386 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Default values used for unset fields of Config
+const (
+	DefaultTTL      = 5 * time.Minute
+	DefaultTimeout  = 100 * time.Millisecond
+	DefaultPoolSize = 8
+)
+
+// Config contains the parameters of the remote cache
+type Config struct {
+	// Addr is the host:port of the Redis server
+	Addr string `yaml:"addr"`
+	// TTL is how long a row stays cached
+	TTL time.Duration `yaml:"ttl"`
+	// Timeout bounds every cache command; a slow cache is treated as a miss
+	Timeout time.Duration `yaml:"timeout"`
+	// PoolSize is the number of connections to the server
+	PoolSize int `yaml:"pool_size"`
+}
+
+// Connector caches rows in a server speaking the Redis protocol, so that
+// several processes can share the same cache. Read and MultiRead look rows up
+// by scope, name prefix, entity and primary key, and fall back to the next
+// connector on a miss. Writes and removes go to the next connector and then
+// delete the cached rows, and truncating or dropping a scope makes all its
+// cached rows outdated. Cached rows are deleted even when the caller's
+// context is done, and a write whose cached rows could not be deleted returns
+// an error, as the cache would serve the previous rows until they expire. A
+// row read on a miss is only cached if it was not
+// written, and its scope not truncated, while it was being read, so a slow
+// read never caches a value older than a concurrent write.
+//
+// Rows are stored with all their columns, encoded according to the column
+// types of the entity definition. The cache is best effort: when the server
+// cannot be reached the next connector is used directly.
+type Connector struct {
+	base.Connector
+	client *client
+	ttl    time.Duration
+}
+
+// NewConnector creates a remote caching connector in front of next. The
+// server is only contacted on first use.
+func NewConnector(next dosa.Connector, cfg *Config) (*Connector, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("invalid address")
+	}
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	poolSize := cfg.PoolSize
+	if poolSize <= 0 {
+		poolSize = DefaultPoolSize
+	}
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		client:    newClient(cfg.Addr, timeout, poolSize),
+		ttl:       ttl,
+	}, nil
+}
+
+// scopeKey is the key of the version of a scope. The version is
+// incremented when the scope is truncated or dropped. Cached rows record the
+// version they were read at, and rows cached at an older version are misses.
+func scopeKey(scope string) []byte {
+	return []byte(fmt.Sprintf("dosa-scope:%q", scope))
+}
+
+// writesKey is the key counting the writes to a row. A row read from the
+// next connector is only cached if no write happened since the cache miss.
+func writesKey(rowKey []byte) []byte {
+	return append([]byte("dosa-writes:"), rowKey...)
+}
+
+// fillScript caches a row (KEYS[1]) unless the scope version (KEYS[2]) or the
+// write counter of the row (KEYS[3]) changed since the cache was looked up
+var fillScript = []byte(`local function get(key) return redis.call('GET', key) or '' end
+if get(KEYS[2]) == ARGV[1] and get(KEYS[3]) == ARGV[2] then
+	return redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+end
+return 0`)
+
+// invalidateScript deletes cached rows and increments their write counters.
+// KEYS holds pairs of row key and write counter key.
+var invalidateScript = []byte(`for i = 1, #KEYS, 2 do
+	redis.call('DEL', KEYS[i])
+	redis.call('INCR', KEYS[i + 1])
+	redis.call('PEXPIRE', KEYS[i + 1], ARGV[1])
+end
+return 0`)
+
+// lookup is the result of looking up rows in the cache. It keeps the scope
+// version and the write counters seen, so rows read from the next connector
+// are only cached if nothing changed in the meantime.
+type lookup struct {
+	rows    []map[string]dosa.FieldValue
+	version []byte
+	writes  [][]byte
+}
+
+// get returns the cached rows for the given keys; missing, outdated or
+// unreadable rows are nil. It returns nil if the cache cannot be used.
+func (c *Connector) get(ctx context.Context, ei *dosa.EntityInfo, keys [][]byte) *lookup {
+	args := [][]byte{[]byte("MGET"), scopeKey(ei.Ref.Scope)}
+	args = append(args, keys...)
+	for _, key := range keys {
+		args = append(args, writesKey(key))
+	}
+	reply, err := c.client.do(ctx, args...)
+	if err != nil {
+		return nil
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 1+2*len(keys) {
+		return nil
+	}
+	l := &lookup{rows: make([]map[string]dosa.FieldValue, len(keys)), writes: make([][]byte, len(keys))}
+	l.version, _ = items[0].([]byte)
+	for i := range keys {
+		l.writes[i], _ = items[1+len(keys)+i].([]byte)
+		if data, ok := items[1+i].([]byte); ok && data != nil {
+			// an outdated or undecodable row is treated as a miss and
+			// overwritten
+			l.rows[i], _ = decodeCachedRow(ei.Def, l.version, data)
+		}
+	}
+	return l
+}
+
+// encodeCachedRow encodes a row along with the scope version it was read at
+func encodeCachedRow(ed *dosa.EntityDefinition, version []byte, values map[string]dosa.FieldValue) ([]byte, error) {
+	data, err := encodeRow(ed, values)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	writeBytes(&b, version)
+	b.Write(data)
+	return b.Bytes(), nil
+}
+
+// decodeCachedRow decodes a row written by encodeCachedRow, returning nil if
+// it was cached at another scope version
+func decodeCachedRow(ed *dosa.EntityDefinition, version []byte, data []byte) (map[string]dosa.FieldValue, error) {
+	r := bytes.NewReader(data)
+	cached, err := readBytes(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid cached row")
+	}
+	if !bytes.Equal(cached, version) {
+		return nil, nil
+	}
+	return decodeRow(ed, data[len(data)-r.Len():])
+}
+
+// fill caches the i-th row of a lookup, ignoring failures. Nothing is cached
+// if the scope was truncated or the row was written since the lookup.
+func (c *Connector) fill(ctx context.Context, ei *dosa.EntityInfo, l *lookup, i int, key []byte, values map[string]dosa.FieldValue) {
+	data, err := encodeCachedRow(ei.Def, l.version, values)
+	if err != nil {
+		return
+	}
+	ttl := strconv.FormatInt(int64(c.ttl/time.Millisecond), 10)
+	_, _ = c.client.do(ctx, []byte("EVAL"), fillScript, []byte("3"),
+		key, scopeKey(ei.Ref.Scope), writesKey(key),
+		l.version, l.writes[i], data, []byte(ttl))
+}
+
+// detached keeps the values of a context but not its deadline or
+// cancellation, so cached rows are deleted even after the caller gave up
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// invalidate deletes the cached rows with the given key values and counts a
+// write for each of them, so that reads in flight do not cache older values.
+// It runs on a detached context, bounded by the cache timeout only.
+func (c *Connector) invalidate(ctx context.Context, ei *dosa.EntityInfo, rows ...map[string]dosa.FieldValue) error {
+	var keys [][]byte
+	for _, row := range rows {
+		if key, ok := rowKey(ei, row); ok {
+			keys = append(keys, key, writesKey(key))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	ttl := strconv.FormatInt(int64(c.ttl/time.Millisecond), 10)
+	args := [][]byte{[]byte("EVAL"), invalidateScript, []byte(strconv.Itoa(len(keys)))}
+	args = append(args, keys...)
+	if _, err := c.client.do(detached{ctx}, append(args, []byte(ttl))...); err != nil {
+		return errors.Wrap(err, "failed to delete the cached rows")
+	}
+	return nil
+}
+
+// invalidateScope makes all cached rows of a scope outdated. Like
+// invalidate, it runs on a detached context.
+func (c *Connector) invalidateScope(ctx context.Context, scope string) error {
+	if _, err := c.client.do(detached{ctx}, []byte("INCR"), scopeKey(scope)); err != nil {
+		return errors.Wrapf(err, "failed to outdate the cached rows of scope %q", scope)
+	}
+	return nil
+}
+
+// invalidated returns the error of a write, or the error of the
+// invalidation that followed it
+func invalidated(err error, invalidateErr error) error {
+	if err != nil {
+		return err
+	}
+	return invalidateErr
+}
+
+// project returns the requested fields of a row; all of them when
+// fieldsToRead is nil
+func project(values map[string]dosa.FieldValue, fieldsToRead []string) map[string]dosa.FieldValue {
+	if fieldsToRead == nil {
+		return values
+	}
+	result := make(map[string]dosa.FieldValue, len(fieldsToRead))
+	for _, name := range fieldsToRead {
+		if v, ok := values[name]; ok {
+			result[name] = v
+		}
+	}
+	return result
+}
+
+// Read returns the cached row when there is one, otherwise it reads the
+// whole row from the next connector and caches it
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	key, ok := rowKey(ei, keys)
+	if !ok {
+		return c.Connector.Read(ctx, ei, keys, fieldsToRead)
+	}
+	l := c.get(ctx, ei, [][]byte{key})
+	if l != nil && l.rows[0] != nil {
+		return project(l.rows[0], fieldsToRead), nil
+	}
+	values, err := c.Connector.Read(ctx, ei, keys, nil)
+	if err != nil {
+		return nil, err
+	}
+	if l != nil {
+		c.fill(ctx, ei, l, 0, key, values)
+	}
+	return project(values, fieldsToRead), nil
+}
+
+// MultiRead serves the cached rows and reads the others from the next
+// connector in a single call
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	cacheKeys := make([][]byte, len(keys))
+	for i, k := range keys {
+		key, ok := rowKey(ei, k)
+		if !ok {
+			return c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+		}
+		cacheKeys[i] = key
+	}
+	if len(keys) == 0 {
+		return c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+	}
+
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	var missing []int
+	l := c.get(ctx, ei, cacheKeys)
+	for i := range keys {
+		if l != nil && l.rows[i] != nil {
+			results[i] = &dosa.FieldValuesOrError{Values: project(l.rows[i], fieldsToRead)}
+			continue
+		}
+		missing = append(missing, i)
+	}
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	batch := make([]map[string]dosa.FieldValue, len(missing))
+	for i, idx := range missing {
+		batch[i] = keys[idx]
+	}
+	fetched, err := c.Connector.MultiRead(ctx, ei, batch, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i, idx := range missing {
+		if i >= len(fetched) || fetched[i] == nil {
+			continue
+		}
+		if fetched[i].Error != nil {
+			results[idx] = fetched[i]
+			continue
+		}
+		if l != nil {
+			c.fill(ctx, ei, l, idx, cacheKeys[idx], fetched[i].Values)
+		}
+		results[idx] = &dosa.FieldValuesOrError{Values: project(fetched[i].Values, fieldsToRead)}
+	}
+	return results, nil
+}
+
+// Upsert calls Next and deletes the cached row
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	err := c.Connector.Upsert(ctx, ei, values)
+	return invalidated(err, c.invalidate(ctx, ei, values))
+}
+
+// MultiUpsert calls Next and deletes the cached rows
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	results, err := c.Connector.MultiUpsert(ctx, ei, values)
+	return results, invalidated(err, c.invalidate(ctx, ei, values...))
+}
+
+// Remove calls Next and deletes the cached row
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	err := c.Connector.Remove(ctx, ei, keys)
+	return invalidated(err, c.invalidate(ctx, ei, keys))
+}
+
+// MultiRemove calls Next and deletes the cached rows
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	results, err := c.Connector.MultiRemove(ctx, ei, multiKeys)
+	return results, invalidated(err, c.invalidate(ctx, ei, multiKeys...))
+}
+
+// TruncateScope calls Next and makes the cached rows of the scope outdated
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	err := c.Connector.TruncateScope(ctx, scope)
+	return invalidated(err, c.invalidateScope(ctx, scope))
+}
+
+// DropScope calls Next and makes the cached rows of the scope outdated
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	err := c.Connector.DropScope(ctx, scope)
+	return invalidated(err, c.invalidateScope(ctx, scope))
+}
+
+// Shutdown closes the connections to the cache and shuts down Next
+func (c *Connector) Shutdown() error {
+	_ = c.client.close()
+	return c.Connector.Shutdown()
+}
@@ -0,0 +1,618 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+// fakeServer is an in-process stand-in for Redis that understands the few
+// commands used by the connector
+type fakeServer struct {
+	listener net.Listener
+
+	lock     sync.Mutex
+	data     map[string][]byte
+	ttls     map[string]string
+	commands []string
+	fail     bool
+	conns    int
+	delay    time.Duration
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &fakeServer{listener: l, data: map[string][]byte{}, ttls: map[string]string{}}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.conns++
+		s.lock.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([][]byte, len(items))
+		for i, item := range items {
+			args[i], _ = item.([]byte)
+		}
+		s.lock.Lock()
+		delay := s.delay
+		s.lock.Unlock()
+		time.Sleep(delay)
+		if _, err := conn.Write([]byte(s.exec(args))); err != nil {
+			return
+		}
+	}
+}
+
+func bulk(data []byte) string {
+	if data == nil {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(data), data)
+}
+
+func (s *fakeServer) exec(args [][]byte) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(args) == 0 {
+		return "-ERR empty command\r\n"
+	}
+	cmd := strings.ToUpper(string(args[0]))
+	s.commands = append(s.commands, cmd)
+	if s.fail {
+		return "-ERR failing\r\n"
+	}
+	switch cmd {
+	case "GET":
+		return bulk(s.data[string(args[1])])
+	case "MGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			reply += bulk(s.data[string(key)])
+		}
+		return reply
+	case "SET":
+		s.data[string(args[1])] = args[2]
+		if len(args) == 5 {
+			s.ttls[string(args[1])] = string(args[3]) + " " + string(args[4])
+		}
+		return "+OK\r\n"
+	case "INCR":
+		return ":" + strconv.FormatInt(s.incr(string(args[1])), 10) + "\r\n"
+	case "EVAL":
+		return s.eval(args[1:])
+	case "DEL":
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := s.data[string(key)]; ok {
+				delete(s.data, string(key))
+				n++
+			}
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	}
+	return "-ERR unknown command '" + cmd + "'\r\n"
+}
+
+func (s *fakeServer) incr(key string) int64 {
+	n, _ := strconv.ParseInt(string(s.data[key]), 10, 64)
+	n++
+	s.data[key] = []byte(strconv.FormatInt(n, 10))
+	return n
+}
+
+// eval runs the scripts used by the connector; the caller must hold the lock
+func (s *fakeServer) eval(args [][]byte) string {
+	n, _ := strconv.Atoi(string(args[1]))
+	keys, argv := args[2:2+n], args[2+n:]
+	switch string(args[0]) {
+	case string(fillScript):
+		if string(s.data[string(keys[1])]) != string(argv[0]) || string(s.data[string(keys[2])]) != string(argv[1]) {
+			return ":0\r\n"
+		}
+		s.data[string(keys[0])] = argv[2]
+		s.ttls[string(keys[0])] = "PX " + string(argv[3])
+		return "+OK\r\n"
+	case string(invalidateScript):
+		for i := 0; i < n; i += 2 {
+			delete(s.data, string(keys[i]))
+			s.incr(string(keys[i+1]))
+			s.ttls[string(keys[i+1])] = "PX " + string(argv[0])
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown script\r\n"
+}
+
+// rows returns the number of cached rows
+func (s *fakeServer) rows() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	n := 0
+	for key := range s.data {
+		if strings.HasPrefix(key, "dosa:") {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *fakeServer) Commands() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	commands := s.commands
+	s.commands = nil
+	return commands
+}
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testScope",
+		NamePrefix: "testPrefix",
+		EntityName: "testEntityName",
+	},
+	Def: &dosa.EntityDefinition{
+		Name: "testentityname",
+		Key: &dosa.PrimaryKey{
+			PartitionKeys:  []string{"p1"},
+			ClusteringKeys: []*dosa.ClusteringKey{{Name: "c1"}},
+		},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "p1", Type: dosa.String},
+			{Name: "c1", Type: dosa.Int64},
+			{Name: "v1", Type: dosa.TUUID},
+			{Name: "v2", Type: dosa.Blob},
+			{Name: "v3", Type: dosa.Int32},
+			{Name: "v4", Type: dosa.Double},
+			{Name: "v5", Type: dosa.Bool},
+			{Name: "v6", Type: dosa.Timestamp},
+		},
+	},
+}
+
+func keys(p1 string) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"p1": p1, "c1": int64(1)}
+}
+
+func row(p1 string) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"p1": p1,
+		"c1": int64(1),
+		"v1": dosa.UUID("3e4befa0-69d2-11e7-907b-a6006ad3dba0"),
+		"v2": []byte{0, 1, 2},
+		"v3": int32(-3),
+		"v4": 4.5,
+		"v5": true,
+		"v6": time.Unix(1500000000, 123),
+	}
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	data, err := encodeRow(testEi.Def, row("a"))
+	assert.NoError(t, err)
+	values, err := decodeRow(testEi.Def, data)
+	assert.NoError(t, err)
+	assert.Equal(t, row("a"), values)
+
+	// empty values and columns outside the definition
+	r := map[string]dosa.FieldValue{"p1": "", "v2": []byte{}, "v5": false, "unknown": 1}
+	data, err = encodeRow(testEi.Def, r)
+	assert.NoError(t, err)
+	values, err = decodeRow(testEi.Def, data)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dosa.FieldValue{"p1": "", "v2": []byte{}, "v5": false}, values)
+
+	_, err = encodeRow(testEi.Def, map[string]dosa.FieldValue{"c1": "not an int"})
+	assert.Contains(t, err.Error(), `column "c1"`)
+
+	_, err = decodeRow(testEi.Def, data[:len(data)-1])
+	assert.Error(t, err)
+	_, err = decodeRow(&dosa.EntityDefinition{}, data)
+	assert.Contains(t, err.Error(), "unknown column")
+}
+
+func TestRowKey(t *testing.T) {
+	key, ok := rowKey(testEi, row("a"))
+	assert.True(t, ok)
+	assert.Equal(t, "dosa:\"testScope\"/\"testPrefix\"/\"testEntityName\"/\x01a\x02", string(key))
+
+	_, ok = rowKey(testEi, map[string]dosa.FieldValue{"p1": "a"})
+	assert.False(t, ok)
+	_, ok = rowKey(testEi, map[string]dosa.FieldValue{"p1": "a", "c1": "1"})
+	assert.False(t, ok)
+}
+
+func TestNewConnector(t *testing.T) {
+	_, err := NewConnector(nil, &Config{})
+	assert.Error(t, err)
+	c, err := NewConnector(nil, &Config{Addr: "localhost:6379"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultTTL, c.ttl)
+	assert.Equal(t, DefaultTimeout, c.client.timeout)
+	assert.Equal(t, DefaultPoolSize, cap(c.client.slots))
+}
+
+func TestConnector_Read(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.listener.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().Shutdown().Return(nil)
+	sut, err := NewConnector(mc, &Config{Addr: server.listener.Addr().String(), TTL: time.Second})
+	assert.NoError(t, err)
+	defer sut.Shutdown()
+
+	mc.EXPECT().Read(ctx, testEi, keys("a"), nil).Return(row("a"), nil).Times(1)
+	values, err := sut.Read(ctx, testEi, keys("a"), []string{"v1", "v6"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dosa.FieldValue{"v1": row("a")["v1"], "v6": row("a")["v6"]}, values)
+	assert.Equal(t, []string{"MGET", "EVAL"}, server.Commands())
+	key, _ := rowKey(testEi, keys("a"))
+	assert.Equal(t, "PX 1000", server.ttls[string(key)])
+
+	// second read is a hit
+	values, err = sut.Read(ctx, testEi, keys("a"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row("a"), values)
+	assert.Equal(t, []string{"MGET"}, server.Commands())
+
+	// misses are not cached
+	mc.EXPECT().Read(ctx, testEi, keys("b"), nil).Return(nil, &dosa.ErrNotFound{})
+	_, err = sut.Read(ctx, testEi, keys("b"), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.Equal(t, []string{"MGET"}, server.Commands())
+
+	// incomplete keys bypass the cache
+	mc.EXPECT().Read(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}, nil).Return(nil, errors.New("missing key"))
+	_, err = sut.Read(ctx, testEi, map[string]dosa.FieldValue{"p1": "a"}, nil)
+	assert.Error(t, err)
+	assert.Empty(t, server.Commands())
+}
+
+func TestConnector_MultiRead(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.listener.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut, err := NewConnector(mc, &Config{Addr: server.listener.Addr().String()})
+	assert.NoError(t, err)
+
+	mc.EXPECT().Read(ctx, testEi, keys("b"), nil).Return(row("b"), nil)
+	_, err = sut.Read(ctx, testEi, keys("b"), nil)
+	assert.NoError(t, err)
+
+	mc.EXPECT().MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("a"), keys("c")}, nil).Return([]*dosa.FieldValuesOrError{
+		{Values: row("a")},
+		{Error: &dosa.ErrNotFound{}},
+	}, nil)
+	results, err := sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("a"), keys("b"), keys("c")}, []string{"p1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dosa.FieldValue{"p1": "a"}, results[0].Values)
+	assert.Equal(t, map[string]dosa.FieldValue{"p1": "b"}, results[1].Values)
+	assert.True(t, dosa.ErrorIsNotFound(results[2].Error))
+
+	results, err = sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("a"), keys("b")}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row("a"), results[0].Values)
+	assert.Equal(t, row("b"), results[1].Values)
+
+	mc.EXPECT().MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("c")}, nil).Return(nil, errors.New("boom"))
+	_, err = sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("c")}, nil)
+	assert.Error(t, err)
+}
+
+func TestConnector_Invalidation(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.listener.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut, err := NewConnector(mc, &Config{Addr: server.listener.Addr().String()})
+	assert.NoError(t, err)
+
+	fill := func(p1 string) {
+		mc.EXPECT().Read(ctx, testEi, keys(p1), nil).Return(row(p1), nil)
+		_, err := sut.Read(ctx, testEi, keys(p1), nil)
+		assert.NoError(t, err)
+	}
+	cached := server.rows
+
+	fill("a")
+	fill("b")
+	mc.EXPECT().Upsert(ctx, testEi, row("a")).Return(nil)
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a")))
+	assert.Equal(t, 1, cached())
+	mc.EXPECT().Remove(ctx, testEi, keys("b")).Return(nil)
+	assert.NoError(t, sut.Remove(ctx, testEi, keys("b")))
+	assert.Equal(t, 0, cached())
+
+	fill("a")
+	fill("b")
+	mc.EXPECT().MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("a")}).Return(nil, nil)
+	_, err = sut.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("a")})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cached())
+	mc.EXPECT().MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("b")}).Return(nil, nil)
+	_, err = sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("b")})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cached())
+}
+
+func TestConnector_InvalidationOutlivesContext(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.listener.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut, err := NewConnector(mc, &Config{Addr: server.listener.Addr().String()})
+	assert.NoError(t, err)
+
+	mc.EXPECT().Read(ctx, testEi, keys("a"), nil).Return(row("a"), nil)
+	_, err = sut.Read(ctx, testEi, keys("a"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, server.rows())
+
+	// the caller gives up once the write is done, the cached row is still
+	// deleted
+	cctx, cancel := context.WithCancel(ctx)
+	mc.EXPECT().Upsert(cctx, testEi, row("a")).Do(func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue) {
+		cancel()
+	}).Return(nil)
+	assert.NoError(t, sut.Upsert(cctx, testEi, row("a")))
+	assert.Equal(t, 0, server.rows())
+}
+
+func TestConnector_InvalidationFailure(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.listener.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut, err := NewConnector(mc, &Config{Addr: server.listener.Addr().String()})
+	assert.NoError(t, err)
+
+	server.lock.Lock()
+	server.fail = true
+	server.lock.Unlock()
+
+	// the write is applied, but the caller learns the cache may be stale
+	mc.EXPECT().Upsert(ctx, testEi, row("a")).Return(nil)
+	err = sut.Upsert(ctx, testEi, row("a"))
+	assert.Contains(t, err.Error(), "failed to delete the cached rows")
+	mc.EXPECT().MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("a")}).Return([]error{nil}, nil)
+	results, err := sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("a")})
+	assert.Equal(t, []error{nil}, results)
+	assert.Contains(t, err.Error(), "failed to delete the cached rows")
+	mc.EXPECT().TruncateScope(ctx, "testScope").Return(nil)
+	err = sut.TruncateScope(ctx, "testScope")
+	assert.Contains(t, err.Error(), "testScope")
+
+	// the error of the write comes first
+	mc.EXPECT().Remove(ctx, testEi, keys("a")).Return(errors.New("boom"))
+	assert.EqualError(t, sut.Remove(ctx, testEi, keys("a")), "boom")
+}
+
+func TestClient_Pool(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.listener.Close()
+	server.delay = 50 * time.Millisecond
+	c := newClient(server.listener.Addr().String(), time.Second, 2)
+	defer c.close()
+
+	// commands run concurrently, on at most two connections
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.do(ctx, []byte("GET"), []byte("key"))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.True(t, time.Since(start) < 4*server.delay, "commands were serialized")
+	server.lock.Lock()
+	assert.Equal(t, 2, server.conns)
+	server.lock.Unlock()
+
+	// the connections are reused
+	_, err := c.do(ctx, []byte("GET"), []byte("key"))
+	assert.NoError(t, err)
+	server.lock.Lock()
+	assert.Equal(t, 2, server.conns)
+	server.lock.Unlock()
+
+	// waiting for a connection counts towards the timeout
+	c = newClient(server.listener.Addr().String(), 10*time.Millisecond, 1)
+	defer c.close()
+	c.slots <- struct{}{}
+	_, err = c.do(ctx, []byte("GET"), []byte("key"))
+	assert.Contains(t, err.Error(), "no free connection")
+}
+
+func TestConnector_ScopeInvalidation(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.listener.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut, err := NewConnector(mc, &Config{Addr: server.listener.Addr().String()})
+	assert.NoError(t, err)
+
+	otherEi := &dosa.EntityInfo{Ref: &dosa.SchemaRef{Scope: "otherScope", NamePrefix: "testPrefix", EntityName: "testEntityName"}, Def: testEi.Def}
+	for _, ei := range []*dosa.EntityInfo{testEi, otherEi} {
+		mc.EXPECT().Read(ctx, ei, keys("a"), nil).Return(row("a"), nil)
+		_, err = sut.Read(ctx, ei, keys("a"), nil)
+		assert.NoError(t, err)
+	}
+
+	// truncating or dropping a scope outdates its rows, not those of other
+	// scopes
+	mc.EXPECT().TruncateScope(ctx, "testScope").Return(nil)
+	assert.NoError(t, sut.TruncateScope(ctx, "testScope"))
+	mc.EXPECT().Read(ctx, testEi, keys("a"), nil).Return(nil, &dosa.ErrNotFound{})
+	_, err = sut.Read(ctx, testEi, keys("a"), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	_, err = sut.Read(ctx, otherEi, keys("a"), nil)
+	assert.NoError(t, err)
+
+	mc.EXPECT().DropScope(ctx, "otherScope").Return(nil)
+	assert.NoError(t, sut.DropScope(ctx, "otherScope"))
+	mc.EXPECT().Read(ctx, otherEi, keys("a"), nil).Return(nil, &dosa.ErrNotFound{})
+	_, err = sut.Read(ctx, otherEi, keys("a"), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+}
+
+// slowReader runs a function after reading from the next connector, to
+// simulate a write that happens while a read is in flight
+type slowReader struct {
+	dosa.Connector
+	after func()
+}
+
+func (r *slowReader) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	values, err := r.Connector.Read(ctx, ei, keys, fieldsToRead)
+	if r.after != nil {
+		r.after()
+		r.after = nil
+	}
+	return values, err
+}
+
+func TestConnector_ConcurrentWrite(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.listener.Close()
+	next := &slowReader{Connector: memory.NewConnector()}
+	sut, err := NewConnector(next, &Config{Addr: server.listener.Addr().String()})
+	assert.NoError(t, err)
+
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a")))
+	updated := row("a")
+	updated["v3"] = int32(42)
+	next.after = func() {
+		assert.NoError(t, sut.Upsert(ctx, testEi, updated))
+	}
+
+	// the read returns the value from before the write, but does not cache it
+	values, err := sut.Read(ctx, testEi, keys("a"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(-3), values["v3"])
+	assert.Equal(t, 0, server.rows())
+
+	values, err = sut.Read(ctx, testEi, keys("a"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(42), values["v3"])
+	assert.Equal(t, 1, server.rows())
+}
+
+func TestConnector_CacheUnavailable(t *testing.T) {
+	server := newFakeServer(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	sut, err := NewConnector(mc, &Config{Addr: server.listener.Addr().String()})
+	assert.NoError(t, err)
+
+	// server errors are misses
+	server.lock.Lock()
+	server.fail = true
+	server.lock.Unlock()
+	mc.EXPECT().Read(ctx, testEi, keys("a"), nil).Return(row("a"), nil).Times(2)
+	_, err = sut.Read(ctx, testEi, keys("a"), nil)
+	assert.NoError(t, err)
+
+	// so is a server that cannot be reached
+	server.listener.Close()
+	_ = sut.client.close()
+	_, err = sut.Read(ctx, testEi, keys("a"), nil)
+	assert.NoError(t, err)
+	_, err = sut.client.do(ctx, []byte("PING"))
+	assert.Contains(t, err.Error(), "failed to connect")
+}
+
+func TestReadReply(t *testing.T) {
+	data := []struct {
+		input string
+		reply interface{}
+		err   string
+	}{
+		{input: "+OK\r\n", reply: "OK"},
+		{input: ":42\r\n", reply: int64(42)},
+		{input: "$3\r\nfoo\r\n", reply: []byte("foo")},
+		{input: "$-1\r\n", reply: []byte(nil)},
+		{input: "*2\r\n$1\r\na\r\n$-1\r\n", reply: []interface{}{[]byte("a"), []byte(nil)}},
+		{input: "*-1\r\n", reply: nil},
+		{input: "-ERR bad\r\n", err: "redis: ERR bad"},
+		{input: "?\r\n", err: "invalid reply type"},
+		{input: "+OK\n", err: "invalid reply line"},
+		{input: "\r\n", err: "empty reply"},
+		{input: "$x\r\n", err: "invalid syntax"},
+	}
+	for _, d := range data {
+		reply, err := readReply(bufio.NewReader(strings.NewReader(d.input)))
+		if d.err != "" {
+			assert.Contains(t, err.Error(), d.err, d.input)
+			continue
+		}
+		assert.NoError(t, err, d.input)
+		assert.Equal(t, d.reply, reply, d.input)
+	}
+}
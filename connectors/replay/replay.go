@@ -0,0 +1,690 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Args are the arguments of a recorded call. Only the fields used by the
+// method are set.
+type Args struct {
+	Scope        string
+	NamePrefix   string
+	Owner        string
+	Values       map[string]dosa.FieldValue
+	MultiValues  []map[string]dosa.FieldValue
+	FieldsToRead []string
+	Conditions   map[string][]*dosa.Condition
+	FieldPair    *dosa.FieldNameValuePair
+	Token        string
+	Limit        int
+	Entities     []*dosa.EntityDefinition
+	Version      int32
+}
+
+// RowResult is the result for one row of a MultiRead
+type RowResult struct {
+	Values map[string]dosa.FieldValue
+	Err    Error
+}
+
+// Results are the results of a recorded call. Only the fields returned by
+// the method are set.
+type Results struct {
+	Values     map[string]dosa.FieldValue
+	Rows       []map[string]dosa.FieldValue
+	RowResults []RowResult
+	Errors     []Error
+	Token      string
+	Version    int32
+	Status     *dosa.SchemaStatus
+	Exists     bool
+	Scopes     []string
+	Err        Error
+}
+
+// error kinds that are replayed as their original types
+const (
+	kindNone             = ""
+	kindNotFound         = "not_found"
+	kindAlreadyExists    = "already_exists"
+	kindRetryable        = "retryable"
+	kindInvalidRequest   = "invalid_request"
+	kindSchemaMismatch   = "schema_mismatch"
+	kindTimeout          = "timeout"
+	kindThrottled        = "throttled"
+	kindInternal         = "internal"
+	kindNoMoreConnector  = "no_more_connector"
+	kindDeadlineExceeded = "deadline_exceeded"
+	kindCanceled         = "canceled"
+	kindOther            = "error"
+)
+
+// Error is a recorded error. The kind of the error is kept so the replayed
+// error satisfies the same dosa.ErrorIsX checks as the original one.
+type Error struct {
+	Kind    string
+	Message string
+	// Cause is the message of the underlying error of a retryable,
+	// invalid request, schema mismatch, timeout, throttled or internal error
+	Cause string
+	// Column is the offending column of an invalid request error
+	Column string
+}
+
+// Call is a recorded call
+type Call struct {
+	Method  string
+	Ref     *dosa.SchemaRef
+	Args    Args
+	Results Results
+}
+
+// ErrUnexpectedCall is returned when replaying a call that was not recorded,
+// or that was recorded fewer times than it is replayed
+type ErrUnexpectedCall struct {
+	Method string
+	Ref    *dosa.SchemaRef
+}
+
+// Error returns the method and entity of the unexpected call
+func (e *ErrUnexpectedCall) Error() string {
+	if e.Ref == nil {
+		return fmt.Sprintf("unexpected call to %s", e.Method)
+	}
+	return fmt.Sprintf("unexpected call to %s on %s.%s.%s", e.Method, e.Ref.Scope, e.Ref.NamePrefix, e.Ref.EntityName)
+}
+
+// ErrorIsUnexpectedCall checks if the error is caused by "ErrUnexpectedCall"
+func ErrorIsUnexpectedCall(err error) bool {
+	_, ok := errors.Cause(err).(*ErrUnexpectedCall)
+	return ok
+}
+
+// Connector records the calls made to another connector, or replays calls
+// recorded earlier so tests can run against captured traffic without a
+// backend.
+//
+// When recording, every call is passed to the next connector and then
+// appended to a file along with its results. When replaying, a call is
+// answered with the results of the first recorded call that has the same
+// method, SchemaRef and arguments and was not replayed yet; any other call
+// fails with ErrUnexpectedCall. Field values, including UUIDs, timestamps
+// and blobs, are returned with the types and values they were recorded
+// with.
+type Connector struct {
+	lock sync.Mutex
+	next dosa.Connector
+	// set when recording
+	file *os.File
+	enc  *gob.Encoder
+	// set when replaying
+	calls []*Call
+	used  []bool
+}
+
+// NewRecorder creates a connector that passes every call to next and
+// records it in the file at path. The file is overwritten.
+func NewRecorder(next dosa.Connector, path string) (*Connector, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create %q", path)
+	}
+	return &Connector{next: next, file: f, enc: gob.NewEncoder(f)}, nil
+}
+
+// NewReplayer creates a connector that replays the calls recorded in the
+// file at path
+func NewReplayer(path string) (*Connector, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open %q", path)
+	}
+	defer f.Close()
+
+	c := &Connector{}
+	dec := gob.NewDecoder(f)
+	for {
+		call := &Call{}
+		err := dec.Decode(call)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot decode %q", path)
+		}
+		normalize(&call.Args)
+		c.calls = append(c.calls, call)
+	}
+	c.used = make([]bool, len(c.calls))
+	return c, nil
+}
+
+// Unused returns the recorded calls that were not replayed
+func (c *Connector) Unused() []*Call {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var unused []*Call
+	for i, call := range c.calls {
+		if !c.used[i] {
+			unused = append(unused, call)
+		}
+	}
+	return unused
+}
+
+// do records or replays a call. When recording, call runs it on the next
+// connector.
+func (c *Connector) do(method string, ref *dosa.SchemaRef, args Args, call func(next dosa.Connector) (*Results, error)) (*Results, error) {
+	if c.enc == nil {
+		return c.replay(method, ref, args)
+	}
+
+	if c.next == nil {
+		return nil, base.ErrNoMoreConnector{}
+	}
+	results, err := call(c.next)
+	if results == nil {
+		results = &Results{}
+	}
+	results.Err = encodeError(err)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if werr := c.enc.Encode(&Call{Method: method, Ref: ref, Args: args, Results: *results}); werr != nil {
+		return nil, errors.Wrapf(werr, "cannot record %s", method)
+	}
+	return results, err
+}
+
+func (c *Connector) replay(method string, ref *dosa.SchemaRef, args Args) (*Results, error) {
+	// compare the arguments the way they read back from the file
+	canonical, err := roundTrip(args)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot encode arguments of %s", method)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, call := range c.calls {
+		if c.used[i] || call.Method != method || !reflect.DeepEqual(call.Ref, ref) || !reflect.DeepEqual(call.Args, canonical) {
+			continue
+		}
+		c.used[i] = true
+		return &call.Results, decodeError(call.Results.Err)
+	}
+	return nil, &ErrUnexpectedCall{Method: method, Ref: ref}
+}
+
+// roundTrip returns the arguments as they would be decoded from a recording
+func roundTrip(args Args) (Args, error) {
+	var buf bytes.Buffer
+	var decoded Args
+	if err := gob.NewEncoder(&buf).Encode(&args); err != nil {
+		return decoded, err
+	}
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		return decoded, err
+	}
+	normalize(&decoded)
+	return decoded, nil
+}
+
+// normalize converts the timestamps in args to UTC, so timestamps of the
+// same instant compare equal whatever location they were created in
+func normalize(args *Args) {
+	normalizeValues(args.Values)
+	for _, values := range args.MultiValues {
+		normalizeValues(values)
+	}
+	for _, conditions := range args.Conditions {
+		for _, cond := range conditions {
+			cond.Value = normalizeValue(cond.Value)
+		}
+	}
+	if args.FieldPair != nil {
+		args.FieldPair.Value = normalizeValue(args.FieldPair.Value)
+	}
+}
+
+func normalizeValues(values map[string]dosa.FieldValue) {
+	for name, value := range values {
+		values[name] = normalizeValue(value)
+	}
+}
+
+func normalizeValue(value dosa.FieldValue) dosa.FieldValue {
+	if t, ok := value.(time.Time); ok {
+		return t.UTC()
+	}
+	return value
+}
+
+// entities returns copies of the entity definitions that can be recorded;
+// custom types cannot be encoded and are left out
+func entities(eds []*dosa.EntityDefinition) []*dosa.EntityDefinition {
+	if eds == nil {
+		return nil
+	}
+	copies := make([]*dosa.EntityDefinition, len(eds))
+	for i, ed := range eds {
+		if ed == nil {
+			continue
+		}
+		cp := *ed
+		cp.Columns = make([]*dosa.ColumnDefinition, len(ed.Columns))
+		for j, col := range ed.Columns {
+			colCopy := *col
+			colCopy.CustomType = nil
+			cp.Columns[j] = &colCopy
+		}
+		copies[i] = &cp
+	}
+	return copies
+}
+
+func encodeError(err error) Error {
+	if err == nil {
+		return Error{}
+	}
+	e := Error{Kind: kindOther, Message: err.Error()}
+	// the typed dosa errors wrap others, so the outermost error of a known
+	// type decides the kind
+	for next := err; next != nil && e.Kind == kindOther; next = unwrap(next) {
+		e = encodeCause(e, next)
+	}
+	return e
+}
+
+// unwrap returns the error wrapped by err, or nil
+func unwrap(err error) error {
+	if c, ok := err.(interface {
+		Cause() error
+	}); ok {
+		return c.Cause()
+	}
+	return nil
+}
+
+// encodeCause sets the kind of e if err has a known type
+func encodeCause(e Error, err error) Error {
+	switch cause := err.(type) {
+	case *dosa.ErrNotFound:
+		e.Kind = kindNotFound
+	case *dosa.ErrAlreadyExists:
+		e.Kind = kindAlreadyExists
+	case *dosa.ErrRetryable:
+		e.Kind = kindRetryable
+		e.Cause = cause.Err.Error()
+	case *dosa.ErrInvalidRequest:
+		e.Kind = kindInvalidRequest
+		e.Cause = cause.Err.Error()
+		e.Column = cause.Column
+	case *dosa.ErrSchemaMismatch:
+		e.Kind = kindSchemaMismatch
+		e.Cause = cause.Err.Error()
+	case *dosa.ErrTimeout:
+		e.Kind = kindTimeout
+		e.Cause = cause.Err.Error()
+	case *dosa.ErrThrottled:
+		e.Kind = kindThrottled
+		e.Cause = cause.Err.Error()
+	case *dosa.ErrInternal:
+		e.Kind = kindInternal
+		e.Cause = cause.Err.Error()
+	case base.ErrNoMoreConnector:
+		e.Kind = kindNoMoreConnector
+	default:
+		switch cause {
+		case context.DeadlineExceeded:
+			e.Kind = kindDeadlineExceeded
+		case context.Canceled:
+			e.Kind = kindCanceled
+		}
+	}
+	return e
+}
+
+// replayedError has the message of a recorded error and the type of its
+// cause
+type replayedError struct {
+	msg   string
+	cause error
+}
+
+func (e *replayedError) Error() string {
+	return e.msg
+}
+
+// Cause returns the error of the recorded type
+func (e *replayedError) Cause() error {
+	return e.cause
+}
+
+func decodeError(e Error) error {
+	var cause error
+	switch e.Kind {
+	case kindNone:
+		return nil
+	case kindNotFound:
+		cause = &dosa.ErrNotFound{}
+	case kindAlreadyExists:
+		cause = &dosa.ErrAlreadyExists{}
+	case kindRetryable:
+		cause = &dosa.ErrRetryable{Err: errors.New(e.Cause)}
+	case kindInvalidRequest:
+		cause = &dosa.ErrInvalidRequest{Column: e.Column, Err: errors.New(e.Cause)}
+	case kindSchemaMismatch:
+		cause = &dosa.ErrSchemaMismatch{Err: errors.New(e.Cause)}
+	case kindTimeout:
+		cause = &dosa.ErrTimeout{Err: errors.New(e.Cause)}
+	case kindThrottled:
+		cause = &dosa.ErrThrottled{Err: errors.New(e.Cause)}
+	case kindInternal:
+		cause = &dosa.ErrInternal{Err: errors.New(e.Cause)}
+	case kindNoMoreConnector:
+		cause = base.ErrNoMoreConnector{}
+	case kindDeadlineExceeded:
+		cause = context.DeadlineExceeded
+	case kindCanceled:
+		cause = context.Canceled
+	default:
+		return errors.New(e.Message)
+	}
+	if cause.Error() == e.Message {
+		return cause
+	}
+	return &replayedError{msg: e.Message, cause: cause}
+}
+
+func schemaRef(ei *dosa.EntityInfo) *dosa.SchemaRef {
+	if ei == nil {
+		return nil
+	}
+	return ei.Ref
+}
+
+// CreateIfNotExists records or replays a CreateIfNotExists
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	_, err := c.do("CreateIfNotExists", schemaRef(ei), Args{Values: values}, func(next dosa.Connector) (*Results, error) {
+		return nil, next.CreateIfNotExists(ctx, ei, values)
+	})
+	return err
+}
+
+// Read records or replays a Read
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	results, err := c.do("Read", schemaRef(ei), Args{Values: keys, FieldsToRead: fieldsToRead}, func(next dosa.Connector) (*Results, error) {
+		values, err := next.Read(ctx, ei, keys, fieldsToRead)
+		return &Results{Values: values}, err
+	})
+	if results == nil {
+		return nil, err
+	}
+	return results.Values, err
+}
+
+// MultiRead records or replays a MultiRead
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	results, err := c.do("MultiRead", schemaRef(ei), Args{MultiValues: keys, FieldsToRead: fieldsToRead}, func(next dosa.Connector) (*Results, error) {
+		values, err := next.MultiRead(ctx, ei, keys, fieldsToRead)
+		rows := make([]RowResult, len(values))
+		for i, value := range values {
+			if value != nil {
+				rows[i] = RowResult{Values: value.Values, Err: encodeError(value.Error)}
+			}
+		}
+		return &Results{RowResults: rows}, err
+	})
+	if results == nil || results.RowResults == nil {
+		return nil, err
+	}
+	values := make([]*dosa.FieldValuesOrError, len(results.RowResults))
+	for i, row := range results.RowResults {
+		values[i] = &dosa.FieldValuesOrError{Values: row.Values, Error: decodeError(row.Err)}
+	}
+	return values, err
+}
+
+// Upsert records or replays an Upsert
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	_, err := c.do("Upsert", schemaRef(ei), Args{Values: values}, func(next dosa.Connector) (*Results, error) {
+		return nil, next.Upsert(ctx, ei, values)
+	})
+	return err
+}
+
+// multiErrors records or replays a call returning one error per row
+func (c *Connector) multiErrors(method string, ei *dosa.EntityInfo, rows []map[string]dosa.FieldValue, call func(next dosa.Connector) ([]error, error)) ([]error, error) {
+	results, err := c.do(method, schemaRef(ei), Args{MultiValues: rows}, func(next dosa.Connector) (*Results, error) {
+		errs, err := call(next)
+		if errs == nil {
+			return nil, err
+		}
+		encoded := make([]Error, len(errs))
+		for i, e := range errs {
+			encoded[i] = encodeError(e)
+		}
+		return &Results{Errors: encoded}, err
+	})
+	if results == nil || results.Errors == nil {
+		return nil, err
+	}
+	errs := make([]error, len(results.Errors))
+	for i, e := range results.Errors {
+		errs[i] = decodeError(e)
+	}
+	return errs, err
+}
+
+// MultiUpsert records or replays a MultiUpsert
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	return c.multiErrors("MultiUpsert", ei, values, func(next dosa.Connector) ([]error, error) {
+		return next.MultiUpsert(ctx, ei, values)
+	})
+}
+
+// Remove records or replays a Remove
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	_, err := c.do("Remove", schemaRef(ei), Args{Values: keys}, func(next dosa.Connector) (*Results, error) {
+		return nil, next.Remove(ctx, ei, keys)
+	})
+	return err
+}
+
+// MultiRemove records or replays a MultiRemove
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	return c.multiErrors("MultiRemove", ei, multiKeys, func(next dosa.Connector) ([]error, error) {
+		return next.MultiRemove(ctx, ei, multiKeys)
+	})
+}
+
+// page records or replays a call returning a page of rows
+func (c *Connector) page(method string, ei *dosa.EntityInfo, args Args, call func(next dosa.Connector) ([]map[string]dosa.FieldValue, string, error)) ([]map[string]dosa.FieldValue, string, error) {
+	results, err := c.do(method, schemaRef(ei), args, func(next dosa.Connector) (*Results, error) {
+		rows, token, err := call(next)
+		return &Results{Rows: rows, Token: token}, err
+	})
+	if results == nil {
+		return nil, "", err
+	}
+	return results.Rows, results.Token, err
+}
+
+// Range records or replays a Range
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	args := Args{Conditions: columnConditions, FieldsToRead: fieldsToRead, Token: token, Limit: limit}
+	return c.page("Range", ei, args, func(next dosa.Connector) ([]map[string]dosa.FieldValue, string, error) {
+		return next.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+	})
+}
+
+// Search records or replays a Search
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPairs dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	args := Args{FieldPair: &fieldPairs, FieldsToRead: fieldsToRead, Token: token, Limit: limit}
+	return c.page("Search", ei, args, func(next dosa.Connector) ([]map[string]dosa.FieldValue, string, error) {
+		return next.Search(ctx, ei, fieldPairs, fieldsToRead, token, limit)
+	})
+}
+
+// Scan records or replays a Scan
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	args := Args{FieldsToRead: fieldsToRead, Token: token, Limit: limit}
+	return c.page("Scan", ei, args, func(next dosa.Connector) ([]map[string]dosa.FieldValue, string, error) {
+		return next.Scan(ctx, ei, fieldsToRead, token, limit)
+	})
+}
+
+// CheckSchema records or replays a CheckSchema
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	args := Args{Scope: scope, NamePrefix: namePrefix, Entities: entities(ed)}
+	results, err := c.do("CheckSchema", nil, args, func(next dosa.Connector) (*Results, error) {
+		version, err := next.CheckSchema(ctx, scope, namePrefix, ed)
+		return &Results{Version: version}, err
+	})
+	if results == nil {
+		return dosa.InvalidVersion, err
+	}
+	return results.Version, err
+}
+
+// UpsertSchema records or replays an UpsertSchema
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	args := Args{Scope: scope, NamePrefix: namePrefix, Entities: entities(ed)}
+	results, err := c.do("UpsertSchema", nil, args, func(next dosa.Connector) (*Results, error) {
+		status, err := next.UpsertSchema(ctx, scope, namePrefix, ed)
+		return &Results{Status: status}, err
+	})
+	if results == nil {
+		return nil, err
+	}
+	return results.Status, err
+}
+
+// CheckSchemaStatus records or replays a CheckSchemaStatus
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	args := Args{Scope: scope, NamePrefix: namePrefix, Version: version}
+	results, err := c.do("CheckSchemaStatus", nil, args, func(next dosa.Connector) (*Results, error) {
+		status, err := next.CheckSchemaStatus(ctx, scope, namePrefix, version)
+		return &Results{Status: status}, err
+	})
+	if results == nil {
+		return nil, err
+	}
+	return results.Status, err
+}
+
+// scopeOp records or replays a scope operation
+func (c *Connector) scopeOp(method, scope string, call func(next dosa.Connector) error) error {
+	_, err := c.do(method, nil, Args{Scope: scope}, func(next dosa.Connector) (*Results, error) {
+		return nil, call(next)
+	})
+	return err
+}
+
+// CreateScope records or replays a CreateScope
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	return c.scopeOp("CreateScope", scope, func(next dosa.Connector) error {
+		return next.CreateScope(ctx, scope)
+	})
+}
+
+// TruncateScope records or replays a TruncateScope
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	return c.scopeOp("TruncateScope", scope, func(next dosa.Connector) error {
+		return next.TruncateScope(ctx, scope)
+	})
+}
+
+// DropScope records or replays a DropScope
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	return c.scopeOp("DropScope", scope, func(next dosa.Connector) error {
+		return next.DropScope(ctx, scope)
+	})
+}
+
+// ScopeExists records or replays a ScopeExists
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	results, err := c.do("ScopeExists", nil, Args{Scope: scope}, func(next dosa.Connector) (*Results, error) {
+		exists, err := next.ScopeExists(ctx, scope)
+		return &Results{Exists: exists}, err
+	})
+	if results == nil {
+		return false, err
+	}
+	return results.Exists, err
+}
+
+// ListScopes records or replays a ListScopes
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	results, err := c.do("ListScopes", nil, Args{Owner: owner}, func(next dosa.Connector) (*Results, error) {
+		scopes, err := next.ListScopes(ctx, owner)
+		return &Results{Scopes: scopes}, err
+	})
+	if results == nil {
+		return nil, err
+	}
+	return results.Scopes, err
+}
+
+// Shutdown closes the recording and shuts down the next connector. Shutdown
+// is not recorded.
+func (c *Connector) Shutdown() error {
+	if c.file == nil {
+		return nil
+	}
+	c.lock.Lock()
+	err := c.file.Close()
+	c.lock.Unlock()
+	if c.next != nil {
+		if nerr := c.next.Shutdown(); nerr != nil {
+			return nerr
+		}
+	}
+	return errors.Wrap(err, "cannot close recording")
+}
+
+func init() {
+	// types stored in interface values must be registered with gob
+	gob.Register(dosa.UUID(""))
+	gob.Register(time.Time{})
+
+	dosa.RegisterConnector("replay", func(args map[string]interface{}) (dosa.Connector, error) {
+		path, ok := args["file"].(string)
+		if !ok || path == "" {
+			return nil, errors.New("file must be specified")
+		}
+		return NewReplayer(path)
+	})
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package replay_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/connectors/replay"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{Scope: "scope", NamePrefix: "prefix", EntityName: "event"},
+	Def: &dosa.EntityDefinition{
+		Name: "event",
+		Key: &dosa.PrimaryKey{
+			PartitionKeys:  []string{"id"},
+			ClusteringKeys: []*dosa.ClusteringKey{{Name: "at"}},
+		},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "id", Type: dosa.TUUID},
+			{Name: "at", Type: dosa.Timestamp},
+			{Name: "payload", Type: dosa.Blob},
+			{Name: "count", Type: dosa.Int32},
+		},
+	},
+}
+
+var (
+	id = dosa.UUID("3e4befa0-69d2-11e7-9ba7-aa7f2c2c5c7e")
+	at = time.Date(2017, 7, 17, 10, 30, 0, 123456789, time.FixedZone("PDT", -7*3600))
+)
+
+func tempFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "replay")
+	assert.NoError(t, err)
+	return filepath.Join(dir, "calls.gob"), func() { _ = os.RemoveAll(dir) }
+}
+
+// record runs a few calls against a memory connector and records them
+func record(t *testing.T, path string) {
+	rec, err := replay.NewRecorder(memory.NewConnector(), path)
+	assert.NoError(t, err)
+
+	values := map[string]dosa.FieldValue{"id": id, "at": at, "payload": []byte{0, 1, 2}, "count": int32(7)}
+	assert.NoError(t, rec.Upsert(ctx, testEi, values))
+	_, err = rec.Read(ctx, testEi, map[string]dosa.FieldValue{"id": id, "at": at}, nil)
+	assert.NoError(t, err)
+	_, err = rec.Read(ctx, testEi, map[string]dosa.FieldValue{"id": id, "at": at.Add(time.Second)}, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.True(t, dosa.ErrorIsAlreadyExists(rec.CreateIfNotExists(ctx, testEi, values)))
+	results, err := rec.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{
+		{"id": id, "at": at},
+		{"id": dosa.UUID("unknown"), "at": at},
+	}, []string{"count"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	_, _, err = rec.Range(ctx, testEi, map[string][]*dosa.Condition{
+		"id": {{Op: dosa.Eq, Value: id}},
+		"at": {{Op: dosa.GtOrEq, Value: at}},
+	}, nil, "", 10)
+	assert.NoError(t, err)
+	assert.NoError(t, rec.Shutdown())
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path, cleanup := tempFile(t)
+	defer cleanup()
+	record(t, path)
+
+	rep, err := replay.NewReplayer(path)
+	assert.NoError(t, err)
+	assert.Len(t, rep.Unused(), 6)
+
+	// the same instant in another location matches the recorded call
+	values, err := rep.Read(ctx, testEi, map[string]dosa.FieldValue{"id": id, "at": at.UTC()}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, id, values["id"])
+	assert.Equal(t, []byte{0, 1, 2}, values["payload"])
+	assert.Equal(t, int32(7), values["count"])
+	replayed, ok := values["at"].(time.Time)
+	assert.True(t, ok)
+	assert.True(t, at.Equal(replayed))
+	_, offset := replayed.Zone()
+	assert.Equal(t, -7*3600, offset)
+
+	_, err = rep.Read(ctx, testEi, map[string]dosa.FieldValue{"id": id, "at": at.Add(time.Second)}, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	results, err := rep.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{
+		{"id": id, "at": at},
+		{"id": dosa.UUID("unknown"), "at": at},
+	}, []string{"count"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(7), results[0].Values["count"])
+	assert.True(t, dosa.ErrorIsNotFound(results[1].Error))
+
+	rows, token, err := rep.Range(ctx, testEi, map[string][]*dosa.Condition{
+		"id": {{Op: dosa.Eq, Value: id}},
+		"at": {{Op: dosa.GtOrEq, Value: at}},
+	}, nil, "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, token)
+	assert.Len(t, rows, 1)
+
+	// a recorded call is replayed only once
+	_, err = rep.Read(ctx, testEi, map[string]dosa.FieldValue{"id": id, "at": at}, nil)
+	assert.True(t, replay.ErrorIsUnexpectedCall(err))
+	assert.Contains(t, err.Error(), "Read on scope.prefix.event")
+
+	// a call with other arguments was never recorded
+	_, err = rep.Read(ctx, testEi, map[string]dosa.FieldValue{"id": id, "at": at}, []string{"count"})
+	assert.True(t, replay.ErrorIsUnexpectedCall(err))
+
+	unused := rep.Unused()
+	assert.Len(t, unused, 2)
+	assert.Equal(t, "Upsert", unused[0].Method)
+	assert.Equal(t, "CreateIfNotExists", unused[1].Method)
+	assert.NoError(t, rep.Shutdown())
+}
+
+func TestReplayErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	path, cleanup := tempFile(t)
+	defer cleanup()
+
+	next := mocks.NewMockConnector(ctrl)
+	next.EXPECT().ScopeExists(ctx, "flaky").Return(false, &dosa.ErrRetryable{Err: context.DeadlineExceeded})
+	next.EXPECT().ScopeExists(ctx, "busy").Return(false, &dosa.ErrThrottled{Err: errors.New("slow down")})
+	next.EXPECT().ScopeExists(ctx, "bad").Return(false, &dosa.ErrInvalidRequest{Column: "id", Err: errors.New("wrong type")})
+	next.EXPECT().CheckSchema(ctx, "scope", "prefix", []*dosa.EntityDefinition{testEi.Def}).Return(int32(4), nil)
+	next.EXPECT().Shutdown().Return(nil)
+
+	rec, err := replay.NewRecorder(next, path)
+	assert.NoError(t, err)
+	_, err = rec.ScopeExists(ctx, "flaky")
+	assert.Error(t, err)
+	_, err = rec.ScopeExists(ctx, "busy")
+	assert.Error(t, err)
+	_, err = rec.ScopeExists(ctx, "bad")
+	assert.Error(t, err)
+	_, err = rec.CheckSchema(ctx, "scope", "prefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	assert.NoError(t, rec.Shutdown())
+
+	conn, err := dosa.GetConnector("replay", map[string]interface{}{"file": path})
+	assert.NoError(t, err)
+	_, err = conn.ScopeExists(ctx, "flaky")
+	assert.True(t, dosa.ErrorIsRetryable(err))
+	assert.EqualError(t, err, "retryable error: context deadline exceeded")
+	_, err = conn.ScopeExists(ctx, "busy")
+	assert.True(t, dosa.ErrorIsThrottled(err))
+	assert.EqualError(t, err, "throttled: slow down")
+	_, err = conn.ScopeExists(ctx, "bad")
+	assert.True(t, dosa.ErrorIsInvalidRequest(err))
+	assert.EqualError(t, err, `invalid request for column "id": wrong type`)
+	version, err := conn.CheckSchema(ctx, "scope", "prefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), version)
+	_, err = conn.CheckSchema(ctx, "scope", "other", []*dosa.EntityDefinition{testEi.Def})
+	assert.True(t, replay.ErrorIsUnexpectedCall(err))
+}
+
+func TestNewReplayerErrors(t *testing.T) {
+	_, err := replay.NewReplayer("/does/not/exist")
+	assert.Error(t, err)
+
+	path, cleanup := tempFile(t)
+	defer cleanup()
+	assert.NoError(t, ioutil.WriteFile(path, []byte("not gob"), 0644))
+	_, err = replay.NewReplayer(path)
+	assert.Contains(t, err.Error(), "cannot decode")
+
+	_, err = dosa.GetConnector("replay", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Default values used for unset fields of Config
+const (
+	DefaultMaxAttempts    = 3
+	DefaultInitialBackoff = 10 * time.Millisecond
+	DefaultMaxBackoff     = time.Second
+	DefaultMultiplier     = 2.0
+	DefaultJitter         = 0.2
+)
+
+// Config controls how often and how fast failed operations are retried
+type Config struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts
+	MaxBackoff time.Duration
+	// Multiplier is applied to the delay after every retry
+	Multiplier float64
+	// Jitter is the fraction of the delay that is randomized; 0.2 means the
+	// actual delay is anywhere between 80% and 120% of the computed one
+	Jitter float64
+	// IsRetryable decides whether an error is worth retrying; it defaults
+	// to the IsRetryable function of this package
+	IsRetryable func(error) bool
+}
+
+// IsRetryable returns true for errors flagged as retryable, timeouts and
+// throttling. Internal errors are not retried, as they are unlikely to go
+// away on their own.
+func IsRetryable(err error) bool {
+	return dosa.ErrorIsRetryable(err) || dosa.ErrorIsTimeout(err) || dosa.ErrorIsThrottled(err)
+}
+
+// Connector retries idempotent operations that fail with a retryable error.
+// Read, MultiRead, Upsert, Range, Scan and CheckSchema are retried; all
+// other operations, in particular CreateIfNotExists, are passed to the next
+// connector exactly once.
+//
+// Retries never outlive the context: if the context is done, or its deadline
+// would expire before the next attempt, the last error is returned.
+type Connector struct {
+	base.Connector
+	config Config
+
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+// NewConnector creates a retrying connector in front of next. Zero fields of
+// cfg are replaced with the package defaults.
+func NewConnector(next dosa.Connector, cfg Config) *Connector {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = DefaultInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = DefaultMultiplier
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		cfg.Jitter = DefaultJitter
+	}
+	if cfg.IsRetryable == nil {
+		cfg.IsRetryable = IsRetryable
+	}
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		config:    cfg,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// backoff returns the jittered delay to wait before the given retry; the
+// first retry is number 1
+func (c *Connector) backoff(retry int) time.Duration {
+	delay := float64(c.config.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		delay *= c.config.Multiplier
+		if delay >= float64(c.config.MaxBackoff) {
+			break
+		}
+	}
+	if delay > float64(c.config.MaxBackoff) {
+		delay = float64(c.config.MaxBackoff)
+	}
+	if c.config.Jitter > 0 {
+		c.lock.Lock()
+		r := c.rand.Float64()
+		c.lock.Unlock()
+		delay += delay * c.config.Jitter * (2*r - 1)
+	}
+	return time.Duration(delay)
+}
+
+// wait sleeps before the given retry. It returns false without sleeping if
+// the context deadline would expire first, or if the context is cancelled
+// while sleeping.
+func (c *Connector) wait(ctx context.Context, retry int) bool {
+	delay := c.backoff(retry)
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return false
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// do calls op until it succeeds, fails with an error that is not retryable,
+// or runs out of attempts or time
+func (c *Connector) do(ctx context.Context, op func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = op(); err == nil || !c.config.IsRetryable(err) {
+			return err
+		}
+		if attempt >= c.config.MaxAttempts || !c.wait(ctx, attempt) {
+			return err
+		}
+	}
+}
+
+// Read retries the read of a single entity
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	var values map[string]dosa.FieldValue
+	err := c.do(ctx, func() (err error) {
+		values, err = c.Connector.Read(ctx, ei, keys, fieldsToRead)
+		return err
+	})
+	return values, err
+}
+
+// MultiRead retries the whole call when it fails with a retryable error.
+// When only some of the rows fail with a retryable error, just those rows are
+// read again.
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	var results []*dosa.FieldValuesOrError
+	// pending holds the indexes (into keys) of the rows still to be read
+	var pending []int
+	for attempt := 1; ; attempt++ {
+		batch := keys
+		if pending != nil {
+			batch = make([]map[string]dosa.FieldValue, len(pending))
+			for i, idx := range pending {
+				batch[i] = keys[idx]
+			}
+		}
+		partial, err := c.Connector.MultiRead(ctx, ei, batch, fieldsToRead)
+		if err != nil {
+			if !c.config.IsRetryable(err) || attempt >= c.config.MaxAttempts || !c.wait(ctx, attempt) {
+				if results != nil {
+					// return what earlier attempts read; the rows still
+					// pending keep their previous errors
+					return results, nil
+				}
+				return nil, err
+			}
+			continue
+		}
+		if results == nil {
+			results = partial
+		} else {
+			for i, idx := range pending {
+				if i < len(partial) {
+					results[idx] = partial[i]
+				}
+			}
+		}
+
+		pending = pending[:0]
+		for i, result := range results {
+			if result != nil && result.Error != nil && c.config.IsRetryable(result.Error) {
+				pending = append(pending, i)
+			}
+		}
+		if len(pending) == 0 || attempt >= c.config.MaxAttempts || !c.wait(ctx, attempt) {
+			return results, nil
+		}
+	}
+}
+
+// Upsert retries the upsert; upserts are idempotent so repeating one is safe
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	return c.do(ctx, func() error {
+		return c.Connector.Upsert(ctx, ei, values)
+	})
+}
+
+// Range retries the read of a single page
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	var values []map[string]dosa.FieldValue
+	var next string
+	err := c.do(ctx, func() (err error) {
+		values, next, err = c.Connector.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+		return err
+	})
+	return values, next, err
+}
+
+// Scan retries the read of a single page
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	var values []map[string]dosa.FieldValue
+	var next string
+	err := c.do(ctx, func() (err error) {
+		values, next, err = c.Connector.Scan(ctx, ei, fieldsToRead, token, limit)
+		return err
+	})
+	return values, next, err
+}
+
+// CheckSchema retries the schema check
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	var version int32
+	err := c.do(ctx, func() (err error) {
+		version, err = c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+		return err
+	})
+	return version, err
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package retry_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/retry"
+	"github.com/uber-go/dosa/connectors/yarpc"
+	"github.com/uber-go/dosa/mocks"
+	drpc "github.com/uber/dosa-idl/.gen/dosa"
+	"github.com/uber/dosa-idl/.gen/dosa/dosatest"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testScope",
+		NamePrefix: "testPrefix",
+		EntityName: "testEntityName",
+	},
+	Def: &dosa.EntityDefinition{},
+}
+
+var (
+	errTransient = &dosa.ErrRetryable{Err: errors.New("overloaded")}
+	errFatal     = errors.New("bad request")
+	testKeys     = map[string]dosa.FieldValue{"id": int64(1)}
+	testValues   = map[string]dosa.FieldValue{"id": int64(1), "name": "one"}
+	fastConfig   = retry.Config{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+)
+
+func TestRetry_ReadSucceedsAfterRetries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	gomock.InOrder(
+		mc.EXPECT().Read(ctx, testEi, testKeys, nil).Return(nil, errTransient),
+		mc.EXPECT().Read(ctx, testEi, testKeys, nil).Return(nil, errors.Wrap(errTransient, "wrapped")),
+		mc.EXPECT().Read(ctx, testEi, testKeys, nil).Return(testValues, nil),
+	)
+
+	values, err := retry.NewConnector(mc, fastConfig).Read(ctx, testEi, testKeys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, testValues, values)
+}
+
+func TestRetry_DefaultIsRetryable(t *testing.T) {
+	cause := errors.New("cause")
+	assert.True(t, retry.IsRetryable(errTransient))
+	assert.True(t, retry.IsRetryable(errors.Wrap(&dosa.ErrTimeout{Err: cause}, "wrapped")))
+	assert.True(t, retry.IsRetryable(&dosa.ErrThrottled{Err: cause}))
+	assert.False(t, retry.IsRetryable(&dosa.ErrInternal{Err: cause}))
+	assert.False(t, retry.IsRetryable(&dosa.ErrNotFound{}))
+	assert.False(t, retry.IsRetryable(errFatal))
+}
+
+func TestRetry_OverYaRPC(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	timeout, throttled, notFound := yarpc.ErrCodeTimeout, yarpc.ErrCodeThrottled, yarpc.ErrCodeNotFound
+	client := dosatest.NewMockClient(ctrl)
+	gomock.InOrder(
+		client.EXPECT().Read(ctx, gomock.Any()).Return(nil, &drpc.BadRequestError{ErrorCode: &timeout}),
+		client.EXPECT().Read(ctx, gomock.Any()).Return(nil, &drpc.BadRequestError{ErrorCode: &throttled}),
+		client.EXPECT().Read(ctx, gomock.Any()).Return(&drpc.ReadResponse{EntityValues: drpc.FieldValueMap{}}, nil),
+		client.EXPECT().Read(ctx, gomock.Any()).Return(nil, &drpc.BadRequestError{ErrorCode: &notFound}).Times(1),
+	)
+
+	sut := retry.NewConnector(&yarpc.Connector{Client: client}, fastConfig)
+	_, err := sut.Read(ctx, testEi, testKeys, nil)
+	assert.NoError(t, err)
+	_, err = sut.Read(ctx, testEi, testKeys, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+}
+
+func TestRetry_NotRetryable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().Upsert(ctx, testEi, testValues).Return(errFatal).Times(1)
+
+	err := retry.NewConnector(mc, fastConfig).Upsert(ctx, testEi, testValues)
+	assert.Equal(t, errFatal, err)
+}
+
+func TestRetry_MaxAttempts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().Range(ctx, testEi, nil, nil, "token", 10).Return(nil, "", errTransient).Times(3)
+	mc.EXPECT().Scan(ctx, testEi, nil, "", 10).Return(nil, "", errTransient).Times(3)
+	mc.EXPECT().CheckSchema(ctx, "scope", "prefix", nil).Return(int32(0), errTransient).Times(3)
+
+	sut := retry.NewConnector(mc, fastConfig)
+	_, _, err := sut.Range(ctx, testEi, nil, nil, "token", 10)
+	assert.True(t, dosa.ErrorIsRetryable(err))
+	_, _, err = sut.Scan(ctx, testEi, nil, "", 10)
+	assert.True(t, dosa.ErrorIsRetryable(err))
+	_, err = sut.CheckSchema(ctx, "scope", "prefix", nil)
+	assert.True(t, dosa.ErrorIsRetryable(err))
+}
+
+func TestRetry_CustomIsRetryable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	gomock.InOrder(
+		mc.EXPECT().Upsert(ctx, testEi, testValues).Return(errFatal),
+		mc.EXPECT().Upsert(ctx, testEi, testValues).Return(nil),
+	)
+
+	cfg := fastConfig
+	cfg.IsRetryable = func(err error) bool { return err == errFatal }
+	assert.NoError(t, retry.NewConnector(mc, cfg).Upsert(ctx, testEi, testValues))
+}
+
+func TestRetry_RespectsDeadline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	dctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	mc.EXPECT().Read(dctx, testEi, testKeys, nil).Return(nil, errTransient).Times(1)
+
+	// the first backoff is longer than the deadline, so there is no retry
+	sut := retry.NewConnector(mc, retry.Config{MaxAttempts: 5, InitialBackoff: time.Second})
+	start := time.Now()
+	_, err := sut.Read(dctx, testEi, testKeys, nil)
+	assert.True(t, dosa.ErrorIsRetryable(err))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestRetry_CancelledWhileWaiting(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	cctx, cancel := context.WithCancel(ctx)
+	mc.EXPECT().Read(cctx, testEi, testKeys, nil).Do(func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue, []string) {
+		cancel()
+	}).Return(nil, errTransient).Times(1)
+
+	sut := retry.NewConnector(mc, retry.Config{MaxAttempts: 5, InitialBackoff: time.Minute})
+	_, err := sut.Read(cctx, testEi, testKeys, nil)
+	assert.True(t, dosa.ErrorIsRetryable(err))
+}
+
+func TestRetry_MultiReadRetriesFailedRows(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	keys := []map[string]dosa.FieldValue{{"id": int64(1)}, {"id": int64(2)}, {"id": int64(3)}}
+	notFound := &dosa.ErrNotFound{}
+	gomock.InOrder(
+		mc.EXPECT().MultiRead(ctx, testEi, keys, nil).Return(nil, errTransient),
+		mc.EXPECT().MultiRead(ctx, testEi, keys, nil).Return([]*dosa.FieldValuesOrError{
+			{Error: errTransient},
+			{Values: map[string]dosa.FieldValue{"id": int64(2)}},
+			{Error: notFound},
+		}, nil),
+		mc.EXPECT().MultiRead(ctx, testEi, keys[:1], nil).Return([]*dosa.FieldValuesOrError{
+			{Values: map[string]dosa.FieldValue{"id": int64(1)}},
+		}, nil),
+	)
+
+	results, err := retry.NewConnector(mc, fastConfig).MultiRead(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, map[string]dosa.FieldValue{"id": int64(1)}, results[0].Values)
+	assert.Equal(t, map[string]dosa.FieldValue{"id": int64(2)}, results[1].Values)
+	assert.Equal(t, notFound, results[2].Error)
+}
+
+func TestRetry_MultiReadGivesUp(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	keys := []map[string]dosa.FieldValue{{"id": int64(1)}, {"id": int64(2)}}
+	gomock.InOrder(
+		mc.EXPECT().MultiRead(ctx, testEi, keys, nil).Return([]*dosa.FieldValuesOrError{
+			{Error: errTransient},
+			{Values: map[string]dosa.FieldValue{"id": int64(2)}},
+		}, nil),
+		mc.EXPECT().MultiRead(ctx, testEi, keys[:1], nil).Return(nil, errFatal),
+	)
+
+	results, err := retry.NewConnector(mc, fastConfig).MultiRead(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.True(t, dosa.ErrorIsRetryable(results[0].Error))
+	assert.Equal(t, map[string]dosa.FieldValue{"id": int64(2)}, results[1].Values)
+}
+
+func TestRetry_NeverRetriesCreateIfNotExists(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().CreateIfNotExists(ctx, testEi, testValues).Return(errTransient).Times(1)
+	mc.EXPECT().MultiUpsert(ctx, testEi, nil).Return(nil, errTransient).Times(1)
+	mc.EXPECT().Remove(ctx, testEi, testKeys).Return(errTransient).Times(1)
+
+	sut := retry.NewConnector(mc, fastConfig)
+	assert.Equal(t, errTransient, sut.CreateIfNotExists(ctx, testEi, testValues))
+	_, err := sut.MultiUpsert(ctx, testEi, nil)
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, errTransient, sut.Remove(ctx, testEi, testKeys))
+}
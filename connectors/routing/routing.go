@@ -0,0 +1,488 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package routing
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// wildcard matches any value in a rule; an empty field does too
+const wildcard = "*"
+
+// ConnectorConfig describes a connector created through dosa.GetConnector
+type ConnectorConfig struct {
+	// Type is the name the connector was registered with, e.g. "yarpc"
+	Type    string
+	Options map[string]interface{}
+}
+
+// Rule sends the operations on matching entities to a connector. Empty
+// fields and "*" match anything.
+type Rule struct {
+	Scope      string
+	NamePrefix string
+	Entity     string
+	// Connector is a key of Config.Connectors
+	Connector string
+}
+
+// Config lists the connectors and the rules choosing between them
+type Config struct {
+	Connectors map[string]ConnectorConfig
+	// Rules are tried in order and the first match wins, so more specific
+	// rules should come first. A last rule with only wildcards provides a
+	// default.
+	Rules []Rule
+}
+
+// ignored is passed to route for names that are not known, such as the
+// entity of a scope operation. It matches every rule; it is not a valid name
+// so it cannot clash with a real one.
+const ignored = "\x00"
+
+func isWildcard(pattern string) bool {
+	return pattern == "" || pattern == wildcard
+}
+
+func matches(pattern, value string) bool {
+	return value == ignored || isWildcard(pattern) || pattern == value
+}
+
+// Connector forwards every operation to one of several connectors,
+// depending on the scope, name prefix and entity name it applies to. Scope
+// operations go to every connector a rule for the scope may route to. Schema
+// operations split the entities they list by connector and run once per
+// connector; without entities, and for CheckSchemaStatus, they go to every
+// connector a rule for the scope and name prefix may route to. The
+// connectors must agree on the schema version, as entity operations carry a
+// single one.
+type Connector struct {
+	rules      []Rule
+	connectors map[string]dosa.Connector
+}
+
+// NewConnector creates the connectors of the configuration and a routing
+// connector using them
+func NewConnector(cfg *Config) (*Connector, error) {
+	c := &Connector{rules: cfg.Rules, connectors: map[string]dosa.Connector{}}
+	for _, rule := range cfg.Rules {
+		if _, ok := cfg.Connectors[rule.Connector]; !ok {
+			return nil, errors.Errorf("rule for scope %q, prefix %q and entity %q uses unknown connector %q", rule.Scope, rule.NamePrefix, rule.Entity, rule.Connector)
+		}
+	}
+	// create the connectors in a stable order so failures are predictable
+	names := make([]string, 0, len(cfg.Connectors))
+	for name := range cfg.Connectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cc := cfg.Connectors[name]
+		conn, err := dosa.GetConnector(cc.Type, cc.Options)
+		if err != nil {
+			_ = c.Shutdown()
+			return nil, errors.Wrapf(err, "failed to create connector %q", name)
+		}
+		c.connectors[name] = conn
+	}
+	return c, nil
+}
+
+// noRoute returns the error for names no rule matches
+func noRoute(scope, namePrefix, entity string) error {
+	if namePrefix == ignored {
+		return errors.Errorf("no connector for scope %q", scope)
+	}
+	if entity == ignored {
+		return errors.Errorf("no connector for scope %q and prefix %q", scope, namePrefix)
+	}
+	return errors.Errorf("no connector for scope %q, prefix %q and entity %q", scope, namePrefix, entity)
+}
+
+// route returns the name of the connector of the first rule matching the
+// given names
+func (c *Connector) route(scope, namePrefix, entity string) (string, error) {
+	for _, rule := range c.rules {
+		if matches(rule.Scope, scope) && matches(rule.NamePrefix, namePrefix) && matches(rule.Entity, entity) {
+			return rule.Connector, nil
+		}
+	}
+	return "", noRoute(scope, namePrefix, entity)
+}
+
+// routeAll returns the names of the distinct connectors that the rules
+// matching the scope, and the name prefix unless it is ignored, route to, in
+// rule order. A rule matching every entity of the scope or prefix hides the
+// rules after it.
+func (c *Connector) routeAll(scope, namePrefix string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, rule := range c.rules {
+		if !matches(rule.Scope, scope) || !matches(rule.NamePrefix, namePrefix) {
+			continue
+		}
+		if !seen[rule.Connector] {
+			seen[rule.Connector] = true
+			names = append(names, rule.Connector)
+		}
+		if isWildcard(rule.Entity) && (namePrefix != ignored || isWildcard(rule.NamePrefix)) {
+			break
+		}
+	}
+	if len(names) == 0 {
+		return nil, noRoute(scope, namePrefix, ignored)
+	}
+	return names, nil
+}
+
+// multiError lists the errors of several connectors. Its cause is the first
+// one.
+type multiError []error
+
+func (e multiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e multiError) Cause() error {
+	return e[0]
+}
+
+// broadcast calls op on the named connectors and combines the errors
+func (c *Connector) broadcast(names []string, op func(i int, conn dosa.Connector) error) error {
+	var errs multiError
+	for i, name := range names {
+		if err := op(i, c.connectors[name]); err != nil {
+			errs = append(errs, errors.Wrapf(err, "connector %q", name))
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// broadcastScope calls op on every connector of the scope and combines the
+// errors
+func (c *Connector) broadcastScope(scope string, op func(conn dosa.Connector) error) error {
+	names, err := c.routeAll(scope, ignored)
+	if err != nil {
+		return err
+	}
+	return c.broadcast(names, func(_ int, conn dosa.Connector) error {
+		return op(conn)
+	})
+}
+
+// routeEntity routes an entity operation
+func (c *Connector) routeEntity(ei *dosa.EntityInfo) (dosa.Connector, error) {
+	if ei == nil || ei.Ref == nil {
+		return nil, errors.New("no schema reference to route on")
+	}
+	name, err := c.route(ei.Ref.Scope, ei.Ref.NamePrefix, ei.Ref.EntityName)
+	if err != nil {
+		return nil, err
+	}
+	return c.connectors[name], nil
+}
+
+// routeSchema splits the entities of a schema operation by connector. It
+// returns the connector names in order of first use and the entities of
+// each; without entities, every connector of the name prefix gets none.
+func (c *Connector) routeSchema(scope, namePrefix string, ed []*dosa.EntityDefinition) ([]string, [][]*dosa.EntityDefinition, error) {
+	if len(ed) == 0 {
+		names, err := c.routeAll(scope, namePrefix)
+		return names, make([][]*dosa.EntityDefinition, len(names)), err
+	}
+	var names []string
+	var groups [][]*dosa.EntityDefinition
+	index := map[string]int{}
+	for _, def := range ed {
+		name, err := c.route(scope, namePrefix, def.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(names)
+			index[name] = i
+			names = append(names, name)
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], def)
+	}
+	return names, groups, nil
+}
+
+// sameVersion checks that the connectors agree on the schema version
+func sameVersion(names []string, versions []int32) error {
+	for i, v := range versions {
+		if v != versions[0] {
+			return errors.Errorf("schema version %d of connector %q differs from %d of connector %q", v, names[i], versions[0], names[0])
+		}
+	}
+	return nil
+}
+
+// statuses runs a schema operation on the named connectors. They must agree
+// on the version, and the status of the first one is returned.
+func (c *Connector) statuses(names []string, op func(i int, conn dosa.Connector) (*dosa.SchemaStatus, error)) (*dosa.SchemaStatus, error) {
+	statuses := make([]*dosa.SchemaStatus, len(names))
+	err := c.broadcast(names, func(i int, conn dosa.Connector) (err error) {
+		statuses[i], err = op(i, conn)
+		if err == nil && statuses[i] == nil {
+			err = errors.New("no schema status")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int32, len(statuses))
+	for i, status := range statuses {
+		versions[i] = status.Version
+	}
+	if err := sameVersion(names, versions); err != nil {
+		return nil, err
+	}
+	return statuses[0], nil
+}
+
+// CreateIfNotExists routes the call
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	conn, err := c.routeEntity(ei)
+	if err != nil {
+		return err
+	}
+	return conn.CreateIfNotExists(ctx, ei, values)
+}
+
+// Read routes the call
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	conn, err := c.routeEntity(ei)
+	if err != nil {
+		return nil, err
+	}
+	return conn.Read(ctx, ei, keys, fieldsToRead)
+}
+
+// MultiRead routes the call
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	conn, err := c.routeEntity(ei)
+	if err != nil {
+		return nil, err
+	}
+	return conn.MultiRead(ctx, ei, keys, fieldsToRead)
+}
+
+// Upsert routes the call
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	conn, err := c.routeEntity(ei)
+	if err != nil {
+		return err
+	}
+	return conn.Upsert(ctx, ei, values)
+}
+
+// MultiUpsert routes the call
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	conn, err := c.routeEntity(ei)
+	if err != nil {
+		return nil, err
+	}
+	return conn.MultiUpsert(ctx, ei, values)
+}
+
+// Remove routes the call
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	conn, err := c.routeEntity(ei)
+	if err != nil {
+		return err
+	}
+	return conn.Remove(ctx, ei, keys)
+}
+
+// MultiRemove routes the call
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	conn, err := c.routeEntity(ei)
+	if err != nil {
+		return nil, err
+	}
+	return conn.MultiRemove(ctx, ei, multiKeys)
+}
+
+// Range routes the call
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	conn, err := c.routeEntity(ei)
+	if err != nil {
+		return nil, "", err
+	}
+	return conn.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+}
+
+// Search routes the call
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPairs dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	conn, err := c.routeEntity(ei)
+	if err != nil {
+		return nil, "", err
+	}
+	return conn.Search(ctx, ei, fieldPairs, fieldsToRead, token, limit)
+}
+
+// Scan routes the call
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	conn, err := c.routeEntity(ei)
+	if err != nil {
+		return nil, "", err
+	}
+	return conn.Scan(ctx, ei, fieldsToRead, token, limit)
+}
+
+// CheckSchema checks the entities on the connectors they are routed to.
+// The connectors must agree on the version.
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	names, groups, err := c.routeSchema(scope, namePrefix, ed)
+	if err != nil {
+		return dosa.InvalidVersion, err
+	}
+	versions := make([]int32, len(names))
+	err = c.broadcast(names, func(i int, conn dosa.Connector) (err error) {
+		versions[i], err = conn.CheckSchema(ctx, scope, namePrefix, groups[i])
+		return err
+	})
+	if err == nil {
+		err = sameVersion(names, versions)
+	}
+	if err != nil {
+		return dosa.InvalidVersion, err
+	}
+	return versions[0], nil
+}
+
+// UpsertSchema upserts the entities on the connectors they are routed to and
+// returns the status of the first one. The connectors must agree on the
+// version.
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	names, groups, err := c.routeSchema(scope, namePrefix, ed)
+	if err != nil {
+		return nil, err
+	}
+	return c.statuses(names, func(i int, conn dosa.Connector) (*dosa.SchemaStatus, error) {
+		return conn.UpsertSchema(ctx, scope, namePrefix, groups[i])
+	})
+}
+
+// CheckSchemaStatus checks the status on every connector the entities of
+// the name prefix may be routed to, and returns the status of the first one.
+// The connectors must agree on the version.
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	names, err := c.routeAll(scope, namePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return c.statuses(names, func(_ int, conn dosa.Connector) (*dosa.SchemaStatus, error) {
+		return conn.CheckSchemaStatus(ctx, scope, namePrefix, version)
+	})
+}
+
+// CreateScope creates the scope on every connector it is routed to
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	return c.broadcastScope(scope, func(conn dosa.Connector) error {
+		return conn.CreateScope(ctx, scope)
+	})
+}
+
+// TruncateScope truncates the scope on every connector it is routed to
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	return c.broadcastScope(scope, func(conn dosa.Connector) error {
+		return conn.TruncateScope(ctx, scope)
+	})
+}
+
+// DropScope drops the scope on every connector it is routed to
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	return c.broadcastScope(scope, func(conn dosa.Connector) error {
+		return conn.DropScope(ctx, scope)
+	})
+}
+
+// ScopeExists checks the scope on every connector it is routed to; it only
+// exists when it exists on all of them
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	exists := true
+	err := c.broadcastScope(scope, func(conn dosa.Connector) error {
+		ok, err := conn.ScopeExists(ctx, scope)
+		exists = exists && ok
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// ListScopes lists the scopes of all the connectors, in order
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	names := make([]string, 0, len(c.connectors))
+	for name := range c.connectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := map[string]bool{}
+	scopes := []string{}
+	for _, name := range names {
+		listed, err := c.connectors[name].ListScopes(ctx, owner)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot list scopes of connector %q", name)
+		}
+		for _, scope := range listed {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+// Shutdown shuts down all the connectors and returns the first error
+func (c *Connector) Shutdown() error {
+	var first error
+	for _, conn := range c.connectors {
+		if err := conn.Shutdown(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
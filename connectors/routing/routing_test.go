@@ -0,0 +1,298 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package routing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/routing"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+// testConnectors are handed out by the "routingtest" connector type
+var testConnectors = map[string]dosa.Connector{}
+
+func init() {
+	dosa.RegisterConnector("routingtest", func(opts map[string]interface{}) (dosa.Connector, error) {
+		name, _ := opts["name"].(string)
+		conn, ok := testConnectors[name]
+		if !ok {
+			return nil, errors.Errorf("no test connector %q", name)
+		}
+		return conn, nil
+	})
+}
+
+func entityInfo(scope, namePrefix, entity string) *dosa.EntityInfo {
+	return &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: scope, NamePrefix: namePrefix, EntityName: entity},
+		Def: &dosa.EntityDefinition{Name: entity},
+	}
+}
+
+func testConfig(names ...string) *routing.Config {
+	cfg := &routing.Config{
+		Connectors: map[string]routing.ConnectorConfig{},
+		Rules: []routing.Rule{
+			{Scope: "prod", NamePrefix: "billing", Entity: "invoice", Connector: "invoices"},
+			{Scope: "prod", NamePrefix: "billing", Connector: "billing"},
+			{Scope: "prod", Connector: "prod"},
+			{Scope: "*", NamePrefix: "*", Entity: "*", Connector: "default"},
+		},
+	}
+	for _, name := range names {
+		cfg.Connectors[name] = routing.ConnectorConfig{Type: "routingtest", Options: map[string]interface{}{"name": name}}
+	}
+	return cfg
+}
+
+func newMocks(ctrl *gomock.Controller) map[string]*mocks.MockConnector {
+	ms := map[string]*mocks.MockConnector{}
+	for _, name := range []string{"invoices", "billing", "prod", "default"} {
+		ms[name] = mocks.NewMockConnector(ctrl)
+		testConnectors[name] = ms[name]
+	}
+	return ms
+}
+
+func TestRouting_EntityOps(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ms := newMocks(ctrl)
+	sut, err := routing.NewConnector(testConfig("invoices", "billing", "prod", "default"))
+	assert.NoError(t, err)
+
+	invoice := entityInfo("prod", "billing", "invoice")
+	payment := entityInfo("prod", "billing", "payment")
+	user := entityInfo("prod", "users", "user")
+	other := entityInfo("staging", "billing", "invoice")
+	values := map[string]dosa.FieldValue{"id": int64(1)}
+
+	ms["invoices"].EXPECT().Read(ctx, invoice, values, nil).Return(values, nil)
+	ms["billing"].EXPECT().Upsert(ctx, payment, values).Return(nil)
+	ms["prod"].EXPECT().Remove(ctx, user, values).Return(nil)
+	ms["default"].EXPECT().CreateIfNotExists(ctx, other, values).Return(nil)
+	ms["invoices"].EXPECT().MultiRead(ctx, invoice, nil, nil).Return(nil, nil)
+	ms["billing"].EXPECT().MultiUpsert(ctx, payment, nil).Return(nil, nil)
+	ms["prod"].EXPECT().MultiRemove(ctx, user, nil).Return(nil, nil)
+	ms["default"].EXPECT().Range(ctx, other, nil, nil, "", 1).Return(nil, "", nil)
+	ms["default"].EXPECT().Search(ctx, other, dosa.FieldNameValuePair{}, nil, "", 1).Return(nil, "", nil)
+	ms["invoices"].EXPECT().Scan(ctx, invoice, nil, "", 1).Return(nil, "", nil)
+
+	_, err = sut.Read(ctx, invoice, values, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, sut.Upsert(ctx, payment, values))
+	assert.NoError(t, sut.Remove(ctx, user, values))
+	assert.NoError(t, sut.CreateIfNotExists(ctx, other, values))
+	_, err = sut.MultiRead(ctx, invoice, nil, nil)
+	assert.NoError(t, err)
+	_, err = sut.MultiUpsert(ctx, payment, nil)
+	assert.NoError(t, err)
+	_, err = sut.MultiRemove(ctx, user, nil)
+	assert.NoError(t, err)
+	_, _, err = sut.Range(ctx, other, nil, nil, "", 1)
+	assert.NoError(t, err)
+	_, _, err = sut.Search(ctx, other, dosa.FieldNameValuePair{}, nil, "", 1)
+	assert.NoError(t, err)
+	_, _, err = sut.Scan(ctx, invoice, nil, "", 1)
+	assert.NoError(t, err)
+}
+
+func TestRouting_ScopeAndSchemaOps(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ms := newMocks(ctrl)
+	sut, err := routing.NewConnector(testConfig("invoices", "billing", "prod", "default"))
+	assert.NoError(t, err)
+
+	// scope operations go to every connector of the scope, up to the first
+	// rule matching the whole scope
+	for _, name := range []string{"invoices", "billing", "prod"} {
+		ms[name].EXPECT().CreateScope(ctx, "prod").Return(nil)
+		ms[name].EXPECT().TruncateScope(ctx, "prod").Return(nil)
+		ms[name].EXPECT().ScopeExists(ctx, "prod").Return(true, nil)
+	}
+	ms["default"].EXPECT().DropScope(ctx, "staging").Return(nil)
+	ms["default"].EXPECT().ScopeExists(ctx, "staging").Return(true, nil)
+	assert.NoError(t, sut.CreateScope(ctx, "prod"))
+	assert.NoError(t, sut.TruncateScope(ctx, "prod"))
+	assert.NoError(t, sut.DropScope(ctx, "staging"))
+	exists, err := sut.ScopeExists(ctx, "prod")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = sut.ScopeExists(ctx, "staging")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// a scope missing on one connector does not exist, and the errors of
+	// all connectors are returned
+	ms["invoices"].EXPECT().ScopeExists(ctx, "prod").Return(true, nil)
+	ms["billing"].EXPECT().ScopeExists(ctx, "prod").Return(false, nil)
+	ms["prod"].EXPECT().ScopeExists(ctx, "prod").Return(true, nil)
+	exists, err = sut.ScopeExists(ctx, "prod")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	ms["invoices"].EXPECT().DropScope(ctx, "prod").Return(&dosa.ErrNotFound{})
+	ms["billing"].EXPECT().DropScope(ctx, "prod").Return(nil)
+	ms["prod"].EXPECT().DropScope(ctx, "prod").Return(errors.New("boom"))
+	err = sut.DropScope(ctx, "prod")
+	assert.EqualError(t, err, `connector "invoices": not found; connector "prod": boom`)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	ms["billing"].EXPECT().TruncateScope(ctx, "prod").Return(errors.New("boom"))
+	ms["invoices"].EXPECT().TruncateScope(ctx, "prod").Return(nil)
+	ms["prod"].EXPECT().TruncateScope(ctx, "prod").Return(nil)
+	assert.EqualError(t, sut.TruncateScope(ctx, "prod"), `connector "billing": boom`)
+
+	// scopes are listed from all connectors
+	ms["invoices"].EXPECT().ListScopes(ctx, "me").Return([]string{"prod"}, nil)
+	ms["billing"].EXPECT().ListScopes(ctx, "me").Return([]string{"prod"}, nil)
+	ms["prod"].EXPECT().ListScopes(ctx, "me").Return(nil, nil)
+	ms["default"].EXPECT().ListScopes(ctx, "me").Return([]string{"staging", "dev"}, nil)
+	scopes, err := sut.ListScopes(ctx, "me")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dev", "prod", "staging"}, scopes)
+
+	// schema operations by entity
+	users := []*dosa.EntityDefinition{{Name: "user"}, {Name: "session"}}
+	ms["prod"].EXPECT().CheckSchema(ctx, "prod", "users", users).Return(int32(3), nil)
+	ms["prod"].EXPECT().UpsertSchema(ctx, "prod", "users", users).Return(&dosa.SchemaStatus{Version: 4}, nil)
+	ms["prod"].EXPECT().CheckSchemaStatus(ctx, "prod", "users", int32(4)).Return(&dosa.SchemaStatus{Version: 4}, nil)
+	version, err := sut.CheckSchema(ctx, "prod", "users", users)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), version)
+	_, err = sut.UpsertSchema(ctx, "prod", "users", users)
+	assert.NoError(t, err)
+	_, err = sut.CheckSchemaStatus(ctx, "prod", "users", 4)
+	assert.NoError(t, err)
+
+	// the entities of a prefix are split by connector
+	invoice, payment, refund := &dosa.EntityDefinition{Name: "invoice"}, &dosa.EntityDefinition{Name: "payment"}, &dosa.EntityDefinition{Name: "refund"}
+	billing := []*dosa.EntityDefinition{payment, invoice, refund}
+	ms["billing"].EXPECT().CheckSchema(ctx, "prod", "billing", []*dosa.EntityDefinition{payment, refund}).Return(int32(2), nil)
+	ms["invoices"].EXPECT().CheckSchema(ctx, "prod", "billing", []*dosa.EntityDefinition{invoice}).Return(int32(2), nil)
+	version, err = sut.CheckSchema(ctx, "prod", "billing", billing)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), version)
+	ms["billing"].EXPECT().UpsertSchema(ctx, "prod", "billing", []*dosa.EntityDefinition{payment, refund}).Return(&dosa.SchemaStatus{Version: 3, Status: "PENDING"}, nil)
+	ms["invoices"].EXPECT().UpsertSchema(ctx, "prod", "billing", []*dosa.EntityDefinition{invoice}).Return(&dosa.SchemaStatus{Version: 3}, nil)
+	status, err := sut.UpsertSchema(ctx, "prod", "billing", billing)
+	assert.NoError(t, err)
+	assert.Equal(t, &dosa.SchemaStatus{Version: 3, Status: "PENDING"}, status)
+
+	// the status, and schema checks without entities, come from every
+	// connector of the prefix
+	ms["invoices"].EXPECT().CheckSchemaStatus(ctx, "prod", "billing", int32(3)).Return(&dosa.SchemaStatus{Version: 3}, nil)
+	ms["billing"].EXPECT().CheckSchemaStatus(ctx, "prod", "billing", int32(3)).Return(&dosa.SchemaStatus{Version: 3}, nil)
+	_, err = sut.CheckSchemaStatus(ctx, "prod", "billing", 3)
+	assert.NoError(t, err)
+	ms["invoices"].EXPECT().CheckSchema(ctx, "prod", "billing", nil).Return(int32(3), nil)
+	ms["billing"].EXPECT().CheckSchema(ctx, "prod", "billing", nil).Return(int32(3), nil)
+	version, err = sut.CheckSchema(ctx, "prod", "billing", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), version)
+
+	// the connectors must agree on the version
+	ms["billing"].EXPECT().CheckSchema(ctx, "prod", "billing", []*dosa.EntityDefinition{payment, refund}).Return(int32(2), nil)
+	ms["invoices"].EXPECT().CheckSchema(ctx, "prod", "billing", []*dosa.EntityDefinition{invoice}).Return(int32(5), nil)
+	version, err = sut.CheckSchema(ctx, "prod", "billing", billing)
+	assert.EqualError(t, err, `schema version 5 of connector "invoices" differs from 2 of connector "billing"`)
+	assert.Equal(t, int32(dosa.InvalidVersion), version)
+	ms["invoices"].EXPECT().CheckSchemaStatus(ctx, "prod", "billing", int32(3)).Return(&dosa.SchemaStatus{Version: 3}, nil)
+	ms["billing"].EXPECT().CheckSchemaStatus(ctx, "prod", "billing", int32(3)).Return(nil, errors.New("boom"))
+	_, err = sut.CheckSchemaStatus(ctx, "prod", "billing", 3)
+	assert.EqualError(t, err, `connector "billing": boom`)
+}
+
+func TestRouting_NoRoute(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	newMocks(ctrl)
+	cfg := testConfig("invoices", "billing", "prod")
+	cfg.Rules = cfg.Rules[:3]
+	sut, err := routing.NewConnector(cfg)
+	assert.NoError(t, err)
+
+	ei := entityInfo("staging", "billing", "invoice")
+	assert.EqualError(t, sut.Upsert(ctx, ei, nil), `no connector for scope "staging", prefix "billing" and entity "invoice"`)
+	assert.EqualError(t, sut.CreateScope(ctx, "staging"), `no connector for scope "staging"`)
+	_, err = sut.CheckSchemaStatus(ctx, "staging", "billing", 1)
+	assert.EqualError(t, err, `no connector for scope "staging" and prefix "billing"`)
+	_, err = sut.CheckSchema(ctx, "staging", "billing", []*dosa.EntityDefinition{{Name: "invoice"}})
+	assert.Error(t, err)
+	_, err = sut.Read(ctx, &dosa.EntityInfo{}, nil, nil)
+	assert.EqualError(t, err, "no schema reference to route on")
+
+	_, err = sut.MultiRead(ctx, ei, nil, nil)
+	assert.Error(t, err)
+	_, err = sut.MultiUpsert(ctx, ei, nil)
+	assert.Error(t, err)
+	_, err = sut.MultiRemove(ctx, ei, nil)
+	assert.Error(t, err)
+	assert.Error(t, sut.Remove(ctx, ei, nil))
+	assert.Error(t, sut.CreateIfNotExists(ctx, ei, nil))
+	_, _, err = sut.Range(ctx, ei, nil, nil, "", 1)
+	assert.Error(t, err)
+	_, _, err = sut.Search(ctx, ei, dosa.FieldNameValuePair{}, nil, "", 1)
+	assert.Error(t, err)
+	_, _, err = sut.Scan(ctx, ei, nil, "", 1)
+	assert.Error(t, err)
+	_, err = sut.UpsertSchema(ctx, "staging", "billing", nil)
+	assert.Error(t, err)
+	assert.Error(t, sut.TruncateScope(ctx, "staging"))
+	assert.Error(t, sut.DropScope(ctx, "staging"))
+	_, err = sut.ScopeExists(ctx, "staging")
+	assert.Error(t, err)
+}
+
+func TestRouting_NewConnector(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ms := newMocks(ctrl)
+
+	// unknown connector in a rule
+	_, err := routing.NewConnector(testConfig("invoices", "billing", "prod"))
+	assert.EqualError(t, err, `rule for scope "*", prefix "*" and entity "*" uses unknown connector "default"`)
+
+	// connectors created before a failure are shut down
+	cfg := testConfig("invoices", "billing", "prod", "default")
+	cfg.Connectors["zzz"] = routing.ConnectorConfig{Type: "nosuchtype"}
+	for _, name := range []string{"invoices", "billing", "prod", "default"} {
+		ms[name].EXPECT().Shutdown().Return(nil)
+	}
+	_, err = routing.NewConnector(cfg)
+	assert.Contains(t, err.Error(), `failed to create connector "zzz"`)
+
+	delete(cfg.Connectors, "zzz")
+	sut, err := routing.NewConnector(cfg)
+	assert.NoError(t, err)
+	ms["prod"].EXPECT().Shutdown().Return(errors.New("boom"))
+	for _, name := range []string{"invoices", "billing", "default"} {
+		ms[name].EXPECT().Shutdown().Return(nil)
+	}
+	assert.EqualError(t, sut.Shutdown(), "boom")
+}
@@ -0,0 +1,533 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Mismatch describes a difference between the primary and the secondary
+type Mismatch struct {
+	Method string
+	Ref    *dosa.SchemaRef
+	// Keys identifies the row: the primary key values for Read and
+	// MultiRead, the position in the page ("index") for Range
+	Keys map[string]dosa.FieldValue
+	// Column is the column that differs. It is empty when the row was found
+	// on one side only, in which case Primary and Secondary hold the errors.
+	Column    string
+	Primary   interface{}
+	Secondary interface{}
+}
+
+// Reporter receives the differences and the errors of the secondary. It is
+// called from the goroutine that mirrors calls to the secondary, and from
+// callers whose call is dropped, so it must be safe for concurrent use.
+type Reporter interface {
+	// ReportMismatch is called for every column that differs
+	ReportMismatch(m *Mismatch)
+	// ReportError is called when the secondary fails where the primary
+	// succeeded, and with ErrQueueFull when a call could not be mirrored
+	ReportError(method string, ref *dosa.SchemaRef, err error)
+}
+
+// ErrQueueFull is reported when a call is not mirrored because too many
+// calls are already waiting for the secondary
+type ErrQueueFull struct{}
+
+// Error satisfies the error interface
+func (*ErrQueueFull) Error() string {
+	return "shadow queue full"
+}
+
+// Default values used for unset fields of Config
+const (
+	DefaultQueueSize = 1024
+	DefaultTimeout   = time.Second
+)
+
+// Config controls what is sent to the secondary
+type Config struct {
+	// Reporter receives mismatches and secondary errors; they are dropped
+	// when it is nil
+	Reporter Reporter
+	// MirrorReads also sends Read, MultiRead and Range to the secondary and
+	// compares the results
+	MirrorReads bool
+	// QueueSize is the number of calls that may wait for the secondary;
+	// calls beyond it are dropped
+	QueueSize int
+	// Timeout bounds every call to the secondary
+	Timeout time.Duration
+}
+
+type nopReporter struct{}
+
+func (nopReporter) ReportMismatch(*Mismatch)                   {}
+func (nopReporter) ReportError(string, *dosa.SchemaRef, error) {}
+
+// detached keeps the values of a context but not its deadline or
+// cancellation, so mirrored calls outlive the call that started them
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// Connector sends all writes to a primary and a secondary connector, for
+// example while moving entities to a new gateway. Only the results of the
+// primary are returned; calls to the secondary are queued once the primary
+// succeeded and run in order in the background, so a slow secondary never
+// delays the caller. When the queue is full the call is dropped and
+// reported. Schema and scope changes are mirrored too.
+//
+// With MirrorReads, reads are also sent to the secondary and their results
+// are compared column by column according to the entity definition.
+type Connector struct {
+	base.Connector
+	secondary   dosa.Connector
+	reporter    Reporter
+	mirrorReads bool
+	timeout     time.Duration
+
+	lock    sync.RWMutex
+	closed  bool
+	queue   chan func()
+	stopped chan struct{}
+}
+
+// NewConnector creates a connector writing to both primary and secondary
+func NewConnector(primary, secondary dosa.Connector, cfg Config) *Connector {
+	reporter := cfg.Reporter
+	if reporter == nil {
+		reporter = nopReporter{}
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	c := &Connector{
+		Connector:   base.Connector{Next: primary},
+		secondary:   secondary,
+		reporter:    reporter,
+		mirrorReads: cfg.MirrorReads,
+		timeout:     cfg.Timeout,
+		queue:       make(chan func(), cfg.QueueSize),
+		stopped:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// run calls the secondary until the queue is closed
+func (c *Connector) run() {
+	defer close(c.stopped)
+	for op := range c.queue {
+		op()
+	}
+}
+
+// mirror queues op, or reports it as dropped when the queue is full. op gets
+// a context with the values of ctx and its own timeout.
+func (c *Connector) mirror(ctx context.Context, method string, r *dosa.SchemaRef, op func(ctx context.Context)) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.queue <- func() {
+		ctx, cancel := context.WithTimeout(detached{ctx}, c.timeout)
+		defer cancel()
+		op(ctx)
+	}:
+	default:
+		c.reporter.ReportError(method, r, &ErrQueueFull{})
+	}
+}
+
+// Flush waits until the calls queued so far have been sent to the secondary
+func (c *Connector) Flush() {
+	done := make(chan struct{})
+	c.lock.RLock()
+	if c.closed {
+		c.lock.RUnlock()
+		return
+	}
+	c.queue <- func() { close(done) }
+	c.lock.RUnlock()
+	<-done
+}
+
+func ref(ei *dosa.EntityInfo) *dosa.SchemaRef {
+	if ei == nil {
+		return nil
+	}
+	return ei.Ref
+}
+
+func scopeRef(scope, namePrefix string) *dosa.SchemaRef {
+	return &dosa.SchemaRef{Scope: scope, NamePrefix: namePrefix}
+}
+
+// reportError reports a secondary error
+func (c *Connector) reportError(method string, r *dosa.SchemaRef, err error) {
+	if err != nil {
+		c.reporter.ReportError(method, r, err)
+	}
+}
+
+// equal compares two values of a column of the given type
+func equal(typ dosa.Type, a, b dosa.FieldValue) bool {
+	switch typ {
+	case dosa.Blob:
+		ab, aok := a.([]byte)
+		bb, bok := b.([]byte)
+		if aok && bok {
+			return bytes.Equal(ab, bb)
+		}
+	case dosa.Timestamp:
+		at, aok := a.(time.Time)
+		bt, bok := b.(time.Time)
+		if aok && bok {
+			return at.Equal(bt)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareRows reports every column that differs between two rows
+func (c *Connector) compareRows(method string, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, primary, secondary map[string]dosa.FieldValue) {
+	var types map[string]dosa.Type
+	if ei != nil && ei.Def != nil {
+		types = ei.Def.ColumnTypes()
+	}
+	columns := make([]string, 0, len(primary))
+	for name := range primary {
+		columns = append(columns, name)
+	}
+	for name := range secondary {
+		if _, ok := primary[name]; !ok {
+			columns = append(columns, name)
+		}
+	}
+	sort.Strings(columns)
+	for _, name := range columns {
+		p, s := primary[name], secondary[name]
+		if !equal(types[name], p, s) {
+			c.reporter.ReportMismatch(&Mismatch{
+				Method:    method,
+				Ref:       ref(ei),
+				Keys:      keys,
+				Column:    name,
+				Primary:   p,
+				Secondary: s,
+			})
+		}
+	}
+}
+
+// compareResults compares the outcome of a single row read on both sides
+func (c *Connector) compareResults(method string, ei *dosa.EntityInfo, keys, primary map[string]dosa.FieldValue, primaryErr error, secondary map[string]dosa.FieldValue, secondaryErr error) {
+	switch {
+	case primaryErr == nil && secondaryErr == nil:
+		c.compareRows(method, ei, keys, primary, secondary)
+	case primaryErr == nil && dosa.ErrorIsNotFound(secondaryErr),
+		dosa.ErrorIsNotFound(primaryErr) && secondaryErr == nil:
+		c.reporter.ReportMismatch(&Mismatch{
+			Method:    method,
+			Ref:       ref(ei),
+			Keys:      keys,
+			Primary:   primaryErr,
+			Secondary: secondaryErr,
+		})
+	case secondaryErr != nil && !dosa.ErrorIsNotFound(secondaryErr):
+		c.reporter.ReportError(method, ref(ei), secondaryErr)
+	}
+}
+
+// copyRow copies a row so the caller may change it while it is mirrored
+func copyRow(row map[string]dosa.FieldValue) map[string]dosa.FieldValue {
+	if row == nil {
+		return nil
+	}
+	c := make(map[string]dosa.FieldValue, len(row))
+	for k, v := range row {
+		c[k] = v
+	}
+	return c
+}
+
+func copyRows(rows []map[string]dosa.FieldValue) []map[string]dosa.FieldValue {
+	c := make([]map[string]dosa.FieldValue, len(rows))
+	for i, row := range rows {
+		c[i] = copyRow(row)
+	}
+	return c
+}
+
+// succeeded returns the rows whose primary call did not fail
+func succeeded(rows []map[string]dosa.FieldValue, errs []error) []map[string]dosa.FieldValue {
+	var ok []map[string]dosa.FieldValue
+	for i, row := range rows {
+		if i >= len(errs) || errs[i] == nil {
+			ok = append(ok, copyRow(row))
+		}
+	}
+	return ok
+}
+
+// reportRowErrors reports the per-row errors of the secondary
+func (c *Connector) reportRowErrors(method string, r *dosa.SchemaRef, errs []error) {
+	for _, err := range errs {
+		c.reportError(method, r, err)
+	}
+}
+
+// CreateIfNotExists creates the row on the primary, then on the secondary
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	err := c.Connector.CreateIfNotExists(ctx, ei, values)
+	if err == nil {
+		values = copyRow(values)
+		c.mirror(ctx, "CreateIfNotExists", ref(ei), func(ctx context.Context) {
+			c.reportError("CreateIfNotExists", ref(ei), c.secondary.CreateIfNotExists(ctx, ei, values))
+		})
+	}
+	return err
+}
+
+// Upsert writes the row to the primary, then to the secondary
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	err := c.Connector.Upsert(ctx, ei, values)
+	if err == nil {
+		values = copyRow(values)
+		c.mirror(ctx, "Upsert", ref(ei), func(ctx context.Context) {
+			c.reportError("Upsert", ref(ei), c.secondary.Upsert(ctx, ei, values))
+		})
+	}
+	return err
+}
+
+// MultiUpsert writes the rows to the primary, then the rows that succeeded
+// to the secondary
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	errs, err := c.Connector.MultiUpsert(ctx, ei, values)
+	if rows := succeeded(values, errs); err == nil && len(rows) > 0 {
+		c.mirror(ctx, "MultiUpsert", ref(ei), func(ctx context.Context) {
+			secondaryErrs, secondaryErr := c.secondary.MultiUpsert(ctx, ei, rows)
+			c.reportError("MultiUpsert", ref(ei), secondaryErr)
+			c.reportRowErrors("MultiUpsert", ref(ei), secondaryErrs)
+		})
+	}
+	return errs, err
+}
+
+// Remove removes the row from the primary, then from the secondary
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	err := c.Connector.Remove(ctx, ei, keys)
+	if err == nil {
+		keys = copyRow(keys)
+		c.mirror(ctx, "Remove", ref(ei), func(ctx context.Context) {
+			c.reportError("Remove", ref(ei), c.secondary.Remove(ctx, ei, keys))
+		})
+	}
+	return err
+}
+
+// MultiRemove removes the rows from the primary, then the rows that
+// succeeded from the secondary
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	errs, err := c.Connector.MultiRemove(ctx, ei, multiKeys)
+	if rows := succeeded(multiKeys, errs); err == nil && len(rows) > 0 {
+		c.mirror(ctx, "MultiRemove", ref(ei), func(ctx context.Context) {
+			secondaryErrs, secondaryErr := c.secondary.MultiRemove(ctx, ei, rows)
+			c.reportError("MultiRemove", ref(ei), secondaryErr)
+			c.reportRowErrors("MultiRemove", ref(ei), secondaryErrs)
+		})
+	}
+	return errs, err
+}
+
+// Read reads from the primary, and compares with the secondary when reads
+// are mirrored
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	values, err := c.Connector.Read(ctx, ei, keys, fieldsToRead)
+	if c.mirrorReads && (err == nil || dosa.ErrorIsNotFound(err)) {
+		keys, primary, primaryErr := copyRow(keys), copyRow(values), err
+		c.mirror(ctx, "Read", ref(ei), func(ctx context.Context) {
+			secondary, secondaryErr := c.secondary.Read(ctx, ei, keys, fieldsToRead)
+			c.compareResults("Read", ei, keys, primary, primaryErr, secondary, secondaryErr)
+		})
+	}
+	return values, err
+}
+
+// MultiRead reads from the primary, and compares with the secondary when
+// reads are mirrored
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	results, err := c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+	if !c.mirrorReads || err != nil {
+		return results, err
+	}
+	keys = copyRows(keys)
+	primary := make([]*dosa.FieldValuesOrError, len(results))
+	for i, result := range results {
+		if result != nil {
+			primary[i] = &dosa.FieldValuesOrError{Values: copyRow(result.Values), Error: result.Error}
+		}
+	}
+	c.mirror(ctx, "MultiRead", ref(ei), func(ctx context.Context) {
+		secondary, secondaryErr := c.secondary.MultiRead(ctx, ei, keys, fieldsToRead)
+		if secondaryErr != nil {
+			c.reporter.ReportError("MultiRead", ref(ei), secondaryErr)
+			return
+		}
+		for i, result := range primary {
+			if result == nil || i >= len(keys) || i >= len(secondary) || secondary[i] == nil {
+				continue
+			}
+			if result.Error != nil && !dosa.ErrorIsNotFound(result.Error) {
+				continue
+			}
+			c.compareResults("MultiRead", ei, keys[i], result.Values, result.Error, secondary[i].Values, secondary[i].Error)
+		}
+	})
+	return results, err
+}
+
+// Range reads a page from the primary, and compares it row by row with the
+// same page of the secondary when reads are mirrored
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	values, next, err := c.Connector.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+	// tokens are backend specific, so only first pages can be compared
+	if !c.mirrorReads || err != nil || token != "" {
+		return values, next, err
+	}
+	primary := copyRows(values)
+	c.mirror(ctx, "Range", ref(ei), func(ctx context.Context) {
+		secondary, _, secondaryErr := c.secondary.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+		if secondaryErr != nil {
+			c.reporter.ReportError("Range", ref(ei), secondaryErr)
+			return
+		}
+		rows := len(primary)
+		if len(secondary) > rows {
+			rows = len(secondary)
+		}
+		for i := 0; i < rows; i++ {
+			index := map[string]dosa.FieldValue{"index": i}
+			switch {
+			case i >= len(primary):
+				c.reporter.ReportMismatch(&Mismatch{Method: "Range", Ref: ref(ei), Keys: index, Secondary: secondary[i]})
+			case i >= len(secondary):
+				c.reporter.ReportMismatch(&Mismatch{Method: "Range", Ref: ref(ei), Keys: index, Primary: primary[i]})
+			default:
+				c.compareRows("Range", ei, index, primary[i], secondary[i])
+			}
+		}
+	})
+	return values, next, err
+}
+
+// CheckSchema checks the schema on the primary, then on the secondary so it
+// learns the schema of the mirrored calls
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	version, err := c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+	if err == nil {
+		c.mirror(ctx, "CheckSchema", scopeRef(scope, namePrefix), func(ctx context.Context) {
+			_, secondaryErr := c.secondary.CheckSchema(ctx, scope, namePrefix, ed)
+			c.reportError("CheckSchema", scopeRef(scope, namePrefix), secondaryErr)
+		})
+	}
+	return version, err
+}
+
+// UpsertSchema upserts the schema on the primary, then on the secondary
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	status, err := c.Connector.UpsertSchema(ctx, scope, namePrefix, ed)
+	if err == nil {
+		c.mirror(ctx, "UpsertSchema", scopeRef(scope, namePrefix), func(ctx context.Context) {
+			_, secondaryErr := c.secondary.UpsertSchema(ctx, scope, namePrefix, ed)
+			c.reportError("UpsertSchema", scopeRef(scope, namePrefix), secondaryErr)
+		})
+	}
+	return status, err
+}
+
+// CreateScope creates the scope on the primary, then on the secondary
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	err := c.Connector.CreateScope(ctx, scope)
+	if err == nil {
+		c.mirror(ctx, "CreateScope", scopeRef(scope, ""), func(ctx context.Context) {
+			c.reportError("CreateScope", scopeRef(scope, ""), c.secondary.CreateScope(ctx, scope))
+		})
+	}
+	return err
+}
+
+// TruncateScope truncates the scope on the primary, then on the secondary
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	err := c.Connector.TruncateScope(ctx, scope)
+	if err == nil {
+		c.mirror(ctx, "TruncateScope", scopeRef(scope, ""), func(ctx context.Context) {
+			c.reportError("TruncateScope", scopeRef(scope, ""), c.secondary.TruncateScope(ctx, scope))
+		})
+	}
+	return err
+}
+
+// DropScope drops the scope on the primary, then on the secondary
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	err := c.Connector.DropScope(ctx, scope)
+	if err == nil {
+		c.mirror(ctx, "DropScope", scopeRef(scope, ""), func(ctx context.Context) {
+			c.reportError("DropScope", scopeRef(scope, ""), c.secondary.DropScope(ctx, scope))
+		})
+	}
+	return err
+}
+
+// Shutdown waits for the queued calls, shuts down both connectors and
+// returns the error of the primary
+func (c *Connector) Shutdown() error {
+	c.lock.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.lock.Unlock()
+	<-c.stopped
+	err := c.Connector.Shutdown()
+	c.reportError("Shutdown", nil, c.secondary.Shutdown())
+	return err
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package shadow_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/devnull"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/connectors/shadow"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testScope",
+		NamePrefix: "testPrefix",
+		EntityName: "testEntityName",
+	},
+	Def: &dosa.EntityDefinition{
+		Name: "testentityname",
+		Key: &dosa.PrimaryKey{
+			PartitionKeys:  []string{"p1"},
+			ClusteringKeys: []*dosa.ClusteringKey{{Name: "c1"}},
+		},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "p1", Type: dosa.String},
+			{Name: "c1", Type: dosa.Int64},
+			{Name: "v1", Type: dosa.Blob},
+			{Name: "v2", Type: dosa.Timestamp},
+		},
+	},
+}
+
+var baseTime = time.Unix(1500000000, 0)
+
+func row(p1 string, c1 int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"p1": p1, "c1": c1, "v1": []byte{1}, "v2": baseTime}
+}
+
+func keys(p1 string, c1 int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"p1": p1, "c1": c1}
+}
+
+type recordingReporter struct {
+	mismatches []*shadow.Mismatch
+	errs       []string
+}
+
+func (r *recordingReporter) ReportMismatch(m *shadow.Mismatch) {
+	r.mismatches = append(r.mismatches, m)
+}
+
+func (r *recordingReporter) ReportError(method string, ref *dosa.SchemaRef, err error) {
+	r.errs = append(r.errs, method+": "+err.Error())
+}
+
+func TestShadow_DualWrites(t *testing.T) {
+	primary, secondary := memory.NewConnector(), memory.NewConnector()
+	r := &recordingReporter{}
+	sut := shadow.NewConnector(primary, secondary, shadow.Config{Reporter: r})
+
+	assert.NoError(t, sut.CreateIfNotExists(ctx, testEi, row("a", 1)))
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a", 2)))
+	_, err := sut.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("b", 1), row("b", 2)})
+	assert.NoError(t, err)
+	assert.NoError(t, sut.Remove(ctx, testEi, keys("a", 1)))
+	_, err = sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("b", 1)})
+	assert.NoError(t, err)
+	sut.Flush()
+
+	for _, conn := range []dosa.Connector{primary, secondary} {
+		rows, _, err := conn.Scan(ctx, testEi, nil, "", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []map[string]dosa.FieldValue{row("a", 2), row("b", 2)}, rows)
+	}
+	assert.Empty(t, r.errs)
+
+	// failed primary writes are not sent to the secondary
+	err = sut.CreateIfNotExists(ctx, testEi, row("a", 2))
+	assert.True(t, dosa.ErrorIsAlreadyExists(err))
+	sut.Flush()
+	assert.Empty(t, r.errs)
+
+	// secondary errors are reported, the primary result is returned
+	assert.NoError(t, secondary.Remove(ctx, testEi, keys("a", 2)))
+	assert.NoError(t, sut.Remove(ctx, testEi, keys("a", 2)))
+	assert.NoError(t, secondary.Upsert(ctx, testEi, row("c", 1)))
+	assert.NoError(t, sut.CreateIfNotExists(ctx, testEi, row("c", 1)))
+	_, err = sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("b", 2), keys("b", 9)})
+	assert.NoError(t, err)
+	assert.NoError(t, secondary.Upsert(ctx, testEi, row("d", 1)))
+	_, err = sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("d", 1)})
+	assert.NoError(t, err)
+	sut.Flush()
+	assert.Equal(t, []string{
+		"Remove: not found",
+		"CreateIfNotExists: already exists",
+	}, r.errs[:2])
+	assert.Len(t, r.errs, 2)
+}
+
+func TestShadow_MirrorReads(t *testing.T) {
+	primary, secondary := memory.NewConnector(), memory.NewConnector()
+	r := &recordingReporter{}
+	sut := shadow.NewConnector(primary, secondary, shadow.Config{Reporter: r, MirrorReads: true})
+
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a", 1)))
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a", 2)))
+	sut.Flush()
+
+	// same rows, timestamps in different locations
+	different := row("a", 2)
+	different["v2"] = baseTime.UTC()
+	assert.NoError(t, secondary.Upsert(ctx, testEi, different))
+	_, err := sut.Read(ctx, testEi, keys("a", 2), nil)
+	assert.NoError(t, err)
+	sut.Flush()
+	assert.Empty(t, r.mismatches)
+
+	different["v1"] = []byte{2}
+	assert.NoError(t, secondary.Upsert(ctx, testEi, different))
+	values, err := sut.Read(ctx, testEi, keys("a", 2), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row("a", 2), values)
+	sut.Flush()
+	assert.Equal(t, []*shadow.Mismatch{{
+		Method:    "Read",
+		Ref:       testEi.Ref,
+		Keys:      keys("a", 2),
+		Column:    "v1",
+		Primary:   []byte{1},
+		Secondary: []byte{2},
+	}}, r.mismatches)
+
+	// found on one side only
+	r.mismatches = nil
+	assert.NoError(t, secondary.Upsert(ctx, testEi, row("z", 1)))
+	_, err = sut.Read(ctx, testEi, keys("z", 1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	sut.Flush()
+	assert.Len(t, r.mismatches, 1)
+	assert.Equal(t, "", r.mismatches[0].Column)
+	assert.Nil(t, r.mismatches[0].Secondary)
+
+	r.mismatches = nil
+	results, err := sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("a", 1), keys("a", 2), keys("z", 1)}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, results[0].Error)
+	assert.True(t, dosa.ErrorIsNotFound(results[2].Error))
+	sut.Flush()
+	assert.Len(t, r.mismatches, 2)
+	assert.Equal(t, "v1", r.mismatches[0].Column)
+	assert.Equal(t, keys("z", 1), r.mismatches[1].Keys)
+
+	r.mismatches = nil
+	conditions := map[string][]*dosa.Condition{"p1": {{Op: dosa.Eq, Value: "a"}}}
+	rows, _, err := sut.Range(ctx, testEi, conditions, nil, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	sut.Flush()
+	assert.Equal(t, []*shadow.Mismatch{{
+		Method:    "Range",
+		Ref:       testEi.Ref,
+		Keys:      map[string]dosa.FieldValue{"index": 1},
+		Column:    "v1",
+		Primary:   []byte{1},
+		Secondary: []byte{2},
+	}}, r.mismatches)
+
+	r.mismatches = nil
+	assert.NoError(t, secondary.Remove(ctx, testEi, keys("a", 1)))
+	assert.NoError(t, primary.Upsert(ctx, testEi, row("a", 3)))
+	_, _, err = sut.Range(ctx, testEi, conditions, nil, "", 10)
+	assert.NoError(t, err)
+	sut.Flush()
+	// rows are compared by position, so everything after the missing row
+	// differs
+	assert.Len(t, r.mismatches, 4)
+	assert.Equal(t, "c1", r.mismatches[0].Column)
+	assert.Equal(t, row("a", 3), r.mismatches[3].Primary)
+
+	// later pages are not compared
+	r.mismatches = nil
+	_, token, err := primary.Range(ctx, testEi, conditions, nil, "", 1)
+	assert.NoError(t, err)
+	_, _, err = sut.Range(ctx, testEi, conditions, nil, token, 10)
+	assert.NoError(t, err)
+	sut.Flush()
+	assert.Empty(t, r.mismatches)
+}
+
+func TestShadow_SecondaryFailures(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	secondary := mocks.NewMockConnector(ctrl)
+	r := &recordingReporter{}
+	sut := shadow.NewConnector(&devnull.Connector{}, secondary, shadow.Config{Reporter: r, MirrorReads: true})
+	boom := errors.New("boom")
+
+	any := gomock.Any()
+	secondary.EXPECT().Read(any, testEi, keys("a", 1), nil).Return(nil, boom)
+	secondary.EXPECT().MultiRead(any, testEi, any, nil).Return(nil, boom)
+	secondary.EXPECT().MultiUpsert(any, testEi, any).Return([]error{boom}, nil)
+	secondary.EXPECT().CheckSchema(any, "s", "p", nil).Return(int32(0), boom)
+	secondary.EXPECT().UpsertSchema(any, "s", "p", nil).Return(nil, boom)
+	secondary.EXPECT().CreateScope(any, "s").Return(boom)
+	secondary.EXPECT().TruncateScope(any, "s").Return(boom)
+	secondary.EXPECT().DropScope(any, "s").Return(boom)
+	secondary.EXPECT().Shutdown().Return(boom)
+
+	_, err := sut.Read(ctx, testEi, keys("a", 1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	_, err = sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("a", 1)}, nil)
+	assert.NoError(t, err)
+	_, err = sut.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("a", 1)})
+	assert.NoError(t, err)
+	_, err = sut.CheckSchema(ctx, "s", "p", nil)
+	assert.NoError(t, err)
+	_, err = sut.UpsertSchema(ctx, "s", "p", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, sut.CreateScope(ctx, "s"))
+	assert.NoError(t, sut.TruncateScope(ctx, "s"))
+	assert.NoError(t, sut.DropScope(ctx, "s"))
+	assert.NoError(t, sut.Shutdown())
+
+	assert.Equal(t, []string{
+		"Read: boom",
+		"MultiRead: boom",
+		"MultiUpsert: boom",
+		"CheckSchema: boom",
+		"UpsertSchema: boom",
+		"CreateScope: boom",
+		"TruncateScope: boom",
+		"DropScope: boom",
+		"Shutdown: boom",
+	}, r.errs)
+}
+
+func TestShadow_NoReporter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	secondary := mocks.NewMockConnector(ctrl)
+	secondary.EXPECT().Upsert(gomock.Any(), testEi, row("a", 1)).Return(errors.New("boom"))
+	sut := shadow.NewConnector(&devnull.Connector{}, secondary, shadow.Config{})
+	defer sut.Flush()
+
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a", 1)))
+	// reads are not mirrored by default
+	_, err := sut.Read(ctx, testEi, keys("a", 1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+}
+
+func TestShadow_MirrorsSucceededRows(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	primary := mocks.NewMockConnector(ctrl)
+	secondary := mocks.NewMockConnector(ctrl)
+	sut := shadow.NewConnector(primary, secondary, shadow.Config{})
+	boom := errors.New("boom")
+
+	rows := []map[string]dosa.FieldValue{row("a", 1), row("a", 2), row("a", 3)}
+	primary.EXPECT().MultiUpsert(ctx, testEi, rows).Return([]error{nil, boom, nil}, nil)
+	secondary.EXPECT().MultiUpsert(gomock.Any(), testEi, []map[string]dosa.FieldValue{row("a", 1), row("a", 3)}).Return(nil, nil)
+	primary.EXPECT().MultiRemove(ctx, testEi, gomock.Any()).Return([]error{boom}, nil)
+
+	errs, err := sut.MultiUpsert(ctx, testEi, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, boom, nil}, errs)
+	// nothing is mirrored when every row failed
+	_, err = sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("a", 1)})
+	assert.NoError(t, err)
+	sut.Flush()
+}
+
+type ctxKey struct{}
+
+// blockingConnector blocks every Upsert until released
+type blockingConnector struct {
+	devnull.Connector
+	started chan context.Context
+	release chan struct{}
+}
+
+func (c *blockingConnector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	c.started <- ctx
+	<-c.release
+	return ctx.Err()
+}
+
+func TestShadow_Async(t *testing.T) {
+	secondary := &blockingConnector{started: make(chan context.Context, 1), release: make(chan struct{})}
+	r := &recordingReporter{}
+	sut := shadow.NewConnector(&devnull.Connector{}, secondary, shadow.Config{Reporter: r, QueueSize: 1, Timeout: time.Minute})
+
+	// the caller does not wait for the secondary, nor does its cancellation
+	// reach it, but its values do
+	cctx, cancel := context.WithCancel(context.WithValue(ctx, ctxKey{}, "v"))
+	assert.NoError(t, sut.Upsert(cctx, testEi, row("a", 1)))
+	cancel()
+	sctx := <-secondary.started
+	assert.Equal(t, "v", sctx.Value(ctxKey{}))
+	assert.NoError(t, sctx.Err())
+	deadline, ok := sctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 10*time.Second)
+
+	// one call waits in the queue, the next is dropped
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a", 2)))
+	assert.NoError(t, sut.Upsert(ctx, testEi, row("a", 3)))
+	assert.Equal(t, []string{"Upsert: shadow queue full"}, r.errs)
+
+	close(secondary.release)
+	<-secondary.started
+	assert.NoError(t, sut.Shutdown())
+	assert.Len(t, r.errs, 1)
+}
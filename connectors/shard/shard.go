@@ -0,0 +1,550 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package shard
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// DefaultReplicas is the default number of points each shard has on the
+// hash ring
+const DefaultReplicas = 64
+
+// Config controls the distribution of rows
+type Config struct {
+	// Replicas is the number of points each shard has on the hash ring;
+	// more points spread the rows more evenly
+	Replicas int
+}
+
+// point is a position on the hash ring
+type point struct {
+	hash  uint64
+	shard int
+}
+
+// Connector spreads rows across several connectors by consistent hashing of
+// their partition key values, so all the rows of a partition live on the
+// same shard. Operations addressing a row or a partition go to a single
+// shard; MultiRead, MultiUpsert and MultiRemove are split by shard and their
+// results put back in order. Search and Scan go through every shard in turn,
+// with a token that records the position in each of them. Schema and scope
+// operations are sent to all shards at once, and fail if any shard fails.
+type Connector struct {
+	shards []dosa.Connector
+	ring   []point
+}
+
+// NewConnector creates a sharding connector over the given connectors. The
+// order of the shards matters: changing it moves rows to other shards.
+func NewConnector(shards []dosa.Connector, cfg Config) (*Connector, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("at least one shard is required")
+	}
+	replicas := cfg.Replicas
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	ring := make([]point, 0, len(shards)*replicas)
+	for i := range shards {
+		for r := 0; r < replicas; r++ {
+			ring = append(ring, point{hash: hash([]byte(fmt.Sprintf("shard-%d-%d", i, r))), shard: i})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return &Connector{shards: shards, ring: ring}, nil
+}
+
+func hash(data []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	// FNV barely changes the high bits for keys that differ in their last
+	// bytes, so mix them in (the murmur3 finalizer) to spread them on the ring
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// writeValue appends a value to a partition key encoding
+func writeValue(b *bytes.Buffer, value dosa.FieldValue) {
+	switch v := value.(type) {
+	case []byte:
+		fmt.Fprintf(b, "%x", v)
+	case time.Time:
+		fmt.Fprintf(b, "%d", v.UnixNano())
+	default:
+		fmt.Fprintf(b, "%#v", v)
+	}
+}
+
+// shardFor returns the shard of the partition with the given key values
+func (c *Connector) shardFor(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) (int, error) {
+	if ei == nil || ei.Def == nil || ei.Def.Key == nil {
+		return 0, errors.New("no primary key to shard on")
+	}
+	var b bytes.Buffer
+	if ei.Ref != nil {
+		fmt.Fprintf(&b, "%q/%q/%q", ei.Ref.Scope, ei.Ref.NamePrefix, ei.Ref.EntityName)
+	}
+	for _, name := range ei.Def.Key.PartitionKeys {
+		value, ok := values[name]
+		if !ok {
+			return 0, errors.Errorf("missing value for partition key %q", name)
+		}
+		b.WriteByte('/')
+		writeValue(&b, value)
+	}
+	h := hash(b.Bytes())
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= h })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.ring[i].shard, nil
+}
+
+// CreateIfNotExists creates the row on its shard
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	i, err := c.shardFor(ei, values)
+	if err != nil {
+		return err
+	}
+	return c.shards[i].CreateIfNotExists(ctx, ei, values)
+}
+
+// Read reads the row from its shard
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	i, err := c.shardFor(ei, keys)
+	if err != nil {
+		return nil, err
+	}
+	return c.shards[i].Read(ctx, ei, keys, fieldsToRead)
+}
+
+// Upsert writes the row to its shard
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	i, err := c.shardFor(ei, values)
+	if err != nil {
+		return err
+	}
+	return c.shards[i].Upsert(ctx, ei, values)
+}
+
+// Remove removes the row from its shard
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	i, err := c.shardFor(ei, keys)
+	if err != nil {
+		return err
+	}
+	return c.shards[i].Remove(ctx, ei, keys)
+}
+
+// split groups the positions of rows by shard
+func (c *Connector) split(ei *dosa.EntityInfo, rows []map[string]dosa.FieldValue) (map[int][]int, error) {
+	groups := map[int][]int{}
+	for i, row := range rows {
+		shard, err := c.shardFor(ei, row)
+		if err != nil {
+			return nil, errors.Wrapf(err, "row %d", i)
+		}
+		groups[shard] = append(groups[shard], i)
+	}
+	return groups, nil
+}
+
+// scatter calls op concurrently for every shard of groups with the rows at
+// the grouped positions
+func scatter(groups map[int][]int, rows []map[string]dosa.FieldValue, op func(shard int, batch []map[string]dosa.FieldValue, positions []int)) {
+	var wg sync.WaitGroup
+	for shard, positions := range groups {
+		batch := make([]map[string]dosa.FieldValue, len(positions))
+		for i, pos := range positions {
+			batch[i] = rows[pos]
+		}
+		wg.Add(1)
+		go func(shard int, batch []map[string]dosa.FieldValue, positions []int) {
+			defer wg.Done()
+			op(shard, batch, positions)
+		}(shard, batch, positions)
+	}
+	wg.Wait()
+}
+
+// MultiRead reads every row from its shard. When a whole shard fails, its
+// error is returned for each of its rows.
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	groups, err := c.split(ei, keys)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	scatter(groups, keys, func(shard int, batch []map[string]dosa.FieldValue, positions []int) {
+		partial, err := c.shards[shard].MultiRead(ctx, ei, batch, fieldsToRead)
+		if err == nil && len(partial) != len(batch) {
+			err = errors.Errorf("shard %d returned %d results for %d keys", shard, len(partial), len(batch))
+		}
+		for i, pos := range positions {
+			if err != nil {
+				results[pos] = &dosa.FieldValuesOrError{Error: err}
+				continue
+			}
+			results[pos] = partial[i]
+		}
+	})
+	return results, nil
+}
+
+// multiWrite runs a MultiUpsert or MultiRemove on every shard and puts the
+// per-row errors back in order
+func (c *Connector) multiWrite(ei *dosa.EntityInfo, rows []map[string]dosa.FieldValue, op func(shard int, batch []map[string]dosa.FieldValue) ([]error, error)) ([]error, error) {
+	groups, err := c.split(ei, rows)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(rows))
+	scatter(groups, rows, func(shard int, batch []map[string]dosa.FieldValue, positions []int) {
+		partial, err := op(shard, batch)
+		for i, pos := range positions {
+			switch {
+			case err != nil:
+				errs[pos] = err
+			case i < len(partial):
+				errs[pos] = partial[i]
+			}
+		}
+	})
+	return errs, nil
+}
+
+// MultiUpsert writes every row to its shard. When a whole shard fails, its
+// error is returned for each of its rows.
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	return c.multiWrite(ei, values, func(shard int, batch []map[string]dosa.FieldValue) ([]error, error) {
+		return c.shards[shard].MultiUpsert(ctx, ei, batch)
+	})
+}
+
+// MultiRemove removes every row from its shard. When a whole shard fails,
+// its error is returned for each of its rows.
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	return c.multiWrite(ei, multiKeys, func(shard int, batch []map[string]dosa.FieldValue) ([]error, error) {
+		return c.shards[shard].MultiRemove(ctx, ei, batch)
+	})
+}
+
+// Range reads from the shard of the partition, which is given by the
+// equality conditions on the partition keys
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	partition := map[string]dosa.FieldValue{}
+	for name, conditions := range columnConditions {
+		for _, cond := range conditions {
+			if cond.Op == dosa.Eq {
+				partition[name] = cond.Value
+			}
+		}
+	}
+	i, err := c.shardFor(ei, partition)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Range requires equality conditions on the partition keys")
+	}
+	return c.shards[i].Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+}
+
+// position is the state of one shard in a composite token
+type position struct {
+	Token string `json:"t,omitempty"`
+	Done  bool   `json:"d,omitempty"`
+}
+
+func decodeToken(token string, shards int) ([]position, error) {
+	positions := make([]position, shards)
+	if token == "" {
+		return positions, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid token")
+	}
+	if err := json.Unmarshal(data, &positions); err != nil {
+		return nil, errors.Wrap(err, "invalid token")
+	}
+	if len(positions) != shards {
+		return nil, errors.Errorf("invalid token: %d shards instead of %d", len(positions), shards)
+	}
+	return positions, nil
+}
+
+func encodeToken(positions []position) string {
+	for _, p := range positions {
+		if !p.Done {
+			data, _ := json.Marshal(positions)
+			return base64.RawURLEncoding.EncodeToString(data)
+		}
+	}
+	return ""
+}
+
+// fanOut pages through the shards in order, filling up to limit rows
+func (c *Connector) fanOut(token string, limit int, page func(shard int, token string, limit int) ([]map[string]dosa.FieldValue, string, error)) ([]map[string]dosa.FieldValue, string, error) {
+	positions, err := decodeToken(token, len(c.shards))
+	if err != nil {
+		return nil, "", err
+	}
+	var rows []map[string]dosa.FieldValue
+	for i := range c.shards {
+		if positions[i].Done {
+			continue
+		}
+		// without a limit each shard picks its own page size, so stop at
+		// the first page with rows
+		if len(rows) > 0 && (limit <= 0 || len(rows) >= limit) {
+			break
+		}
+		remaining := 0
+		if limit > 0 {
+			remaining = limit - len(rows)
+		}
+		values, next, err := page(i, positions[i].Token, remaining)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "shard %d", i)
+		}
+		rows = append(rows, values...)
+		positions[i] = position{Token: next, Done: next == ""}
+	}
+	return rows, encodeToken(positions), nil
+}
+
+// Search searches every shard in turn
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPairs dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	return c.fanOut(token, limit, func(shard int, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+		return c.shards[shard].Search(ctx, ei, fieldPairs, fieldsToRead, token, limit)
+	})
+}
+
+// Scan scans every shard in turn
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	return c.fanOut(token, limit, func(shard int, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+		return c.shards[shard].Scan(ctx, ei, fieldsToRead, token, limit)
+	})
+}
+
+// multiError lists the errors of several shards. Its cause is the first
+// one.
+type multiError []error
+
+func (e multiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e multiError) Cause() error {
+	return e[0]
+}
+
+// broadcast calls op on every shard at once and waits for all of them. It
+// returns the errors of all the shards that failed.
+func (c *Connector) broadcast(op func(i int, shard dosa.Connector) error) error {
+	errs := make([]error, len(c.shards))
+	var wg sync.WaitGroup
+	for i, shard := range c.shards {
+		wg.Add(1)
+		go func(i int, shard dosa.Connector) {
+			defer wg.Done()
+			if err := op(i, shard); err != nil {
+				errs[i] = errors.Wrapf(err, "shard %d", i)
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+	var failed multiError
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	}
+	return failed
+}
+
+// sameVersion checks that all the shards have the same schema version
+func sameVersion(versions []int32) error {
+	for i, v := range versions {
+		if v != versions[0] {
+			return errors.Errorf("schema version %d of shard %d differs from %d of shard 0", v, i, versions[0])
+		}
+	}
+	return nil
+}
+
+// CheckSchema checks the schema on every shard. The shards must agree on
+// the version.
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	versions := make([]int32, len(c.shards))
+	err := c.broadcast(func(i int, shard dosa.Connector) (err error) {
+		versions[i], err = shard.CheckSchema(ctx, scope, namePrefix, ed)
+		return err
+	})
+	if err == nil {
+		err = sameVersion(versions)
+	}
+	if err != nil {
+		return dosa.InvalidVersion, err
+	}
+	return versions[0], nil
+}
+
+// statuses runs a schema operation on every shard. The shards must agree on
+// the version, and the status of the first one is returned.
+func (c *Connector) statuses(op func(shard dosa.Connector) (*dosa.SchemaStatus, error)) (*dosa.SchemaStatus, error) {
+	statuses := make([]*dosa.SchemaStatus, len(c.shards))
+	err := c.broadcast(func(i int, shard dosa.Connector) (err error) {
+		statuses[i], err = op(shard)
+		if err == nil && statuses[i] == nil {
+			err = errors.New("no schema status")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int32, len(statuses))
+	for i, status := range statuses {
+		versions[i] = status.Version
+	}
+	if err := sameVersion(versions); err != nil {
+		return nil, err
+	}
+	return statuses[0], nil
+}
+
+// UpsertSchema upserts the schema on every shard and returns the status of
+// the first one. The shards must agree on the version.
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	return c.statuses(func(shard dosa.Connector) (*dosa.SchemaStatus, error) {
+		return shard.UpsertSchema(ctx, scope, namePrefix, ed)
+	})
+}
+
+// CheckSchemaStatus checks the status on every shard and returns the status
+// of the first one. The shards must agree on the version.
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	return c.statuses(func(shard dosa.Connector) (*dosa.SchemaStatus, error) {
+		return shard.CheckSchemaStatus(ctx, scope, namePrefix, version)
+	})
+}
+
+// CreateScope creates the scope on every shard
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	return c.broadcast(func(_ int, shard dosa.Connector) error {
+		return shard.CreateScope(ctx, scope)
+	})
+}
+
+// TruncateScope truncates the scope on every shard
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	return c.broadcast(func(_ int, shard dosa.Connector) error {
+		return shard.TruncateScope(ctx, scope)
+	})
+}
+
+// DropScope drops the scope on every shard
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	return c.broadcast(func(_ int, shard dosa.Connector) error {
+		return shard.DropScope(ctx, scope)
+	})
+}
+
+// ScopeExists returns true if the scope exists on every shard
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	exists := make([]bool, len(c.shards))
+	err := c.broadcast(func(i int, shard dosa.Connector) (err error) {
+		exists[i], err = shard.ScopeExists(ctx, scope)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, e := range exists {
+		if !e {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ListScopes returns the scopes that exist on every shard
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	listed := make([][]string, len(c.shards))
+	err := c.broadcast(func(i int, shard dosa.Connector) (err error) {
+		listed[i], err = shard.ListScopes(ctx, owner)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, scopes := range listed {
+		for _, scope := range scopes {
+			counts[scope]++
+		}
+	}
+	scopes := []string{}
+	for _, scope := range listed[0] {
+		if counts[scope] == len(c.shards) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// Shutdown shuts down every shard and returns the first error
+func (c *Connector) Shutdown() error {
+	var first error
+	for i, shard := range c.shards {
+		if err := shard.Shutdown(); err != nil && first == nil {
+			first = errors.Wrapf(err, "shard %d", i)
+		}
+	}
+	return first
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package shard_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/connectors/shard"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{Scope: "scope", NamePrefix: "prefix", EntityName: "user"},
+	Def: &dosa.EntityDefinition{
+		Name: "user",
+		Key: &dosa.PrimaryKey{
+			PartitionKeys:  []string{"tenant"},
+			ClusteringKeys: []*dosa.ClusteringKey{{Name: "id"}},
+		},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "tenant", Type: dosa.String},
+			{Name: "id", Type: dosa.Int64},
+			{Name: "name", Type: dosa.String},
+		},
+	},
+}
+
+func row(tenant string, id int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"tenant": tenant, "id": id, "name": fmt.Sprintf("%s-%d", tenant, id)}
+}
+
+func keys(tenant string, id int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"tenant": tenant, "id": id}
+}
+
+func newSharded(t *testing.T, n int) (*shard.Connector, []*memory.Connector) {
+	children := make([]dosa.Connector, n)
+	mems := make([]*memory.Connector, n)
+	for i := range children {
+		mems[i] = memory.NewConnector()
+		children[i] = mems[i]
+	}
+	sc, err := shard.NewConnector(children, shard.Config{})
+	assert.NoError(t, err)
+	return sc, mems
+}
+
+func TestNewConnectorNoShards(t *testing.T) {
+	_, err := shard.NewConnector(nil, shard.Config{})
+	assert.Error(t, err)
+}
+
+func TestSinglePartitionOnOneShard(t *testing.T) {
+	sc, mems := newSharded(t, 4)
+	for id := int64(0); id < 10; id++ {
+		assert.NoError(t, sc.Upsert(ctx, testEi, row("acme", id)))
+	}
+
+	var holders int
+	for _, mem := range mems {
+		rows, _, err := mem.Scan(ctx, testEi, nil, "", 100)
+		assert.NoError(t, err)
+		if len(rows) > 0 {
+			holders++
+			assert.Len(t, rows, 10)
+		}
+	}
+	assert.Equal(t, 1, holders)
+
+	values, err := sc.Read(ctx, testEi, keys("acme", 3), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "acme-3", values["name"])
+
+	conditions := map[string][]*dosa.Condition{
+		"tenant": {{Op: dosa.Eq, Value: "acme"}},
+		"id":     {{Op: dosa.GtOrEq, Value: int64(5)}},
+	}
+	rows, _, err := sc.Range(ctx, testEi, conditions, nil, "", 100)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 5)
+
+	assert.NoError(t, sc.Remove(ctx, testEi, keys("acme", 3)))
+	_, err = sc.Read(ctx, testEi, keys("acme", 3), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	assert.Error(t, sc.CreateIfNotExists(ctx, testEi, row("acme", 4)))
+}
+
+func TestMissingPartitionKey(t *testing.T) {
+	sc, _ := newSharded(t, 2)
+	_, err := sc.Read(ctx, testEi, map[string]dosa.FieldValue{"id": int64(1)}, nil)
+	assert.Contains(t, err.Error(), "tenant")
+
+	_, _, err = sc.Range(ctx, testEi, map[string][]*dosa.Condition{
+		"tenant": {{Op: dosa.Gt, Value: "a"}},
+	}, nil, "", 10)
+	assert.Contains(t, err.Error(), "partition keys")
+}
+
+func TestMultiOpsKeepOrder(t *testing.T) {
+	sc, mems := newSharded(t, 3)
+	var rows, ks []map[string]dosa.FieldValue
+	for i := 0; i < 20; i++ {
+		tenant := fmt.Sprintf("tenant%d", i)
+		rows = append(rows, row(tenant, int64(i)))
+		ks = append(ks, keys(tenant, int64(i)))
+	}
+	errs, err := sc.MultiUpsert(ctx, testEi, rows)
+	assert.NoError(t, err)
+	assert.Len(t, errs, 20)
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	var used int
+	for _, mem := range mems {
+		stored, _, err := mem.Scan(ctx, testEi, nil, "", 100)
+		assert.NoError(t, err)
+		if len(stored) > 0 {
+			used++
+		}
+	}
+	assert.True(t, used > 1, "rows should spread over several shards")
+
+	ks = append(ks, keys("missing", 0))
+	results, err := sc.MultiRead(ctx, testEi, ks, []string{"name"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 21)
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, results[i].Error)
+		assert.Equal(t, fmt.Sprintf("tenant%d-%d", i, i), results[i].Values["name"])
+	}
+	assert.True(t, dosa.ErrorIsNotFound(results[20].Error))
+
+	errs, err = sc.MultiRemove(ctx, testEi, ks[:20])
+	assert.NoError(t, err)
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	all, _, err := sc.Scan(ctx, testEi, nil, "", 100)
+	assert.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestMultiReadShardFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	failing := mocks.NewMockConnector(ctrl)
+	failing.EXPECT().MultiRead(gomock.Any(), testEi, gomock.Any(), gomock.Any()).Return(nil, errors.New("unavailable")).AnyTimes()
+	failing.EXPECT().MultiUpsert(gomock.Any(), testEi, gomock.Any()).Return(nil, errors.New("unavailable")).AnyTimes()
+	sc, err := shard.NewConnector([]dosa.Connector{memory.NewConnector(), failing}, shard.Config{})
+	assert.NoError(t, err)
+
+	var rows, ks []map[string]dosa.FieldValue
+	for i := 0; i < 10; i++ {
+		rows = append(rows, row(fmt.Sprintf("tenant%d", i), 1))
+		ks = append(ks, keys(fmt.Sprintf("tenant%d", i), 1))
+	}
+	errs, err := sc.MultiUpsert(ctx, testEi, rows)
+	assert.NoError(t, err)
+	results, err := sc.MultiRead(ctx, testEi, ks, nil)
+	assert.NoError(t, err)
+
+	var failed int
+	for i := range results {
+		if errs[i] != nil {
+			failed++
+			assert.EqualError(t, errs[i], "unavailable")
+			assert.EqualError(t, results[i].Error, "unavailable")
+		} else {
+			assert.NoError(t, results[i].Error)
+		}
+	}
+	assert.True(t, failed > 0 && failed < 10)
+}
+
+func TestScanAcrossShards(t *testing.T) {
+	sc, _ := newSharded(t, 3)
+	for i := 0; i < 25; i++ {
+		assert.NoError(t, sc.Upsert(ctx, testEi, row(fmt.Sprintf("tenant%d", i), int64(i))))
+	}
+
+	seen := map[string]bool{}
+	var token string
+	for pages := 0; pages < 10; pages++ {
+		rows, next, err := sc.Scan(ctx, testEi, []string{"tenant"}, token, 4)
+		assert.NoError(t, err)
+		assert.True(t, len(rows) <= 4)
+		for _, r := range rows {
+			tenant := r["tenant"].(string)
+			assert.False(t, seen[tenant], "duplicate row %s", tenant)
+			seen[tenant] = true
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Len(t, seen, 25)
+
+	_, _, err := sc.Scan(ctx, testEi, nil, "not a token", 4)
+	assert.Error(t, err)
+}
+
+func TestSchemaAndScopeBroadcast(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	a, b := mocks.NewMockConnector(ctrl), mocks.NewMockConnector(ctrl)
+	sc, err := shard.NewConnector([]dosa.Connector{a, b}, shard.Config{Replicas: 8})
+	assert.NoError(t, err)
+
+	eds := []*dosa.EntityDefinition{testEi.Def}
+	a.EXPECT().CheckSchema(ctx, "scope", "prefix", eds).Return(int32(3), nil)
+	b.EXPECT().CheckSchema(ctx, "scope", "prefix", eds).Return(int32(3), nil)
+	version, err := sc.CheckSchema(ctx, "scope", "prefix", eds)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), version)
+
+	a.EXPECT().CheckSchema(ctx, "scope", "prefix", eds).Return(int32(3), nil)
+	b.EXPECT().CheckSchema(ctx, "scope", "prefix", eds).Return(int32(4), nil)
+	_, err = sc.CheckSchema(ctx, "scope", "prefix", eds)
+	assert.Contains(t, err.Error(), "differs")
+
+	a.EXPECT().CreateScope(ctx, "scope").Return(nil)
+	b.EXPECT().CreateScope(ctx, "scope").Return(errors.New("boom"))
+	assert.EqualError(t, sc.CreateScope(ctx, "scope"), "shard 1: boom")
+
+	// every shard is called even when one fails, and all errors are returned
+	a.EXPECT().DropScope(ctx, "scope").Return(&dosa.ErrNotFound{})
+	b.EXPECT().DropScope(ctx, "scope").Return(errors.New("boom"))
+	err = sc.DropScope(ctx, "scope")
+	assert.EqualError(t, err, "shard 0: not found; shard 1: boom")
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	a.EXPECT().UpsertSchema(ctx, "scope", "prefix", eds).Return(&dosa.SchemaStatus{Version: 5}, nil)
+	b.EXPECT().UpsertSchema(ctx, "scope", "prefix", eds).Return(&dosa.SchemaStatus{Version: 5}, nil)
+	status, err := sc.UpsertSchema(ctx, "scope", "prefix", eds)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(5), status.Version)
+
+	a.EXPECT().UpsertSchema(ctx, "scope", "prefix", eds).Return(&dosa.SchemaStatus{Version: 5}, nil)
+	b.EXPECT().UpsertSchema(ctx, "scope", "prefix", eds).Return(&dosa.SchemaStatus{Version: 6}, nil)
+	_, err = sc.UpsertSchema(ctx, "scope", "prefix", eds)
+	assert.EqualError(t, err, "schema version 6 of shard 1 differs from 5 of shard 0")
+
+	a.EXPECT().CheckSchemaStatus(ctx, "scope", "prefix", int32(5)).Return(&dosa.SchemaStatus{Version: 5}, nil)
+	b.EXPECT().CheckSchemaStatus(ctx, "scope", "prefix", int32(5)).Return(nil, errors.New("boom"))
+	_, err = sc.CheckSchemaStatus(ctx, "scope", "prefix", 5)
+	assert.EqualError(t, err, "shard 1: boom")
+
+	// shards are called at the same time
+	started := make(chan struct{}, 2)
+	wait := func(context.Context, string) {
+		started <- struct{}{}
+		deadline := time.After(5 * time.Second)
+		for len(started) < 2 {
+			select {
+			case <-deadline:
+				t.Error("shards were not called concurrently")
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+	a.EXPECT().TruncateScope(ctx, "scope").Do(wait).Return(nil)
+	b.EXPECT().TruncateScope(ctx, "scope").Do(wait).Return(nil)
+	assert.NoError(t, sc.TruncateScope(ctx, "scope"))
+
+	a.EXPECT().ScopeExists(ctx, "scope").Return(true, nil)
+	b.EXPECT().ScopeExists(ctx, "scope").Return(false, nil)
+	exists, err := sc.ScopeExists(ctx, "scope")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	a.EXPECT().ListScopes(ctx, "").Return([]string{"one", "two"}, nil)
+	b.EXPECT().ListScopes(ctx, "").Return([]string{"two"}, nil)
+	scopes, err := sc.ListScopes(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"two"}, scopes)
+
+	a.EXPECT().Shutdown().Return(errors.New("first"))
+	b.EXPECT().Shutdown().Return(nil)
+	assert.EqualError(t, sc.Shutdown(), "shard 0: first")
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tracing
+
+import (
+	"context"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Tags set on the spans, in addition to the standard component and error tags
+const (
+	ScopeTag      = "dosa.scope"
+	NamePrefixTag = "dosa.prefix"
+	EntityTag     = "dosa.entity"
+	LimitTag      = "dosa.limit"
+	ConditionsTag = "dosa.conditions"
+	RowsTag       = "dosa.rows"
+)
+
+const component = "dosa"
+
+// Connector starts a span for every operation. The span is a child of the
+// span found in the context, if any, and is stored in the context passed to
+// the next connector. The yarpc connector sends it along in the transport
+// headers, so the trace continues on the gateway.
+type Connector struct {
+	base.Connector
+	tracer opentracing.Tracer
+}
+
+// NewConnector creates a tracing connector in front of next. When tracer is
+// nil the global tracer is used.
+func NewConnector(next dosa.Connector, tracer opentracing.Tracer) *Connector {
+	if tracer == nil {
+		tracer = opentracing.GlobalTracer()
+	}
+	return &Connector{
+		Connector: base.Connector{Next: next},
+		tracer:    tracer,
+	}
+}
+
+// startSpan starts the span of an operation and returns it with a context
+// that carries it
+func (c *Connector) startSpan(ctx context.Context, method string) (opentracing.Span, context.Context) {
+	var opts []opentracing.StartSpanOption
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		opts = append(opts, opentracing.ChildOf(parent.Context()))
+	}
+	span := c.tracer.StartSpan("dosa."+method, opts...)
+	ext.Component.Set(span, component)
+	return span, opentracing.ContextWithSpan(ctx, span)
+}
+
+// startEntitySpan starts a span tagged with the entity of the operation
+func (c *Connector) startEntitySpan(ctx context.Context, method string, ei *dosa.EntityInfo) (opentracing.Span, context.Context) {
+	span, ctx := c.startSpan(ctx, method)
+	if ei != nil && ei.Ref != nil {
+		span.SetTag(ScopeTag, ei.Ref.Scope)
+		span.SetTag(NamePrefixTag, ei.Ref.NamePrefix)
+		span.SetTag(EntityTag, ei.Ref.EntityName)
+	}
+	return span, ctx
+}
+
+// startSchemaSpan starts a span tagged with the scope and name prefix of a
+// schema operation
+func (c *Connector) startSchemaSpan(ctx context.Context, method, scope, namePrefix string) (opentracing.Span, context.Context) {
+	span, ctx := c.startSpan(ctx, method)
+	span.SetTag(ScopeTag, scope)
+	span.SetTag(NamePrefixTag, namePrefix)
+	return span, ctx
+}
+
+// startScopeSpan starts a span tagged with the scope of a scope operation
+func (c *Connector) startScopeSpan(ctx context.Context, method, scope string) (opentracing.Span, context.Context) {
+	span, ctx := c.startSpan(ctx, method)
+	span.SetTag(ScopeTag, scope)
+	return span, ctx
+}
+
+// finish records the outcome of the operation and finishes the span
+func finish(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogKV("event", "error", "message", err.Error())
+	}
+	span.Finish()
+}
+
+// CreateIfNotExists traces the call
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	span, ctx := c.startEntitySpan(ctx, "CreateIfNotExists", ei)
+	err := c.Connector.CreateIfNotExists(ctx, ei, values)
+	finish(span, err)
+	return err
+}
+
+// Read traces the call
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	span, ctx := c.startEntitySpan(ctx, "Read", ei)
+	values, err := c.Connector.Read(ctx, ei, keys, fieldsToRead)
+	finish(span, err)
+	return values, err
+}
+
+// MultiRead traces the call
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	span, ctx := c.startEntitySpan(ctx, "MultiRead", ei)
+	span.SetTag(RowsTag, len(keys))
+	results, err := c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+	finish(span, err)
+	return results, err
+}
+
+// Upsert traces the call
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	span, ctx := c.startEntitySpan(ctx, "Upsert", ei)
+	err := c.Connector.Upsert(ctx, ei, values)
+	finish(span, err)
+	return err
+}
+
+// MultiUpsert traces the call
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	span, ctx := c.startEntitySpan(ctx, "MultiUpsert", ei)
+	span.SetTag(RowsTag, len(values))
+	errs, err := c.Connector.MultiUpsert(ctx, ei, values)
+	finish(span, err)
+	return errs, err
+}
+
+// Remove traces the call
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	span, ctx := c.startEntitySpan(ctx, "Remove", ei)
+	err := c.Connector.Remove(ctx, ei, keys)
+	finish(span, err)
+	return err
+}
+
+// MultiRemove traces the call
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	span, ctx := c.startEntitySpan(ctx, "MultiRemove", ei)
+	span.SetTag(RowsTag, len(multiKeys))
+	errs, err := c.Connector.MultiRemove(ctx, ei, multiKeys)
+	finish(span, err)
+	return errs, err
+}
+
+// Range traces the call; the span is tagged with the limit and the number of
+// conditions
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	span, ctx := c.startEntitySpan(ctx, "Range", ei)
+	conditions := 0
+	for _, conds := range columnConditions {
+		conditions += len(conds)
+	}
+	span.SetTag(ConditionsTag, conditions)
+	span.SetTag(LimitTag, limit)
+	values, next, err := c.Connector.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+	finish(span, err)
+	return values, next, err
+}
+
+// Search traces the call; the span is tagged with the limit
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPairs dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	span, ctx := c.startEntitySpan(ctx, "Search", ei)
+	span.SetTag(LimitTag, limit)
+	values, next, err := c.Connector.Search(ctx, ei, fieldPairs, fieldsToRead, token, limit)
+	finish(span, err)
+	return values, next, err
+}
+
+// Scan traces the call; the span is tagged with the limit
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	span, ctx := c.startEntitySpan(ctx, "Scan", ei)
+	span.SetTag(LimitTag, limit)
+	values, next, err := c.Connector.Scan(ctx, ei, fieldsToRead, token, limit)
+	finish(span, err)
+	return values, next, err
+}
+
+// CheckSchema traces the call
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	span, ctx := c.startSchemaSpan(ctx, "CheckSchema", scope, namePrefix)
+	version, err := c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+	finish(span, err)
+	return version, err
+}
+
+// UpsertSchema traces the call
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	span, ctx := c.startSchemaSpan(ctx, "UpsertSchema", scope, namePrefix)
+	status, err := c.Connector.UpsertSchema(ctx, scope, namePrefix, ed)
+	finish(span, err)
+	return status, err
+}
+
+// CheckSchemaStatus traces the call
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope string, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	span, ctx := c.startSchemaSpan(ctx, "CheckSchemaStatus", scope, namePrefix)
+	status, err := c.Connector.CheckSchemaStatus(ctx, scope, namePrefix, version)
+	finish(span, err)
+	return status, err
+}
+
+// CreateScope traces the call
+func (c *Connector) CreateScope(ctx context.Context, scope string) error {
+	span, ctx := c.startScopeSpan(ctx, "CreateScope", scope)
+	err := c.Connector.CreateScope(ctx, scope)
+	finish(span, err)
+	return err
+}
+
+// TruncateScope traces the call
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	span, ctx := c.startScopeSpan(ctx, "TruncateScope", scope)
+	err := c.Connector.TruncateScope(ctx, scope)
+	finish(span, err)
+	return err
+}
+
+// DropScope traces the call
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	span, ctx := c.startScopeSpan(ctx, "DropScope", scope)
+	err := c.Connector.DropScope(ctx, scope)
+	finish(span, err)
+	return err
+}
+
+// ScopeExists traces the call
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	span, ctx := c.startScopeSpan(ctx, "ScopeExists", scope)
+	exists, err := c.Connector.ScopeExists(ctx, scope)
+	finish(span, err)
+	return exists, err
+}
+
+// ListScopes traces the call
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	span, ctx := c.startSpan(ctx, "ListScopes")
+	scopes, err := c.Connector.ListScopes(ctx, owner)
+	finish(span, err)
+	return scopes, err
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/devnull"
+	"github.com/uber-go/dosa/connectors/tracing"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testScope",
+		NamePrefix: "testPrefix",
+		EntityName: "testEntityName",
+	},
+	Def: &dosa.EntityDefinition{},
+}
+
+var testValues = map[string]dosa.FieldValue{"id": int64(1)}
+
+func TestTracing_Range(t *testing.T) {
+	tracer := mocktracer.New()
+	sut := tracing.NewConnector(&devnull.Connector{}, tracer)
+
+	conditions := map[string][]*dosa.Condition{
+		"c1": {{Op: dosa.Gt, Value: int64(1)}, {Op: dosa.Lt, Value: int64(9)}},
+		"c2": {{Op: dosa.Eq, Value: "x"}},
+	}
+	_, _, err := sut.Range(ctx, testEi, conditions, nil, "", 32)
+	assert.Error(t, err)
+
+	spans := tracer.FinishedSpans()
+	assert.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "dosa.Range", span.OperationName)
+	assert.Equal(t, map[string]interface{}{
+		"component":       "dosa",
+		"dosa.scope":      "testScope",
+		"dosa.prefix":     "testPrefix",
+		"dosa.entity":     "testEntityName",
+		"dosa.conditions": 3,
+		"dosa.limit":      32,
+		"error":           true,
+	}, span.Tags())
+	assert.Len(t, span.Logs(), 1)
+}
+
+func TestTracing_ChildOfContextSpan(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := mocks.NewMockConnector(ctrl)
+	tracer := mocktracer.New()
+	sut := tracing.NewConnector(mc, tracer)
+
+	parent := tracer.StartSpan("handler")
+	pctx := opentracing.ContextWithSpan(ctx, parent)
+
+	var carrier opentracing.HTTPHeadersCarrier
+	mc.EXPECT().Upsert(gomock.Any(), testEi, testValues).Do(func(ctx context.Context, _ *dosa.EntityInfo, _ map[string]dosa.FieldValue) {
+		// the next connector sees the operation span in its context and
+		// can put it on the wire, like the yarpc transports do
+		span := opentracing.SpanFromContext(ctx)
+		assert.NotNil(t, span)
+		carrier = opentracing.HTTPHeadersCarrier{}
+		assert.NoError(t, tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier))
+	}).Return(nil)
+
+	assert.NoError(t, sut.Upsert(pctx, testEi, testValues))
+	parent.Finish()
+
+	spans := tracer.FinishedSpans()
+	assert.Len(t, spans, 2)
+	child := spans[0]
+	assert.Equal(t, "dosa.Upsert", child.OperationName)
+	assert.Equal(t, parent.(*mocktracer.MockSpan).SpanContext.SpanID, child.ParentID)
+	assert.Equal(t, parent.(*mocktracer.MockSpan).SpanContext.TraceID, child.SpanContext.TraceID)
+	assert.Nil(t, child.Tag("error"))
+
+	extracted, err := tracer.Extract(opentracing.HTTPHeaders, carrier)
+	assert.NoError(t, err)
+	assert.Equal(t, child.SpanContext.SpanID, extracted.(mocktracer.MockSpanContext).SpanID)
+}
+
+func TestTracing_AllOps(t *testing.T) {
+	tracer := mocktracer.New()
+	sut := tracing.NewConnector(&devnull.Connector{}, tracer)
+
+	_ = sut.CreateIfNotExists(ctx, testEi, testValues)
+	_, _ = sut.Read(ctx, testEi, testValues, nil)
+	_, _ = sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{testValues, testValues}, nil)
+	_, _ = sut.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{testValues})
+	_ = sut.Remove(ctx, testEi, testValues)
+	_, _ = sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{testValues})
+	_, _, _ = sut.Search(ctx, testEi, dosa.FieldNameValuePair{}, nil, "", 5)
+	_, _, _ = sut.Scan(ctx, testEi, nil, "", 5)
+	_, _ = sut.CheckSchema(ctx, "s", "p", nil)
+	_, _ = sut.UpsertSchema(ctx, "s", "p", nil)
+	_, _ = sut.CheckSchemaStatus(ctx, "s", "p", 1)
+	_ = sut.CreateScope(ctx, "s")
+	_ = sut.TruncateScope(ctx, "s")
+	_ = sut.DropScope(ctx, "s")
+	_, _ = sut.ScopeExists(ctx, "s")
+
+	spans := tracer.FinishedSpans()
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.OperationName
+	}
+	assert.Equal(t, []string{
+		"dosa.CreateIfNotExists", "dosa.Read", "dosa.MultiRead", "dosa.MultiUpsert",
+		"dosa.Remove", "dosa.MultiRemove", "dosa.Search", "dosa.Scan",
+		"dosa.CheckSchema", "dosa.UpsertSchema", "dosa.CheckSchemaStatus",
+		"dosa.CreateScope", "dosa.TruncateScope", "dosa.DropScope", "dosa.ScopeExists",
+	}, names)
+
+	assert.Equal(t, 2, spans[2].Tag(tracing.RowsTag))
+	assert.Equal(t, 5, spans[7].Tag(tracing.LimitTag))
+	assert.Equal(t, "p", spans[8].Tag(tracing.NamePrefixTag))
+	assert.Equal(t, "s", spans[11].Tag(tracing.ScopeTag))
+	assert.Nil(t, spans[11].Tag(tracing.EntityTag))
+}
+
+func TestTracing_GlobalTracer(t *testing.T) {
+	tracer := mocktracer.New()
+	old := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(old)
+
+	sut := tracing.NewConnector(nil, nil)
+	err := sut.Upsert(ctx, testEi, testValues)
+	assert.Error(t, err)
+	assert.Len(t, tracer.FinishedSpans(), 1)
+	assert.Equal(t, true, tracer.FinishedSpans()[0].Tag("error"))
+	assert.Contains(t, errors.Cause(err).Error(), "no more connectors")
+}
@@ -0,0 +1,376 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package validate
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+)
+
+// Connector checks every call against the entity definition before passing
+// it to the next connector, so malformed requests fail early with a
+// dosa.ErrInvalidRequest naming the offending column instead of an opaque
+// error from the server.
+type Connector struct {
+	base.Connector
+}
+
+// NewConnector creates a validating connector in front of next
+func NewConnector(next dosa.Connector) *Connector {
+	return &Connector{Connector: base.Connector{Next: next}}
+}
+
+func invalid(column, format string, args ...interface{}) error {
+	return &dosa.ErrInvalidRequest{Column: column, Err: errors.Errorf(format, args...)}
+}
+
+func definition(ei *dosa.EntityInfo) (*dosa.EntityDefinition, error) {
+	if ei == nil || ei.Def == nil || ei.Def.Key == nil {
+		return nil, invalid("", "no entity definition")
+	}
+	return ei.Def, nil
+}
+
+// sortedNames returns the names of a row in order, so the same request
+// always reports the same column
+func sortedNames(values map[string]dosa.FieldValue) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkValue checks that a column exists and the value has its type
+func checkValue(columns map[string]dosa.Type, name string, value dosa.FieldValue) error {
+	t, ok := columns[name]
+	if !ok {
+		return invalid(name, "no such column")
+	}
+	if err := dosa.EnsureTypeMatch(t, value); err != nil {
+		return &dosa.ErrInvalidRequest{Column: name, Err: err}
+	}
+	return nil
+}
+
+// checkKeys checks that keys has a value of the right type for every key
+// column and nothing else
+func checkKeys(ed *dosa.EntityDefinition, keys map[string]dosa.FieldValue) error {
+	keySet := ed.KeySet()
+	columns := ed.ColumnTypes()
+	for _, name := range sortedNames(keys) {
+		if _, ok := keySet[name]; !ok {
+			return invalid(name, "not a key column")
+		}
+		if err := checkValue(columns, name, keys[name]); err != nil {
+			return err
+		}
+	}
+	return checkKeysPresent(ed, keys)
+}
+
+// checkKeysPresent checks that values has a value for every key column
+func checkKeysPresent(ed *dosa.EntityDefinition, values map[string]dosa.FieldValue) error {
+	for _, name := range ed.Key.PartitionKeys {
+		if _, ok := values[name]; !ok {
+			return invalid(name, "missing value for key column")
+		}
+	}
+	for _, ck := range ed.Key.ClusteringKeys {
+		if _, ok := values[ck.Name]; !ok {
+			return invalid(ck.Name, "missing value for key column")
+		}
+	}
+	return nil
+}
+
+// checkValues checks that values has a value for every key column and only
+// values of the right type for existing columns
+func checkValues(ed *dosa.EntityDefinition, values map[string]dosa.FieldValue) error {
+	columns := ed.ColumnTypes()
+	for _, name := range sortedNames(values) {
+		if err := checkValue(columns, name, values[name]); err != nil {
+			return err
+		}
+	}
+	return checkKeysPresent(ed, values)
+}
+
+// checkFields checks that fieldsToRead only names existing columns
+func checkFields(ed *dosa.EntityDefinition, fieldsToRead []string) error {
+	columns := ed.ColumnTypes()
+	for _, name := range fieldsToRead {
+		if _, ok := columns[name]; !ok {
+			return invalid(name, "cannot read unknown column")
+		}
+	}
+	return nil
+}
+
+// checkRows runs check on every row. It returns the valid rows, their
+// positions in rows, and the error of every invalid row at its position.
+func checkRows(ed *dosa.EntityDefinition, rows []map[string]dosa.FieldValue, check func(*dosa.EntityDefinition, map[string]dosa.FieldValue) error) ([]map[string]dosa.FieldValue, []int, []error) {
+	valid := make([]map[string]dosa.FieldValue, 0, len(rows))
+	positions := make([]int, 0, len(rows))
+	errs := make([]error, len(rows))
+	for i, row := range rows {
+		if err := check(ed, row); err != nil {
+			errs[i] = err
+			continue
+		}
+		valid = append(valid, row)
+		positions = append(positions, i)
+	}
+	return valid, positions, errs
+}
+
+// multiWrite validates every row and sends the valid ones to op. Invalid
+// rows get their ErrInvalidRequest in the per-row errors.
+func multiWrite(ed *dosa.EntityDefinition, rows []map[string]dosa.FieldValue, check func(*dosa.EntityDefinition, map[string]dosa.FieldValue) error, op func([]map[string]dosa.FieldValue) ([]error, error)) ([]error, error) {
+	valid, positions, errs := checkRows(ed, rows, check)
+	if len(valid) == len(rows) {
+		return op(rows)
+	}
+	if len(valid) == 0 {
+		return errs, nil
+	}
+	results, err := op(valid)
+	if err != nil {
+		return nil, err
+	}
+	for i, pos := range positions {
+		if i < len(results) {
+			errs[pos] = results[i]
+		}
+	}
+	return errs, nil
+}
+
+// CreateIfNotExists validates the values before creating the row
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	ed, err := definition(ei)
+	if err != nil {
+		return err
+	}
+	if err := checkValues(ed, values); err != nil {
+		return err
+	}
+	return c.Connector.CreateIfNotExists(ctx, ei, values)
+}
+
+// Read validates the keys and fields before reading the row
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
+	ed, err := definition(ei)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeys(ed, keys); err != nil {
+		return nil, err
+	}
+	if err := checkFields(ed, fieldsToRead); err != nil {
+		return nil, err
+	}
+	return c.Connector.Read(ctx, ei, keys, fieldsToRead)
+}
+
+// MultiRead validates the fields, and every set of keys before reading the
+// rows. Invalid keys get an ErrInvalidRequest in their result and are not
+// read.
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, fieldsToRead []string) ([]*dosa.FieldValuesOrError, error) {
+	ed, err := definition(ei)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFields(ed, fieldsToRead); err != nil {
+		return nil, err
+	}
+	valid, positions, errs := checkRows(ed, keys, checkKeys)
+	if len(valid) == len(keys) {
+		return c.Connector.MultiRead(ctx, ei, keys, fieldsToRead)
+	}
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	for i, err := range errs {
+		if err != nil {
+			results[i] = &dosa.FieldValuesOrError{Error: err}
+		}
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+	found, err := c.Connector.MultiRead(ctx, ei, valid, fieldsToRead)
+	if err != nil {
+		return nil, err
+	}
+	for i, pos := range positions {
+		if i < len(found) {
+			results[pos] = found[i]
+		}
+	}
+	return results, nil
+}
+
+// Upsert validates the values before writing the row
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	ed, err := definition(ei)
+	if err != nil {
+		return err
+	}
+	if err := checkValues(ed, values); err != nil {
+		return err
+	}
+	return c.Connector.Upsert(ctx, ei, values)
+}
+
+// MultiUpsert validates every row before writing the valid rows. Invalid
+// rows get an ErrInvalidRequest in their error.
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	ed, err := definition(ei)
+	if err != nil {
+		return nil, err
+	}
+	return multiWrite(ed, values, checkValues, func(rows []map[string]dosa.FieldValue) ([]error, error) {
+		return c.Connector.MultiUpsert(ctx, ei, rows)
+	})
+}
+
+// Remove validates the keys before removing the row
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	ed, err := definition(ei)
+	if err != nil {
+		return err
+	}
+	if err := checkKeys(ed, keys); err != nil {
+		return err
+	}
+	return c.Connector.Remove(ctx, ei, keys)
+}
+
+// MultiRemove validates every set of keys before removing the rows with
+// valid keys. Invalid keys get an ErrInvalidRequest in their error.
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	ed, err := definition(ei)
+	if err != nil {
+		return nil, err
+	}
+	return multiWrite(ed, multiKeys, checkKeys, func(rows []map[string]dosa.FieldValue) ([]error, error) {
+		return c.Connector.MultiRemove(ctx, ei, rows)
+	})
+}
+
+// Range validates the conditions and fields before reading the rows
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	ed, err := definition(ei)
+	if err != nil {
+		return nil, "", err
+	}
+	// check each column first so the error can name it
+	keySet := ed.KeySet()
+	columns := ed.ColumnTypes()
+	names := make([]string, 0, len(columnConditions))
+	for name := range columnConditions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := keySet[name]; !ok {
+			return nil, "", invalid(name, "cannot enforce condition on non-key column")
+		}
+		for _, cond := range columnConditions[name] {
+			if cond == nil {
+				return nil, "", invalid(name, "nil condition")
+			}
+			if err := checkValue(columns, name, cond.Value); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	if err := dosa.EnsureValidRangeConditions(ed, columnConditions, func(name string) string { return name }); err != nil {
+		return nil, "", &dosa.ErrInvalidRequest{Err: err}
+	}
+	if err := checkFields(ed, fieldsToRead); err != nil {
+		return nil, "", err
+	}
+	return c.Connector.Range(ctx, ei, columnConditions, fieldsToRead, token, limit)
+}
+
+// Search validates the searched value and fields before searching
+func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPairs dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	ed, err := definition(ei)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkValue(ed.ColumnTypes(), fieldPairs.Name, fieldPairs.Value); err != nil {
+		return nil, "", err
+	}
+	if !ed.FindColumnDefinition(fieldPairs.Name).IsSearchable() {
+		return nil, "", invalid(fieldPairs.Name, "column is not searchable")
+	}
+	if err := checkFields(ed, fieldsToRead); err != nil {
+		return nil, "", err
+	}
+	return c.Connector.Search(ctx, ei, fieldPairs, fieldsToRead, token, limit)
+}
+
+// Scan validates the fields before scanning
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	ed, err := definition(ei)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkFields(ed, fieldsToRead); err != nil {
+		return nil, "", err
+	}
+	return c.Connector.Scan(ctx, ei, fieldsToRead, token, limit)
+}
+
+// checkDefinitions checks that the entity definitions of a schema are valid
+func checkDefinitions(eds []*dosa.EntityDefinition) error {
+	for _, ed := range eds {
+		if ed == nil {
+			return invalid("", "nil entity definition")
+		}
+		if err := ed.EnsureValid(); err != nil {
+			return &dosa.ErrInvalidRequest{Err: err}
+		}
+	}
+	return nil
+}
+
+// CheckSchema validates the entity definitions before checking the schema
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	if err := checkDefinitions(ed); err != nil {
+		return dosa.InvalidVersion, err
+	}
+	return c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+}
+
+// UpsertSchema validates the entity definitions before upserting the schema
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	if err := checkDefinitions(ed); err != nil {
+		return nil, err
+	}
+	return c.Connector.UpsertSchema(ctx, scope, namePrefix, ed)
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package validate_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/validate"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{Scope: "scope", NamePrefix: "prefix", EntityName: "event"},
+	Def: &dosa.EntityDefinition{
+		Name: "event",
+		Key: &dosa.PrimaryKey{
+			PartitionKeys:  []string{"id"},
+			ClusteringKeys: []*dosa.ClusteringKey{{Name: "at"}},
+		},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "id", Type: dosa.TUUID},
+			{Name: "at", Type: dosa.Timestamp},
+			{Name: "count", Type: dosa.Int32},
+		},
+	},
+}
+
+var (
+	id   = dosa.UUID("3e4befa0-69d2-11e7-9ba7-aa7f2c2c5c7e")
+	at   = time.Unix(1500000000, 0)
+	keys = map[string]dosa.FieldValue{"id": id, "at": at}
+)
+
+// invalidColumn returns the column named by an ErrInvalidRequest
+func invalidColumn(t *testing.T, err error) string {
+	invalid, ok := errors.Cause(err).(*dosa.ErrInvalidRequest)
+	if !assert.True(t, ok, "unexpected error %v", err) {
+		return ""
+	}
+	return invalid.Column
+}
+
+func TestKeys(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	next := mocks.NewMockConnector(ctrl)
+	c := validate.NewConnector(next)
+
+	_, err := c.Read(ctx, testEi, map[string]dosa.FieldValue{"id": id}, nil)
+	assert.Equal(t, "at", invalidColumn(t, err))
+
+	_, err = c.Read(ctx, testEi, map[string]dosa.FieldValue{"id": id, "at": at, "count": int32(1)}, nil)
+	assert.Equal(t, "count", invalidColumn(t, err))
+	assert.Contains(t, err.Error(), "not a key column")
+
+	_, err = c.Read(ctx, testEi, map[string]dosa.FieldValue{"id": "not a uuid", "at": at}, nil)
+	assert.Equal(t, "id", invalidColumn(t, err))
+
+	_, err = c.Read(ctx, testEi, keys, []string{"count", "missing"})
+	assert.Equal(t, "missing", invalidColumn(t, err))
+
+	err = c.Remove(ctx, testEi, map[string]dosa.FieldValue{"at": at})
+	assert.Equal(t, "id", invalidColumn(t, err))
+
+	// invalid rows fail on their own, the valid ones are read
+	found := map[string]dosa.FieldValue{"count": int32(1)}
+	next.EXPECT().MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys}, nil).Return([]*dosa.FieldValuesOrError{{Values: found}}, nil)
+	results, err := c.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{{"id": id, "at": "yesterday"}, keys}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "at", invalidColumn(t, results[0].Error))
+	assert.Equal(t, found, results[1].Values)
+	results, err = c.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{{"id": id}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "at", invalidColumn(t, results[0].Error))
+	_, err = c.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys}, []string{"missing"})
+	assert.Equal(t, "missing", invalidColumn(t, err))
+
+	_, err = c.Read(ctx, &dosa.EntityInfo{Ref: testEi.Ref}, keys, nil)
+	assert.True(t, dosa.ErrorIsInvalidRequest(err))
+
+	next.EXPECT().Read(ctx, testEi, keys, []string{"count"}).Return(map[string]dosa.FieldValue{"count": int32(1)}, nil)
+	_, err = c.Read(ctx, testEi, keys, []string{"count"})
+	assert.NoError(t, err)
+
+	next.EXPECT().MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys}).Return([]error{nil}, nil)
+	_, err = c.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys})
+	assert.NoError(t, err)
+}
+
+func TestValues(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	next := mocks.NewMockConnector(ctrl)
+	c := validate.NewConnector(next)
+
+	err := c.Upsert(ctx, testEi, map[string]dosa.FieldValue{"id": id, "at": at, "count": 1})
+	assert.Equal(t, "count", invalidColumn(t, err))
+	assert.Contains(t, err.Error(), "int32")
+
+	err = c.CreateIfNotExists(ctx, testEi, map[string]dosa.FieldValue{"id": id, "count": int32(1)})
+	assert.Equal(t, "at", invalidColumn(t, err))
+
+	values := map[string]dosa.FieldValue{"id": id, "at": at, "count": int32(1)}
+	boom := errors.New("boom")
+	next.EXPECT().MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{values, values}).Return([]error{nil, boom}, nil)
+	errs, err := c.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{values, {"id": id, "at": at, "other": "x"}, values})
+	assert.NoError(t, err)
+	assert.Len(t, errs, 3)
+	assert.NoError(t, errs[0])
+	assert.Equal(t, "other", invalidColumn(t, errs[1]))
+	assert.Equal(t, boom, errs[2])
+
+	next.EXPECT().MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{values}).Return(nil, boom)
+	_, err = c.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{{"id": id}, values})
+	assert.Equal(t, boom, err)
+
+	next.EXPECT().Upsert(ctx, testEi, values).Return(nil)
+	assert.NoError(t, c.Upsert(ctx, testEi, values))
+}
+
+func TestRangeSearchScan(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	next := mocks.NewMockConnector(ctrl)
+	c := validate.NewConnector(next)
+
+	_, _, err := c.Range(ctx, testEi, map[string][]*dosa.Condition{
+		"id":    {{Op: dosa.Eq, Value: id}},
+		"count": {{Op: dosa.Gt, Value: int32(1)}},
+	}, nil, "", 10)
+	assert.Equal(t, "count", invalidColumn(t, err))
+
+	_, _, err = c.Range(ctx, testEi, map[string][]*dosa.Condition{
+		"id": {{Op: dosa.Eq, Value: id}},
+		"at": {{Op: dosa.Gt, Value: int64(1)}},
+	}, nil, "", 10)
+	assert.Equal(t, "at", invalidColumn(t, err))
+
+	_, _, err = c.Range(ctx, testEi, map[string][]*dosa.Condition{
+		"id": {{Op: dosa.Gt, Value: id}},
+	}, nil, "", 10)
+	assert.True(t, dosa.ErrorIsInvalidRequest(err))
+	assert.Contains(t, err.Error(), "partition key: id")
+
+	conditions := map[string][]*dosa.Condition{
+		"id": {{Op: dosa.Eq, Value: id}},
+		"at": {{Op: dosa.GtOrEq, Value: at}},
+	}
+	next.EXPECT().Range(ctx, testEi, conditions, nil, "", 10).Return(nil, "", nil)
+	_, _, err = c.Range(ctx, testEi, conditions, nil, "", 10)
+	assert.NoError(t, err)
+
+	_, _, err = c.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "count", Value: "1"}, nil, "", 10)
+	assert.Equal(t, "count", invalidColumn(t, err))
+
+	_, _, err = c.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "count", Value: int32(1)}, nil, "", 10)
+	assert.Equal(t, "count", invalidColumn(t, err))
+	assert.Contains(t, err.Error(), "not searchable")
+
+	searchable := *testEi
+	searchable.Def = &dosa.EntityDefinition{
+		Name: "event",
+		Key:  testEi.Def.Key,
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "id", Type: dosa.TUUID},
+			{Name: "at", Type: dosa.Timestamp},
+			{Name: "count", Type: dosa.Int32, Tags: map[string]string{dosa.SearchableTag: ""}},
+		},
+	}
+	pair := dosa.FieldNameValuePair{Name: "count", Value: int32(1)}
+	next.EXPECT().Search(ctx, &searchable, pair, nil, "", 10).Return(nil, "", nil)
+	_, _, err = c.Search(ctx, &searchable, pair, nil, "", 10)
+	assert.NoError(t, err)
+
+	_, _, err = c.Scan(ctx, testEi, []string{"nope"}, "", 10)
+	assert.Equal(t, "nope", invalidColumn(t, err))
+}
+
+func TestSchema(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	next := mocks.NewMockConnector(ctrl)
+	c := validate.NewConnector(next)
+
+	_, err := c.UpsertSchema(ctx, "scope", "prefix", []*dosa.EntityDefinition{{Name: "broken"}})
+	assert.True(t, dosa.ErrorIsInvalidRequest(err))
+
+	_, err = c.CheckSchema(ctx, "scope", "prefix", []*dosa.EntityDefinition{testEi.Def, nil})
+	assert.True(t, dosa.ErrorIsInvalidRequest(err))
+
+	eds := []*dosa.EntityDefinition{testEi.Def}
+	next.EXPECT().CheckSchema(ctx, "scope", "prefix", eds).Return(int32(1), nil)
+	version, err := c.CheckSchema(ctx, "scope", "prefix", eds)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), version)
+}
@@ -21,8 +21,11 @@
 package yarpc
 
 import (
+	"context"
+	"sort"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/uber-go/dosa"
 	dosarpc "github.com/uber/dosa-idl/.gen/dosa"
 	"reflect"
@@ -163,12 +166,50 @@ func EntityDefinitionToThrift(ed *dosa.EntityDefinition) *dosarpc.EntityDefiniti
 	fd := make(map[string]*dosarpc.FieldDesc, len(ed.Columns))
 	for _, column := range ed.Columns {
 		rpcType := RPCTypeFromClientType(column.Type)
-		fd[column.Name] = &dosarpc.FieldDesc{Type: &rpcType}
+		fd[column.Name] = &dosarpc.FieldDesc{Type: &rpcType, Tags: encodeTags(column.Tags)}
 	}
 	name := ed.Name
 	return &dosarpc.EntityDefinition{PrimaryKey: &pk, FieldDescs: fd, Name: &name}
 }
 
+// encodeTags converts the tags of a column to the RPC tags, sorted by name so
+// the same definition always gives the same request
+func encodeTags(tags map[string]string) []*dosarpc.FieldTag {
+	if len(tags) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rpcTags := make([]*dosarpc.FieldTag, len(names))
+	for i, name := range names {
+		name, value := name, tags[name]
+		rpcTags[i] = &dosarpc.FieldTag{Name: &name, Value: &value}
+	}
+	return rpcTags
+}
+
+// decodeTags converts RPC tags to the tags of a column
+func decodeTags(rpcTags []*dosarpc.FieldTag) map[string]string {
+	if len(rpcTags) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(rpcTags))
+	for _, tag := range rpcTags {
+		if tag == nil || tag.Name == nil {
+			continue
+		}
+		value := ""
+		if tag.Value != nil {
+			value = *tag.Value
+		}
+		tags[*tag.Name] = value
+	}
+	return tags
+}
+
 // FromThriftToEntityDefinition converts the RPC EntityDefinition to client EntityDefinition
 func FromThriftToEntityDefinition(ed *dosarpc.EntityDefinition) *dosa.EntityDefinition {
 	fields := make([]*dosa.ColumnDefinition, len(ed.FieldDescs))
@@ -177,7 +218,7 @@ func FromThriftToEntityDefinition(ed *dosarpc.EntityDefinition) *dosa.EntityDefi
 		fields[i] = &dosa.ColumnDefinition{
 			Name: k,
 			Type: RPCTypeToClientType(*v.Type),
-			// TODO Tag
+			Tags: decodeTags(v.Tags),
 		}
 		i++
 	}
@@ -262,3 +303,95 @@ func fieldValueMapFromClientMap(values map[string]dosa.FieldValue) dosarpc.Field
 	}
 	return fields
 }
+
+// errorFromCode converts an error code sent by the gateway into the typed
+// dosa error for it, or returns nil if the code is not known
+func errorFromCode(code int32, msg string) error {
+	switch code {
+	case ErrCodeInvalidRequest:
+		return &dosa.ErrInvalidRequest{Err: errors.New(msg)}
+	case ErrCodeNotFound:
+		return &dosa.ErrNotFound{}
+	case ErrCodeAlreadyExists:
+		return &dosa.ErrAlreadyExists{}
+	case ErrCodeSchemaMismatch:
+		return &dosa.ErrSchemaMismatch{Err: errors.New(msg)}
+	case ErrCodeTimeout, ErrCodeGatewayTimeout:
+		return &dosa.ErrTimeout{Err: errors.New(msg)}
+	case ErrCodeThrottled:
+		return &dosa.ErrThrottled{Err: errors.New(msg)}
+	case ErrCodeInternal:
+		return &dosa.ErrInternal{Err: errors.New(msg)}
+	}
+	return nil
+}
+
+// decodeError converts an error returned by the RPC client into a typed dosa
+// error. A BadRequestError is converted according to its error code, and is an
+// invalid request if the code is missing or not known. Errors that are not
+// from the gateway, such as transport errors, are returned unchanged unless
+// the deadline was exceeded.
+func decodeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch e := errors.Cause(err).(type) {
+	case *dosarpc.BadRequestError:
+		msg := "bad request"
+		if e.Message != nil {
+			msg = *e.Message
+		}
+		if e.ErrorCode != nil {
+			if typed := errorFromCode(*e.ErrorCode, msg); typed != nil {
+				return typed
+			}
+		}
+		return &dosa.ErrInvalidRequest{Err: errors.New(msg)}
+	case *dosarpc.InternalServerError:
+		msg := "internal server error"
+		if e.Message != nil {
+			msg = *e.Message
+		}
+		return &dosa.ErrInternal{Err: errors.New(msg)}
+	}
+	if errors.Cause(err) == context.DeadlineExceeded {
+		return &dosa.ErrTimeout{Err: err}
+	}
+	return err
+}
+
+// decodeRPCError converts a per-row error from the wire into a dosa error
+// using the same error codes as decodeError, so callers can use helpers like
+// dosa.ErrorIsNotFound on them. A row error without a known code is an
+// internal error, and errors the server marked with ShouldRetry are wrapped
+// in a dosa.ErrRetryable.
+func decodeRPCError(rpcErr *dosarpc.Error) error {
+	if rpcErr == nil {
+		return nil
+	}
+	msg := "unknown error"
+	if rpcErr.Msg != nil {
+		msg = *rpcErr.Msg
+	}
+	var err error
+	if rpcErr.ErrCode != nil {
+		err = errorFromCode(*rpcErr.ErrCode, msg)
+	}
+	if err == nil {
+		err = &dosa.ErrInternal{Err: errors.New(msg)}
+	}
+	if rpcErr.ShouldRetry != nil && *rpcErr.ShouldRetry {
+		return &dosa.ErrRetryable{Err: err}
+	}
+	return err
+}
+
+// decodeRPCErrors converts per-row errors from the wire, preserving order;
+// successful rows are nil
+func decodeRPCErrors(rpcErrors []*dosarpc.Error) []error {
+	results := make([]error, len(rpcErrors))
+	for i, rpcErr := range rpcErrors {
+		results[i] = decodeRPCError(rpcErr)
+	}
+	return results
+}
@@ -21,8 +21,10 @@
 package yarpc
 
 import (
+	"context"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/uber-go/dosa"
 	dosarpc "github.com/uber/dosa-idl/.gen/dosa"
@@ -129,6 +131,7 @@ var testEntityDefinition = &dosa.EntityDefinition{
 		{
 			Name: stringField,
 			Type: dosa.String,
+			Tags: map[string]string{dosa.SearchableTag: ""},
 		},
 		{
 			Name: int64Field,
@@ -170,3 +173,56 @@ func TestEncodeOperator(t *testing.T) {
 		assert.Equal(t, test.rpcop, *encodeOperator(test.dop))
 	}
 }
+
+func TestDecodeError(t *testing.T) {
+	code := func(c int32) *int32 { return &c }
+	msg := "message"
+	data := []struct {
+		err error
+		is  func(error) bool
+	}{
+		{err: &dosarpc.BadRequestError{Message: &msg, ErrorCode: code(400)}, is: dosa.ErrorIsInvalidRequest},
+		{err: &dosarpc.BadRequestError{Message: &msg, ErrorCode: code(404)}, is: dosa.ErrorIsNotFound},
+		{err: &dosarpc.BadRequestError{Message: &msg, ErrorCode: code(408)}, is: dosa.ErrorIsTimeout},
+		{err: &dosarpc.BadRequestError{Message: &msg, ErrorCode: code(409)}, is: dosa.ErrorIsAlreadyExists},
+		{err: &dosarpc.BadRequestError{Message: &msg, ErrorCode: code(412)}, is: dosa.ErrorIsSchemaMismatch},
+		{err: &dosarpc.BadRequestError{Message: &msg, ErrorCode: code(429)}, is: dosa.ErrorIsThrottled},
+		{err: &dosarpc.BadRequestError{Message: &msg, ErrorCode: code(500)}, is: dosa.ErrorIsInternal},
+		{err: &dosarpc.BadRequestError{Message: &msg, ErrorCode: code(504)}, is: dosa.ErrorIsTimeout},
+		{err: &dosarpc.BadRequestError{Message: &msg, ErrorCode: code(418)}, is: dosa.ErrorIsInvalidRequest},
+		{err: &dosarpc.BadRequestError{Message: &msg}, is: dosa.ErrorIsInvalidRequest},
+		{err: &dosarpc.InternalServerError{Message: &msg}, is: dosa.ErrorIsInternal},
+		{err: errors.Wrap(context.DeadlineExceeded, "message"), is: dosa.ErrorIsTimeout},
+	}
+	for _, test := range data {
+		err := decodeError(test.err)
+		assert.True(t, test.is(err), "%T: %v", test.err, err)
+		if !dosa.ErrorIsNotFound(err) && !dosa.ErrorIsAlreadyExists(err) {
+			assert.Contains(t, err.Error(), msg)
+		}
+	}
+
+	assert.NoError(t, decodeError(nil))
+	assert.Equal(t, context.Canceled, decodeError(context.Canceled))
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(decodeError(context.DeadlineExceeded)))
+	assert.True(t, dosa.ErrorIsInternal(decodeError(&dosarpc.InternalServerError{})))
+}
+
+func TestDecodeRPCError(t *testing.T) {
+	code := func(c int32) *int32 { return &c }
+	msg := "message"
+	retry := true
+
+	assert.Nil(t, decodeRPCError(nil))
+	assert.True(t, dosa.ErrorIsNotFound(decodeRPCError(&dosarpc.Error{ErrCode: code(404)})))
+	assert.True(t, dosa.ErrorIsThrottled(decodeRPCError(&dosarpc.Error{ErrCode: code(429), Msg: &msg})))
+	assert.True(t, dosa.ErrorIsSchemaMismatch(decodeRPCError(&dosarpc.Error{ErrCode: code(412), Msg: &msg})))
+
+	err := decodeRPCError(&dosarpc.Error{Msg: &msg})
+	assert.True(t, dosa.ErrorIsInternal(err))
+	assert.EqualError(t, err, "internal error: message")
+
+	err = decodeRPCError(&dosarpc.Error{ErrCode: code(429), Msg: &msg, ShouldRetry: &retry})
+	assert.True(t, dosa.ErrorIsRetryable(err))
+	assert.EqualError(t, err, "retryable error: throttled: message")
+}
@@ -26,6 +26,7 @@ import (
 
 	"os"
 
+	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/uber-go/dosa"
 	"github.com/uber-go/dosa/connectors/base"
@@ -46,6 +47,9 @@ type Config struct {
 	Port        string `yaml:"port"`
 	CallerName  string `yaml:"callerName"`
 	ServiceName string `yaml:"serviceName"`
+	// Tracer is used by the transport to send the span found in the request
+	// context to the gateway; the global tracer is used when it is nil
+	Tracer opentracing.Tracer `yaml:"-"`
 }
 
 // Connector holds the client-side RPC interface and some schema information
@@ -114,10 +118,15 @@ func NewConnector(cfg *Config) (*Connector, error) {
 		cfg.ServiceName = _defaultServiceName
 	}
 
+	tracer := cfg.Tracer
+	if tracer == nil {
+		tracer = opentracing.GlobalTracer()
+	}
+
 	switch cfg.Transport {
 	case "http":
 		uri := fmt.Sprintf("http://%s:%s", cfg.Host, cfg.Port)
-		ts := http.NewTransport()
+		ts := http.NewTransport(http.Tracer(tracer))
 		ycfg.Outbounds = rpc.Outbounds{
 			cfg.ServiceName: {
 				Unary: ts.NewSingleOutbound(uri),
@@ -128,7 +137,7 @@ func NewConnector(cfg *Config) (*Connector, error) {
 		// this looks wrong, BUT since it's a uni-directional tchannel
 		// connection, we have to pass CallerName as the tchannel "ServiceName"
 		// for source/destination to be reported correctly by RPC layer.
-		ts, err := tchannel.NewChannelTransport(tchannel.ServiceName(cfg.CallerName))
+		ts, err := tchannel.NewChannelTransport(tchannel.ServiceName(cfg.CallerName), tchannel.Tracer(tracer))
 		if err != nil {
 			return nil, err
 		}
@@ -155,20 +164,30 @@ func NewConnector(cfg *Config) (*Connector, error) {
 	}, nil
 }
 
+// withSchema runs an entity operation, returning its decoded error. When the
+// gateway does not know the schema of the entity, for example because it
+// restarted since the client checked it, the entity is registered again with
+// CheckSchema and the operation runs once more.
+func (c *Connector) withSchema(ctx context.Context, ei *dosa.EntityInfo, op func() error) error {
+	err := decodeError(op())
+	if !dosa.ErrorIsSchemaMismatch(err) || ei == nil || ei.Ref == nil || ei.Def == nil {
+		return err
+	}
+	if _, cerr := c.CheckSchema(ctx, ei.Ref.Scope, ei.Ref.NamePrefix, []*dosa.EntityDefinition{ei.Def}); cerr != nil {
+		return err
+	}
+	return decodeError(op())
+}
+
 // CreateIfNotExists ...
 func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
 	createRequest := dosarpc.CreateRequest{
 		Ref:          entityInfoToSchemaRef(ei),
 		EntityValues: fieldValueMapFromClientMap(values),
 	}
-	err := c.Client.CreateIfNotExists(ctx, &createRequest)
-	if err != nil {
-		if be, ok := err.(*dosarpc.BadRequestError); ok {
-			if be.ErrorCode != nil && *be.ErrorCode == errCodeAlreadyExists {
-				return errors.Wrap(&dosa.ErrAlreadyExists{}, "failed to create")
-			}
-		}
-	}
+	err := c.withSchema(ctx, ei, func() error {
+		return c.Client.CreateIfNotExists(ctx, &createRequest)
+	})
 	return errors.Wrap(err, "failed to create")
 }
 
@@ -178,7 +197,10 @@ func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[
 		Ref:          entityInfoToSchemaRef(ei),
 		EntityValues: fieldValueMapFromClientMap(values),
 	}
-	return c.Client.Upsert(ctx, &upsertRequest)
+	err := c.withSchema(ctx, ei, func() error {
+		return c.Client.Upsert(ctx, &upsertRequest)
+	})
+	return errors.Wrap(err, "YARPC Upsert failed")
 }
 
 // Read reads a single entity
@@ -206,13 +228,12 @@ func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[stri
 		FieldsToRead: rpcFieldsToRead,
 	}
 
-	response, err := c.Client.Read(ctx, readRequest)
+	var response *dosarpc.ReadResponse
+	err := c.withSchema(ctx, ei, func() (err error) {
+		response, err = c.Client.Read(ctx, readRequest)
+		return err
+	})
 	if err != nil {
-		if be, ok := err.(*dosarpc.BadRequestError); ok {
-			if be.ErrorCode != nil && *be.ErrorCode == errCodeNotFound {
-				return nil, errors.Wrap(&dosa.ErrNotFound{}, "failed to read in yarpc connector")
-			}
-		}
 		return nil, errors.Wrap(err, "failed to read in yarpc connector")
 	}
 
@@ -243,7 +264,11 @@ func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []m
 		FieldsToRead: rpcFieldsToRead,
 	}
 
-	response, err := c.Client.MultiRead(ctx, request)
+	var response *dosarpc.MultiReadResponse
+	err := c.withSchema(ctx, ei, func() (err error) {
+		response, err = c.Client.MultiRead(ctx, request)
+		return err
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "YARPC MultiRead failed")
 	}
@@ -261,17 +286,37 @@ func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []m
 			}
 		}
 		if rpcResult.Error != nil {
-			// TODO check other fields in the thrift error object such as ShouldRetry
-			results[i].Error = errors.New(*rpcResult.Error.Msg)
+			results[i].Error = decodeRPCError(rpcResult.Error)
 		}
 	}
 
 	return results, nil
 }
 
-// MultiUpsert is not yet implemented
+// MultiUpsert upserts multiple entities at one time
 func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
-	panic("not implemented")
+	// convert each entity from the client's map to RPC's FieldValueMap
+	entities := make([]dosarpc.FieldValueMap, len(multiValues))
+	for i, values := range multiValues {
+		entities[i] = fieldValueMapFromClientMap(values)
+	}
+
+	// perform the multi upsert request
+	request := &dosarpc.MultiUpsertRequest{
+		Ref:      entityInfoToSchemaRef(ei),
+		Entities: entities,
+	}
+
+	var response *dosarpc.MultiUpsertResponse
+	err := c.withSchema(ctx, ei, func() (err error) {
+		response, err = c.Client.MultiUpsert(ctx, request)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "YARPC MultiUpsert failed")
+	}
+
+	return decodeRPCErrors(response.Errors), nil
 }
 
 // Remove marshals a request to the YaRPC remove call
@@ -289,7 +334,9 @@ func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[st
 		KeyValues: rpcFields,
 	}
 
-	err := c.Client.Remove(ctx, removeRequest)
+	err := c.withSchema(ctx, ei, func() error {
+		return c.Client.Remove(ctx, removeRequest)
+	})
 	if err != nil {
 		return errors.Wrap(err, "YARPC Remove failed")
 	}
@@ -297,9 +344,30 @@ func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[st
 
 }
 
-// MultiRemove is not yet implemented
+// MultiRemove removes multiple entities at one time
 func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
-	panic("not implemented")
+	// convert the keys of each entity to RPC's FieldValueMap
+	keyValues := make([]dosarpc.FieldValueMap, len(multiKeys))
+	for i, keys := range multiKeys {
+		keyValues[i] = fieldValueMapFromClientMap(keys)
+	}
+
+	// perform the multi remove request
+	request := &dosarpc.MultiRemoveRequest{
+		Ref:       entityInfoToSchemaRef(ei),
+		KeyValues: keyValues,
+	}
+
+	var response *dosarpc.MultiRemoveResponse
+	err := c.withSchema(ctx, ei, func() (err error) {
+		response, err = c.Client.MultiRemove(ctx, request)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "YARPC MultiRemove failed")
+	}
+
+	return decodeRPCErrors(response.Errors), nil
 }
 
 // Range does a scan across a range
@@ -325,7 +393,11 @@ func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnCondit
 		Conditions:   rpcConditions,
 		FieldsToRead: rpcFieldsToRead,
 	}
-	response, err := c.Client.Range(ctx, &rangeRequest)
+	var response *dosarpc.RangeResponse
+	err := c.withSchema(ctx, ei, func() (err error) {
+		response, err = c.Client.Range(ctx, &rangeRequest)
+		return err
+	})
 	if err != nil {
 		return nil, "", errors.Wrap(err, "YARPC Range failed")
 	}
@@ -336,9 +408,31 @@ func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnCondit
 	return results, *response.NextToken, nil
 }
 
-// Search is not yet implemented
+// Search marshals a search request into YaRPC
 func (c *Connector) Search(ctx context.Context, ei *dosa.EntityInfo, fieldPairs dosa.FieldNameValuePair, fieldsToRead []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
-	panic("not implemented")
+	limit32 := int32(limit)
+	rpcFieldsToRead := makeRPCFieldsToRead(fieldsToRead)
+	fieldName := fieldPairs.Name
+	searchRequest := dosarpc.SearchRequest{
+		Ref:          entityInfoToSchemaRef(ei),
+		Token:        &token,
+		Limit:        &limit32,
+		SearchBy:     &dosarpc.Field{Name: &fieldName, Value: &dosarpc.Value{ElemValue: RawValueFromInterface(fieldPairs.Value)}},
+		FieldsToRead: rpcFieldsToRead,
+	}
+	var response *dosarpc.SearchResponse
+	err := c.withSchema(ctx, ei, func() (err error) {
+		response, err = c.Client.Search(ctx, &searchRequest)
+		return err
+	})
+	if err != nil {
+		return nil, "", errors.Wrap(err, "YARPC Search failed")
+	}
+	results := []map[string]dosa.FieldValue{}
+	for _, entity := range response.Entities {
+		results = append(results, decodeResults(ei, entity))
+	}
+	return results, *response.NextToken, nil
 }
 
 // Scan marshals a scan request into YaRPC
@@ -351,7 +445,11 @@ func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, fieldsToRead
 		Limit:        &limit32,
 		FieldsToRead: rpcFieldsToRead,
 	}
-	response, err := c.Client.Scan(ctx, &scanRequest)
+	var response *dosarpc.ScanResponse
+	err := c.withSchema(ctx, ei, func() (err error) {
+		response, err = c.Client.Scan(ctx, &scanRequest)
+		return err
+	})
 	if err != nil {
 		return nil, "", errors.Wrap(err, "YARPC Scan failed")
 	}
@@ -375,7 +473,7 @@ func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, e
 	response, err := c.Client.CheckSchema(ctx, &csr)
 
 	if err != nil {
-		return dosa.InvalidVersion, errors.Wrap(err, "YARPC CheckSchema failed")
+		return dosa.InvalidVersion, errors.Wrap(decodeError(err), "YARPC CheckSchema failed")
 	}
 
 	return *response.Version, nil
@@ -396,7 +494,7 @@ func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string,
 
 	response, err := c.Client.UpsertSchema(ctx, request)
 	if err != nil {
-		return nil, errors.Wrap(err, "YARPC UpsertSchema failed")
+		return nil, errors.Wrap(decodeError(err), "YARPC UpsertSchema failed")
 	}
 
 	status := ""
@@ -421,7 +519,7 @@ func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix str
 	response, err := c.Client.CheckSchemaStatus(ctx, &request)
 
 	if err != nil {
-		return nil, errors.Wrap(err, "YARPC ChecksShemaStatus failed")
+		return nil, errors.Wrap(decodeError(err), "YARPC ChecksShemaStatus failed")
 	}
 
 	status := ""
@@ -439,14 +537,15 @@ func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix str
 	}, nil
 }
 
-// CreateScope creates the scope specified
+// CreateScope creates the scope specified. The gateway API has no owner
+// field, so the owner set with dosa.WithScopeOwner is not sent.
 func (c *Connector) CreateScope(ctx context.Context, scope string) error {
 	request := &dosarpc.CreateScopeRequest{
 		Name: &scope,
 	}
 
 	if err := c.Client.CreateScope(ctx, request); err != nil {
-		return errors.Wrap(err, "YARPC CreateScope failed")
+		return errors.Wrap(decodeError(err), "YARPC CreateScope failed")
 	}
 
 	return nil
@@ -459,7 +558,7 @@ func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
 	}
 
 	if err := c.Client.TruncateScope(ctx, request); err != nil {
-		return errors.Wrap(err, "YARPC TruncateScope failed")
+		return errors.Wrap(decodeError(err), "YARPC TruncateScope failed")
 	}
 
 	return nil
@@ -472,15 +571,30 @@ func (c *Connector) DropScope(ctx context.Context, scope string) error {
 	}
 
 	if err := c.Client.DropScope(ctx, request); err != nil {
-		return errors.Wrap(err, "YARPC DropScope failed")
+		return errors.Wrap(decodeError(err), "YARPC DropScope failed")
 	}
 
 	return nil
 }
 
-// ScopeExists is not implemented yet
+// ScopeExists checks whether the scope specified exists
 func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
-	panic("not implemented")
+	request := &dosarpc.ScopeExistsRequest{
+		Name: &scope,
+	}
+
+	response, err := c.Client.ScopeExists(ctx, request)
+	if err != nil {
+		return false, errors.Wrap(decodeError(err), "YARPC ScopeExists failed")
+	}
+
+	return response.Exists != nil && *response.Exists, nil
+}
+
+// ListScopes is not supported: the gateway API has no operation to list
+// scopes, and CreateScope does not send the scope owner to the gateway
+func (c *Connector) ListScopes(ctx context.Context, owner string) ([]string, error) {
+	return nil, errors.New("ListScopes is not supported by the yarpc connector: the gateway API cannot list scopes")
 }
 
 // Shutdown stops the dispatcher and drains client
@@ -529,7 +643,15 @@ func init() {
 	})
 }
 
+// Error codes sent by the gateway in BadRequestError and in per-row errors.
+// The connector converts them into the typed dosa errors.
 const (
-	errCodeNotFound      int32 = 404
-	errCodeAlreadyExists int32 = 409
+	ErrCodeInvalidRequest int32 = 400
+	ErrCodeNotFound       int32 = 404
+	ErrCodeTimeout        int32 = 408
+	ErrCodeAlreadyExists  int32 = 409
+	ErrCodeSchemaMismatch int32 = 412
+	ErrCodeThrottled      int32 = 429
+	ErrCodeInternal       int32 = 500
+	ErrCodeGatewayTimeout int32 = 504
 )
@@ -399,6 +399,30 @@ func TestYaRPCClient_MultiRead(t *testing.T) {
 	ctrl.Finish()
 }
 
+func TestYaRPCClient_MultiReadRetryable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockedClient := dosatest.NewMockClient(ctrl)
+	sut := yarpc.Connector{Client: mockedClient}
+
+	notFound := int32(404)
+	mockedClient.EXPECT().MultiRead(ctx, gomock.Any()).Return(&drpc.MultiReadResponse{
+		Results: []*drpc.EntityOrError{
+			{Error: &drpc.Error{Msg: testStringPtr("overloaded"), ShouldRetry: testBoolPtr(true)}},
+			{Error: &drpc.Error{ErrCode: &notFound, ShouldRetry: testBoolPtr(false)}},
+		},
+	}, nil)
+
+	values, err := sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{{"f1": dosa.FieldValue(int64(5))}, {"f2": dosa.FieldValue(int64(6))}}, []string{"f1"})
+	assert.NoError(t, err)
+	assert.Len(t, values, 2)
+	assert.True(t, dosa.ErrorIsRetryable(values[0].Error))
+	assert.Contains(t, values[0].Error.Error(), "overloaded")
+	assert.False(t, dosa.ErrorIsRetryable(values[1].Error))
+	assert.True(t, dosa.ErrorIsNotFound(values[1].Error))
+
+	ctrl.Finish()
+}
+
 func TestYaRPCClient_CreateIfNotExists(t *testing.T) {
 	// build a mock RPC client
 	ctrl := gomock.NewController(t)
@@ -630,6 +654,36 @@ func TestClient_DropScope(t *testing.T) {
 	assert.Contains(t, err.Error(), "test error")
 }
 
+func TestClient_ScopeExists(t *testing.T) {
+	// build a mock RPC client
+	ctrl := gomock.NewController(t)
+	mockedClient := dosatest.NewMockClient(ctrl)
+	sut := yarpc.Connector{Client: mockedClient}
+
+	scope := "scope"
+	exists := true
+	mockedClient.EXPECT().ScopeExists(ctx, &drpc.ScopeExistsRequest{Name: &scope}).Return(&drpc.ScopeExistsResponse{Exists: &exists}, nil)
+	result, err := sut.ScopeExists(ctx, scope)
+	assert.NoError(t, err)
+	assert.True(t, result)
+
+	mockedClient.EXPECT().ScopeExists(ctx, gomock.Any()).Return(&drpc.ScopeExistsResponse{}, nil)
+	result, err = sut.ScopeExists(ctx, scope)
+	assert.NoError(t, err)
+	assert.False(t, result)
+
+	mockedClient.EXPECT().ScopeExists(ctx, gomock.Any()).Return(nil, errors.New("test error"))
+	_, err = sut.ScopeExists(ctx, scope)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "test error")
+}
+
+func TestClient_ListScopes(t *testing.T) {
+	sut := yarpc.Connector{}
+	_, err := sut.ListScopes(ctx, "")
+	assert.Contains(t, err.Error(), "not supported")
+}
+
 func TestConnector_Range(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -805,26 +859,229 @@ func TestConnector_Remove(t *testing.T) {
 	ctrl.Finish()
 }
 
-// TestPanic is an unimplemented method test for coverage, remove these as they are implemented
-func TestPanic(t *testing.T) {
+func TestConnector_MultiUpsert(t *testing.T) {
+	// build a mock RPC client
 	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
 	mockedClient := dosatest.NewMockClient(ctrl)
 
+	// set up the parameters
+	multiUpsertRequest := &drpc.MultiUpsertRequest{
+		Ref: &testRPCSchemaRef,
+		Entities: []drpc.FieldValueMap{
+			{"c1": {ElemValue: &drpc.RawValue{Int64Value: testInt64Ptr(1)}}},
+			{"c1": {ElemValue: &drpc.RawValue{Int64Value: testInt64Ptr(2)}}},
+			{"c1": {ElemValue: &drpc.RawValue{Int64Value: testInt64Ptr(3)}}},
+		},
+	}
+	errCode := int32(404)
+	mockedClient.EXPECT().MultiUpsert(ctx, multiUpsertRequest).Return(&drpc.MultiUpsertResponse{
+		Errors: []*drpc.Error{
+			nil,
+			{ErrCode: &errCode, Msg: testStringPtr("not found")},
+			{Msg: testStringPtr("test row error")},
+		},
+	}, nil)
+
+	// Prepare the dosa client interface using the mocked RPC layer
 	sut := yarpc.Connector{Client: mockedClient}
 
-	assert.Panics(t, func() {
-		sut.MultiUpsert(ctx, testEi, nil)
-	})
+	// perform the multi upsert
+	multiValues := []map[string]dosa.FieldValue{{"c1": int64(1)}, {"c1": int64(2)}, {"c1": int64(3)}}
+	errs, err := sut.MultiUpsert(ctx, testEi, multiValues)
+	assert.NoError(t, err)
+	assert.Len(t, errs, 3)
+	assert.Nil(t, errs[0])
+	assert.True(t, dosa.ErrorIsNotFound(errs[1]))
+	assert.Contains(t, errs[2].Error(), "test row error")
 
-	assert.Panics(t, func() {
-		sut.MultiRemove(ctx, testEi, nil)
-	})
+	// failed call, return error
+	mockedClient.EXPECT().MultiUpsert(ctx, gomock.Any()).Return(nil, errors.New("test error"))
+	errs, err = sut.MultiUpsert(ctx, testEi, multiValues)
+	assert.Nil(t, errs)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "test error")
+}
 
-	assert.Panics(t, func() {
-		sut.Search(ctx, testEi, dosa.FieldNameValuePair{}, nil, "", 0)
-	})
+func TestConnector_MultiRemove(t *testing.T) {
+	// build a mock RPC client
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockedClient := dosatest.NewMockClient(ctrl)
 
-	assert.Panics(t, func() {
-		sut.ScopeExists(ctx, "")
-	})
+	// set up the parameters
+	multiRemoveRequest := &drpc.MultiRemoveRequest{
+		Ref: &testRPCSchemaRef,
+		KeyValues: []drpc.FieldValueMap{
+			{"f1": {ElemValue: &drpc.RawValue{Int64Value: testInt64Ptr(5)}}},
+			{"f1": {ElemValue: &drpc.RawValue{Int64Value: testInt64Ptr(6)}}},
+		},
+	}
+	errCode := int32(404)
+	mockedClient.EXPECT().MultiRemove(ctx, multiRemoveRequest).Return(&drpc.MultiRemoveResponse{
+		Errors: []*drpc.Error{nil, {ErrCode: &errCode, Msg: testStringPtr("not found")}},
+	}, nil)
+
+	// Prepare the dosa client interface using the mocked RPC layer
+	sut := yarpc.Connector{Client: mockedClient}
+
+	// perform the multi remove
+	multiKeys := []map[string]dosa.FieldValue{{"f1": int64(5)}, {"f1": int64(6)}}
+	errs, err := sut.MultiRemove(ctx, testEi, multiKeys)
+	assert.NoError(t, err)
+	assert.Len(t, errs, 2)
+	assert.Nil(t, errs[0])
+	assert.True(t, dosa.ErrorIsNotFound(errs[1]))
+
+	// failed call, return error
+	mockedClient.EXPECT().MultiRemove(ctx, gomock.Any()).Return(nil, errors.New("test error"))
+	errs, err = sut.MultiRemove(ctx, testEi, multiKeys)
+	assert.Nil(t, errs)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "test error")
+}
+
+func TestConnector_Search(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockedClient := dosatest.NewMockClient(ctrl)
+
+	testToken := "testToken"
+	responseToken := "responseToken"
+	testLimit := int32(32)
+	fieldName := "c1"
+	field := drpc.Field{Name: &fieldName, Value: &drpc.Value{ElemValue: &drpc.RawValue{Int64Value: testInt64Ptr(10)}}}
+
+	// Prepare the dosa client interface using the mocked RPC layer
+	sut := yarpc.Connector{Client: mockedClient}
+
+	// successful call, return results
+	mockedClient.EXPECT().Search(ctx, gomock.Any()).Do(func(_ context.Context, request *drpc.SearchRequest) {
+		assert.Equal(t, map[string]struct{}{"c1": {}}, request.FieldsToRead)
+		assert.Equal(t, testLimit, *request.Limit)
+		assert.Equal(t, testRPCSchemaRef, *request.Ref)
+		assert.Equal(t, testToken, *request.Token)
+		assert.Equal(t, &field, request.SearchBy)
+	}).Return(&drpc.SearchResponse{
+		Entities: []drpc.FieldValueMap{
+			{
+				"c1": {ElemValue: &drpc.RawValue{Int64Value: testInt64Ptr(10)}},
+				"c2": {ElemValue: &drpc.RawValue{DoubleValue: testFloat64Ptr(2.2)}},
+			},
+		},
+		NextToken: &responseToken,
+	}, nil)
+
+	values, token, err := sut.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "c1", Value: int64(10)}, []string{"c1"}, testToken, 32)
+	assert.NoError(t, err)
+	assert.Equal(t, responseToken, token)
+	assert.Equal(t, 1, len(values))
+	assert.Equal(t, int64(10), values[0]["c1"])
+	assert.Equal(t, float64(2.2), values[0]["c2"])
+
+	// failed call, return error
+	mockedClient.EXPECT().Search(ctx, gomock.Any()).Return(nil, errors.New("test error"))
+	values, token, err = sut.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "c1", Value: int64(10)}, nil, "", 64)
+	assert.Nil(t, values)
+	assert.Empty(t, token)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "test error")
+}
+
+// testSimpleEi has no custom object column, so its schema can be checked
+var testSimpleEi = &dosa.EntityInfo{
+	Ref: &testSchemaRef,
+	Def: &dosa.EntityDefinition{
+		Columns: []*dosa.ColumnDefinition{{Name: "c1", Type: dosa.Int64}},
+		Key:     &dosa.PrimaryKey{PartitionKeys: []string{"c1"}},
+		Name:    "t1",
+	},
+}
+
+func TestConnector_SchemaNotRegistered(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockedClient := dosatest.NewMockClient(ctrl)
+	sut := yarpc.Connector{Client: mockedClient}
+
+	// the gateway lost the schema, for example because it restarted: the
+	// schema is checked again and the operation retried
+	code := yarpc.ErrCodeSchemaMismatch
+	v := int32(12345)
+	values := map[string]dosa.FieldValue{"c1": int64(1)}
+	gomock.InOrder(
+		mockedClient.EXPECT().Read(ctx, gomock.Any()).Return(nil, &drpc.BadRequestError{ErrorCode: &code}),
+		mockedClient.EXPECT().CheckSchema(ctx, gomock.Any()).Do(func(_ context.Context, request *drpc.CheckSchemaRequest) {
+			assert.Equal(t, "scope1", *request.Scope)
+			assert.Equal(t, "namePrefix", *request.NamePrefix)
+			assert.Len(t, request.EntityDefs, 1)
+		}).Return(&drpc.CheckSchemaResponse{Version: &v}, nil),
+		mockedClient.EXPECT().Read(ctx, gomock.Any()).Return(&drpc.ReadResponse{EntityValues: drpc.FieldValueMap{
+			"c1": {ElemValue: &drpc.RawValue{Int64Value: testInt64Ptr(1)}},
+		}}, nil),
+	)
+	result, err := sut.Read(ctx, testSimpleEi, values, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, values, result)
+
+	// the operation is retried once only
+	gomock.InOrder(
+		mockedClient.EXPECT().MultiUpsert(ctx, gomock.Any()).Return(nil, &drpc.BadRequestError{ErrorCode: &code}),
+		mockedClient.EXPECT().CheckSchema(ctx, gomock.Any()).Return(&drpc.CheckSchemaResponse{Version: &v}, nil),
+		mockedClient.EXPECT().MultiUpsert(ctx, gomock.Any()).Return(nil, &drpc.BadRequestError{ErrorCode: &code}),
+	)
+	_, err = sut.MultiUpsert(ctx, testSimpleEi, []map[string]dosa.FieldValue{values})
+	assert.True(t, dosa.ErrorIsSchemaMismatch(err))
+}
+
+func TestConnector_TypedErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockedClient := dosatest.NewMockClient(ctrl)
+	sut := yarpc.Connector{Client: mockedClient}
+
+	code := func(c int32) *int32 { return &c }
+	msg := "test error"
+
+	// a schema mismatch is returned when checking the schema again fails
+	gomock.InOrder(
+		mockedClient.EXPECT().Upsert(ctx, gomock.Any()).Return(&drpc.BadRequestError{Message: &msg, ErrorCode: code(412)}),
+		mockedClient.EXPECT().CheckSchema(ctx, gomock.Any()).Return(nil, errors.New("check failed")),
+	)
+	err := sut.Upsert(ctx, testSimpleEi, map[string]dosa.FieldValue{"c1": int64(1)})
+	assert.True(t, dosa.ErrorIsSchemaMismatch(err))
+	assert.Contains(t, err.Error(), msg)
+
+	mockedClient.EXPECT().Remove(ctx, gomock.Any()).Return(&drpc.BadRequestError{Message: &msg, ErrorCode: code(429)})
+	err = sut.Remove(ctx, testEi, map[string]dosa.FieldValue{"c1": int64(1)})
+	assert.True(t, dosa.ErrorIsThrottled(err))
+
+	mockedClient.EXPECT().Range(ctx, gomock.Any()).Return(nil, &drpc.BadRequestError{Message: &msg})
+	_, _, err = sut.Range(ctx, testEi, nil, nil, "", 10)
+	assert.True(t, dosa.ErrorIsInvalidRequest(err))
+
+	mockedClient.EXPECT().Scan(ctx, gomock.Any()).Return(nil, &drpc.InternalServerError{Message: &msg})
+	_, _, err = sut.Scan(ctx, testEi, nil, "", 10)
+	assert.True(t, dosa.ErrorIsInternal(err))
+
+	mockedClient.EXPECT().CheckSchema(ctx, gomock.Any()).Return(nil, errors.Wrap(context.DeadlineExceeded, msg))
+	_, err = sut.CheckSchema(ctx, "scope", "prefix", nil)
+	assert.True(t, dosa.ErrorIsTimeout(err))
+
+	mockedClient.EXPECT().MultiRead(ctx, gomock.Any()).Return(&drpc.MultiReadResponse{
+		Results: []*drpc.EntityOrError{
+			{Error: &drpc.Error{Msg: &msg}},
+			{Error: &drpc.Error{ErrCode: code(408), Msg: &msg}},
+		},
+	}, nil)
+	results, err := sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{{"c1": int64(1)}, {"c1": int64(2)}}, nil)
+	assert.NoError(t, err)
+	assert.True(t, dosa.ErrorIsInternal(results[0].Error))
+	assert.Contains(t, results[0].Error.Error(), msg)
+	assert.True(t, dosa.ErrorIsTimeout(results[1].Error))
+
+	// transport errors are passed through
+	mockedClient.EXPECT().DropScope(ctx, gomock.Any()).Return(errors.New(msg))
+	err = sut.DropScope(ctx, "scope")
+	assert.EqualError(t, err, "YARPC DropScope failed: test error")
 }
@@ -94,12 +94,27 @@ func (pk PrimaryKey) String() string {
 type ColumnDefinition struct {
 	Name string // normalized column name
 	Type Type
-	// TODO: change as need to support tags like pii, searchable, etc
+	// TODO: change as need to support tags like pii, etc
 	// currently it's in the form of a map from tag name to (optional) tag value
 	Tags map[string]string
 	CustomType reflect.Type
 }
 
+// SearchableTag is the tag name recorded in ColumnDefinition.Tags for fields
+// annotated with `dosa:"searchable"`
+const SearchableTag = "searchable"
+
+// IsSearchable returns true if the column has been marked "searchable"
+func (cd *ColumnDefinition) IsSearchable() bool {
+	_, ok := cd.Tags[SearchableTag]
+	return ok
+}
+
+// CacheTag is the tag name recorded in ColumnDefinition.Tags for fields
+// annotated with `dosa:"cache"`. Caching connectors cache the rows of
+// entities with a column carrying this tag.
+const CacheTag = "cache"
+
 // EntityDefinition stores information about a DOSA entity
 type EntityDefinition struct {
 	Name    string // normalized entity name
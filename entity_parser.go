@@ -45,6 +45,9 @@ var (
 	primaryKeyPattern3 = regexp.MustCompile(`^\s*([^(),\s]+)\s*$`)
 
 	namePattern0 = regexp.MustCompile(`name\s*=\s*(\S*)`)
+
+	searchablePattern0 = regexp.MustCompile(`(^|\s)searchable(\s|$)`)
+	cachePattern0      = regexp.MustCompile(`(^|\s)cache(\s|$)`)
 )
 
 // parseClusteringKeys func parses the clustering key of DOSA object
@@ -310,11 +313,34 @@ func parseField(typ Type, name string, tag string) (*ColumnDefinition, error) {
 	}
 
 	tag = strings.Replace(tag, fullNameTag, "", 1)
+
+	// parse the searchable and cache tags
+	var tags map[string]string
+	for _, flag := range []struct {
+		name    string
+		pattern *regexp.Regexp
+	}{
+		{SearchableTag, searchablePattern0},
+		{CacheTag, cachePattern0},
+	} {
+		if loc := flag.pattern.FindStringIndex(tag); loc != nil {
+			if tags == nil {
+				tags = map[string]string{}
+			}
+			tags[flag.name] = ""
+			tag = tag[:loc[0]] + " " + tag[loc[1]:]
+		}
+	}
+
 	if strings.TrimSpace(tag) != "" {
 		return nil, fmt.Errorf("field %s with an invalid dosa field tag: %s", name, tag)
 	}
 
-	return &ColumnDefinition{Name: name, Type: typ}, nil
+	if _, ok := tags[SearchableTag]; ok && typ == CustomObject {
+		return nil, fmt.Errorf("field %s of a custom object type cannot be searchable", name)
+	}
+
+	return &ColumnDefinition{Name: name, Type: typ, Tags: tags}, nil
 }
 
 var (
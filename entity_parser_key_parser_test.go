@@ -392,6 +392,43 @@ func TestFieldParse(t *testing.T) {
 			Tag:         "name=x name=0",
 			Error:       errors.New("invalid dosa field tag"),
 		},
+		{
+			StructField: validFieldType,
+			Tag:         "searchable",
+			Column: &ColumnDefinition{
+				Name: "valid",
+				Type: TUUID,
+				Tags: map[string]string{SearchableTag: ""},
+			},
+		},
+		{
+			StructField: validFieldType,
+			Tag:         "  name=jj searchable ",
+			Column: &ColumnDefinition{
+				Name: "jj",
+				Type: TUUID,
+				Tags: map[string]string{SearchableTag: ""},
+			},
+		},
+		{
+			StructField: validFieldType,
+			Tag:         "searchable name=jj",
+			Column: &ColumnDefinition{
+				Name: "jj",
+				Type: TUUID,
+				Tags: map[string]string{SearchableTag: ""},
+			},
+		},
+		{
+			StructField: validFieldType,
+			Tag:         "searchablex",
+			Error:       errors.New("invalid dosa field tag"),
+		},
+		{
+			StructField: validFieldType,
+			Tag:         "searchable searchable",
+			Error:       errors.New("invalid dosa field tag"),
+		},
 	}
 	for _, d := range data {
 		cn, err := parseFieldTag(d.StructField, d.Tag)
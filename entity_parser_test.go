@@ -300,6 +300,43 @@ func TestIgnoreTag(t *testing.T) {
 	assert.Len(t, table.Columns, 1)
 }
 
+type SearchableFieldType struct {
+	Entity `dosa:"primaryKey=ID"`
+	ID     int64
+	Email  string `dosa:"name=email_addr searchable"`
+	Name   string
+}
+
+func TestSearchableTag(t *testing.T) {
+	table, err := TableFromInstance(&SearchableFieldType{})
+	assert.NoError(t, err)
+	assert.True(t, table.FindColumnDefinition("email_addr").IsSearchable())
+	assert.False(t, table.FindColumnDefinition("name").IsSearchable())
+	assert.False(t, table.FindColumnDefinition("id").IsSearchable())
+
+	_, err = parseField(CustomObject, "field", "searchable")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "custom object")
+}
+
+type CachedFieldType struct {
+	Entity `dosa:"primaryKey=ID"`
+	ID     int64  `dosa:"cache"`
+	Email  string `dosa:"name=email_addr searchable cache"`
+	Name   string
+}
+
+func TestCacheTag(t *testing.T) {
+	table, err := TableFromInstance(&CachedFieldType{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{CacheTag: ""}, table.FindColumnDefinition("id").Tags)
+	assert.Equal(t, map[string]string{CacheTag: "", SearchableTag: ""}, table.FindColumnDefinition("email_addr").Tags)
+	assert.Nil(t, table.FindColumnDefinition("name").Tags)
+
+	_, err = parseField(Int64, "field", "cached")
+	assert.Error(t, err)
+}
+
 func TestExtraStuffInClusteringKeyDecl(t *testing.T) {
 	type BadClusteringKeyDefinition struct {
 		Entity     `dosa:"primaryKey=(BoolType,StringType asc asc)"`
@@ -54,7 +54,7 @@ func TestNonExistentDirectory(t *testing.T) {
 
 func TestParser(t *testing.T) {
 	entities, errs, err := FindEntities([]string{"."}, []string{})
-	assert.Equal(t, 13, len(entities), fmt.Sprintf("%s", entities))
+	assert.Equal(t, 15, len(entities), fmt.Sprintf("%s", entities))
 	assert.Equal(t, 14, len(errs), fmt.Sprintf("%v", errs))
 	assert.Nil(t, err)
 
@@ -81,6 +81,10 @@ func TestParser(t *testing.T) {
 			e, _ = TableFromInstance(&IgnoreTagType{})
 		case "badcolnamebutrenamed":
 			e, _ = TableFromInstance(&BadColNameButRenamed{})
+		case "searchablefieldtype":
+			e, _ = TableFromInstance(&SearchableFieldType{})
+		case "cachedfieldtype":
+			e, _ = TableFromInstance(&CachedFieldType{})
 		case "clienttestentity1": // skip, see https://jira.uberinternal.com/browse/DOSA-788
 			continue
 		case "clienttestentity2": // skip, same as above
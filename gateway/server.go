@@ -0,0 +1,652 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package gateway implements the DOSA gateway RPC interface on top of any
+// dosa.Connector. It lets the yarpc connector talk to a local store, for
+// example an in-memory or file-backed connector, without running a remote
+// gateway.
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/yarpc"
+	dosarpc "github.com/uber/dosa-idl/.gen/dosa"
+	"github.com/uber/dosa-idl/.gen/dosa/dosaserver"
+	"go.uber.org/yarpc/api/transport"
+)
+
+// Server translates DOSA gateway RPCs into calls on a dosa.Connector.
+//
+// Values on the wire carry no type information, so the server needs the
+// entity definitions to decode them. They are learned from CheckSchema and
+// UpsertSchema requests, which clients always send before using an entity.
+// Requests for an entity the server has not learned, for example after a
+// restart, fail with a schema mismatch, on which the yarpc connector checks
+// the schema again and retries.
+type Server struct {
+	connector dosa.Connector
+
+	lock    sync.RWMutex
+	schemas map[string]*dosa.EntityDefinition
+}
+
+var _ dosaserver.Interface = (*Server)(nil)
+
+// NewServer returns a new gateway server that forwards all requests to connector
+func NewServer(connector dosa.Connector) *Server {
+	return &Server{
+		connector: connector,
+		schemas:   map[string]*dosa.EntityDefinition{},
+	}
+}
+
+// Procedures returns the YARPC procedures of the server, ready to be
+// registered with a dispatcher
+func (s *Server) Procedures() []transport.Procedure {
+	return dosaserver.New(s)
+}
+
+func schemaKey(scope, namePrefix, entityName string) string {
+	return scope + "/" + namePrefix + "/" + entityName
+}
+
+// register remembers the entity definitions of a scope and name prefix
+func (s *Server) register(scope, namePrefix string, eds []*dosa.EntityDefinition) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, ed := range eds {
+		s.schemas[schemaKey(scope, namePrefix, ed.Name)] = ed
+	}
+}
+
+// checkScope validates a scope name from a request. Connectors may use
+// names to build file paths or keys, so names are checked before they reach
+// the connector.
+func checkScope(scope string) error {
+	if err := dosa.IsValidName(scope); err != nil {
+		return badRequest("invalid scope name %q: %s", scope, err)
+	}
+	return nil
+}
+
+// checkNamePrefix validates a name prefix from a request; each of its dot
+// separated parts must be a valid name
+func checkNamePrefix(namePrefix string) error {
+	for _, part := range strings.Split(namePrefix, ".") {
+		if err := dosa.IsValidName(part); err != nil {
+			return badRequest("invalid name prefix %q: %s", namePrefix, err)
+		}
+	}
+	return nil
+}
+
+// checkSchemaNames validates the scope and name prefix of a schema request
+func checkSchemaNames(scope, namePrefix string) error {
+	if err := checkScope(scope); err != nil {
+		return err
+	}
+	return checkNamePrefix(namePrefix)
+}
+
+// entityInfo looks up the entity definition referenced by the request
+func (s *Server) entityInfo(ref *dosarpc.SchemaRef) (*dosa.EntityInfo, error) {
+	if ref == nil || ref.Scope == nil || ref.NamePrefix == nil || ref.EntityName == nil {
+		return nil, badRequest("incomplete schema reference")
+	}
+	if err := checkSchemaNames(*ref.Scope, *ref.NamePrefix); err != nil {
+		return nil, err
+	}
+	if err := dosa.IsValidName(*ref.EntityName); err != nil {
+		return nil, badRequest("invalid entity name %q: %s", *ref.EntityName, err)
+	}
+	s.lock.RLock()
+	ed, ok := s.schemas[schemaKey(*ref.Scope, *ref.NamePrefix, *ref.EntityName)]
+	s.lock.RUnlock()
+	if !ok {
+		// reported as a schema mismatch, so clients check the schema again
+		msg := fmt.Sprintf("schema for entity %q in scope %q with prefix %q is not registered, call CheckSchema or UpsertSchema first",
+			*ref.EntityName, *ref.Scope, *ref.NamePrefix)
+		code := yarpc.ErrCodeSchemaMismatch
+		return nil, &dosarpc.BadRequestError{Message: &msg, ErrorCode: &code}
+	}
+
+	var version int32
+	if ref.Version != nil {
+		version = *ref.Version
+	}
+	return &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{
+			Scope:      *ref.Scope,
+			NamePrefix: *ref.NamePrefix,
+			EntityName: *ref.EntityName,
+			Version:    version,
+		},
+		Def: ed,
+	}, nil
+}
+
+// CreateIfNotExists creates a row if it does not exist yet
+func (s *Server) CreateIfNotExists(ctx context.Context, request *dosarpc.CreateRequest) error {
+	ei, err := s.entityInfo(request.Ref)
+	if err != nil {
+		return err
+	}
+	values, err := decodeFieldValueMap(ei.Def, request.EntityValues)
+	if err != nil {
+		return err
+	}
+	return encodeError(s.connector.CreateIfNotExists(ctx, ei, values))
+}
+
+// Read fetches a row by primary key
+func (s *Server) Read(ctx context.Context, request *dosarpc.ReadRequest) (*dosarpc.ReadResponse, error) {
+	ei, err := s.entityInfo(request.Ref)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := decodeFieldValueMap(ei.Def, request.KeyValues)
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.connector.Read(ctx, ei, keys, decodeFieldsToRead(request.FieldsToRead))
+	if err != nil {
+		return nil, encodeError(err)
+	}
+	return &dosarpc.ReadResponse{EntityValues: encodeFieldValueMap(values)}, nil
+}
+
+// MultiRead fetches several rows by primary key
+func (s *Server) MultiRead(ctx context.Context, request *dosarpc.MultiReadRequest) (*dosarpc.MultiReadResponse, error) {
+	ei, err := s.entityInfo(request.Ref)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := decodeFieldValueMaps(ei.Def, request.KeyValues)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.connector.MultiRead(ctx, ei, keys, decodeFieldsToRead(request.FieldsToRead))
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	rpcResults := make([]*dosarpc.EntityOrError, len(results))
+	for i, result := range results {
+		if result == nil {
+			rpcResults[i] = &dosarpc.EntityOrError{Error: encodeRowError(&dosa.ErrNotFound{})}
+			continue
+		}
+		if result.Error != nil {
+			rpcResults[i] = &dosarpc.EntityOrError{Error: encodeRowError(result.Error)}
+			continue
+		}
+		rpcResults[i] = &dosarpc.EntityOrError{EntityValues: encodeFieldValueMap(result.Values)}
+	}
+	return &dosarpc.MultiReadResponse{Results: rpcResults}, nil
+}
+
+// Upsert updates some columns of a row, creating it if needed
+func (s *Server) Upsert(ctx context.Context, request *dosarpc.UpsertRequest) error {
+	ei, err := s.entityInfo(request.Ref)
+	if err != nil {
+		return err
+	}
+	values, err := decodeFieldValueMap(ei.Def, request.EntityValues)
+	if err != nil {
+		return err
+	}
+	return encodeError(s.connector.Upsert(ctx, ei, values))
+}
+
+// MultiUpsert updates several rows
+func (s *Server) MultiUpsert(ctx context.Context, request *dosarpc.MultiUpsertRequest) (*dosarpc.MultiUpsertResponse, error) {
+	ei, err := s.entityInfo(request.Ref)
+	if err != nil {
+		return nil, err
+	}
+	multiValues, err := decodeFieldValueMaps(ei.Def, request.Entities)
+	if err != nil {
+		return nil, err
+	}
+	rowErrors, err := s.connector.MultiUpsert(ctx, ei, multiValues)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+	return &dosarpc.MultiUpsertResponse{Errors: encodeRowErrors(rowErrors)}, nil
+}
+
+// Remove deletes a row by primary key
+func (s *Server) Remove(ctx context.Context, request *dosarpc.RemoveRequest) error {
+	ei, err := s.entityInfo(request.Ref)
+	if err != nil {
+		return err
+	}
+	keys, err := decodeFieldValueMap(ei.Def, request.KeyValues)
+	if err != nil {
+		return err
+	}
+	return encodeError(s.connector.Remove(ctx, ei, keys))
+}
+
+// MultiRemove deletes several rows by primary key
+func (s *Server) MultiRemove(ctx context.Context, request *dosarpc.MultiRemoveRequest) (*dosarpc.MultiRemoveResponse, error) {
+	ei, err := s.entityInfo(request.Ref)
+	if err != nil {
+		return nil, err
+	}
+	multiKeys, err := decodeFieldValueMaps(ei.Def, request.KeyValues)
+	if err != nil {
+		return nil, err
+	}
+	rowErrors, err := s.connector.MultiRemove(ctx, ei, multiKeys)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+	return &dosarpc.MultiRemoveResponse{Errors: encodeRowErrors(rowErrors)}, nil
+}
+
+// Range does a range scan using a set of conditions
+func (s *Server) Range(ctx context.Context, request *dosarpc.RangeRequest) (*dosarpc.RangeResponse, error) {
+	ei, err := s.entityInfo(request.Ref)
+	if err != nil {
+		return nil, err
+	}
+	conditions, err := decodeConditions(ei.Def, request.Conditions)
+	if err != nil {
+		return nil, err
+	}
+	rows, token, err := s.connector.Range(ctx, ei, conditions, decodeFieldsToRead(request.FieldsToRead),
+		stringOrEmpty(request.Token), int(int32OrZero(request.Limit)))
+	if err != nil {
+		return nil, encodeError(err)
+	}
+	return &dosarpc.RangeResponse{Entities: encodeFieldValueMaps(rows), NextToken: &token}, nil
+}
+
+// Search fetches rows by a searchable field
+func (s *Server) Search(ctx context.Context, request *dosarpc.SearchRequest) (*dosarpc.SearchResponse, error) {
+	ei, err := s.entityInfo(request.Ref)
+	if err != nil {
+		return nil, err
+	}
+	if request.SearchBy == nil || request.SearchBy.Name == nil {
+		return nil, badRequest("missing search field")
+	}
+	value, err := decodeValue(ei.Def, *request.SearchBy.Name, request.SearchBy.Value)
+	if err != nil {
+		return nil, err
+	}
+	fieldPair := dosa.FieldNameValuePair{Name: *request.SearchBy.Name, Value: value}
+	rows, token, err := s.connector.Search(ctx, ei, fieldPair, decodeFieldsToRead(request.FieldsToRead),
+		stringOrEmpty(request.Token), int(int32OrZero(request.Limit)))
+	if err != nil {
+		return nil, encodeError(err)
+	}
+	return &dosarpc.SearchResponse{Entities: encodeFieldValueMaps(rows), NextToken: &token}, nil
+}
+
+// Scan reads all the rows of an entity
+func (s *Server) Scan(ctx context.Context, request *dosarpc.ScanRequest) (*dosarpc.ScanResponse, error) {
+	ei, err := s.entityInfo(request.Ref)
+	if err != nil {
+		return nil, err
+	}
+	rows, token, err := s.connector.Scan(ctx, ei, decodeFieldsToRead(request.FieldsToRead),
+		stringOrEmpty(request.Token), int(int32OrZero(request.Limit)))
+	if err != nil {
+		return nil, encodeError(err)
+	}
+	return &dosarpc.ScanResponse{Entities: encodeFieldValueMaps(rows), NextToken: &token}, nil
+}
+
+// CheckSchema checks the schema with the connector and remembers the entity
+// definitions for later requests
+func (s *Server) CheckSchema(ctx context.Context, request *dosarpc.CheckSchemaRequest) (*dosarpc.CheckSchemaResponse, error) {
+	eds, err := decodeEntityDefinitions(request.EntityDefs)
+	if err != nil {
+		return nil, err
+	}
+	scope, namePrefix := stringOrEmpty(request.Scope), stringOrEmpty(request.NamePrefix)
+	if err := checkSchemaNames(scope, namePrefix); err != nil {
+		return nil, err
+	}
+	version, err := s.connector.CheckSchema(ctx, scope, namePrefix, eds)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+	s.register(scope, namePrefix, eds)
+	return &dosarpc.CheckSchemaResponse{Version: &version}, nil
+}
+
+// UpsertSchema upserts the schema with the connector and remembers the
+// entity definitions for later requests
+func (s *Server) UpsertSchema(ctx context.Context, request *dosarpc.UpsertSchemaRequest) (*dosarpc.UpsertSchemaResponse, error) {
+	eds, err := decodeEntityDefinitions(request.EntityDefs)
+	if err != nil {
+		return nil, err
+	}
+	scope, namePrefix := stringOrEmpty(request.Scope), stringOrEmpty(request.NamePrefix)
+	if err := checkSchemaNames(scope, namePrefix); err != nil {
+		return nil, err
+	}
+	status, err := s.connector.UpsertSchema(ctx, scope, namePrefix, eds)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+	s.register(scope, namePrefix, eds)
+	return &dosarpc.UpsertSchemaResponse{Version: &status.Version, Status: &status.Status}, nil
+}
+
+// CheckSchemaStatus checks the status of a schema version
+func (s *Server) CheckSchemaStatus(ctx context.Context, request *dosarpc.CheckSchemaStatusRequest) (*dosarpc.CheckSchemaStatusResponse, error) {
+	scope, namePrefix := stringOrEmpty(request.Scope), stringOrEmpty(request.NamePrefix)
+	if err := checkSchemaNames(scope, namePrefix); err != nil {
+		return nil, err
+	}
+	status, err := s.connector.CheckSchemaStatus(ctx, scope, namePrefix, int32OrZero(request.Version))
+	if err != nil {
+		return nil, encodeError(err)
+	}
+	return &dosarpc.CheckSchemaStatusResponse{Version: &status.Version, Status: &status.Status}, nil
+}
+
+// CreateScope creates a scope
+func (s *Server) CreateScope(ctx context.Context, request *dosarpc.CreateScopeRequest) error {
+	scope := stringOrEmpty(request.Name)
+	if err := checkScope(scope); err != nil {
+		return err
+	}
+	return encodeError(s.connector.CreateScope(ctx, scope))
+}
+
+// TruncateScope removes all the data of a scope
+func (s *Server) TruncateScope(ctx context.Context, request *dosarpc.TruncateScopeRequest) error {
+	scope := stringOrEmpty(request.Name)
+	if err := checkScope(scope); err != nil {
+		return err
+	}
+	return encodeError(s.connector.TruncateScope(ctx, scope))
+}
+
+// DropScope removes a scope and all of its data
+func (s *Server) DropScope(ctx context.Context, request *dosarpc.DropScopeRequest) error {
+	scope := stringOrEmpty(request.Name)
+	if err := checkScope(scope); err != nil {
+		return err
+	}
+	return encodeError(s.connector.DropScope(ctx, scope))
+}
+
+// ScopeExists checks whether a scope exists
+func (s *Server) ScopeExists(ctx context.Context, request *dosarpc.ScopeExistsRequest) (*dosarpc.ScopeExistsResponse, error) {
+	scope := stringOrEmpty(request.Name)
+	if err := checkScope(scope); err != nil {
+		return nil, err
+	}
+	exists, err := s.connector.ScopeExists(ctx, scope)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+	return &dosarpc.ScopeExistsResponse{Exists: &exists}, nil
+}
+
+// decodeEntityDefinitions converts and validates the entity definitions of a schema request
+func decodeEntityDefinitions(rpcEds []*dosarpc.EntityDefinition) ([]*dosa.EntityDefinition, error) {
+	eds := make([]*dosa.EntityDefinition, len(rpcEds))
+	for i, rpcEd := range rpcEds {
+		if rpcEd == nil || rpcEd.Name == nil || rpcEd.PrimaryKey == nil {
+			return nil, badRequest("incomplete entity definition")
+		}
+		for _, fd := range rpcEd.FieldDescs {
+			if fd == nil || fd.Type == nil {
+				return nil, badRequest("entity definition %q has a column without type", *rpcEd.Name)
+			}
+		}
+		for _, ck := range rpcEd.PrimaryKey.ClusteringKeys {
+			if ck == nil || ck.Name == nil || ck.Asc == nil {
+				return nil, badRequest("entity definition %q has an incomplete clustering key", *rpcEd.Name)
+			}
+		}
+		eds[i] = yarpc.FromThriftToEntityDefinition(rpcEd)
+		if err := eds[i].EnsureValid(); err != nil {
+			return nil, badRequest("invalid entity definition: %s", err)
+		}
+	}
+	return eds, nil
+}
+
+// decodeValue converts a value from the wire using the type of its column
+func decodeValue(ed *dosa.EntityDefinition, name string, value *dosarpc.Value) (dosa.FieldValue, error) {
+	cd := ed.FindColumnDefinition(name)
+	if cd == nil {
+		return nil, badRequest("unknown column %q in entity %q", name, ed.Name)
+	}
+	if value == nil || value.ElemValue == nil {
+		return nil, badRequest("missing value for column %q", name)
+	}
+	if err := ensureRawValueType(cd.Type, value.ElemValue); err != nil {
+		return nil, badRequest("invalid value for column %q: %s", name, err)
+	}
+	return yarpc.RawValueAsInterface(*value.ElemValue, *cd), nil
+}
+
+// ensureRawValueType checks that the raw value has the field needed to
+// decode the type set, so RawValueAsInterface does not panic
+func ensureRawValueType(t dosa.Type, raw *dosarpc.RawValue) error {
+	var ok bool
+	switch t {
+	case dosa.TUUID:
+		_, err := dosa.BytesToUUID(raw.BinaryValue)
+		ok = err == nil
+	case dosa.Blob:
+		ok = raw.BinaryValue != nil
+	case dosa.String:
+		ok = raw.StringValue != nil
+	case dosa.Int32:
+		ok = raw.Int32Value != nil
+	case dosa.Int64, dosa.Timestamp:
+		ok = raw.Int64Value != nil
+	case dosa.Double:
+		ok = raw.DoubleValue != nil
+	case dosa.Bool:
+		ok = raw.BoolValue != nil
+	}
+	if !ok {
+		return errors.Errorf("expected a value of type %s", t)
+	}
+	return nil
+}
+
+func decodeFieldValueMap(ed *dosa.EntityDefinition, fields dosarpc.FieldValueMap) (map[string]dosa.FieldValue, error) {
+	values := make(map[string]dosa.FieldValue, len(fields))
+	for name, value := range fields {
+		v, err := decodeValue(ed, name, value)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+func decodeFieldValueMaps(ed *dosa.EntityDefinition, multiFields []dosarpc.FieldValueMap) ([]map[string]dosa.FieldValue, error) {
+	multiValues := make([]map[string]dosa.FieldValue, len(multiFields))
+	for i, fields := range multiFields {
+		values, err := decodeFieldValueMap(ed, fields)
+		if err != nil {
+			return nil, err
+		}
+		multiValues[i] = values
+	}
+	return multiValues, nil
+}
+
+func decodeConditions(ed *dosa.EntityDefinition, rpcConditions []*dosarpc.Condition) (map[string][]*dosa.Condition, error) {
+	conditions := map[string][]*dosa.Condition{}
+	for _, rpcCondition := range rpcConditions {
+		if rpcCondition == nil || rpcCondition.Op == nil || rpcCondition.Field == nil || rpcCondition.Field.Name == nil {
+			return nil, badRequest("incomplete condition")
+		}
+		op, err := decodeOperator(*rpcCondition.Op)
+		if err != nil {
+			return nil, err
+		}
+		name := *rpcCondition.Field.Name
+		value, err := decodeValue(ed, name, rpcCondition.Field.Value)
+		if err != nil {
+			return nil, err
+		}
+		conditions[name] = append(conditions[name], &dosa.Condition{Op: op, Value: value})
+	}
+	return conditions, nil
+}
+
+func decodeOperator(op dosarpc.Operator) (dosa.Operator, error) {
+	switch op {
+	case dosarpc.OperatorEq:
+		return dosa.Eq, nil
+	case dosarpc.OperatorLt:
+		return dosa.Lt, nil
+	case dosarpc.OperatorLtOrEq:
+		return dosa.LtOrEq, nil
+	case dosarpc.OperatorGt:
+		return dosa.Gt, nil
+	case dosarpc.OperatorGtOrEq:
+		return dosa.GtOrEq, nil
+	}
+	return 0, badRequest("unknown operator %v", op)
+}
+
+// decodeFieldsToRead converts the set of fields to read; a nil set means all fields
+func decodeFieldsToRead(rpcFieldsToRead map[string]struct{}) []string {
+	if rpcFieldsToRead == nil {
+		return nil
+	}
+	fieldsToRead := make([]string, 0, len(rpcFieldsToRead))
+	for field := range rpcFieldsToRead {
+		fieldsToRead = append(fieldsToRead, field)
+	}
+	return fieldsToRead
+}
+
+func encodeFieldValueMap(values map[string]dosa.FieldValue) dosarpc.FieldValueMap {
+	fields := make(dosarpc.FieldValueMap, len(values))
+	for name, value := range values {
+		fields[name] = &dosarpc.Value{ElemValue: yarpc.RawValueFromInterface(value)}
+	}
+	return fields
+}
+
+func encodeFieldValueMaps(rows []map[string]dosa.FieldValue) []dosarpc.FieldValueMap {
+	entities := make([]dosarpc.FieldValueMap, len(rows))
+	for i, row := range rows {
+		entities[i] = encodeFieldValueMap(row)
+	}
+	return entities
+}
+
+// errorCode returns the wire error code for the dosa error, if it has one
+func errorCode(err error) (int32, bool) {
+	switch {
+	case dosa.ErrorIsNotFound(err):
+		return yarpc.ErrCodeNotFound, true
+	case dosa.ErrorIsAlreadyExists(err):
+		return yarpc.ErrCodeAlreadyExists, true
+	case dosa.ErrorIsInvalidRequest(err):
+		return yarpc.ErrCodeInvalidRequest, true
+	case dosa.ErrorIsSchemaMismatch(err):
+		return yarpc.ErrCodeSchemaMismatch, true
+	case dosa.ErrorIsTimeout(err), errors.Cause(err) == context.DeadlineExceeded:
+		return yarpc.ErrCodeTimeout, true
+	case dosa.ErrorIsThrottled(err):
+		return yarpc.ErrCodeThrottled, true
+	}
+	return 0, false
+}
+
+// encodeError converts a connector error into an RPC error. Errors with a
+// known error code are sent as bad requests with that code, everything else
+// is an internal error.
+func encodeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if code, ok := errorCode(err); ok {
+		return &dosarpc.BadRequestError{Message: &msg, ErrorCode: &code}
+	}
+	return &dosarpc.InternalServerError{Message: &msg}
+}
+
+// shouldRetry tells whether the client may retry a failed row
+func shouldRetry(err error) bool {
+	return dosa.ErrorIsRetryable(err) || dosa.ErrorIsTimeout(err) || dosa.ErrorIsThrottled(err) ||
+		errors.Cause(err) == context.DeadlineExceeded
+}
+
+// encodeRowError converts the error of one row of a multi operation. Rows
+// that failed because of a transient problem are marked so the client can
+// retry them.
+func encodeRowError(err error) *dosarpc.Error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	retry := shouldRetry(err)
+	rpcErr := &dosarpc.Error{Msg: &msg, ShouldRetry: &retry}
+	if code, ok := errorCode(err); ok {
+		rpcErr.ErrCode = &code
+	}
+	return rpcErr
+}
+
+func encodeRowErrors(errs []error) []*dosarpc.Error {
+	rpcErrors := make([]*dosarpc.Error, len(errs))
+	for i, err := range errs {
+		rpcErrors[i] = encodeRowError(err)
+	}
+	return rpcErrors
+}
+
+func badRequest(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	code := yarpc.ErrCodeInvalidRequest
+	return &dosarpc.BadRequestError{Message: &msg, ErrorCode: &code}
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func int32OrZero(i *int32) int32 {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
@@ -0,0 +1,432 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package gateway_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/fault"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/connectors/yarpc"
+	"github.com/uber-go/dosa/gateway"
+	dosarpc "github.com/uber/dosa-idl/.gen/dosa"
+	rpc "go.uber.org/yarpc"
+)
+
+// localClient is an in-process RPC client calling the server directly
+type localClient struct {
+	s *gateway.Server
+}
+
+func (c localClient) CheckSchema(ctx context.Context, r *dosarpc.CheckSchemaRequest, opts ...rpc.CallOption) (*dosarpc.CheckSchemaResponse, error) {
+	return c.s.CheckSchema(ctx, r)
+}
+func (c localClient) CheckSchemaStatus(ctx context.Context, r *dosarpc.CheckSchemaStatusRequest, opts ...rpc.CallOption) (*dosarpc.CheckSchemaStatusResponse, error) {
+	return c.s.CheckSchemaStatus(ctx, r)
+}
+func (c localClient) CreateIfNotExists(ctx context.Context, r *dosarpc.CreateRequest, opts ...rpc.CallOption) error {
+	return c.s.CreateIfNotExists(ctx, r)
+}
+func (c localClient) CreateScope(ctx context.Context, r *dosarpc.CreateScopeRequest, opts ...rpc.CallOption) error {
+	return c.s.CreateScope(ctx, r)
+}
+func (c localClient) DropScope(ctx context.Context, r *dosarpc.DropScopeRequest, opts ...rpc.CallOption) error {
+	return c.s.DropScope(ctx, r)
+}
+func (c localClient) MultiRead(ctx context.Context, r *dosarpc.MultiReadRequest, opts ...rpc.CallOption) (*dosarpc.MultiReadResponse, error) {
+	return c.s.MultiRead(ctx, r)
+}
+func (c localClient) MultiRemove(ctx context.Context, r *dosarpc.MultiRemoveRequest, opts ...rpc.CallOption) (*dosarpc.MultiRemoveResponse, error) {
+	return c.s.MultiRemove(ctx, r)
+}
+func (c localClient) MultiUpsert(ctx context.Context, r *dosarpc.MultiUpsertRequest, opts ...rpc.CallOption) (*dosarpc.MultiUpsertResponse, error) {
+	return c.s.MultiUpsert(ctx, r)
+}
+func (c localClient) Range(ctx context.Context, r *dosarpc.RangeRequest, opts ...rpc.CallOption) (*dosarpc.RangeResponse, error) {
+	return c.s.Range(ctx, r)
+}
+func (c localClient) Read(ctx context.Context, r *dosarpc.ReadRequest, opts ...rpc.CallOption) (*dosarpc.ReadResponse, error) {
+	return c.s.Read(ctx, r)
+}
+func (c localClient) Remove(ctx context.Context, r *dosarpc.RemoveRequest, opts ...rpc.CallOption) error {
+	return c.s.Remove(ctx, r)
+}
+func (c localClient) Scan(ctx context.Context, r *dosarpc.ScanRequest, opts ...rpc.CallOption) (*dosarpc.ScanResponse, error) {
+	return c.s.Scan(ctx, r)
+}
+func (c localClient) ScopeExists(ctx context.Context, r *dosarpc.ScopeExistsRequest, opts ...rpc.CallOption) (*dosarpc.ScopeExistsResponse, error) {
+	return c.s.ScopeExists(ctx, r)
+}
+func (c localClient) Search(ctx context.Context, r *dosarpc.SearchRequest, opts ...rpc.CallOption) (*dosarpc.SearchResponse, error) {
+	return c.s.Search(ctx, r)
+}
+func (c localClient) TruncateScope(ctx context.Context, r *dosarpc.TruncateScopeRequest, opts ...rpc.CallOption) error {
+	return c.s.TruncateScope(ctx, r)
+}
+func (c localClient) Upsert(ctx context.Context, r *dosarpc.UpsertRequest, opts ...rpc.CallOption) error {
+	return c.s.Upsert(ctx, r)
+}
+func (c localClient) UpsertSchema(ctx context.Context, r *dosarpc.UpsertSchemaRequest, opts ...rpc.CallOption) (*dosarpc.UpsertSchemaResponse, error) {
+	return c.s.UpsertSchema(ctx, r)
+}
+
+var ctx = context.Background()
+
+var testEi = &dosa.EntityInfo{
+	Ref: &dosa.SchemaRef{
+		Scope:      "testscope",
+		NamePrefix: "testprefix",
+		EntityName: "testentity",
+	},
+	Def: &dosa.EntityDefinition{
+		Name: "testentity",
+		Key: &dosa.PrimaryKey{
+			PartitionKeys:  []string{"id"},
+			ClusteringKeys: []*dosa.ClusteringKey{{Name: "ts", Descending: true}},
+		},
+		Columns: []*dosa.ColumnDefinition{
+			{Name: "id", Type: dosa.TUUID},
+			{Name: "ts", Type: dosa.Timestamp},
+			{Name: "name", Type: dosa.String, Tags: map[string]string{dosa.SearchableTag: ""}},
+			{Name: "count", Type: dosa.Int64},
+			{Name: "data", Type: dosa.Blob},
+			{Name: "ratio", Type: dosa.Double},
+			{Name: "active", Type: dosa.Bool},
+			{Name: "small", Type: dosa.Int32},
+		},
+	},
+}
+
+var testUUID = dosa.UUID("b1f23fa3-f453-45b4-a5d5-6d73078ac3bd")
+
+func row(i int) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"id":     testUUID,
+		"ts":     time.Unix(0, int64(1500000000+i)*int64(time.Second)),
+		"name":   "name",
+		"count":  int64(i),
+		"data":   []byte{byte(i)},
+		"ratio":  float64(i) / 2,
+		"active": i%2 == 0,
+		"small":  int32(i),
+	}
+}
+
+func key(i int) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"id": testUUID, "ts": row(i)["ts"]}
+}
+
+func newConnector(t *testing.T) *yarpc.Connector {
+	sut := &yarpc.Connector{Client: localClient{gateway.NewServer(memory.NewConnector())}}
+	_, err := sut.CheckSchema(ctx, "testscope", "testprefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	return sut
+}
+
+func TestServer_CRUD(t *testing.T) {
+	sut := newConnector(t)
+
+	_, err := sut.Read(ctx, testEi, key(1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	assert.NoError(t, sut.CreateIfNotExists(ctx, testEi, row(1)))
+	assert.True(t, dosa.ErrorIsAlreadyExists(sut.CreateIfNotExists(ctx, testEi, row(1))))
+
+	values, err := sut.Read(ctx, testEi, key(1), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row(1), values)
+
+	values, err = sut.Read(ctx, testEi, key(1), []string{"name", "small"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dosa.FieldValue{"name": "name", "small": int32(1)}, values)
+
+	update := key(1)
+	update["name"] = "updated"
+	assert.NoError(t, sut.Upsert(ctx, testEi, update))
+	values, err = sut.Read(ctx, testEi, key(1), []string{"name"})
+	assert.NoError(t, err)
+	assert.Equal(t, "updated", values["name"])
+
+	assert.NoError(t, sut.Remove(ctx, testEi, key(1)))
+	_, err = sut.Read(ctx, testEi, key(1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+}
+
+func TestServer_MultiOps(t *testing.T) {
+	sut := newConnector(t)
+
+	errs, err := sut.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row(1), row(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, errs)
+
+	results, err := sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{key(2), key(3), key(1)}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, row(2), results[0].Values)
+	assert.Error(t, results[1].Error)
+	assert.Equal(t, row(1), results[2].Values)
+
+	errs, err = sut.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{key(1), key(3)})
+	assert.NoError(t, err)
+	assert.Len(t, errs, 2)
+	assert.NoError(t, errs[0])
+	assert.True(t, dosa.ErrorIsNotFound(errs[1]))
+}
+
+func TestServer_RangeAndScan(t *testing.T) {
+	sut := newConnector(t)
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, sut.Upsert(ctx, testEi, row(i)))
+	}
+
+	conditions := map[string][]*dosa.Condition{
+		"id": {{Op: dosa.Eq, Value: testUUID}},
+		"ts": {{Op: dosa.GtOrEq, Value: row(2)["ts"]}, {Op: dosa.Lt, Value: row(5)["ts"]}},
+	}
+	rows, token, err := sut.Range(ctx, testEi, conditions, []string{"count"}, "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{{"count": int64(4)}, {"count": int64(3)}}, rows)
+	assert.NotEmpty(t, token)
+	rows, token, err = sut.Range(ctx, testEi, conditions, []string{"count"}, token, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{{"count": int64(2)}}, rows)
+	assert.Empty(t, token)
+
+	// invalid conditions are rejected by the connector behind the server
+	_, _, err = sut.Range(ctx, testEi, map[string][]*dosa.Condition{"ts": {{Op: dosa.Eq, Value: row(2)["ts"]}}}, nil, "", 0)
+	assert.Error(t, err)
+
+	rows, token, err = sut.Scan(ctx, testEi, nil, "", 0)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 5)
+	assert.Empty(t, token)
+
+	// the searchable tag is carried over the wire with the schema
+	rows, token, err = sut.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "name", Value: "name"}, []string{"count"}, "", 3)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.NotEmpty(t, token)
+	rows, token, err = sut.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "name", Value: "name"}, []string{"count"}, token, 3)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Empty(t, token)
+
+	// fields without the tag cannot be searched
+	_, _, err = sut.Search(ctx, testEi, dosa.FieldNameValuePair{Name: "count", Value: int64(1)}, nil, "", 0)
+	assert.Error(t, err)
+}
+
+func TestServer_Restart(t *testing.T) {
+	next := memory.NewConnector()
+	sut := &yarpc.Connector{Client: localClient{gateway.NewServer(next)}}
+	_, err := sut.CheckSchema(ctx, "testscope", "testprefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	assert.NoError(t, sut.Upsert(ctx, testEi, row(1)))
+
+	// a new server on the same data has not learned the schema, the client
+	// checks it again without being asked to
+	sut.Client = localClient{gateway.NewServer(next)}
+	values, err := sut.Read(ctx, testEi, key(1), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, row(1), values)
+}
+
+func TestServer_Schema(t *testing.T) {
+	server := gateway.NewServer(memory.NewConnector())
+	sut := &yarpc.Connector{Client: localClient{server}}
+
+	// entity is unknown until the schema has been checked or upserted
+	scope, prefix, entity := "testscope", "testprefix", testEi.Def.Name
+	err := server.Upsert(ctx, &dosarpc.UpsertRequest{Ref: &dosarpc.SchemaRef{Scope: &scope, NamePrefix: &prefix, EntityName: &entity}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not registered")
+	assert.Equal(t, yarpc.ErrCodeSchemaMismatch, *err.(*dosarpc.BadRequestError).ErrorCode)
+
+	status, err := sut.UpsertSchema(ctx, "testscope", "testprefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+	assert.Equal(t, &dosa.SchemaStatus{Version: 1, Status: "COMPLETED"}, status)
+	assert.NoError(t, sut.Upsert(ctx, testEi, row(1)))
+
+	status, err = sut.CheckSchemaStatus(ctx, "testscope", "testprefix", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), status.Version)
+
+	// invalid entity definition
+	_, err = sut.CheckSchema(ctx, "testscope", "testprefix", []*dosa.EntityDefinition{{
+		Name:    "Bad Name",
+		Key:     &dosa.PrimaryKey{PartitionKeys: []string{"id"}},
+		Columns: []*dosa.ColumnDefinition{{Name: "id", Type: dosa.Int64}},
+	}})
+	assert.Error(t, err)
+}
+
+func TestServer_Scopes(t *testing.T) {
+	sut := newConnector(t)
+
+	assert.NoError(t, sut.CreateScope(ctx, "testscope"))
+	assert.Error(t, sut.CreateScope(ctx, "testscope"))
+	assert.NoError(t, sut.Upsert(ctx, testEi, row(1)))
+	assert.NoError(t, sut.TruncateScope(ctx, "testscope"))
+	_, err := sut.Read(ctx, testEi, key(1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.NoError(t, sut.DropScope(ctx, "testscope"))
+	assert.Error(t, sut.DropScope(ctx, "testscope"))
+
+	server := gateway.NewServer(memory.NewConnector())
+	name := "testscope"
+	response, err := server.ScopeExists(ctx, &dosarpc.ScopeExistsRequest{Name: &name})
+	assert.NoError(t, err)
+	assert.False(t, *response.Exists)
+	assert.NoError(t, server.CreateScope(ctx, &dosarpc.CreateScopeRequest{Name: &name}))
+	response, err = server.ScopeExists(ctx, &dosarpc.ScopeExistsRequest{Name: &name})
+	assert.NoError(t, err)
+	assert.True(t, *response.Exists)
+}
+
+func TestServer_BadRequests(t *testing.T) {
+	server := gateway.NewServer(memory.NewConnector())
+	sut := &yarpc.Connector{Client: localClient{server}}
+	_, err := sut.CheckSchema(ctx, "testscope", "testprefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+
+	scope, prefix, entity := "testscope", "testprefix", "testentity"
+	ref := &dosarpc.SchemaRef{Scope: &scope, NamePrefix: &prefix, EntityName: &entity}
+	name := "name"
+	badColumn := "nope"
+
+	// incomplete schema reference
+	_, err = server.Read(ctx, &dosarpc.ReadRequest{Ref: &dosarpc.SchemaRef{Scope: &scope}})
+	assert.IsType(t, &dosarpc.BadRequestError{}, err)
+
+	// unknown column
+	err = server.Upsert(ctx, &dosarpc.UpsertRequest{Ref: ref, EntityValues: dosarpc.FieldValueMap{
+		badColumn: {ElemValue: yarpc.RawValueFromInterface("x")},
+	}})
+	assert.IsType(t, &dosarpc.BadRequestError{}, err)
+
+	// wrong value type
+	err = server.Upsert(ctx, &dosarpc.UpsertRequest{Ref: ref, EntityValues: dosarpc.FieldValueMap{
+		name: {ElemValue: yarpc.RawValueFromInterface(int64(1))},
+	}})
+	assert.IsType(t, &dosarpc.BadRequestError{}, err)
+
+	// missing value
+	err = server.Upsert(ctx, &dosarpc.UpsertRequest{Ref: ref, EntityValues: dosarpc.FieldValueMap{name: {}}})
+	assert.IsType(t, &dosarpc.BadRequestError{}, err)
+
+	// incomplete condition
+	_, err = server.Range(ctx, &dosarpc.RangeRequest{Ref: ref, Conditions: []*dosarpc.Condition{{}}})
+	assert.IsType(t, &dosarpc.BadRequestError{}, err)
+
+	// missing search field
+	_, err = server.Search(ctx, &dosarpc.SearchRequest{Ref: ref})
+	assert.IsType(t, &dosarpc.BadRequestError{}, err)
+
+	// errors from the connector without a known code are internal errors
+	err = server.Upsert(ctx, &dosarpc.UpsertRequest{Ref: ref, EntityValues: dosarpc.FieldValueMap{
+		name: {ElemValue: yarpc.RawValueFromInterface("x")},
+	}})
+	assert.IsType(t, &dosarpc.InternalServerError{}, err)
+
+	assert.NotEmpty(t, server.Procedures())
+}
+
+func TestServer_TypedErrors(t *testing.T) {
+	cause := errors.New("cause")
+	tcs := []struct {
+		err error
+		is  func(error) bool
+	}{
+		{&dosa.ErrInvalidRequest{Err: cause}, dosa.ErrorIsInvalidRequest},
+		{&dosa.ErrSchemaMismatch{Err: cause}, dosa.ErrorIsSchemaMismatch},
+		{&dosa.ErrTimeout{Err: cause}, dosa.ErrorIsTimeout},
+		{errors.Wrap(context.DeadlineExceeded, "cause"), dosa.ErrorIsTimeout},
+		{&dosa.ErrThrottled{Err: cause}, dosa.ErrorIsThrottled},
+		{cause, dosa.ErrorIsInternal},
+	}
+	for _, tc := range tcs {
+		next := fault.NewConnector(memory.NewConnector(), fault.Config{Faults: []fault.Fault{
+			{Methods: []string{"Upsert", "MultiRead"}, Error: tc.err},
+		}})
+		sut := &yarpc.Connector{Client: localClient{gateway.NewServer(next)}}
+		_, err := sut.CheckSchema(ctx, "testscope", "testprefix", []*dosa.EntityDefinition{testEi.Def})
+		assert.NoError(t, err)
+
+		err = sut.Upsert(ctx, testEi, row(1))
+		assert.True(t, tc.is(err), "%v", err)
+		assert.Contains(t, err.Error(), "cause")
+
+		_, err = sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{key(1)}, nil)
+		assert.True(t, tc.is(err), "%v", err)
+	}
+}
+
+func TestServer_InvalidNames(t *testing.T) {
+	sut := newConnector(t)
+
+	for _, scope := range []string{"", "..", "a/../..", "Bad Scope"} {
+		assert.True(t, dosa.ErrorIsInvalidRequest(sut.CreateScope(ctx, scope)), scope)
+		assert.True(t, dosa.ErrorIsInvalidRequest(sut.TruncateScope(ctx, scope)), scope)
+		assert.True(t, dosa.ErrorIsInvalidRequest(sut.DropScope(ctx, scope)), scope)
+		_, err := sut.ScopeExists(ctx, scope)
+		assert.True(t, dosa.ErrorIsInvalidRequest(err), scope)
+		_, err = sut.CheckSchema(ctx, scope, "testprefix", []*dosa.EntityDefinition{testEi.Def})
+		assert.True(t, dosa.ErrorIsInvalidRequest(err), scope)
+	}
+
+	for _, prefix := range []string{"", "..", "a..b", "a/b"} {
+		_, err := sut.CheckSchema(ctx, "testscope", prefix, []*dosa.EntityDefinition{testEi.Def})
+		assert.True(t, dosa.ErrorIsInvalidRequest(err), prefix)
+		_, err = sut.CheckSchemaStatus(ctx, "testscope", prefix, 1)
+		assert.True(t, dosa.ErrorIsInvalidRequest(err), prefix)
+	}
+	_, err := sut.CheckSchema(ctx, "testscope", "service.testprefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+
+	ei := &dosa.EntityInfo{Ref: &dosa.SchemaRef{Scope: "..", NamePrefix: "testprefix", EntityName: "testentity"}, Def: testEi.Def}
+	assert.True(t, dosa.ErrorIsInvalidRequest(sut.Upsert(ctx, ei, row(1))))
+}
+
+func TestServer_RetryableRows(t *testing.T) {
+	next := fault.NewConnector(memory.NewConnector(), fault.Config{Faults: []fault.Fault{
+		{Methods: []string{"MultiRead"}, RowErrorProbability: 1, RowError: &dosa.ErrThrottled{Err: errors.New("busy")}},
+	}})
+	server := gateway.NewServer(next)
+	sut := &yarpc.Connector{Client: localClient{server}}
+	_, err := sut.CheckSchema(ctx, "testscope", "testprefix", []*dosa.EntityDefinition{testEi.Def})
+	assert.NoError(t, err)
+
+	results, err := sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{key(1)}, nil)
+	assert.NoError(t, err)
+	assert.True(t, dosa.ErrorIsRetryable(results[0].Error))
+
+	// rows that are not found are not retried
+	sut = newConnector(t)
+	results, err = sut.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{key(1)}, nil)
+	assert.NoError(t, err)
+	assert.True(t, dosa.ErrorIsNotFound(results[0].Error))
+	assert.False(t, dosa.ErrorIsRetryable(results[0].Error))
+}
@@ -103,6 +103,17 @@ func (_mr *_MockConnectorRecorder) DropScope(arg0, arg1 interface{}) *gomock.Cal
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "DropScope", arg0, arg1)
 }
 
+func (_m *MockConnector) ListScopes(_param0 context.Context, _param1 string) ([]string, error) {
+	ret := _m.ctrl.Call(_m, "ListScopes", _param0, _param1)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnectorRecorder) ListScopes(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListScopes", arg0, arg1)
+}
+
 func (_m *MockConnector) MultiRead(_param0 context.Context, _param1 *dosa.EntityInfo, _param2 []map[string]dosa.FieldValue, _param3 []string) ([]*dosa.FieldValuesOrError, error) {
 	ret := _m.ctrl.Call(_m, "MultiRead", _param0, _param1, _param2, _param3)
 	ret0, _ := ret[0].([]*dosa.FieldValuesOrError)
@@ -232,6 +232,22 @@ func compare(t Type, a, b interface{}) int {
 	panic("invalid type") // shouldn't reach here
 }
 
+// EnsureTypeMatch checks that a value has the Go type used for values of
+// the given column type. Unlike ensureTypeMatch it returns an error, rather
+// than panic, for invalid types.
+func EnsureTypeMatch(t Type, v FieldValue) error {
+	switch t {
+	case TUUID, Int64, Int32, String, Blob, Bool, Double, Timestamp:
+		return ensureTypeMatch(t, v)
+	case CustomObject:
+		if _, ok := v.(CustomObjectInterface); !ok {
+			return errors.Errorf("invalid value for custom object type: %v", v)
+		}
+		return nil
+	}
+	return errors.Errorf("invalid type %v", t)
+}
+
 func ensureTypeMatch(t Type, v FieldValue) error {
 	switch t {
 	case TUUID:
@@ -69,6 +69,13 @@ func TestEnsureTypeMatch(t *testing.T) {
 	})
 }
 
+func TestEnsureTypeMatchExported(t *testing.T) {
+	assert.NoError(t, EnsureTypeMatch(Int64, int64(1)))
+	assert.Error(t, EnsureTypeMatch(Int64, int32(1)))
+	assert.Error(t, EnsureTypeMatch(Invalid, false))
+	assert.Error(t, EnsureTypeMatch(CustomObject, "not an object"))
+}
+
 func TestCompare(t *testing.T) {
 	type testCase struct {
 		tp       Type
@@ -20,42 +20,80 @@
 
 package dosa
 
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
 // SearchOp represents the search query using a "searchable" field.
-type SearchOp struct{}
+type SearchOp struct {
+	sop        ScanOp
+	fieldName  string
+	fieldValue interface{}
+}
 
 // NewSearchOp returns a new SearchOp instance
-func NewSearchOp(DomainObject) *SearchOp {
-	return &SearchOp{}
+func NewSearchOp(object DomainObject) *SearchOp {
+	return &SearchOp{sop: ScanOp{object: object}}
 }
 
 // String satisfies the stringer interface
 func (s *SearchOp) String() string {
-	/* TODO */
-	return ""
+	result := &bytes.Buffer{}
+	result.WriteString("SearchOp")
+	if s.fieldName != "" {
+		fmt.Fprintf(result, " by %s %v", s.fieldName, s.fieldValue)
+	}
+	addLimitTokenString(result, s.sop.limit, s.sop.token)
+	return result.String()
 }
 
 // By indicates the "searchable" field name and its value.
 func (s *SearchOp) By(fieldName string, fieldValue interface{}) *SearchOp {
-	/* TODO */
+	s.fieldName = fieldName
+	s.fieldValue = fieldValue
 	return s
 }
 
 // Limit sets the number of rows returned per call. Default is 128.
 func (s *SearchOp) Limit(n int) *SearchOp {
-	/* TODO */
+	s.sop.limit = n
 	return s
 }
 
 // Offset sets the pagination token. If not set, an empty token would be used.
 func (s *SearchOp) Offset(token string) *SearchOp {
-	/* TODO */
+	s.sop.token = token
 	return s
 }
 
 // Fields list the non-key fields users want to fetch. If not set, all normalized fields
 // (supplied with “storing” annotation) would be fetched.
 // PrimaryKey fields are always fetched.
-func (s *SearchOp) Fields([]string) *SearchOp {
-	/* TODO */
+func (s *SearchOp) Fields(fields []string) *SearchOp {
+	s.sop.fieldsToRead = fields
 	return s
 }
+
+// convertSearchOpField translates the struct field name of the search
+// to the server side column name and checks that the column is searchable
+// and the value has the right type.
+func convertSearchOpField(s *SearchOp, t *Table) (FieldNameValuePair, error) {
+	if s.fieldName == "" {
+		return FieldNameValuePair{}, errors.New("no search field provided, use By to set one")
+	}
+	colName, ok := t.FieldToCol[s.fieldName]
+	if !ok {
+		return FieldNameValuePair{}, errors.Errorf("Cannot find column %q in struct %q", s.fieldName, t.StructName)
+	}
+	cd := t.FindColumnDefinition(colName)
+	if !cd.IsSearchable() {
+		return FieldNameValuePair{}, errors.Errorf("column %q in struct %q is not searchable", s.fieldName, t.StructName)
+	}
+	if err := EnsureTypeMatch(cd.Type, s.fieldValue); err != nil {
+		return FieldNameValuePair{}, errors.Wrapf(err, "column %s", s.fieldName)
+	}
+	return FieldNameValuePair{Name: colName, Value: s.fieldValue}, nil
+}
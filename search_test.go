@@ -34,5 +34,8 @@ func TestNewSearchOp(t *testing.T) {
 
 func TestSearchOpStringer(t *testing.T) {
 	o := dosa.NewSearchOp(&dosa.Entity{})
-	assert.Equal(t, "", o.String())
+	assert.Equal(t, "SearchOp", o.String())
+
+	o.By("Email", "foo@bar.com").Limit(10).Offset("toketoketoke").Fields([]string{"ID"})
+	assert.Equal(t, `SearchOp by Email foo@bar.com limit 10 token "toketoketoke"`, o.String())
 }